@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -16,7 +17,18 @@ import (
 	"github.com/savid/iptv-proxy/pkg/api/handlers"
 	"github.com/savid/iptv-proxy/pkg/api/middleware"
 	"github.com/savid/iptv-proxy/pkg/data"
+	"github.com/savid/iptv-proxy/pkg/devices"
+	"github.com/savid/iptv-proxy/pkg/dvr"
+	"github.com/savid/iptv-proxy/pkg/events"
 	"github.com/savid/iptv-proxy/pkg/hardware"
+	"github.com/savid/iptv-proxy/pkg/hdhomerun"
+	"github.com/savid/iptv-proxy/pkg/health"
+	"github.com/savid/iptv-proxy/pkg/logging"
+	"github.com/savid/iptv-proxy/pkg/ssdp"
+	"github.com/savid/iptv-proxy/pkg/timeshift"
+	"github.com/savid/iptv-proxy/pkg/tracing"
+	"github.com/savid/iptv-proxy/pkg/tuner"
+	"github.com/savid/iptv-proxy/pkg/upstream"
 	"github.com/sirupsen/logrus"
 )
 
@@ -33,17 +45,34 @@ func Execute() {
 		logrus.WithError(err).Fatal("Failed to load configuration")
 	}
 
-	// Set log level based on config
-	level, err := logrus.ParseLevel(cfg.LogLevel)
-	if err != nil {
-		logrus.WithError(err).Fatal("Failed to parse log level")
+	// Set log format and level based on config
+	logger := logrus.StandardLogger()
+	if err := logging.Configure(logger, cfg.LogFormat, cfg.LogLevel); err != nil {
+		logger.WithError(err).Fatal("Failed to configure logging")
 	}
-	logrus.SetLevel(level)
 
-	logger := logrus.StandardLogger()
+	// Export spans of the fetch and stream pipelines
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TraceExporter, cfg.TraceEndpoint, cfg.TraceSampleRatio)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to configure tracing")
+	}
+	defer func() {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.WithError(err).Warn("Failed to flush traces")
+		}
+	}()
+	if cfg.TraceExporter != "" && cfg.TraceExporter != tracing.ExporterNone {
+		logger.WithFields(logrus.Fields{
+			"exporter":     cfg.TraceExporter,
+			"endpoint":     cfg.TraceEndpoint,
+			"sample_ratio": cfg.TraceSampleRatio,
+		}).Info("Tracing enabled")
+	}
 
 	// List available hardware devices
-	if cfg.TranscodeMode == "transcode" {
+	if cfg.TranscodeMode != "copy" {
 		stdLogger := log.New(logger.Writer(), "", 0)
 		detector := hardware.NewDetector(stdLogger)
 		devices, err := detector.DetectAllDevices()
@@ -60,31 +89,104 @@ func Execute() {
 		}
 	}
 
+	// Every source gets its own pooled client with the provider's headers
+	upstreams, err := upstream.LoadSet(upstreamOptions(cfg), cfg.Upstreams)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to configure upstream clients")
+	}
+	// FFmpeg would bypass proxies it does not support and reach the provider directly
+	if usesFFmpegInput(cfg) {
+		if err := upstreams.Stream.CheckInput(); err != nil {
+			logger.WithError(err).Fatal("Stream proxy cannot be used for transcoding or stream analysis")
+		}
+	}
+
 	// Create store and fetcher
 	store := data.NewStore()
 	store.SetTestChannelsEnabled(cfg.EnableTestChannels)
+	store.SetHideDeadAfter(cfg.HideDeadAfter)
 	fetcher := data.NewFetcher(cfg, logger)
+	fetcher.SetUpstream(upstreams.M3U, upstreams.EPG)
+	refresher := data.NewRefresher(store, fetcher, cfg.RefreshInterval, logger)
+	store.SetChangeHandler(changeHandler(cfg, logger))
+
+	// Operational incidents are published to the configured sinks
+	bus := newEventBus(cfg, logger)
+	refresher.SetEvents(bus)
 
 	// Perform initial data fetch (blocking)
 	logger.Info("Fetching initial data...")
-	result, err := fetcher.FetchAll()
-	if err != nil {
+	if err := refresher.Refresh(context.Background(), data.SourceAll); err != nil {
 		logger.WithError(err).Fatal("Failed to fetch initial data")
 	}
-	store.SetM3U(result.M3U.Raw, result.M3U.Channels)
-	store.SetEPG(result.EPG.Raw, result.EPG.Filtered)
 	logger.Info("Initial data loaded successfully")
 
 	// Start background refresh manager
-	refresher := data.NewRefresher(store, fetcher, cfg.RefreshInterval, logger)
 	ctx, cancel := context.WithCancel(context.Background())
+	go bus.Run(ctx)
 	go refresher.Start(ctx)
 
+	tuners := tuner.NewPool(cfg.TunerCount)
+	tuners.SetEvents(bus)
+
+	// Start background channel health checks
+	if cfg.HealthCheckInterval > 0 {
+		checker := health.NewChecker(cfg, store, tuners, logger)
+		checker.SetUpstream(upstreams.Stream)
+		checker.SetEvents(bus)
+		go checker.Start(ctx)
+	}
+
+	// Clients recognise the tuner by its persisted identity; without it the
+	// proxy still runs with a new identity on every start
+	identity, err := devices.LoadIdentityOrNew(cfg.IdentityFile)
+	if err != nil {
+		if !devices.ValidDeviceID(identity.DeviceID) {
+			logger.WithError(err).Fatal("Failed to create device identity")
+		}
+		logger.WithError(err).Warn("Failed to persist device identity, using a temporary one; set -identity-file to a writable path")
+	}
+
+	tunerDevices, err := devices.Load(cfg, identity)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load virtual devices")
+	}
+
 	mux := http.NewServeMux()
-	setupRoutes(mux, cfg, store, logger)
+	setupRoutes(ctx, mux, cfg, store, refresher, tuners, upstreams.Stream, bus, logger)
+
+	// Virtual devices are served under their base path, or on their own port
+	for _, device := range tunerDevices {
+		if device.Port == 0 {
+			setupDeviceRoutes(mux, device.Path, cfg, store, refresher, device)
+		} else {
+			go serveDevice(ctx, cfg, store, refresher, device, logger)
+		}
+		logger.WithFields(logrus.Fields{
+			"id":       device.ID,
+			"name":     device.Name,
+			"base_url": device.BaseURL,
+		}).Info("Tuner device")
+	}
+
+	// Answer SSDP discovery so media servers find the tuner
+	ssdpDone := make(chan struct{})
+	if cfg.SSDP {
+		go func() {
+			defer close(ssdpDone)
+			startSSDP(ctx, cfg, tunerDevices, logger)
+		}()
+	} else {
+		close(ssdpDone)
+	}
+
+	// Answer HDHomeRun discovery for native apps
+	if cfg.HDHomeRunDiscovery {
+		go startHDHomeRunDiscovery(ctx, cfg, tunerDevices, logger)
+	}
 
-	// Apply logging middleware to the mux
-	handler := middleware.LoggingMiddleware(logger)(mux)
+	// Apply logging and tracing middleware to the mux
+	handler := middleware.LoggingMiddleware(logger)(middleware.TracingMiddleware()(mux))
 
 	server := &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", cfg.BindAddr, cfg.Port),
@@ -122,26 +224,239 @@ func Execute() {
 	}
 
 	<-ctx.Done()
+	<-ssdpDone
 	logger.Info("Server stopped")
 	cancel()
 }
 
-func setupRoutes(mux *http.ServeMux, cfg *config.Config, store *data.Store, logger *logrus.Logger) {
-	// Tuner advertising routes
-	mux.HandleFunc("/", handlers.RootXMLHandler(cfg))
-	mux.HandleFunc("/discovery.json", handlers.DiscoveryHandler(cfg))
-	mux.HandleFunc("/discover.json", handlers.DiscoveryHandler(cfg)) // Plex compatibility
-	mux.HandleFunc("/lineup.json", handlers.LineupHandler(cfg, store))
-	mux.HandleFunc("/lineup_status.json", handlers.LineupStatusHandler())
+// usesTranscoder reports whether streams are served by the transcoding
+// handler: when the transcode mode is not "copy" or per-channel rules or
+// client profiles are configured.
+func usesTranscoder(cfg *config.Config) bool {
+	return cfg.TranscodeMode != "copy" || cfg.TranscodeRules != "" || cfg.ClientProfiles != ""
+}
+
+// usesFFmpegInput reports whether FFmpeg or ffprobe read stream upstreams.
+func usesFFmpegInput(cfg *config.Config) bool {
+	return usesTranscoder(cfg) || (cfg.HealthCheckInterval > 0 && cfg.HealthCheckAnalyze)
+}
+
+// upstreamOptions returns the upstream client settings of the flags, which
+// the per-source settings of the upstreams file override.
+func upstreamOptions(cfg *config.Config) upstream.Options {
+	return upstream.Options{
+		UserAgent:          cfg.UpstreamUserAgent,
+		Referer:            cfg.UpstreamReferer,
+		Cookies:            cfg.UpstreamCookies,
+		Proxy:              cfg.UpstreamProxy,
+		InsecureSkipVerify: cfg.UpstreamInsecure,
+		CAFile:             cfg.UpstreamCAFile,
+		ConnectTimeout:     cfg.UpstreamConnectTimeout,
+		ReadTimeout:        cfg.UpstreamReadTimeout,
+		ForwardHeaders:     cfg.ParseForwardHeaders(),
+	}
+}
+
+// newEventBus creates the event bus with a log sink and the configured
+// notification sinks.
+func newEventBus(cfg *config.Config, logger *logrus.Logger) *events.Bus {
+	bus := events.NewBus(logger)
+	bus.SetDedupWindow(cfg.EventsDedupWindow)
+	bus.SetRateLimit(cfg.EventsRateLimit)
+	bus.AddSink(events.NewLogSink(logger))
+	if cfg.EventsWebhook != "" {
+		bus.AddSink(events.NewWebhookSink(cfg.EventsWebhook))
+	}
+	if cfg.EventsNtfy != "" {
+		bus.AddSink(events.NewNtfySink(cfg.EventsNtfy, cfg.EventsNtfyToken))
+	}
+	if cfg.EventsGotify != "" {
+		bus.AddSink(events.NewGotifySink(cfg.EventsGotify, cfg.EventsGotifyToken))
+	}
+	return bus
+}
+
+// accessLogMiddleware returns the middleware writing completed streams to
+// the configured access log, or one passing requests through when the
+// access log is disabled.
+func accessLogMiddleware(cfg *config.Config, logger *logrus.Logger) func(http.Handler) http.Handler {
+	if cfg.AccessLog == "" {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	var w io.Writer = os.Stdout
+	if cfg.AccessLog != "-" {
+		file, err := os.OpenFile(cfg.AccessLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to open access log")
+		}
+		w = file
+	}
+	access, err := logging.NewAccessLog(w, cfg.AccessLogFormat)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to create access log")
+	}
+	return middleware.AccessLogMiddleware(access, logger)
+}
+
+// changeHandler logs playlist and guide changes and posts them to the
+// configured webhook.
+func changeHandler(cfg *config.Config, logger *logrus.Logger) func(data.Diff) {
+	var webhook *events.WebhookSink
+	if cfg.ChangesWebhook != "" {
+		webhook = events.NewWebhookSink(cfg.ChangesWebhook)
+	}
+
+	return func(diff data.Diff) {
+		logger.WithFields(logrus.Fields{
+			"source":     diff.Source,
+			"generation": diff.Generation,
+			"changes":    len(diff.Changes),
+		}).Info("Detected changes since the previous refresh")
+
+		if webhook == nil {
+			return
+		}
+		// Deliver in the background so a slow webhook does not hold up refreshes
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := webhook.Post(ctx, diff); err != nil {
+				logger.WithError(err).Warn("Failed to post changes webhook")
+			}
+		}()
+	}
+}
+
+// startSSDP answers SSDP searches and announces the tuners until the
+// context is cancelled.
+func startSSDP(ctx context.Context, cfg *config.Config, tunerDevices []*devices.Device, logger *logrus.Logger) {
+	conn, err := ssdp.Listen()
+	if err != nil {
+		logger.WithError(err).Warn("SSDP discovery disabled")
+		return
+	}
+	announced := make([]ssdp.Device, 0, len(tunerDevices))
+	for _, device := range tunerDevices {
+		announced = append(announced, ssdp.Device{
+			UDN:      device.UDN(),
+			Location: device.BaseURL + "/",
+		})
+	}
+	responder, err := ssdp.NewResponder(conn, announced, logger)
+	if err != nil {
+		_ = conn.Close()
+		logger.WithError(err).Warn("SSDP discovery disabled")
+		return
+	}
+	responder.SetNotifyInterval(cfg.SSDPNotifyInterval)
+
+	logger.WithField("address", ssdp.MulticastAddr).Info("SSDP discovery enabled")
+	responder.Serve(ctx)
+}
+
+// startHDHomeRunDiscovery answers HDHomeRun discover requests until the
+// context is cancelled.
+func startHDHomeRunDiscovery(ctx context.Context, cfg *config.Config, tunerDevices []*devices.Device, logger *logrus.Logger) {
+	conn, err := hdhomerun.Listen(cfg.BindAddr)
+	if err != nil {
+		logger.WithError(err).Warn("HDHomeRun discovery disabled")
+		return
+	}
+	announced := make([]hdhomerun.Device, 0, len(tunerDevices))
+	for _, device := range tunerDevices {
+		discovery := handlers.NewDiscovery(device)
+		announced = append(announced, hdhomerun.Device{
+			DeviceID:   hdhomerun.ParseDeviceID(discovery.DeviceID),
+			TunerCount: discovery.TunerCount,
+			BaseURL:    discovery.BaseURL,
+			LineupURL:  discovery.LineupURL,
+			DeviceAuth: discovery.DeviceAuth,
+		})
+	}
+	responder, err := hdhomerun.NewResponder(conn, announced, logger)
+	if err != nil {
+		_ = conn.Close()
+		logger.WithError(err).Warn("HDHomeRun discovery disabled")
+		return
+	}
+
+	logger.WithField("port", hdhomerun.DiscoveryPort).Info("HDHomeRun discovery enabled")
+	responder.Serve(ctx)
+}
+
+// setupDeviceRoutes registers the tuner advertising routes of a device under
+// a base path.
+func setupDeviceRoutes(mux *http.ServeMux, path string, cfg *config.Config, store *data.Store, refresher *data.Refresher, device *devices.Device) {
+	mux.HandleFunc(path+"/", handlers.RootXMLHandler(device))
+	mux.HandleFunc(path+"/discovery.json", handlers.DiscoveryHandler(device))
+	mux.HandleFunc(path+"/discover.json", handlers.DiscoveryHandler(device)) // Plex compatibility
+	mux.HandleFunc(path+"/lineup.json", handlers.LineupHandler(cfg, store, device))
+	mux.HandleFunc(path+"/lineup.xml", handlers.LineupXMLHandler(cfg, store, device))
+	mux.HandleFunc(path+"/lineup.m3u", handlers.LineupM3UHandler(cfg, store, device))
+	mux.HandleFunc(path+"/lineup_status.json", handlers.LineupStatusHandler(refresher))
+	mux.HandleFunc(path+"/lineup.post", handlers.LineupPostHandler(refresher))
+}
+
+// serveDevice serves a virtual device on its own port until the context is
+// cancelled.
+func serveDevice(ctx context.Context, cfg *config.Config, store *data.Store, refresher *data.Refresher, device *devices.Device, logger *logrus.Logger) {
+	mux := http.NewServeMux()
+	setupDeviceRoutes(mux, "", cfg, store, refresher, device)
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", cfg.BindAddr, device.Port),
+		Handler:           middleware.LoggingMiddleware(logger)(middleware.TracingMiddleware()(mux)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.WithError(err).WithField("device", device.ID).Error("Failed to serve virtual device")
+	}
+}
+
+func setupRoutes(
+	ctx context.Context,
+	mux *http.ServeMux,
+	cfg *config.Config,
+	store *data.Store,
+	refresher *data.Refresher,
+	tuners *tuner.Pool,
+	streams *upstream.Client,
+	bus *events.Bus,
+	logger *logrus.Logger,
+) {
 
 	m3uHandler := handlers.NewM3UHandler(store, cfg, logger)
 	epgHandler := handlers.NewEPGHandler(store, cfg, logger)
 
-	// Use transcoding handler when transcode mode is not "copy"
-	if cfg.TranscodeMode != "copy" {
-		// Create a standard logger wrapper for logrus
-		stdLogger := log.New(logger.Writer(), "", 0)
-		streamHandler, err := handlers.NewStreamV2Handler(cfg, stdLogger)
+	// Time-shift buffers are recorded per channel while it is watched
+	var timeshiftManager *timeshift.Manager
+	if cfg.TimeshiftWindow > 0 {
+		timeshiftManager = timeshift.NewManager(cfg.TimeshiftWindow, cfg.TimeshiftDir, log.New(logger.Writer(), "", 0))
+		timeshiftManager.SetUpstream(streams)
+		timeshiftManager.SetTuners(tuners)
+		mux.HandleFunc("/api/timeshift", handlers.TimeshiftHandler(timeshiftManager))
+		logger.WithFields(logrus.Fields{
+			"window": cfg.TimeshiftWindow,
+			"dir":    cfg.TimeshiftDir,
+		}).Info("Time-shift enabled")
+	}
+
+	// The stream handler also serves DVR recordings
+	var stream http.Handler
+
+	// Completed streams are written to the access log
+	streamed := accessLogMiddleware(cfg, logger)
+
+	// Use transcoding handler when transcode mode is not "copy" or per-channel rules
+	// or client profiles are configured
+	if usesTranscoder(cfg) {
+		streamHandler, err := handlers.NewStreamV2Handler(cfg, store, tuners, logger)
 		if err != nil {
 			logger.WithError(err).Fatal("Failed to create transcoding stream handler")
 		}
@@ -149,11 +464,45 @@ func setupRoutes(mux *http.ServeMux, cfg *config.Config, store *data.Store, logg
 			"video_codec": cfg.VideoCodec,
 			"audio_codec": cfg.AudioCodec,
 		}).Info("Using transcoding stream handler")
-		mux.Handle("/stream/", streamHandler)
+		if timeshiftManager != nil {
+			streamHandler.SetTimeshift(timeshiftManager)
+		}
+		streamHandler.SetUpstream(streams)
+		streamHandler.SetEvents(bus)
+		stream = streamHandler
+		mux.Handle("/stream/", streamed(streamHandler))
+		mux.HandleFunc("/api/sessions", streamHandler.SessionsHandler())
+		mux.HandleFunc("/api/hardware", streamHandler.HardwareHandler())
 	} else {
-		streamHandler := handlers.NewStreamHandler(logger)
+		streamHandler := handlers.NewStreamHandler(tuners, logger)
 		logger.Info("Using direct stream handler (no transcoding)")
-		mux.Handle("/stream/", streamHandler)
+		if timeshiftManager != nil {
+			streamHandler.SetTimeshift(timeshiftManager)
+		}
+		streamHandler.SetUpstream(streams)
+		streamHandler.SetEvents(bus)
+		stream = streamHandler
+		mux.Handle("/stream/", streamed(streamHandler))
+		mux.HandleFunc("/api/sessions", streamHandler.SessionsHandler())
+	}
+
+	// Catch-up archives are streamed through the stream handler
+	mux.Handle("/catchup/", streamed(handlers.CatchupHandler(store, stream, logger)))
+
+	// DVR recordings are scheduled from the guide and recorded through the
+	// stream handler
+	if cfg.DVRDir != "" {
+		scheduler, err := dvr.NewScheduler(cfg, store, tuners, stream, logger)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to create DVR scheduler")
+		}
+		go scheduler.Start(ctx)
+		mux.HandleFunc("/api/dvr/jobs", handlers.DVRJobsHandler(scheduler))
+		mux.HandleFunc("/api/dvr/jobs/", handlers.DVRJobsHandler(scheduler))
+		mux.HandleFunc("/api/dvr/recordings", handlers.DVRRecordingsHandler(scheduler))
+		mux.HandleFunc("/api/dvr/recordings/", handlers.DVRRecordingsHandler(scheduler))
+		mux.HandleFunc("/api/dvr/guide", handlers.DVRGuideHandler(scheduler))
+		logger.WithField("dir", cfg.DVRDir).Info("DVR enabled")
 	}
 
 	mux.Handle("/iptv.m3u", m3uHandler)
@@ -161,13 +510,21 @@ func setupRoutes(mux *http.ServeMux, cfg *config.Config, store *data.Store, logg
 
 	// Add test channel handlers if enabled
 	if cfg.EnableTestChannels {
-		mux.HandleFunc("/test/", handlers.TestChannelHandler)
+		mux.Handle("/test/", streamed(handlers.TestChannelHandler(logger)))
 		mux.HandleFunc("/test-icon/", handlers.TestIconHandler)
 	}
 
+	// Channel health API
+	mux.HandleFunc("/api/health/channels", handlers.ChannelHealthHandler(store))
+
+	// Refreshes can be triggered on demand
+	mux.HandleFunc("/api/refresh", handlers.RefreshHandler(refresher))
+	mux.HandleFunc("/api/refresh/status", handlers.RefreshStatusHandler(refresher))
+	mux.HandleFunc("/api/changes", handlers.ChangesHandler(store))
+
 	// Debug endpoints for troubleshooting
-	mux.HandleFunc("/debug", handlers.DebugHandler)
-	mux.HandleFunc("/plex-debug", handlers.PlexDebugHandler)
+	mux.HandleFunc("/debug", handlers.DebugHandler(logger))
+	mux.HandleFunc("/plex-debug", handlers.PlexDebugHandler(logger))
 
 	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -23,6 +24,8 @@ var (
 	ErrRefreshIntervalPositive = errors.New("refresh interval must be positive")
 	// ErrInvalidLogLevel is returned when log level is invalid.
 	ErrInvalidLogLevel = errors.New("invalid log level")
+	// ErrInvalidLogFormat is returned when the log or access log format is invalid.
+	ErrInvalidLogFormat = errors.New("invalid log format")
 	// ErrInvalidHardwareAccel is returned when hardware acceleration value is invalid.
 	ErrInvalidHardwareAccel = errors.New("invalid hardware acceleration")
 	// ErrBufferSizeTooSmall is returned when buffer size is less than 1MB.
@@ -49,34 +52,116 @@ var (
 	ErrInvalidHardwareDeviceFormat = errors.New("invalid hardware device format (must be auto, none, or device ID like nvidia:0)")
 	// ErrInvalidDeviceID is returned when device ID is not a valid number.
 	ErrInvalidDeviceID = errors.New("invalid device ID")
+	// ErrProbeCacheTTLPositive is returned when the probe cache TTL is not positive.
+	ErrProbeCacheTTLPositive = errors.New("probe cache TTL must be positive")
+	// ErrInvalidVideoFilter is returned when scaling, framerate or deinterlace settings are invalid.
+	ErrInvalidVideoFilter = errors.New("invalid video filter settings")
+	// ErrInvalidSessionLimit is returned when a GPU session limit is malformed.
+	ErrInvalidSessionLimit = errors.New("invalid GPU session limit (must be type=N or type:id=N)")
+	// ErrHardwareCooldownPositive is returned when the hardware failure cooldown is not positive.
+	ErrHardwareCooldownPositive = errors.New("hardware cooldown must be positive")
+	// ErrInvalidHealthCheck is returned when health check settings are invalid.
+	ErrInvalidHealthCheck = errors.New("invalid health check settings")
+	// ErrTimeshiftWindowNegative is returned when the time-shift window is negative.
+	ErrTimeshiftWindowNegative = errors.New("time-shift window must not be negative")
+	// ErrInvalidDVR is returned when DVR settings are invalid.
+	ErrInvalidDVR = errors.New("invalid DVR settings")
+	// ErrSSDPNotifyIntervalPositive is returned when the SSDP announcement interval is not positive.
+	ErrSSDPNotifyIntervalPositive = errors.New("SSDP notify interval must be positive")
+	// ErrInvalidEvents is returned when event notification settings are invalid.
+	ErrInvalidEvents = errors.New("invalid event notification settings")
+	// ErrInvalidTracing is returned when tracing settings are invalid.
+	ErrInvalidTracing = errors.New("invalid tracing settings")
+	// ErrInvalidUpstream is returned when upstream client settings are invalid.
+	ErrInvalidUpstream = errors.New("invalid upstream settings")
 )
 
 // Config holds the application configuration.
 type Config struct {
-	M3UURL          string
-	EPGURL          string
-	BaseURL         string
-	BindAddr        string
-	Port            int
-	LogLevel        string
+	M3UURL    string
+	EPGURL    string
+	BaseURL   string
+	BindAddr  string
+	Port      int
+	LogLevel  string
+	LogFormat string
+	// AccessLog is the file each completed stream is logged to, "-" for
+	// standard output; empty disables the access log.
+	AccessLog       string
+	AccessLogFormat string
 	RefreshInterval time.Duration
 	TunerCount      int
 	// New transcoding fields
-	TranscodeMode      string `mapstructure:"transcode_mode"`
-	HardwareDevice     string `mapstructure:"hardware_device"`
-	VideoCodec         string `mapstructure:"video_codec"`
-	AudioCodec         string `mapstructure:"audio_codec"`
-	VideoQuality       string `mapstructure:"video_quality"`
-	AudioQuality       string `mapstructure:"audio_quality"`
-	CustomVideoBitrate string `mapstructure:"custom_video_bitrate"`
-	CustomAudioBitrate string `mapstructure:"custom_audio_bitrate"`
+	TranscodeMode      string        `mapstructure:"transcode_mode"`
+	HardwareDevice     string        `mapstructure:"hardware_device"`
+	VideoCodec         string        `mapstructure:"video_codec"`
+	AudioCodec         string        `mapstructure:"audio_codec"`
+	VideoQuality       string        `mapstructure:"video_quality"`
+	AudioQuality       string        `mapstructure:"audio_quality"`
+	CustomVideoBitrate string        `mapstructure:"custom_video_bitrate"`
+	CustomAudioBitrate string        `mapstructure:"custom_audio_bitrate"`
+	TranscodeRules     string        `mapstructure:"transcode_rules"`
+	ProbeCacheTTL      time.Duration `mapstructure:"probe_cache_ttl"`
+	ClientProfiles     string        `mapstructure:"client_profiles"`
+	MaxWidth           int           `mapstructure:"max_width"`
+	MaxHeight          int           `mapstructure:"max_height"`
+	Framerate          float64       `mapstructure:"framerate"`
+	Deinterlace        string        `mapstructure:"deinterlace"`
+	GPUSessionLimits   string        `mapstructure:"gpu_session_limits"`
+	HardwareCooldown   time.Duration `mapstructure:"hardware_cooldown"`
 	// Buffer settings
 	BufferSize          int           `mapstructure:"buffer_size"`
 	BufferDuration      time.Duration `mapstructure:"buffer_duration"`
 	BufferPrefetchRatio float64       `mapstructure:"buffer_prefetch_ratio"`
+	// Time-shift settings
+	TimeshiftWindow time.Duration `mapstructure:"timeshift_window"`
+	TimeshiftDir    string        `mapstructure:"timeshift_dir"`
+	// DVR settings
+	DVRDir           string        `mapstructure:"dvr_dir"`
+	DVRPaddingBefore time.Duration `mapstructure:"dvr_padding_before"`
+	DVRPaddingAfter  time.Duration `mapstructure:"dvr_padding_after"`
+	DVRRetention     time.Duration `mapstructure:"dvr_retention"`
+	// Discovery settings
+	SSDP               bool          `mapstructure:"ssdp"`
+	SSDPNotifyInterval time.Duration `mapstructure:"ssdp_notify_interval"`
+	HDHomeRunDiscovery bool          `mapstructure:"hdhomerun_discovery"`
+	Devices            string        `mapstructure:"devices"`
+	IdentityFile       string        `mapstructure:"identity_file"`
+	Favorites          string        `mapstructure:"favorites"`
 	// Test settings
 	EnableTestChannels bool `mapstructure:"enable_test_channels"`
 	TestChannelPort    int  `mapstructure:"test_channel_port"`
+	// Health check settings
+	HealthCheckInterval    time.Duration `mapstructure:"health_check_interval"`
+	HealthCheckConcurrency int           `mapstructure:"health_check_concurrency"`
+	HealthCheckTimeout     time.Duration `mapstructure:"health_check_timeout"`
+	HealthCheckAnalyze     bool          `mapstructure:"health_check_analyze"`
+	HideDeadAfter          int           `mapstructure:"hide_dead_after"`
+	// Change detection settings
+	ChangesWebhook string `mapstructure:"changes_webhook"`
+	// Event notification settings
+	EventsWebhook     string        `mapstructure:"events_webhook"`
+	EventsNtfy        string        `mapstructure:"events_ntfy"`
+	EventsNtfyToken   string        `mapstructure:"events_ntfy_token"`
+	EventsGotify      string        `mapstructure:"events_gotify"`
+	EventsGotifyToken string        `mapstructure:"events_gotify_token"`
+	EventsDedupWindow time.Duration `mapstructure:"events_dedup_window"`
+	EventsRateLimit   int           `mapstructure:"events_rate_limit"`
+	// Tracing settings
+	TraceExporter    string  `mapstructure:"trace_exporter"`
+	TraceEndpoint    string  `mapstructure:"trace_endpoint"`
+	TraceSampleRatio float64 `mapstructure:"trace_sample_ratio"`
+	// Upstream settings
+	UpstreamUserAgent      string        `mapstructure:"upstream_user_agent"`
+	UpstreamReferer        string        `mapstructure:"upstream_referer"`
+	UpstreamCookies        string        `mapstructure:"upstream_cookies"`
+	UpstreamProxy          string        `mapstructure:"upstream_proxy"`
+	UpstreamInsecure       bool          `mapstructure:"upstream_insecure"`
+	UpstreamCAFile         string        `mapstructure:"upstream_ca_file"`
+	UpstreamConnectTimeout time.Duration `mapstructure:"upstream_connect_timeout"`
+	UpstreamReadTimeout    time.Duration `mapstructure:"upstream_read_timeout"`
+	ForwardHeaders         string        `mapstructure:"forward_headers"`
+	Upstreams              string        `mapstructure:"upstreams"`
 }
 
 // New creates a new configuration instance by parsing command-line flags.
@@ -89,10 +174,13 @@ func New() (*Config, error) {
 	flag.StringVar(&cfg.BindAddr, "bind", "0.0.0.0", "IP address to bind the server to")
 	flag.IntVar(&cfg.Port, "port", 8080, "Port to listen on")
 	flag.StringVar(&cfg.LogLevel, "log-level", "info", "Log level (debug, info, warn, error)")
+	flag.StringVar(&cfg.LogFormat, "log-format", "text", "Log format: text or json")
+	flag.StringVar(&cfg.AccessLog, "access-log", "", "File each completed stream is logged to, - for standard output (empty disables)")
+	flag.StringVar(&cfg.AccessLogFormat, "access-log-format", "combined", "Access log format: combined or json")
 	flag.DurationVar(&cfg.RefreshInterval, "refresh-interval", 30*time.Minute, "Interval between data refreshes")
 	flag.IntVar(&cfg.TunerCount, "tuner-count", 2, "Number of tuners to advertise")
 	// New transcoding flags
-	flag.StringVar(&cfg.TranscodeMode, "transcode-mode", "transcode", "Transcoding mode: copy or transcode")
+	flag.StringVar(&cfg.TranscodeMode, "transcode-mode", "transcode", "Transcoding mode: copy, transcode, or auto (copy compatible tracks, transcode the rest)")
 	flag.StringVar(&cfg.HardwareDevice, "hardware-device", "auto", "Hardware device: auto, none, or device ID (e.g., nvidia:0, intel:0)")
 	flag.StringVar(&cfg.VideoCodec, "video-codec", "h264", "Video codec when transcoding: h264, h265, vp9, mpeg2")
 	flag.StringVar(&cfg.AudioCodec, "audio-codec", "aac", "Audio codec when transcoding: aac, mp3, mp2, opus")
@@ -100,13 +188,68 @@ func New() (*Config, error) {
 	flag.StringVar(&cfg.AudioQuality, "audio-quality", "medium", "Audio quality: low, medium, high, or custom")
 	flag.StringVar(&cfg.CustomVideoBitrate, "custom-video-bitrate", "", "Custom video bitrate when quality is 'custom'")
 	flag.StringVar(&cfg.CustomAudioBitrate, "custom-audio-bitrate", "", "Custom audio bitrate when quality is 'custom'")
+	flag.StringVar(&cfg.TranscodeRules, "transcode-rules", "", "Path to a JSON file with per-channel and per-group transcoding profiles")
+	flag.DurationVar(&cfg.ProbeCacheTTL, "probe-cache-ttl", 6*time.Hour, "How long the probed source stream of a channel is cached")
+	flag.StringVar(&cfg.ClientProfiles, "client-profiles", "", "Path to a JSON file with client capability profiles and the User-Agents they match")
+	flag.IntVar(&cfg.MaxWidth, "max-width", 0, "Maximum output width when transcoding (0 keeps source)")
+	flag.IntVar(&cfg.MaxHeight, "max-height", 0, "Maximum output height when transcoding (0 keeps source)")
+	flag.Float64Var(&cfg.Framerate, "framerate", 0, "Output frame rate when transcoding (0 keeps source)")
+	flag.StringVar(&cfg.Deinterlace, "deinterlace", "none", "Deinterlacing when transcoding - none, yadif, or bwdif")
+	flag.StringVar(&cfg.GPUSessionLimits, "gpu-session-limits", "", "Concurrent transcode limits per GPU type or device (e.g., nvidia=5,intel:0=4)")
+	flag.DurationVar(&cfg.HardwareCooldown, "hardware-cooldown", 5*time.Minute, "How long a GPU that failed at runtime is excluded from scheduling")
 	// Buffer flags
 	flag.IntVar(&cfg.BufferSize, "buffer-size", 10, "Buffer size in MB")
 	flag.DurationVar(&cfg.BufferDuration, "buffer-duration", 10*time.Second, "Buffer duration")
 	flag.Float64Var(&cfg.BufferPrefetchRatio, "buffer-prefetch-ratio", 0.8, "Buffer prefetch ratio (0.0-1.0)")
+	// Time-shift flags
+	flag.DurationVar(&cfg.TimeshiftWindow, "timeshift-window", 0, "How much of each time-shifted channel is recorded, e.g. 30m (0 disables)")
+	flag.StringVar(&cfg.TimeshiftDir, "timeshift-dir", "", "Directory for time-shift buffers (empty keeps them in memory)")
+	// DVR flags
+	flag.StringVar(&cfg.DVRDir, "dvr-dir", "", "Directory for DVR recordings (empty disables the DVR)")
+	flag.DurationVar(&cfg.DVRPaddingBefore, "dvr-padding-before", time.Minute, "Default time recorded before a programme starts")
+	flag.DurationVar(&cfg.DVRPaddingAfter, "dvr-padding-after", 2*time.Minute, "Default time recorded after a programme ends")
+	flag.DurationVar(&cfg.DVRRetention, "dvr-retention", 0, "Delete recordings this long after they finish (0 keeps them)")
+	// Discovery flags
+	flag.StringVar(&cfg.IdentityFile, "identity-file", "", "File holding the generated device ID and UUID (default: iptv-proxy/identity.json in the user configuration directory)")
+	flag.StringVar(&cfg.Devices, "devices", "", "Path to a JSON file with virtual tuner devices, each with its own channel subset")
+	flag.StringVar(&cfg.Favorites, "favorites", "", "Comma-separated channel names or groups marked as favorites in the tuner lineup")
+	flag.BoolVar(&cfg.SSDP, "ssdp", false, "Answer SSDP discovery on UDP 1900 so media servers find the tuner")
+	flag.DurationVar(&cfg.SSDPNotifyInterval, "ssdp-notify-interval", 5*time.Minute, "Interval between SSDP alive announcements")
+	flag.BoolVar(&cfg.HDHomeRunDiscovery, "hdhomerun-discovery", false, "Answer HDHomeRun discovery on UDP 65001 for native HDHomeRun apps and Channels DVR")
 	// Test flags
 	flag.BoolVar(&cfg.EnableTestChannels, "test-channels", false, "Enable test channels")
 	flag.IntVar(&cfg.TestChannelPort, "test-port", 8889, "Port for test channel server")
+	// Health check flags
+	flag.DurationVar(&cfg.HealthCheckInterval, "health-check-interval", 0, "Interval between channel health checks (0 disables)")
+	flag.IntVar(&cfg.HealthCheckConcurrency, "health-check-concurrency", 1, "Maximum concurrent channel health checks (capped by free tuners)")
+	flag.DurationVar(&cfg.HealthCheckTimeout, "health-check-timeout", 10*time.Second, "Timeout for a single channel health check")
+	flag.BoolVar(&cfg.HealthCheckAnalyze, "health-check-analyze", false, "Probe channel codecs with ffprobe during health checks")
+	flag.IntVar(&cfg.HideDeadAfter, "hide-dead-after", 0, "Hide channels after this many consecutive failed health checks (0 disables)")
+	// Change detection flags
+	flag.StringVar(&cfg.ChangesWebhook, "changes-webhook", "", "URL that playlist and guide changes are POSTed to as JSON (empty disables)")
+	// Event notification flags
+	flag.StringVar(&cfg.EventsWebhook, "events-webhook", "", "URL that operational events are POSTed to as JSON (empty disables)")
+	flag.StringVar(&cfg.EventsNtfy, "events-ntfy", "", "ntfy topic URL that operational events are published to, e.g. https://ntfy.sh/my-topic (empty disables)")
+	flag.StringVar(&cfg.EventsNtfyToken, "events-ntfy-token", "", "Access token for the ntfy topic")
+	flag.StringVar(&cfg.EventsGotify, "events-gotify", "", "Gotify server URL that operational events are sent to (empty disables)")
+	flag.StringVar(&cfg.EventsGotifyToken, "events-gotify-token", "", "Gotify application token")
+	flag.DurationVar(&cfg.EventsDedupWindow, "events-dedup-window", 10*time.Minute, "How long a repeated event for the same subject is suppressed (0 disables)")
+	flag.IntVar(&cfg.EventsRateLimit, "events-rate-limit", 30, "Maximum events sent per minute (0 disables)")
+	// Tracing flags
+	flag.StringVar(&cfg.TraceExporter, "trace-exporter", "none", "OpenTelemetry span exporter: none, otlp, or stdout")
+	flag.StringVar(&cfg.TraceEndpoint, "trace-endpoint", "", "OTLP/HTTP collector URL, e.g. http://localhost:4318 (empty uses the OTEL_EXPORTER_OTLP_* environment variables)")
+	flag.Float64Var(&cfg.TraceSampleRatio, "trace-sample-ratio", 1.0, "Fraction of requests and refreshes traced (0.0-1.0)")
+	// Upstream flags
+	flag.StringVar(&cfg.UpstreamUserAgent, "upstream-user-agent", "", "User-Agent sent to the provider and passed to FFmpeg; empty forwards the client's User-Agent to stream upstreams and sends IPTV-Proxy/1.0 otherwise")
+	flag.StringVar(&cfg.UpstreamReferer, "upstream-referer", "", "Referer sent to the provider (empty sends none)")
+	flag.StringVar(&cfg.UpstreamCookies, "upstream-cookies", "", "Cookie header sent to the provider, e.g. \"session=abc; region=us\"")
+	flag.StringVar(&cfg.UpstreamProxy, "upstream-proxy", "", "HTTP, HTTPS or SOCKS5 proxy for upstream requests, e.g. socks5://127.0.0.1:1080; transcoding needs an HTTP proxy (empty uses HTTP_PROXY/HTTPS_PROXY)")
+	flag.BoolVar(&cfg.UpstreamInsecure, "upstream-insecure", false, "Skip TLS certificate verification of upstreams")
+	flag.StringVar(&cfg.UpstreamCAFile, "upstream-ca-file", "", "PEM file of certificate authorities trusted for upstreams in addition to the system ones")
+	flag.DurationVar(&cfg.UpstreamConnectTimeout, "upstream-connect-timeout", 10*time.Second, "Timeout for connecting to an upstream, including the TLS handshake")
+	flag.DurationVar(&cfg.UpstreamReadTimeout, "upstream-read-timeout", 30*time.Second, "How long an upstream may send no data before the request fails")
+	flag.StringVar(&cfg.ForwardHeaders, "forward-headers", "Range,If-Range,Accept", "Comma-separated client request headers forwarded to stream upstreams")
+	flag.StringVar(&cfg.Upstreams, "upstreams", "", "Path to a JSON file with per-source upstream settings for m3u, epg and stream")
 
 	flag.Parse()
 
@@ -161,17 +304,72 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("%w: %s (must be debug, info, warn, or error)", ErrInvalidLogLevel, c.LogLevel)
 	}
 
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("%w: %s (must be text or json)", ErrInvalidLogFormat, c.LogFormat)
+	}
+	if c.AccessLog != "" && c.AccessLogFormat != "combined" && c.AccessLogFormat != "json" {
+		return fmt.Errorf("%w: access log %s (must be combined or json)", ErrInvalidLogFormat, c.AccessLogFormat)
+	}
+
+	if err := c.validateHealthCheck(); err != nil {
+		return err
+	}
+
 	// Validate transcode mode
 	validTranscodeModes := map[string]bool{
 		"copy":      true,
 		"transcode": true,
+		"auto":      true,
 	}
 	if !validTranscodeModes[c.TranscodeMode] {
-		return fmt.Errorf("%w: %s (must be copy or transcode)", ErrInvalidTranscodeMode, c.TranscodeMode)
+		return fmt.Errorf("%w: %s (must be copy, transcode, or auto)", ErrInvalidTranscodeMode, c.TranscodeMode)
+	}
+
+	if c.ProbeCacheTTL <= 0 {
+		return ErrProbeCacheTTLPositive
+	}
+
+	if err := c.validateVideoFilters(); err != nil {
+		return err
+	}
+
+	if _, err := ParseSessionLimits(c.GPUSessionLimits); err != nil {
+		return err
+	}
+
+	if c.HardwareCooldown <= 0 {
+		return ErrHardwareCooldownPositive
+	}
+
+	if c.TimeshiftWindow < 0 {
+		return ErrTimeshiftWindowNegative
+	}
+
+	if c.DVRPaddingBefore < 0 || c.DVRPaddingAfter < 0 || c.DVRRetention < 0 {
+		return fmt.Errorf("%w: padding and retention must not be negative", ErrInvalidDVR)
+	}
+
+	if c.SSDP && c.SSDPNotifyInterval <= 0 {
+		return ErrSSDPNotifyIntervalPositive
+	}
+
+	if c.EventsDedupWindow < 0 || c.EventsRateLimit < 0 {
+		return fmt.Errorf("%w: dedup window and rate limit must not be negative", ErrInvalidEvents)
+	}
+	if c.EventsGotify != "" && c.EventsGotifyToken == "" {
+		return fmt.Errorf("%w: -events-gotify needs -events-gotify-token", ErrInvalidEvents)
+	}
+
+	if err := c.validateTracing(); err != nil {
+		return err
+	}
+
+	if err := c.validateUpstream(); err != nil {
+		return err
 	}
 
 	// If transcode mode is copy, we don't need to validate codecs
-	if c.TranscodeMode != "transcode" {
+	if c.TranscodeMode == "copy" {
 		return nil
 	}
 
@@ -253,15 +451,115 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// validateHealthCheck validates the channel health check settings.
+func (c *Config) validateHealthCheck() error {
+	if c.HealthCheckInterval < 0 {
+		return fmt.Errorf("%w: interval must not be negative", ErrInvalidHealthCheck)
+	}
+
+	if c.HealthCheckInterval > 0 {
+		if c.HealthCheckConcurrency < 1 {
+			return fmt.Errorf("%w: concurrency must be at least 1", ErrInvalidHealthCheck)
+		}
+		if c.HealthCheckTimeout <= 0 {
+			return fmt.Errorf("%w: timeout must be positive", ErrInvalidHealthCheck)
+		}
+	}
+
+	if c.HideDeadAfter < 0 {
+		return fmt.Errorf("%w: hide-dead-after must not be negative", ErrInvalidHealthCheck)
+	}
+
+	return nil
+}
+
+// validateTracing validates the trace exporter settings.
+func (c *Config) validateTracing() error {
+	switch c.TraceExporter {
+	case "", "none", "stdout":
+	case "otlp":
+		if c.TraceEndpoint != "" {
+			if u, err := url.Parse(c.TraceEndpoint); err != nil || u.Host == "" {
+				return fmt.Errorf("%w: trace endpoint %s (must be a URL like http://localhost:4318)", ErrInvalidTracing, c.TraceEndpoint)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: exporter %s (must be none, otlp, or stdout)", ErrInvalidTracing, c.TraceExporter)
+	}
+
+	if c.TraceSampleRatio < 0.0 || c.TraceSampleRatio > 1.0 {
+		return fmt.Errorf("%w: sample ratio must be between 0.0 and 1.0", ErrInvalidTracing)
+	}
+
+	return nil
+}
+
+// validateUpstream validates the upstream proxy and timeouts.
+func (c *Config) validateUpstream() error {
+	if c.UpstreamProxy != "" {
+		u, err := url.Parse(c.UpstreamProxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("%w: proxy %s (must be a URL like socks5://127.0.0.1:1080)", ErrInvalidUpstream, c.UpstreamProxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("%w: proxy scheme %s (must be http, https, or socks5)", ErrInvalidUpstream, u.Scheme)
+		}
+	}
+
+	if c.UpstreamConnectTimeout <= 0 || c.UpstreamReadTimeout <= 0 {
+		return fmt.Errorf("%w: timeouts must be positive", ErrInvalidUpstream)
+	}
+
+	return nil
+}
+
+// ParseForwardHeaders splits the comma-separated forwarded header names.
+func (c *Config) ParseForwardHeaders() []string {
+	headers := []string{}
+	for _, name := range strings.Split(c.ForwardHeaders, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			headers = append(headers, name)
+		}
+	}
+	return headers
+}
+
+// validateVideoFilters validates the scaling, framerate and deinterlace settings.
+func (c *Config) validateVideoFilters() error {
+	if c.MaxWidth < 0 || c.MaxHeight < 0 {
+		return fmt.Errorf("%w: resolution must not be negative", ErrInvalidVideoFilter)
+	}
+
+	if c.Framerate < 0 {
+		return fmt.Errorf("%w: framerate must not be negative", ErrInvalidVideoFilter)
+	}
+
+	switch c.Deinterlace {
+	case "", "none", "yadif", "bwdif":
+	default:
+		return fmt.Errorf("%w: deinterlace %s (must be none, yadif, or bwdif)", ErrInvalidVideoFilter, c.Deinterlace)
+	}
+
+	return nil
+}
+
 // ParseHardwareDevice parses a hardware device string like "nvidia:0" into type and ID.
 func (c *Config) ParseHardwareDevice() (deviceType string, deviceID int, err error) {
-	if c.HardwareDevice == "auto" || c.HardwareDevice == "none" {
-		return c.HardwareDevice, 0, nil
+	return ParseDevice(c.HardwareDevice)
+}
+
+// ParseDevice parses a hardware device string like "nvidia:0" into type and ID.
+// The values "auto" and "none" are returned as the type with ID 0.
+func ParseDevice(device string) (deviceType string, deviceID int, err error) {
+	if device == "auto" || device == "none" {
+		return device, 0, nil
 	}
 
-	parts := strings.Split(c.HardwareDevice, ":")
+	parts := strings.Split(device, ":")
 	if len(parts) != 2 {
-		return "", 0, fmt.Errorf("%w: %s", ErrInvalidHardwareDeviceFormat, c.HardwareDevice)
+		return "", 0, fmt.Errorf("%w: %s", ErrInvalidHardwareDeviceFormat, device)
 	}
 
 	deviceType = parts[0]
@@ -271,3 +569,34 @@ func (c *Config) ParseHardwareDevice() (deviceType string, deviceID int, err err
 
 	return deviceType, deviceID, nil
 }
+
+// ParseSessionLimits parses GPU session limits like "nvidia=5,intel:0=4" into
+// a map keyed by hardware type or "type:id". Zero means unlimited.
+func ParseSessionLimits(value string) (map[string]int, error) {
+	limits := map[string]int{}
+	if strings.TrimSpace(value) == "" {
+		return limits, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		key, limit, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSessionLimit, entry)
+		}
+
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSessionLimit, entry)
+		}
+
+		if strings.Contains(key, ":") {
+			if _, _, err := ParseDevice(key); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidSessionLimit, entry)
+			}
+		}
+
+		limits[key] = n
+	}
+
+	return limits, nil
+}
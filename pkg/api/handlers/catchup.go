@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/data"
+	"github.com/savid/iptv-proxy/pkg/epg"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/savid/iptv-proxy/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrInvalidCatchup is returned when the start or duration query parameter is invalid.
+	ErrInvalidCatchup = errors.New("invalid catch-up start or duration")
+	// ErrUnknownChannel is returned when a request names a channel that is not in the playlist.
+	ErrUnknownChannel = errors.New("unknown channel")
+)
+
+// archiveKey marks stream requests for catch-up archives, which are never
+// served through the time-shift buffers.
+type archiveKey struct{}
+
+// isArchive reports whether a stream request is for a catch-up archive.
+func isArchive(ctx context.Context) bool {
+	archive, _ := ctx.Value(archiveKey{}).(bool)
+	return archive
+}
+
+// CatchupHandler serves past programmes of channels with a provider archive
+// at /catchup/{channel}?start=...&duration=..., where channel is the encoded
+// stream URL or the channel name. The archive is streamed through the
+// stream handler like a live channel.
+func CatchupHandler(store *data.Store, stream http.Handler, logger *logrus.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/catchup/")
+		channel, err := findCatchupChannel(store, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		start, duration, err := parseCatchupRequest(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		archiveURL, err := channel.ArchiveURL(start, duration, time.Now())
+		switch {
+		case errors.Is(err, m3u.ErrNoCatchup) || errors.Is(err, m3u.ErrCatchupRange):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		logger.WithFields(logrus.Fields{
+			"channel":  channel.Name,
+			"start":    start,
+			"duration": duration,
+		}).Debug("Serving catch-up archive")
+
+		req := r.Clone(context.WithValue(r.Context(), archiveKey{}, true))
+		req.URL.Path = "/stream/" + utils.EncodeURL(archiveURL)
+		req.URL.RawPath = ""
+		req.URL.RawQuery = ""
+		stream.ServeHTTP(w, req)
+	}
+}
+
+// findCatchupChannel looks up a playlist channel by encoded URL or name.
+func findCatchupChannel(store *data.Store, id string) (m3u.Channel, error) {
+	_, channels, ok := store.GetM3U()
+	if !ok {
+		return m3u.Channel{}, fmt.Errorf("%w: playlist not loaded", ErrUnknownChannel)
+	}
+
+	target, err := utils.DecodeURL(id)
+	if err != nil {
+		target = id
+	}
+	for _, ch := range channels {
+		if ch.URL == target || ch.Name == target {
+			return ch, nil
+		}
+	}
+	return m3u.Channel{}, fmt.Errorf("%w: %s", ErrUnknownChannel, target)
+}
+
+// parseCatchupRequest reads the programme start, as Unix seconds or an
+// XMLTV timestamp, and its duration, in seconds or as a duration such as
+// "1h30m".
+func parseCatchupRequest(query url.Values) (time.Time, time.Duration, error) {
+	value := query.Get("start")
+	var start time.Time
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && len(value) < len("20060102150405") {
+		start = time.Unix(seconds, 0)
+	} else if start, err = epg.ParseTime(value); err != nil {
+		return time.Time{}, 0, fmt.Errorf("%w: start %q", ErrInvalidCatchup, value)
+	}
+
+	value = query.Get("duration")
+	duration, err := time.ParseDuration(value)
+	if seconds, parseErr := strconv.ParseInt(value, 10, 64); parseErr == nil {
+		duration, err = time.Duration(seconds)*time.Second, nil
+	}
+	if err != nil || duration <= 0 {
+		return time.Time{}, 0, fmt.Errorf("%w: duration %q", ErrInvalidCatchup, value)
+	}
+	return start, duration, nil
+}
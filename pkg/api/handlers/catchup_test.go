@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/data"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/savid/iptv-proxy/pkg/mpegts"
+	"github.com/savid/iptv-proxy/pkg/process/processtest"
+	"github.com/savid/iptv-proxy/pkg/tuner"
+	"github.com/savid/iptv-proxy/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCatchupHandler(t *testing.T) {
+	store := data.NewStore()
+	store.SetM3U(nil, []m3u.Channel{
+		{Name: "One", URL: "http://provider/live/1.ts", Catchup: m3u.CatchupShift, CatchupDays: 7},
+		{Name: "Two", URL: "http://provider/live/2.ts"},
+	})
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	var gotPath string
+	var gotArchive bool
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotArchive = isArchive(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := CatchupHandler(store, stream, logger)
+
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	startParam := start.UTC().Format("20060102150405") + " +0000"
+
+	tests := []struct {
+		name  string
+		path  string
+		query string
+		want  int
+	}{
+		{
+			name:  "by url",
+			path:  "/catchup/" + utils.EncodeURL("http://provider/live/1.ts"),
+			query: "start=" + utils.EncodeURL(startParam) + "&duration=3600",
+			want:  http.StatusOK,
+		},
+		{
+			name:  "by name with duration string",
+			path:  "/catchup/One",
+			query: "start=" + utils.EncodeURL(startParam) + "&duration=1h",
+			want:  http.StatusOK,
+		},
+		{name: "no archive", path: "/catchup/Two", query: "start=1&duration=60", want: http.StatusNotFound},
+		{name: "unknown channel", path: "/catchup/Three", query: "start=1&duration=60", want: http.StatusNotFound},
+		{name: "bad start", path: "/catchup/One", query: "start=yesterday&duration=60", want: http.StatusBadRequest},
+		{name: "bad duration", path: "/catchup/One", query: "start=1&duration=-5", want: http.StatusBadRequest},
+		{name: "too old", path: "/catchup/One", query: "start=1&duration=60", want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotArchive = "", false
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodGet, tt.path+"?"+tt.query, nil))
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			if !gotArchive {
+				t.Errorf("stream request was not marked as an archive")
+			}
+			archiveURL, err := utils.DecodeURL(gotPath[len("/stream/"):])
+			if err != nil {
+				t.Fatalf("DecodeURL() error = %v", err)
+			}
+			want := "http://provider/live/1.ts?utc=" + strconv.FormatInt(start.Unix(), 10)
+			if !strings.HasPrefix(archiveURL, want) {
+				t.Errorf("archive URL = %q, want prefix %q", archiveURL, want)
+			}
+		})
+	}
+}
+
+func TestCatchupArchiveEndsWithoutReconnect(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "video/mp2t")
+		// Flushing first leaves out the Content-Length, as archives often do
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(processtest.MPEGTS(20))
+	}))
+	defer upstream.Close()
+
+	store := data.NewStore()
+	store.SetM3U(nil, []m3u.Channel{
+		{Name: "One", URL: upstream.URL + "/live/1.ts", Catchup: m3u.CatchupShift, CatchupDays: 7},
+	})
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := CatchupHandler(store, NewStreamHandler(tuner.NewPool(1), logger), logger)
+
+	start := time.Now().Add(-2*time.Hour).UTC().Format("20060102150405") + " +0000"
+	// A reconnecting stream would replay the archive until the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/catchup/One?start="+utils.EncodeURL(start)+"&duration=3600", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Body.Len(); got != 20*mpegts.PacketSize {
+		t.Errorf("body = %d bytes, want the archive once", got)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("upstream requests = %d, want 1 for an archive that ended", hits.Load())
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/savid/iptv-proxy/pkg/dvr"
+)
+
+// DVRJobsHandler serves recording jobs at /api/dvr/jobs. GET lists the
+// jobs, POST adds one and DELETE /api/dvr/jobs/{id} removes one.
+func DVRJobsHandler(scheduler *dvr.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/dvr/jobs"), "/")
+
+		switch {
+		case r.Method == http.MethodGet && id == "":
+			writeJSON(w, http.StatusOK, scheduler.Jobs())
+
+		case r.Method == http.MethodPost && id == "":
+			var job dvr.Job
+			if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+				http.Error(w, "Invalid job JSON", http.StatusBadRequest)
+				return
+			}
+
+			created, recordings, err := scheduler.AddJob(job)
+			switch {
+			case errors.Is(err, dvr.ErrConflict):
+				writeJSON(w, http.StatusConflict, map[string]any{
+					"error":     err.Error(),
+					"conflicts": recordings,
+				})
+			case errors.Is(err, dvr.ErrInvalidJob) || errors.Is(err, dvr.ErrUnknownChannel) ||
+				errors.Is(err, dvr.ErrUnknownProgramme) || errors.Is(err, dvr.ErrNoGuide):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			default:
+				writeJSON(w, http.StatusCreated, map[string]any{
+					"job":        created,
+					"recordings": recordings,
+				})
+			}
+
+		case r.Method == http.MethodDelete && id != "":
+			if err := scheduler.DeleteJob(id); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// DVRRecordingsHandler serves recordings at /api/dvr/recordings. GET lists
+// them and DELETE /api/dvr/recordings/{id} stops, unschedules or deletes one.
+func DVRRecordingsHandler(scheduler *dvr.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/dvr/recordings"), "/")
+
+		switch {
+		case r.Method == http.MethodGet && id == "":
+			recordings := scheduler.Recordings()
+			if status := r.URL.Query().Get("status"); status != "" {
+				filtered := make([]dvr.Recording, 0, len(recordings))
+				for _, rec := range recordings {
+					if string(rec.Status) == status {
+						filtered = append(filtered, rec)
+					}
+				}
+				recordings = filtered
+			}
+			writeJSON(w, http.StatusOK, recordings)
+
+		case r.Method == http.MethodDelete && id != "":
+			if err := scheduler.DeleteRecording(id); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+	}
+}
+
+// DVRGuideHandler lists upcoming programmes with the IDs used by programme
+// jobs.
+func DVRGuideHandler(scheduler *dvr.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		listings, err := scheduler.Guide()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, http.StatusOK, listings)
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/savid/iptv-proxy/pkg/data"
+)
+
+// ChannelHealthHandler serves channel health check results at /api/health/channels.
+// The optional status query parameter filters results (ok, failing, unknown).
+func ChannelHealthHandler(store *data.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := store.ChannelHealthReport()
+
+		if status := r.URL.Query().Get("status"); status != "" {
+			filtered := make([]data.ChannelHealth, 0, len(report))
+			for _, health := range report {
+				if string(health.Status) == status {
+					filtered = append(filtered, health)
+				}
+			}
+			report = filtered
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/savid/iptv-proxy/pkg/data"
 	"github.com/savid/iptv-proxy/pkg/epg"
 	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/savid/iptv-proxy/pkg/tuner"
 	"github.com/sirupsen/logrus"
 )
 
@@ -165,7 +166,7 @@ func TestM3UHandlerWithMockServer(t *testing.T) {
 func TestStreamHandler(t *testing.T) {
 	logger := logrus.New()
 	logger.SetOutput(os.Stderr)
-	handler := NewStreamHandler(logger)
+	handler := NewStreamHandler(tuner.NewPool(2), logger)
 
 	tests := []struct {
 		name       string
@@ -33,6 +33,11 @@ func (h *M3UHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
 		return
 	}
 
+	// Re-render the playlist without channels hidden by health checks
+	if visible, hidden := h.store.VisibleChannels(); hidden {
+		data = m3u.Rewrite(visible, h.config.BaseURL)
+	}
+
 	// Convert to string for processing
 	m3uContent := string(data)
 
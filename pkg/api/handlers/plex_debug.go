@@ -5,10 +5,21 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+
+	"github.com/savid/iptv-proxy/pkg/logging"
+	"github.com/sirupsen/logrus"
 )
 
 // PlexDebugHandler provides debugging information for Plex client detection.
-func PlexDebugHandler(w http.ResponseWriter, r *http.Request) {
+func PlexDebugHandler(logger *logrus.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plexDebug(w, r, logger)
+	}
+}
+
+// plexDebug detects the Plex client of a request, logs it and writes the
+// details to the response.
+func plexDebug(w http.ResponseWriter, r *http.Request, logger *logrus.Logger) {
 	userAgent := r.Header.Get("User-Agent")
 
 	// Detect client type
@@ -36,6 +47,13 @@ func PlexDebugHandler(w http.ResponseWriter, r *http.Request) {
 		recommendedGenerator = "tv-compatible"
 	}
 
+	logging.Entry(r.Context(), logger).WithFields(logrus.Fields{
+		"user_agent": userAgent,
+		"client":     clientType,
+		"profile":    recommendedProfile,
+		"generator":  recommendedGenerator,
+	}).Info("Plex debug request")
+
 	// Write debug info
 	w.Header().Set("Content-Type", "text/plain")
 	_, _ = fmt.Fprintf(w, "Plex Client Debug Information\n")
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/savid/iptv-proxy/pkg/data"
+)
+
+// RefreshHandler serves POST /api/refresh?source=all|m3u|epg. It refreshes
+// the source immediately, joining a refresh that is already running, and
+// responds with the refresh status once it finishes.
+func RefreshHandler(refresher *data.Refresher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		source, err := data.ParseSource(r.URL.Query().Get("source"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := refresher.Refresh(r.Context(), source); err != nil {
+			if errors.Is(err, r.Context().Err()) {
+				return
+			}
+			writeJSON(w, http.StatusBadGateway, map[string]any{
+				"error":  err.Error(),
+				"status": refresher.Status(),
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, refresher.Status())
+	}
+}
+
+// RefreshStatusHandler serves the refresh history of the playlist and the
+// guide at /api/refresh/status.
+func RefreshStatusHandler(refresher *data.Refresher) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, refresher.Status())
+	}
+}
+
+// ChangesHandler serves the recent playlist and guide diffs at /api/changes,
+// newest first, optionally filtered with ?source=m3u|epg.
+func ChangesHandler(store *data.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		changes := store.Changes()
+		if source := r.URL.Query().Get("source"); source != "" {
+			filtered := make([]data.Diff, 0, len(changes))
+			for _, diff := range changes {
+				if string(diff.Source) == source {
+					filtered = append(filtered, diff)
+				}
+			}
+			changes = filtered
+		}
+		writeJSON(w, http.StatusOK, changes)
+	}
+}
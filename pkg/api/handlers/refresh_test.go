@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/data"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRefreshHandler(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/epg.xml" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("#EXTM3U\n#EXTINF:-1,One\nhttp://upstream/one\n"))
+	}))
+	defer upstream.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := &config.Config{M3UURL: upstream.URL + "/iptv.m3u", EPGURL: upstream.URL + "/epg.xml", BaseURL: "http://192.0.2.1:8080"}
+	refresher := data.NewRefresher(data.NewStore(), data.NewFetcher(cfg, logger), time.Hour, logger)
+	handler := RefreshHandler(refresher)
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		want   int
+	}{
+		{"get", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"bad source", http.MethodPost, "?source=xmltv", http.StatusBadRequest},
+		{"playlist", http.MethodPost, "?source=m3u", http.StatusOK},
+		{"guide down", http.MethodPost, "", http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(tt.method, "/api/refresh"+tt.query, nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	RefreshStatusHandler(refresher)(w, httptest.NewRequest(http.MethodGet, "/api/refresh/status", nil))
+	var status data.RefreshStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if status.M3U.Channels != 1 || status.M3U.ConsecutiveFailures != 0 || status.EPG.ConsecutiveFailures != 1 || status.EPG.LastError == "" {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestChangesHandler(t *testing.T) {
+	store := data.NewStore()
+	store.SetM3U(nil, []m3u.Channel{{Name: "One", URL: "http://upstream/1"}})
+	store.SetM3U(nil, []m3u.Channel{{Name: "Two", URL: "http://upstream/2"}})
+
+	for query, want := range map[string]int{"": 1, "?source=m3u": 1, "?source=epg": 0} {
+		w := httptest.NewRecorder()
+		ChangesHandler(store)(w, httptest.NewRequest(http.MethodGet, "/api/changes"+query, nil))
+		var diffs []data.Diff
+		if err := json.NewDecoder(w.Body).Decode(&diffs); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if len(diffs) != want {
+			t.Errorf("/api/changes%s = %d diffs, want %d", query, len(diffs), want)
+		}
+	}
+}
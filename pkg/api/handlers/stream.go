@@ -2,11 +2,17 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"strings"
 
+	"github.com/savid/iptv-proxy/pkg/events"
+	"github.com/savid/iptv-proxy/pkg/logging"
 	"github.com/savid/iptv-proxy/pkg/streaming/proxy"
+	"github.com/savid/iptv-proxy/pkg/timeshift"
+	"github.com/savid/iptv-proxy/pkg/tuner"
+	"github.com/savid/iptv-proxy/pkg/upstream"
 	"github.com/savid/iptv-proxy/pkg/utils"
 	"github.com/sirupsen/logrus"
 )
@@ -20,37 +26,91 @@ var (
 
 // StreamHandler handles HTTP requests to proxy IPTV streams.
 type StreamHandler struct {
-	logger *logrus.Logger
+	streamer  *proxy.CopyStreamer
+	timeshift *timeshift.Manager
+	tuners    *tuner.Pool
+	events    *events.Bus
+	logger    *logrus.Logger
 }
 
 // NewStreamHandler creates a new stream handler instance.
-func NewStreamHandler(logger *logrus.Logger) *StreamHandler {
+func NewStreamHandler(tuners *tuner.Pool, logger *logrus.Logger) *StreamHandler {
 	return &StreamHandler{
-		logger: logger,
+		streamer: proxy.NewCopyStreamer(logger),
+		tuners:   tuners,
+		logger:   logger,
+	}
+}
+
+// SetTimeshift serves live requests with an offset or position parameter
+// from the time-shift buffers of manager.
+func (h *StreamHandler) SetTimeshift(manager *timeshift.Manager) {
+	h.timeshift = manager
+}
+
+// SetUpstream sets the client used to request upstream streams.
+func (h *StreamHandler) SetUpstream(client *upstream.Client) {
+	h.streamer.SetUpstream(client)
+}
+
+// SetEvents publishes an event to bus when a stream fails.
+func (h *StreamHandler) SetEvents(bus *events.Bus) {
+	h.events = bus
+}
+
+// SessionsHandler serves the active copy sessions and their MPEG-TS packet
+// statistics at /api/sessions.
+func (h *StreamHandler) SessionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(h.streamer.Sessions()); err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
 	}
 }
 
 func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := logging.Entry(r.Context(), h.logger)
+
 	encodedURL, err := extractEncodedURL(r.URL.Path)
 	if err != nil {
-		h.logger.WithError(err).Error("Failed to extract URL from path")
+		logger.WithError(err).Error("Failed to extract URL from path")
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
 	targetURL, err := utils.DecodeURL(encodedURL)
 	if err != nil {
-		h.logger.WithError(err).Error("Failed to decode URL")
+		logger.WithError(err).Error("Failed to decode URL")
 		http.Error(w, "Invalid URL", http.StatusBadRequest)
 		return
 	}
 
-	h.logger.WithField("url", targetURL).Debug("Proxying stream")
+	logger.WithField("url", targetURL).Debug("Proxying stream")
+
+	// Time-shifted requests are served from the recording, which holds the tuner
+	if h.timeshift != nil && !isArchive(r.Context()) && wantsTimeshift(r.URL.Query()) {
+		if err := serveTimeshift(w, r, h.timeshift, targetURL); err != nil {
+			logger.WithError(err).Error("Failed to stream from time-shift buffer")
+		}
+		return
+	}
 
-	if err := proxy.Stream(w, r, targetURL); err != nil {
+	lease := h.tuners.AcquireContext(r.Context(), tuner.PurposeStream)
+	defer lease.Release()
+
+	stream := h.streamer.Stream
+	if isArchive(r.Context()) {
+		stream = h.streamer.StreamArchive
+	}
+	if err := stream(w, r, targetURL); err != nil {
 		// Don't log context canceled errors - these are normal when clients disconnect
 		if !errors.Is(err, context.Canceled) {
-			h.logger.WithError(err).Error("Failed to proxy stream")
+			logger.WithError(err).Error("Failed to proxy stream")
+			h.events.Publish(streamFailed(targetURL, err))
 		}
 		// If we haven't written headers yet, we can send an error response
 		// This typically happens for validation errors before the stream starts
@@ -64,6 +124,15 @@ func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// streamFailed describes a failed stream as an event.
+func streamFailed(targetURL string, err error) events.Event {
+	return events.Event{
+		Type:    events.TypeStreamFailed,
+		Subject: targetURL,
+		Message: err.Error(),
+	}
+}
+
 func extractEncodedURL(path string) (string, error) {
 	prefix := "/stream/"
 	if !strings.HasPrefix(path, prefix) {
@@ -2,16 +2,27 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/data"
+	"github.com/savid/iptv-proxy/pkg/events"
+	"github.com/savid/iptv-proxy/pkg/logging"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/savid/iptv-proxy/pkg/process"
 	"github.com/savid/iptv-proxy/pkg/streaming/proxy"
 	"github.com/savid/iptv-proxy/pkg/streaming/transcode"
+	"github.com/savid/iptv-proxy/pkg/timeshift"
+	"github.com/savid/iptv-proxy/pkg/tuner"
+	"github.com/savid/iptv-proxy/pkg/upstream"
 	"github.com/savid/iptv-proxy/pkg/utils"
+	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -23,7 +34,11 @@ const (
 // StreamV2Handler handles streaming requests with transcoding support.
 type StreamV2Handler struct {
 	transcoder *proxy.StreamTranscoder
-	logger     *log.Logger
+	timeshift  *timeshift.Manager
+	store      *data.Store
+	tuners     *tuner.Pool
+	events     *events.Bus
+	logger     *logrus.Logger
 }
 
 // getVideoBitrate returns the video bitrate based on quality settings.
@@ -43,45 +58,86 @@ func getAudioBitrate(cfg *config.Config, mapper *transcode.QualityMapper) string
 }
 
 // NewStreamV2Handler creates a new stream handler with transcoding support.
-func NewStreamV2Handler(cfg *config.Config, logger *log.Logger) (*StreamV2Handler, error) {
+func NewStreamV2Handler(cfg *config.Config, store *data.Store, tuners *tuner.Pool, logger *logrus.Logger) (*StreamV2Handler, error) {
+	return NewStreamV2HandlerWithRunner(cfg, store, tuners, process.NewExecRunner(), logger)
+}
+
+// NewStreamV2HandlerWithRunner creates a stream handler that starts ffmpeg and
+// ffprobe through the given runner.
+func NewStreamV2HandlerWithRunner(
+	cfg *config.Config,
+	store *data.Store,
+	tuners *tuner.Pool,
+	runner process.Runner,
+	logger *logrus.Logger,
+) (*StreamV2Handler, error) {
 	// Create quality mapper
 	qualityMapper := transcode.NewQualityMapper()
 
-	// Determine video and audio codecs based on transcode mode
-	videoCodec := cfg.VideoCodec
-	audioCodec := cfg.AudioCodec
-	videoBitrate := ""
-	audioBitrate := ""
+	// Codecs and bitrates are resolved even in copy mode so that per-channel
+	// profiles can switch individual channels to transcoding
+	videoBitrate := getVideoBitrate(cfg, qualityMapper)
+	audioBitrate := getAudioBitrate(cfg, qualityMapper)
 
-	if cfg.TranscodeMode == codecCopy {
-		videoCodec = codecCopy
-		audioCodec = codecCopy
-	} else {
-		videoBitrate = getVideoBitrate(cfg, qualityMapper)
-		audioBitrate = getAudioBitrate(cfg, qualityMapper)
+	// Load per-channel transcoding rules
+	var rules *transcode.RuleSet
+	if cfg.TranscodeRules != "" {
+		var err error
+		rules, err = transcode.LoadRuleSet(cfg.TranscodeRules)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load transcode rules: %w", err)
+		}
+		logger.Infof("Loaded %d transcoding profiles and %d rules", len(rules.Profiles), len(rules.Rules))
+	}
+
+	// Load client capability profiles
+	clients := transcode.DefaultClientProfiles()
+	if cfg.ClientProfiles != "" {
+		var err error
+		clients, err = transcode.LoadClientProfiles(cfg.ClientProfiles)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client profiles: %w", err)
+		}
+		logger.Infof("Loaded %d client profiles", len(clients.Profiles))
 	}
 
-	// Parse hardware device
+	// Parse hardware device; specific devices are pinned by the scheduler
 	hardwareAccel := modeAuto
 	if cfg.HardwareDevice == modeNone {
 		hardwareAccel = modeNone
-	} else if cfg.HardwareDevice != modeAuto {
-		// For specific devices, still use auto and let the selector handle it
-		hardwareAccel = modeAuto
+	}
+
+	sessionLimits, err := config.ParseSessionLimits(cfg.GPUSessionLimits)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create transcoder configuration
 	transcoderConfig := &proxy.TranscoderConfig{
-		VideoCodec:          videoCodec,
-		AudioCodec:          audioCodec,
+		Mode:                cfg.TranscodeMode,
+		VideoCodec:          cfg.VideoCodec,
+		AudioCodec:          cfg.AudioCodec,
+		VideoQuality:        cfg.VideoQuality,
+		AudioQuality:        cfg.AudioQuality,
 		VideoBitrate:        videoBitrate,
 		AudioBitrate:        audioBitrate,
 		HardwareAccel:       hardwareAccel,
+		HardwareDevice:      cfg.HardwareDevice,
+		SessionLimits:       sessionLimits,
+		HardwareCooldown:    cfg.HardwareCooldown,
+		Rules:               rules,
+		Clients:             clients,
+		MaxWidth:            cfg.MaxWidth,
+		MaxHeight:           cfg.MaxHeight,
+		Framerate:           cfg.Framerate,
+		Deinterlace:         cfg.Deinterlace,
 		BufferSize:          cfg.BufferSize * 1024 * 1024, // Convert MB to bytes
 		BufferPrefetchRatio: cfg.BufferPrefetchRatio,
 		MinThreshold:        64 * 1024, // 64KB
 		MaxRetries:          3,
 		RetryDelay:          time.Second,
+		ProbeCacheTTL:       cfg.ProbeCacheTTL,
+		Runner:              runner,
 	}
 
 	// Create transcoder
@@ -89,9 +145,12 @@ func NewStreamV2Handler(cfg *config.Config, logger *log.Logger) (*StreamV2Handle
 	if err != nil {
 		return nil, fmt.Errorf("failed to create transcoder: %w", err)
 	}
+	transcoder.SetTuners(tuners)
 
 	return &StreamV2Handler{
 		transcoder: transcoder,
+		store:      store,
+		tuners:     tuners,
 		logger:     logger,
 	}, nil
 }
@@ -123,11 +182,108 @@ func (h *StreamV2Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		targetURL = decodedURL
 	}
 
-	h.logger.Printf("Streaming request - url: %s", targetURL)
+	logger := logging.Entry(r.Context(), h.logger)
+	logger.Infof("Streaming request - url: %s", targetURL)
+
+	// Time-shifted requests are served from the recorded source stream,
+	// whose recording holds the tuner
+	if h.timeshift != nil && wantsTimeshift(r.URL.Query()) {
+		if err := serveTimeshift(w, r, h.timeshift, targetURL); err != nil {
+			logger.WithError(err).Error("Failed to stream from time-shift buffer")
+		}
+		return
+	}
+
+	lease := h.tuners.AcquireContext(r.Context(), tuner.PurposeStream)
+	defer lease.Release()
+	// The transcoder hands the lease back before probing the source. A nil
+	// lease keeps it from releasing a tuner held by the caller of the request
+	r = r.WithContext(tuner.NewContext(r.Context(), lease))
 
 	// Stream with transcoding
-	if err := h.transcoder.TranscodeStream(w, r, targetURL); err != nil {
-		h.logger.Printf("Stream error: %v", err)
-		// Don't write error to response as headers may already be sent
+	if err := h.transcoder.TranscodeStream(w, r, targetURL, h.lookupChannel(targetURL)); err != nil {
+		// Clients disconnecting cancel the stream, which is not a failure
+		if errors.Is(err, context.Canceled) {
+			logger.WithError(err).Info("Stream ended by the client")
+		} else {
+			logger.WithError(err).Error("Failed to transcode stream")
+			h.events.Publish(streamFailed(targetURL, err))
+		}
+		switch {
+		case errors.Is(err, transcode.ErrUnknownProfile) || errors.Is(err, transcode.ErrUnknownClientProfile):
+			// Profile is resolved before any output is written
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, transcode.ErrStartupFailed):
+			// FFmpeg failed on every device before any output was written
+			http.Error(w, "Transcoder failed to start", http.StatusBadGateway)
+		}
+		// Otherwise don't write error to response as headers may already be sent
+	}
+}
+
+// SetTimeshift serves requests with an offset or position parameter from
+// the time-shift buffers of manager instead of transcoding them.
+func (h *StreamV2Handler) SetTimeshift(manager *timeshift.Manager) {
+	h.timeshift = manager
+}
+
+// SetUpstream sets the client whose headers and proxy FFmpeg and ffprobe use
+// for upstream streams.
+func (h *StreamV2Handler) SetUpstream(client *upstream.Client) {
+	h.transcoder.SetUpstream(client)
+}
+
+// SetEvents publishes an event to bus when a stream fails or falls back to
+// another device.
+func (h *StreamV2Handler) SetEvents(bus *events.Bus) {
+	h.events = bus
+	h.transcoder.SetEvents(bus)
+}
+
+// SessionsHandler serves the active transcoding sessions and their copy or
+// transcode decisions at /api/sessions.
+func (h *StreamV2Handler) SessionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(h.transcoder.Sessions()); err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
 	}
 }
+
+// HardwareHandler serves the active sessions and session limits of each
+// hardware device at /api/hardware.
+func (h *StreamV2Handler) HardwareHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(h.transcoder.HardwareLoad()); err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// lookupChannel finds the playlist channel for an upstream URL.
+func (h *StreamV2Handler) lookupChannel(targetURL string) *m3u.Channel {
+	if h.store == nil {
+		return nil
+	}
+
+	_, channels, ok := h.store.GetM3U()
+	if !ok {
+		return nil
+	}
+
+	for i := range channels {
+		if channels[i].URL == targetURL {
+			return &channels[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,576 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/data"
+	"github.com/savid/iptv-proxy/pkg/process/processtest"
+	"github.com/savid/iptv-proxy/pkg/tracing/tracingtest"
+	"github.com/savid/iptv-proxy/pkg/tuner"
+	"github.com/savid/iptv-proxy/pkg/types"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+const upstreamURL = "http://upstream.example/live/1.ts"
+
+// newTestStreamV2Server starts a StreamV2Handler backed by the fake runner.
+func newTestStreamV2Server(t *testing.T, runner *processtest.Runner) (*httptest.Server, *tuner.Pool) {
+	t.Helper()
+	return newTestStreamV2ServerMode(t, runner, "transcode")
+}
+
+// newTestStreamV2ServerMode starts a StreamV2Handler in the given transcode
+// mode backed by the fake runner.
+func newTestStreamV2ServerMode(t *testing.T, runner *processtest.Runner, mode string) (*httptest.Server, *tuner.Pool) {
+	t.Helper()
+
+	tuners := tuner.NewPool(2)
+	handler, err := NewStreamV2HandlerWithRunner(testStreamV2Config(mode), data.NewStore(), tuners, runner, discardLogger())
+	if err != nil {
+		t.Fatalf("NewStreamV2HandlerWithRunner() error = %v", err)
+	}
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server, tuners
+}
+
+// discardLogger returns a logger that writes nowhere.
+func discardLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// testStreamV2Config returns the configuration of the test handlers.
+func testStreamV2Config(mode string) *config.Config {
+	return &config.Config{
+		TranscodeMode:       mode,
+		VideoCodec:          "h264",
+		AudioCodec:          "aac",
+		VideoQuality:        "medium",
+		AudioQuality:        "medium",
+		HardwareDevice:      modeAuto,
+		BufferSize:          1,
+		BufferPrefetchRatio: 0.8,
+		ProbeCacheTTL:       time.Hour,
+		HardwareCooldown:    time.Minute,
+	}
+}
+
+// fakeTools scripts the probes and GPU tools; encodes are delegated to encode.
+func fakeTools(nvidia bool, encode func(call processtest.Call) processtest.Script) processtest.HandlerFunc {
+	return func(call processtest.Call) processtest.Script {
+		switch {
+		case call.Name == "ffprobe":
+			return processtest.Script{Stdout: processtest.ProbeJSON("h264", "aac")}
+		case call.Name == "nvidia-smi" && nvidia:
+			return processtest.Script{Stdout: []byte("0, Tesla T4, GPU-0000\n")}
+		case processtest.IsEncode(call):
+			return encode(call)
+		case call.Name == "ffmpeg":
+			// Hardware capability tests succeed for detected devices
+			return processtest.Script{}
+		default:
+			return processtest.Script{Stderr: call.Name + ": command not found", ExitCode: 127}
+		}
+	}
+}
+
+// waitForTuners waits for the handler to release its tuner lease.
+func waitForTuners(t *testing.T, tuners *tuner.Pool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tuners.InUse() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("tuners in use = %d, want 0", tuners.InUse())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamV2HandlerStreamsTranscodedOutput(t *testing.T) {
+	output := processtest.MPEGTS(100)
+	runner := processtest.NewRunner(fakeTools(false, func(processtest.Call) processtest.Script {
+		return processtest.Script{Stdout: output}
+	}))
+	server, tuners := newTestStreamV2Server(t, runner)
+
+	resp, err := http.Get(server.URL + "/stream/" + upstreamURL)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if !bytes.Equal(body, output) {
+		t.Errorf("body length = %d, want %d identical bytes", len(body), len(output))
+	}
+	if got := resp.Header.Get("X-Hardware-Acceleration"); got != "cpu" {
+		t.Errorf("X-Hardware-Acceleration = %q, want cpu", got)
+	}
+
+	encodes := 0
+	for _, call := range runner.Calls("ffmpeg") {
+		if processtest.IsEncode(call) {
+			encodes++
+			if call.Arg("-i") != upstreamURL {
+				t.Errorf("ffmpeg input = %q, want %q", call.Arg("-i"), upstreamURL)
+			}
+			if call.Arg("-c:v") != "libx264" {
+				t.Errorf("ffmpeg video encoder = %q, want libx264", call.Arg("-c:v"))
+			}
+		}
+	}
+	if encodes != 1 {
+		t.Errorf("encodes = %d, want 1", encodes)
+	}
+
+	if !runner.WaitIdle(5 * time.Second) {
+		t.Errorf("ffmpeg was not reaped, %d still running", runner.Running())
+	}
+	waitForTuners(t, tuners)
+}
+
+func TestStreamV2HandlerStartupFailure(t *testing.T) {
+	tests := []struct {
+		name        string
+		nvidia      bool
+		encode      func(call processtest.Call) processtest.Script
+		wantStatus  int
+		wantEncodes int
+		wantHW      string
+	}{
+		{
+			name:   "gpu out of sessions restarts on cpu",
+			nvidia: true,
+			encode: func(call processtest.Call) processtest.Script {
+				if call.Arg("-c:v") == "h264_nvenc" {
+					return processtest.Script{
+						Stderr:   "[h264_nvenc] OpenEncodeSessionEx failed: out of memory (10)\n",
+						ExitCode: 1,
+					}
+				}
+				return processtest.Script{Stdout: processtest.MPEGTS(10)}
+			},
+			wantStatus:  http.StatusOK,
+			wantEncodes: 2,
+			wantHW:      "cpu",
+		},
+		{
+			name: "input error is not retried",
+			encode: func(processtest.Call) processtest.Script {
+				return processtest.Script{
+					Stderr:   upstreamURL + ": Server returned 404 Not Found\n",
+					ExitCode: 1,
+				}
+			},
+			wantStatus:  http.StatusBadGateway,
+			wantEncodes: 1,
+		},
+		{
+			name: "cpu failure returns bad gateway",
+			encode: func(processtest.Call) processtest.Script {
+				return processtest.Script{Stderr: "Conversion failed!\n", ExitCode: 1}
+			},
+			wantStatus:  http.StatusBadGateway,
+			wantEncodes: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := processtest.NewRunner(fakeTools(tt.nvidia, tt.encode))
+			server, tuners := newTestStreamV2Server(t, runner)
+
+			resp, err := http.Get(server.URL + "/stream/" + upstreamURL)
+			if err != nil {
+				t.Fatalf("GET error = %v", err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantHW != "" && resp.Header.Get("X-Hardware-Acceleration") != tt.wantHW {
+				t.Errorf("X-Hardware-Acceleration = %q, want %q", resp.Header.Get("X-Hardware-Acceleration"), tt.wantHW)
+			}
+
+			encodes := 0
+			for _, call := range runner.Calls("ffmpeg") {
+				if processtest.IsEncode(call) {
+					encodes++
+				}
+			}
+			if encodes != tt.wantEncodes {
+				t.Errorf("encodes = %d, want %d", encodes, tt.wantEncodes)
+			}
+
+			if !runner.WaitIdle(5 * time.Second) {
+				t.Errorf("ffmpeg was not reaped, %d still running", runner.Running())
+			}
+			waitForTuners(t, tuners)
+		})
+	}
+}
+
+func TestStreamV2HandlerLogsFailuresAsErrors(t *testing.T) {
+	runner := processtest.NewRunner(fakeTools(false, func(processtest.Call) processtest.Script {
+		return processtest.Script{Stderr: "[error] " + upstreamURL + ": Server returned 404 Not Found\n", ExitCode: 1}
+	}))
+	logger, hook := logtest.NewNullLogger()
+	handler, err := NewStreamV2HandlerWithRunner(testStreamV2Config("transcode"), data.NewStore(), tuner.NewPool(1), runner, logger)
+	if err != nil {
+		t.Fatalf("NewStreamV2HandlerWithRunner() error = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/"+upstreamURL, nil))
+
+	levels := make(map[string]logrus.Level)
+	for _, entry := range hook.AllEntries() {
+		levels[entry.Message] = entry.Level
+	}
+	if level, ok := levels["Failed to transcode stream"]; !ok || level != logrus.ErrorLevel {
+		t.Errorf("stream failure logged at %v (found %v), want error", level, ok)
+	}
+	if level := levels["FFmpeg: "+upstreamURL+": Server returned 404 Not Found"]; level != logrus.ErrorLevel {
+		t.Errorf("FFmpeg error logged at %v, want error", level)
+	}
+	if level := levels["Streaming request - url: "+upstreamURL]; level != logrus.InfoLevel {
+		t.Errorf("request logged at %v, want info", level)
+	}
+}
+
+func TestStreamV2HandlerMidStreamCrash(t *testing.T) {
+	output := processtest.MPEGTS(50)
+	runner := processtest.NewRunner(fakeTools(false, func(processtest.Call) processtest.Script {
+		return processtest.Script{
+			Stdout:   output,
+			Stderr:   "Error while decoding stream #0:0: Invalid data found when processing input\n",
+			ExitCode: 1,
+		}
+	}))
+	server, tuners := newTestStreamV2Server(t, runner)
+
+	resp, err := http.Get(server.URL + "/stream/" + upstreamURL)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	// Headers were sent with the first output, so the crash ends the body
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if !bytes.Equal(body, output) {
+		t.Errorf("body length = %d, want the %d bytes written before the crash", len(body), len(output))
+	}
+
+	if !runner.WaitIdle(5 * time.Second) {
+		t.Errorf("ffmpeg was not reaped, %d still running", runner.Running())
+	}
+	waitForTuners(t, tuners)
+}
+
+func TestStreamV2HandlerClientDisconnect(t *testing.T) {
+	runner := processtest.NewRunner(fakeTools(false, func(processtest.Call) processtest.Script {
+		// More than the buffer read threshold, then stay live until killed
+		return processtest.Script{Stdout: processtest.MPEGTS(1000), Hold: true}
+	}))
+	server, tuners := newTestStreamV2Server(t, runner)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/stream/"+upstreamURL, nil)
+	if err != nil {
+		t.Fatalf("NewRequest error = %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if _, err := io.ReadFull(resp.Body, make([]byte, 10*processtest.PacketSize)); err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+
+	if runner.Running() != 1 {
+		t.Fatalf("running = %d, want 1 while the client is connected", runner.Running())
+	}
+
+	cancel()
+	_ = resp.Body.Close()
+
+	if !runner.WaitIdle(5 * time.Second) {
+		t.Fatalf("ffmpeg was not stopped, %d still running", runner.Running())
+	}
+	if runner.Killed() != 1 {
+		t.Errorf("killed = %d, want 1", runner.Killed())
+	}
+	waitForTuners(t, tuners)
+}
+
+func TestStreamV2HandlerTracesTuneIn(t *testing.T) {
+	exporter := tracingtest.Record(t)
+
+	runner := processtest.NewRunner(fakeTools(false, func(processtest.Call) processtest.Script {
+		return processtest.Script{Stdout: processtest.MPEGTS(100)}
+	}))
+	server, tuners := newTestStreamV2Server(t, runner)
+
+	resp, err := http.Get(server.URL + "/stream/" + upstreamURL)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	waitForTuners(t, tuners)
+
+	stream, ok := tracingtest.Find(exporter, "proxy.TranscodeStream")
+	if !ok {
+		t.Fatalf("spans = %v, want proxy.TranscodeStream", tracingtest.Names(exporter))
+	}
+	for _, name := range []string{"transcode.Start", "buffer.MinThreshold"} {
+		span, ok := tracingtest.Find(exporter, name)
+		if !ok {
+			t.Errorf("spans = %v, missing %s", tracingtest.Names(exporter), name)
+			continue
+		}
+		if span.Parent.SpanID() != stream.SpanContext.SpanID() {
+			t.Errorf("%s is not a child of proxy.TranscodeStream", name)
+		}
+	}
+
+	start, _ := tracingtest.Find(exporter, "transcode.Start")
+	for _, name := range []string{"ffmpeg.Spawn", "ffmpeg.FirstOutput"} {
+		span, ok := tracingtest.Find(exporter, name)
+		if !ok || span.Parent.SpanID() != start.SpanContext.SpanID() {
+			t.Errorf("%s is not a child of transcode.Start", name)
+		}
+	}
+}
+
+func TestStreamV2HandlerTunesWithoutProbing(t *testing.T) {
+	runner := processtest.NewRunner(fakeTools(false, func(processtest.Call) processtest.Script {
+		return processtest.Script{Stdout: processtest.MPEGTS(100), Stderr: processtest.InputDump("h264", "aac")}
+	}))
+	server, tuners := newTestStreamV2ServerMode(t, runner, modeAuto)
+
+	tune := func() string {
+		t.Helper()
+		resp, err := http.Get(server.URL + "/stream/" + upstreamURL)
+		if err != nil {
+			t.Fatalf("GET error = %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		waitForTuners(t, tuners)
+		return resp.Header.Get("X-Transcode-Decision")
+	}
+
+	// The first tune starts FFmpeg at once and transcodes the unknown source
+	if got, want := tune(), "video=h264(unknown) audio=aac(unknown)"; got != want {
+		t.Errorf("first decision = %q, want %q", got, want)
+	}
+	calls := runner.Calls("")
+	encode := slices.IndexFunc(calls, processtest.IsEncode)
+	probe := slices.IndexFunc(calls, func(call processtest.Call) bool { return call.Name == "ffprobe" })
+	if encode < 0 || (probe >= 0 && probe < encode) {
+		t.Fatalf("ffprobe ran before FFmpeg started: %v", calls)
+	}
+
+	// The channel is probed in the background once the session has ended
+	deadline := time.Now().Add(5 * time.Second)
+	for len(runner.Calls("ffprobe")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("channel was not probed after the session")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Later tunes copy the known source without probing it again
+	if got, want := tune(), "video=copy(h264) audio=copy(aac)"; got != want {
+		t.Errorf("second decision = %q, want %q", got, want)
+	}
+	if probes := len(runner.Calls("ffprobe")); probes != 1 {
+		t.Errorf("ffprobe calls = %d, want 1", probes)
+	}
+}
+
+func TestStreamV2HandlerProbesOnlyWithSpareTuner(t *testing.T) {
+	probing := make(chan struct{})
+	tools := fakeTools(false, func(processtest.Call) processtest.Script {
+		return processtest.Script{Stdout: processtest.MPEGTS(100)}
+	})
+	runner := processtest.NewRunner(func(call processtest.Call) processtest.Script {
+		if call.Name == "ffprobe" {
+			<-probing
+		}
+		return tools(call)
+	})
+	tuners := tuner.NewPool(1)
+	handler, err := NewStreamV2HandlerWithRunner(testStreamV2Config(modeAuto), data.NewStore(), tuners, runner, discardLogger())
+	if err != nil {
+		t.Fatalf("NewStreamV2HandlerWithRunner() error = %v", err)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	tune := func() {
+		t.Helper()
+		resp, err := http.Get(server.URL + "/stream/" + upstreamURL)
+		if err != nil {
+			t.Fatalf("GET error = %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	// A recording holds the only tuner, so the channel is not probed
+	recording := tuners.Acquire(tuner.PurposeRecording)
+	tune()
+	time.Sleep(100 * time.Millisecond)
+	if probes := len(runner.Calls("ffprobe")); probes != 0 {
+		t.Fatalf("ffprobe calls with no spare tuner = %d, want 0", probes)
+	}
+	recording.Release()
+
+	// The session's own tuner is handed to the probe once it has ended
+	tune()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(runner.Calls("ffprobe")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("channel was not probed after the tuner was freed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := tuners.InUseFor(tuner.PurposeProbe); got != 1 {
+		t.Errorf("probe tuners in use = %d, want 1", got)
+	}
+	if got := tuners.InUseFor(tuner.PurposeHealthCheck); got != 0 {
+		t.Errorf("health check tuners in use = %d, want 0", got)
+	}
+	close(probing)
+	waitForTuners(t, tuners)
+}
+
+func TestStreamV2HandlerReportsBytesMidStream(t *testing.T) {
+	runner := processtest.NewRunner(fakeTools(false, func(processtest.Call) processtest.Script {
+		return processtest.Script{Stdout: processtest.MPEGTS(1000), Interval: time.Millisecond, Hold: true}
+	}))
+	handler, err := NewStreamV2HandlerWithRunner(testStreamV2Config("transcode"), data.NewStore(), tuner.NewPool(2), runner, discardLogger())
+	if err != nil {
+		t.Fatalf("NewStreamV2HandlerWithRunner() error = %v", err)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream/" + upstreamURL)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if _, err := io.ReadFull(resp.Body, make([]byte, 100*1024)); err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+
+	// The session reports its bytes while FFmpeg is still running
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		handler.SessionsHandler()(rec, httptest.NewRequest(http.MethodGet, "/api/sessions", nil))
+		var sessions []types.TranscodeSession
+		if err := json.Unmarshal(rec.Body.Bytes(), &sessions); err != nil {
+			t.Fatalf("sessions JSON error = %v", err)
+		}
+		if len(sessions) != 1 {
+			t.Fatalf("sessions = %d, want 1", len(sessions))
+		}
+		if sessions[0].BytesWritten >= 100*1024 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("bytes_written = %d mid-stream, want at least %d", sessions[0].BytesWritten, 100*1024)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamV2HandlerConcurrentSessions(t *testing.T) {
+	output := processtest.MPEGTS(2000)
+	runner := processtest.NewRunner(fakeTools(false, func(processtest.Call) processtest.Script {
+		return processtest.Script{Stdout: output, Interval: time.Millisecond}
+	}))
+	tuners := tuner.NewPool(3)
+	handler, err := NewStreamV2HandlerWithRunner(testStreamV2Config("transcode"), data.NewStore(), tuners, runner, discardLogger())
+	if err != nil {
+		t.Fatalf("NewStreamV2HandlerWithRunner() error = %v", err)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// Streams run next to each other while the sessions and hardware APIs
+	// are polled, so that go test -race covers the shared buffer and
+	// session state
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(server.URL + "/stream/" + upstreamURL)
+			if err != nil {
+				t.Errorf("GET error = %v", err)
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if !bytes.Equal(body, output) {
+				t.Errorf("body length = %d, want %d identical bytes", len(body), len(output))
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-done:
+			if !runner.WaitIdle(5 * time.Second) {
+				t.Errorf("ffmpeg was not reaped, %d still running", runner.Running())
+			}
+			waitForTuners(t, tuners)
+			return
+		default:
+		}
+		handler.SessionsHandler()(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/sessions", nil))
+		handler.HardwareHandler()(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/hardware", nil))
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -4,19 +4,31 @@ package handlers
 import (
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strings"
 
+	"github.com/savid/iptv-proxy/pkg/logging"
 	"github.com/savid/iptv-proxy/pkg/testchannels"
+	"github.com/sirupsen/logrus"
 )
 
 // TestChannelHandler handles requests for test channel streams.
-func TestChannelHandler(w http.ResponseWriter, r *http.Request) {
+func TestChannelHandler(logger *logrus.Logger) http.HandlerFunc {
+	stdLogger := log.New(logger.Writer(), "", 0)
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveTestChannel(w, r, logging.Entry(r.Context(), logger), logging.StdLogger(r.Context(), stdLogger))
+	}
+}
+
+// serveTestChannel streams the generated test channel of a request. FFmpeg
+// output is written to ffmpegLogger.
+func serveTestChannel(w http.ResponseWriter, r *http.Request, logger *logrus.Entry, ffmpegLogger *log.Logger) {
 	// Extract channel index from URL path
 	// Expected format: /test/{index}
 	var index int
 	if _, err := fmt.Sscanf(r.URL.Path, "/test/%d", &index); err != nil {
-		fmt.Printf("TestChannelHandler: Invalid path format: %s\n", r.URL.Path)
+		logger.WithField("path", r.URL.Path).Warn("Invalid test channel path")
 		http.Error(w, "Invalid test channel ID", http.StatusBadRequest)
 		return
 	}
@@ -24,12 +36,14 @@ func TestChannelHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the test profile
 	profile, ok := testchannels.GetTestProfileByIndex(index)
 	if !ok {
-		fmt.Printf("TestChannelHandler: Channel index %d not found\n", index)
+		logger.WithField("index", index).Warn("Test channel not found")
 		http.Error(w, "Test channel not found", http.StatusNotFound)
 		return
 	}
 
-	fmt.Printf("TestChannelHandler: Starting stream for channel %d (%s)\n", index, profile.Name)
+	logger = logger.WithFields(logrus.Fields{"index": index, "channel": profile.Name})
+	logging.AddFields(r.Context(), logrus.Fields{"channel": profile.Name})
+	logger.Info("Starting test channel stream")
 
 	// Detect client type from User-Agent
 	userAgent := r.Header.Get("User-Agent")
@@ -44,24 +58,26 @@ func TestChannelHandler(w http.ResponseWriter, r *http.Request) {
 	if isWebTV {
 		// Use TV-compatible generator for Web/TV clients
 		generator := testchannels.NewTVCompatibleGenerator()
+		generator.SetLogger(ffmpegLogger)
 		stream, err = generator.GenerateStream(profile)
-		fmt.Printf("TestChannelHandler: Using TV-compatible generator for channel %d (User-Agent: %s)\n", index, userAgent)
+		logger.WithField("user_agent", userAgent).Debug("Using TV-compatible generator")
 	} else {
 		// Use standard generator for Android/mobile clients
 		generator := testchannels.NewTestPatternGenerator()
+		generator.SetLogger(ffmpegLogger)
 		stream, err = generator.GenerateStream(profile)
-		fmt.Printf("TestChannelHandler: Using standard generator for channel %d (User-Agent: %s)\n", index, userAgent)
+		logger.WithField("user_agent", userAgent).Debug("Using standard generator")
 	}
 
 	if err != nil {
-		fmt.Printf("TestChannelHandler: Failed to generate stream for %s: %v\n", profile.Name, err)
+		logger.WithError(err).Error("Failed to generate test stream")
 		http.Error(w, fmt.Sprintf("Failed to generate test stream: %v", err), http.StatusInternalServerError)
 		return
 	}
 	defer func() {
 		if err := stream.Close(); err != nil {
 			// Log error but don't fail the request
-			fmt.Printf("Failed to close test stream: %v\n", err)
+			logger.WithError(err).Warn("Failed to close test stream")
 		}
 	}()
 
@@ -79,14 +95,14 @@ func TestChannelHandler(w http.ResponseWriter, r *http.Request) {
 		select {
 		case <-r.Context().Done():
 			// Client disconnected
-			fmt.Printf("TestChannelHandler: Client disconnected for channel %d\n", index)
+			logger.Debug("Client disconnected from test channel")
 			return
 		default:
 			n, err := stream.Read(buf)
 			if n > 0 {
 				if _, writeErr := w.Write(buf[:n]); writeErr != nil {
 					// Client disconnected
-					fmt.Printf("TestChannelHandler: Write error for channel %d: %v\n", index, writeErr)
+					logger.WithError(writeErr).Debug("Failed to write test stream")
 					return
 				}
 				// Flush after each write for live streaming
@@ -96,7 +112,7 @@ func TestChannelHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			if err != nil {
 				if err != io.EOF {
-					fmt.Printf("TestChannelHandler: Read error for channel %d: %v\n", index, err)
+					logger.WithError(err).Warn("Failed to read test stream")
 				}
 				// End of stream or error
 				return
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/timeshift"
+)
+
+// ErrInvalidTimeshift is returned when the offset or position query parameter is invalid.
+var ErrInvalidTimeshift = errors.New("invalid time-shift offset or position")
+
+// timeshiftRequest is the start point requested by a client.
+type timeshiftRequest struct {
+	// position is an absolute media time from a previous response, used to
+	// resume after pausing or to seek.
+	position *time.Duration
+	// offset is how far behind live to start, zero or negative.
+	offset time.Duration
+}
+
+// wantsTimeshift reports whether the request asks for a time-shifted start.
+func wantsTimeshift(query url.Values) bool {
+	return query.Has("offset") || query.Has("position")
+}
+
+// parseTimeshiftRequest reads the offset and position query parameters, in
+// seconds.
+func parseTimeshiftRequest(query url.Values) (timeshiftRequest, error) {
+	var req timeshiftRequest
+
+	if value := query.Get("position"); value != "" {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds < 0 {
+			return req, fmt.Errorf("%w: position %q", ErrInvalidTimeshift, value)
+		}
+		position := time.Duration(seconds * float64(time.Second))
+		req.position = &position
+		return req, nil
+	}
+
+	if value := query.Get("offset"); value != "" {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds > 0 {
+			return req, fmt.Errorf("%w: offset %q", ErrInvalidTimeshift, value)
+		}
+		req.offset = time.Duration(seconds * float64(time.Second))
+	}
+	return req, nil
+}
+
+// serveTimeshift streams a channel from its time-shift buffer. The response
+// headers report the start position and the window, in media seconds, so
+// that clients can resume or seek with the position parameter.
+func serveTimeshift(w http.ResponseWriter, r *http.Request, manager *timeshift.Manager, targetURL string) error {
+	req, err := parseTimeshiftRequest(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	buffer, release := manager.Open(targetURL)
+	defer release()
+
+	position := buffer.Live() + req.offset
+	if req.position != nil {
+		position = *req.position
+	}
+
+	reader := buffer.NewReader(position)
+	defer func() { _ = reader.Close() }()
+	stop := context.AfterFunc(r.Context(), func() { _ = reader.Close() })
+	defer stop()
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Timeshift-Position", formatSeconds(reader.Position()))
+	w.Header().Set("X-Timeshift-Start", formatSeconds(buffer.Start()))
+	w.Header().Set("X-Timeshift-Live", formatSeconds(buffer.Live()))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, reader); err != nil &&
+		!errors.Is(err, timeshift.ErrReaderClosed) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("time-shift stream failed: %w", err)
+	}
+	return nil
+}
+
+// formatSeconds formats a media time as seconds for response headers.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// TimeshiftHandler serves the recorded time-shift windows at /api/timeshift.
+func TimeshiftHandler(manager *timeshift.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(manager.Channels()); err != nil {
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/process/processtest"
+	"github.com/savid/iptv-proxy/pkg/timeshift"
+	"github.com/savid/iptv-proxy/pkg/tuner"
+	"github.com/savid/iptv-proxy/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+func TestStreamHandlerTimeshiftOnRequest(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		_, _ = w.Write(processtest.MPEGTS(20))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	tuners := tuner.NewPool(2)
+	manager := timeshift.NewManager(time.Minute, "", log.New(io.Discard, "", 0))
+	manager.SetTuners(tuners)
+	defer manager.Close()
+
+	handler := NewStreamHandler(tuners, logger)
+	handler.SetTimeshift(manager)
+
+	serve := func(query string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		req := httptest.NewRequest(http.MethodGet, "/stream/"+utils.EncodeURL(upstream.URL)+query, nil).WithContext(ctx)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Plain live requests are proxied without a recording
+	serve("")
+	if channels := manager.Channels(); len(channels) != 0 {
+		t.Fatalf("Channels() = %+v, want no recording for a live request", channels)
+	}
+
+	serve("?offset=0")
+	if channels := manager.Channels(); len(channels) != 1 {
+		t.Fatalf("Channels() = %+v, want a recording for a time-shift request", channels)
+	}
+	if got := tuners.InUseFor(tuner.PurposeTimeshift); got != 1 {
+		t.Errorf("time-shift tuners = %d, want 1 while the recording runs", got)
+	}
+	if got := tuners.InUseFor(tuner.PurposeStream); got != 0 {
+		t.Errorf("stream tuners = %d, want 0 after the clients left", got)
+	}
+}
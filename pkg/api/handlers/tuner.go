@@ -2,17 +2,29 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"unicode"
 
 	"github.com/savid/iptv-proxy/config"
 	"github.com/savid/iptv-proxy/pkg/data"
+	"github.com/savid/iptv-proxy/pkg/devices"
+	"github.com/savid/iptv-proxy/pkg/m3u"
 	"github.com/savid/iptv-proxy/pkg/testchannels"
 	"github.com/savid/iptv-proxy/pkg/utils"
 )
 
+// ErrInvalidLineupQuery is returned for an unknown lineup show value.
+var ErrInvalidLineupQuery = errors.New("invalid lineup query")
+
+// hdTokens are channel name words that mark an HD channel.
+var hdTokens = map[string]bool{"HD": true, "FHD": true, "UHD": true, "4K": true, "720P": true, "1080I": true, "1080P": true, "2160P": true} //nolint:gochecknoglobals // lookup table
+
 // DeviceXML represents the UPnP device description.
 type DeviceXML struct {
 	XMLName     xml.Name `xml:"root"`
@@ -56,37 +68,77 @@ type DiscoveryJSON struct {
 
 // LineupItem represents a channel in the lineup.
 type LineupItem struct {
-	GuideNumber string `json:"GuideNumber"`
-	GuideName   string `json:"GuideName"`
-	URL         string `json:"URL"`
+	XMLName     xml.Name `json:"-" xml:"Program"`
+	GuideNumber string   `json:"GuideNumber" xml:"GuideNumber"`
+	GuideName   string   `json:"GuideName" xml:"GuideName"`
+	URL         string   `json:"URL" xml:"URL"`
+	HD          int      `json:"HD,omitempty" xml:"HD,omitempty"`
+	Favorite    int      `json:"Favorite,omitempty" xml:"Favorite,omitempty"`
+	DRM         int      `json:"DRM,omitempty" xml:"DRM,omitempty"`
+	VideoCodec  string   `json:"VideoCodec,omitempty" xml:"VideoCodec,omitempty"`
+	AudioCodec  string   `json:"AudioCodec,omitempty" xml:"AudioCodec,omitempty"`
+	Tags        string   `json:"Tags,omitempty" xml:"Tags,omitempty"`
+	// Status is the last health check result, listed with ?tuning.
+	Status string `json:"Status,omitempty" xml:"Status,omitempty"`
+}
+
+// LineupXML is the lineup served at /lineup.xml.
+type LineupXML struct {
+	XMLName  xml.Name     `xml:"Lineup"`
+	Programs []LineupItem `xml:"Program"`
 }
 
 // LineupStatus represents the lineup scanning status.
 type LineupStatus struct {
 	ScanInProgress int      `json:"ScanInProgress"`
 	ScanPossible   int      `json:"ScanPossible"`
-	Source         string   `json:"Source"`
-	SourceList     []string `json:"SourceList"`
+	Progress       int      `json:"Progress,omitempty"`
+	Found          int      `json:"Found,omitempty"`
+	Source         string   `json:"Source,omitempty"`
+	SourceList     []string `json:"SourceList,omitempty"`
+}
+
+// lineupQuery holds the lineup query parameters. Show is "found" for the
+// playable channels, "demo" for the test channels or "all" to also list
+// channels hidden by health checks; tuning adds health check results.
+type lineupQuery struct {
+	show   string
+	tuning bool
 }
 
-// RootXMLHandler serves the UPnP device description at /.
-func RootXMLHandler(cfg *config.Config) http.HandlerFunc {
+// parseLineupQuery reads ?show=found|demo|all and ?tuning.
+func parseLineupQuery(r *http.Request) (lineupQuery, error) {
+	query := r.URL.Query()
+	q := lineupQuery{show: query.Get("show"), tuning: query.Has("tuning")}
+	switch q.show {
+	case "":
+		q.show = "found"
+	case "found", "demo", "all":
+	default:
+		return lineupQuery{}, fmt.Errorf("%w: show=%s", ErrInvalidLineupQuery, q.show)
+	}
+	return q, nil
+}
+
+// RootXMLHandler serves the UPnP device description at the device's base
+// path.
+func RootXMLHandler(dev *devices.Device) http.HandlerFunc {
 	return func(w http.ResponseWriter, _ *http.Request) {
 		device := DeviceXML{
 			Xmlns:   "urn:schemas-upnp-org:device-1-0",
-			URLBase: cfg.BaseURL,
+			URLBase: dev.BaseURL,
 			SpecVersion: SpecVersion{
 				Major: 1,
 				Minor: 0,
 			},
 			Device: Device{
 				DeviceType:   "urn:schemas-upnp-org:device:MediaServer:1",
-				FriendlyName: "IPTV-Proxy",
+				FriendlyName: dev.Name,
 				Manufacturer: "Silicondust",
 				ModelName:    "HDTC-2US",
 				ModelNumber:  "HDTC-2US",
-				SerialNumber: "",
-				UDN:          "uuid:2025-01-IPTV-PROXY01",
+				SerialNumber: dev.ID,
+				UDN:          dev.UDN(),
 			},
 		}
 
@@ -108,22 +160,28 @@ func RootXMLHandler(cfg *config.Config) http.HandlerFunc {
 	}
 }
 
+// NewDiscovery describes a device as served at /discovery.json and in
+// HDHomeRun discover replies.
+func NewDiscovery(dev *devices.Device) DiscoveryJSON {
+	return DiscoveryJSON{
+		FriendlyName:    dev.Name,
+		Manufacturer:    "Golang",
+		ManufacturerURL: "https://github.com/Savid/iptv-proxy",
+		ModelNumber:     "1.0",
+		FirmwareName:    "bin_1.0",
+		TunerCount:      dev.TunerCount,
+		FirmwareVersion: "1.0",
+		DeviceID:        dev.ID,
+		DeviceAuth:      "iptv-proxy",
+		BaseURL:         dev.BaseURL,
+		LineupURL:       dev.LineupURL(),
+	}
+}
+
 // DiscoveryHandler serves device discovery JSON at /discovery.json.
-func DiscoveryHandler(cfg *config.Config) http.HandlerFunc {
+func DiscoveryHandler(dev *devices.Device) http.HandlerFunc {
 	return func(w http.ResponseWriter, _ *http.Request) {
-		discovery := DiscoveryJSON{
-			FriendlyName:    "IPTV-Proxy",
-			Manufacturer:    "Golang",
-			ManufacturerURL: "https://github.com/Savid/iptv-proxy",
-			ModelNumber:     "1.0",
-			FirmwareName:    "bin_1.0",
-			TunerCount:      cfg.TunerCount,
-			FirmwareVersion: "1.0",
-			DeviceID:        "2025-01-IPTV-PROXY01",
-			DeviceAuth:      "iptv-proxy",
-			BaseURL:         cfg.BaseURL,
-			LineupURL:       fmt.Sprintf("%s/lineup.json", cfg.BaseURL),
-		}
+		discovery := NewDiscovery(dev)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -135,59 +193,188 @@ func DiscoveryHandler(cfg *config.Config) http.HandlerFunc {
 	}
 }
 
-// LineupHandler serves channel lineup at /lineup.json.
-func LineupHandler(cfg *config.Config, store *data.Store) http.HandlerFunc {
-	return func(w http.ResponseWriter, _ *http.Request) {
+// LineupHandler serves the channel lineup of a device at /lineup.json.
+// Guide numbers are the same on every device, and streams are served from
+// the base URL.
+func LineupHandler(cfg *config.Config, store *data.Store, dev *devices.Device) http.HandlerFunc {
+	return lineupHandler(cfg, store, dev, func(w http.ResponseWriter, lineup []LineupItem) error {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		return json.NewEncoder(w).Encode(lineup)
+	})
+}
+
+// LineupXMLHandler serves the channel lineup of a device at /lineup.xml.
+func LineupXMLHandler(cfg *config.Config, store *data.Store, dev *devices.Device) http.HandlerFunc {
+	return lineupHandler(cfg, store, dev, func(w http.ResponseWriter, lineup []LineupItem) error {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte(xml.Header)); err != nil {
+			return err
+		}
+		encoder := xml.NewEncoder(w)
+		encoder.Indent("", "  ")
+		return encoder.Encode(LineupXML{Programs: lineup})
+	})
+}
+
+// LineupM3UHandler serves the channel lineup of a device as a playlist at
+// /lineup.m3u.
+func LineupM3UHandler(cfg *config.Config, store *data.Store, dev *devices.Device) http.HandlerFunc {
+	return lineupHandler(cfg, store, dev, func(w http.ResponseWriter, lineup []LineupItem) error {
+		var buf bytes.Buffer
+		buf.WriteString("#EXTM3U\n")
+		for _, item := range lineup {
+			name := strings.ReplaceAll(item.GuideName, `"`, "'")
+			fmt.Fprintf(&buf, "#EXTINF:-1 channel-id=\"%s\" tvg-chno=\"%s\" tvg-name=\"%s\",%s\n%s\n",
+				item.GuideNumber, item.GuideNumber, name, item.GuideName, item.URL)
+		}
+
+		w.Header().Set("Content-Type", "audio/x-mpegurl")
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write(buf.Bytes())
+		return err
+	})
+}
+
+// lineupHandler builds the lineup for the request query and writes it.
+func lineupHandler(cfg *config.Config, store *data.Store, dev *devices.Device, write func(http.ResponseWriter, []LineupItem) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseLineupQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		_, channels, ok := store.GetM3U()
 		if !ok {
 			http.Error(w, "No M3U data available", http.StatusServiceUnavailable)
 			return
 		}
 
-		lineup := make([]LineupItem, 0, len(channels))
-		for i, channel := range channels {
-			// Generate proxy URL for the stream
-			proxyURL := fmt.Sprintf("%s/stream/%s", cfg.BaseURL, utils.EncodeURL(channel.URL))
+		if err := write(w, buildLineup(cfg, store, dev, channels, query)); err != nil {
+			http.Error(w, "Failed to encode lineup", http.StatusInternalServerError)
+			return
+		}
+	}
+}
 
-			lineup = append(lineup, LineupItem{
-				GuideNumber: fmt.Sprintf("%d", i+1),
-				GuideName:   channel.Name,
-				URL:         proxyURL,
-			})
+// buildLineup lists the channels of a device for a lineup query.
+func buildLineup(cfg *config.Config, store *data.Store, dev *devices.Device, channels []m3u.Channel, query lineupQuery) []LineupItem {
+	favorites := make(map[string]bool)
+	for _, name := range strings.Split(cfg.Favorites, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			favorites[strings.ToLower(name)] = true
+		}
+	}
+
+	lineup := make([]LineupItem, 0, len(channels))
+	for i, channel := range channels {
+		if query.show == "demo" {
+			break
+		}
+		if !dev.Matches(channel) {
+			continue
+		}
+		health, checked := store.GetChannelHealth(channel.URL)
+		// Skip channels hidden by health checks, keeping guide numbers stable
+		if checked && health.Hidden && query.show != "all" {
+			continue
+		}
+
+		item := LineupItem{
+			GuideNumber: fmt.Sprintf("%d", i+1),
+			GuideName:   channel.Name,
+			// Generate proxy URL for the stream
+			URL: fmt.Sprintf("%s/stream/%s", cfg.BaseURL, utils.EncodeURL(channel.URL)),
+		}
+		if isHD(channel.Name) {
+			item.HD = 1
+		}
+		if favorites[strings.ToLower(channel.Name)] || favorites[strings.ToLower(channel.Group)] {
+			item.Favorite = 1
+		}
+		if channel.DRM {
+			item.DRM = 1
 		}
 
-		// Add test channels if enabled
-		if cfg.EnableTestChannels {
-			startNumber := len(channels) + 1
-			for i, profile := range testchannels.TestProfiles {
-				testURL := fmt.Sprintf("%s/test/%d", cfg.BaseURL, i)
-				lineup = append(lineup, LineupItem{
-					GuideNumber: fmt.Sprintf("%d", startNumber+i),
-					GuideName:   fmt.Sprintf("Test: %s", profile.Name),
-					URL:         testURL,
-				})
+		var tags []string
+		if channel.Group != "" {
+			tags = append(tags, channel.Group)
+		}
+		if channel.HasCatchup() {
+			tags = append(tags, "catchup")
+		}
+		if checked {
+			item.VideoCodec = lineupCodec(health.VideoCodec)
+			item.AudioCodec = lineupCodec(health.AudioCodec)
+			if health.Hidden {
+				tags = append(tags, "hidden")
+			}
+			if query.tuning {
+				item.Status = string(health.Status)
 			}
+		} else if query.tuning {
+			item.Status = string(data.ChannelStatusUnknown)
 		}
+		item.Tags = strings.Join(tags, ",")
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
+		lineup = append(lineup, item)
+	}
 
-		if err := json.NewEncoder(w).Encode(lineup); err != nil {
-			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
-			return
+	// Add test channels if enabled; they are the demo channels
+	if cfg.EnableTestChannels && !dev.Filtered() {
+		startNumber := len(channels) + 1
+		for i, profile := range testchannels.TestProfiles {
+			testURL := fmt.Sprintf("%s/test/%d", cfg.BaseURL, i)
+			lineup = append(lineup, LineupItem{
+				GuideNumber: fmt.Sprintf("%d", startNumber+i),
+				GuideName:   fmt.Sprintf("Test: %s", profile.Name),
+				URL:         testURL,
+				Tags:        "demo",
+			})
+		}
+	}
+	return lineup
+}
+
+// isHD reports whether a channel name marks an HD channel, e.g. "ESPN HD".
+func isHD(name string) bool {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if hdTokens[strings.ToUpper(word)] {
+			return true
 		}
 	}
+	return false
+}
+
+// lineupCodec formats an ffprobe codec name the way HDHomeRun tuners list
+// it, e.g. "MPEG2" or "H264".
+func lineupCodec(codec string) string {
+	return strings.ToUpper(strings.TrimSuffix(codec, "video"))
 }
 
-// LineupStatusHandler serves the lineup scanning status at /lineup_status.json.
-func LineupStatusHandler() http.HandlerFunc {
+// LineupStatusHandler serves the lineup scanning status at
+// /lineup_status.json. A running refresh is reported as a scan.
+func LineupStatusHandler(refresher *data.Refresher) http.HandlerFunc {
 	return func(w http.ResponseWriter, _ *http.Request) {
 		status := LineupStatus{
 			ScanInProgress: 0,
-			ScanPossible:   0,
+			ScanPossible:   1,
 			Source:         "Cable",
 			SourceList:     []string{"Cable"},
 		}
+		if scan := refresher.ScanStatus(); scan.InProgress {
+			status = LineupStatus{
+				ScanInProgress: 1,
+				ScanPossible:   1,
+				Progress:       scan.Progress,
+				Found:          scan.Found,
+			}
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -198,3 +385,30 @@ func LineupStatusHandler() http.HandlerFunc {
 		}
 	}
 }
+
+// LineupPostHandler handles POST /lineup.post?scan=start|abort, starting
+// or aborting a refresh of the playlist and guide.
+func LineupPostHandler(refresher *data.Refresher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		switch r.URL.Query().Get("scan") {
+		case "start":
+			// A running scan already covers the request
+			if err := refresher.Scan(); err != nil && !errors.Is(err, data.ErrScanInProgress) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		case "abort":
+			refresher.AbortScan()
+		default:
+			http.Error(w, "scan must be start or abort", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/data"
+	"github.com/savid/iptv-proxy/pkg/devices"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/sirupsen/logrus"
+)
+
+func TestVirtualDeviceLineup(t *testing.T) {
+	identity, err := devices.NewIdentity()
+	if err != nil {
+		t.Fatalf("NewIdentity() error = %v", err)
+	}
+	cfg := &config.Config{BaseURL: "http://192.0.2.1:8080", Port: 8080, TunerCount: 2, EnableTestChannels: true}
+	all, err := devices.Parse([]byte(`{"devices": [{"id": "1050A1BD", "name": "Sports", "path": "/sports", "groups": ["Sports"]}]}`), cfg, identity)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	sports := all[1]
+
+	store := data.NewStore()
+	store.SetM3U(nil, []m3u.Channel{
+		{Name: "News", Group: "News", URL: "http://upstream/news"},
+		{Name: "ESPN", Group: "Sports", URL: "http://upstream/espn"},
+	})
+
+	w := httptest.NewRecorder()
+	LineupHandler(cfg, store, sports)(w, httptest.NewRequest(http.MethodGet, "/sports/lineup.json", nil))
+
+	var lineup []LineupItem
+	if err := json.NewDecoder(w.Body).Decode(&lineup); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	// Guide numbers match the full lineup and test channels stay on the default device
+	if len(lineup) != 1 || lineup[0].GuideName != "ESPN" || lineup[0].GuideNumber != "2" {
+		t.Fatalf("lineup = %+v, want only ESPN as channel 2", lineup)
+	}
+	if lineup[0].URL != "http://192.0.2.1:8080/stream/http%3A%2F%2Fupstream%2Fespn" {
+		t.Errorf("URL = %s", lineup[0].URL)
+	}
+
+	w = httptest.NewRecorder()
+	DiscoveryHandler(sports)(w, httptest.NewRequest(http.MethodGet, "/sports/discover.json", nil))
+	var discovery DiscoveryJSON
+	if err := json.NewDecoder(w.Body).Decode(&discovery); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if discovery.DeviceID != "1050A1BD" || discovery.FriendlyName != "Sports" ||
+		discovery.LineupURL != "http://192.0.2.1:8080/sports/lineup.json" {
+		t.Errorf("discovery = %+v", discovery)
+	}
+}
+
+func TestLineupQuery(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://192.0.2.1:8080", TunerCount: 2, EnableTestChannels: true, Favorites: "news"}
+	store := data.NewStore()
+	store.SetHideDeadAfter(1)
+	store.SetM3U(nil, []m3u.Channel{
+		{Name: "ESPN HD", Group: "Sports", URL: "http://upstream/espn", Catchup: "default", CatchupSource: "http://upstream/espn?utc={utc}"},
+		{Name: "News", Group: "News", URL: "http://upstream/news", DRM: true},
+		{Name: "Dead", Group: "Other", URL: "http://upstream/dead"},
+	})
+	store.RecordChannelHealth(data.ChannelHealth{URL: "http://upstream/espn", Status: data.ChannelStatusOK, VideoCodec: "mpeg2video", AudioCodec: "ac3"})
+	store.RecordChannelHealth(data.ChannelHealth{URL: "http://upstream/dead", Status: data.ChannelStatusFailing})
+	handler := LineupHandler(cfg, store, devices.Default(cfg, devices.Identity{DeviceID: "12345674"}))
+
+	lineup := func(query string) []LineupItem {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/lineup.json"+query, nil))
+		var items []LineupItem
+		if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+			t.Fatalf("%s: Decode() error = %v", query, err)
+		}
+		return items
+	}
+
+	found := lineup("")
+	tests := len(found) - 2
+	if tests <= 0 || found[0].GuideName != "ESPN HD" || found[1].GuideName != "News" {
+		t.Fatalf("lineup = %+v, want ESPN HD and News before the test channels", found)
+	}
+	espn, news := found[0], found[1]
+	if espn.HD != 1 || espn.VideoCodec != "MPEG2" || espn.AudioCodec != "AC3" || espn.Tags != "Sports,catchup" || espn.Status != "" {
+		t.Errorf("ESPN HD = %+v", espn)
+	}
+	if news.HD != 0 || news.Favorite != 1 || news.DRM != 1 {
+		t.Errorf("News = %+v", news)
+	}
+
+	all := lineup("?show=all&tuning")
+	if len(all) != 3+tests || all[2].GuideNumber != "3" || all[2].Tags != "Other,hidden" || all[2].Status != "failing" || all[1].Status != "unknown" {
+		t.Errorf("show=all&tuning = %+v", all)
+	}
+	if demo := lineup("?show=demo"); len(demo) != tests || demo[0].Tags != "demo" {
+		t.Errorf("show=demo = %+v", demo)
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/lineup.json?show=other", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("show=other status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestLineupVariants(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://192.0.2.1:8080", TunerCount: 2}
+	store := data.NewStore()
+	store.SetM3U(nil, []m3u.Channel{{Name: `Say "Hi"`, URL: "http://upstream/hi"}})
+	dev := devices.Default(cfg, devices.Identity{DeviceID: "12345674"})
+
+	w := httptest.NewRecorder()
+	LineupXMLHandler(cfg, store, dev)(w, httptest.NewRequest(http.MethodGet, "/lineup.xml", nil))
+	var lineup LineupXML
+	if err := xml.NewDecoder(w.Body).Decode(&lineup); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if len(lineup.Programs) != 1 || lineup.Programs[0].GuideNumber != "1" || lineup.Programs[0].GuideName != `Say "Hi"` {
+		t.Errorf("lineup.xml = %+v", lineup)
+	}
+
+	w = httptest.NewRecorder()
+	LineupM3UHandler(cfg, store, dev)(w, httptest.NewRequest(http.MethodGet, "/lineup.m3u", nil))
+	want := "#EXTM3U\n#EXTINF:-1 channel-id=\"1\" tvg-chno=\"1\" tvg-name=\"Say 'Hi'\",Say \"Hi\"\nhttp://192.0.2.1:8080/stream/http%3A%2F%2Fupstream%2Fhi\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("lineup.m3u = %q, want %q", got, want)
+	}
+}
+
+func TestLineupScan(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/epg.xml" {
+			<-release
+			_, _ = w.Write([]byte(`<tv></tv>`))
+			return
+		}
+		_, _ = w.Write([]byte("#EXTM3U\n#EXTINF:-1,One\nhttp://upstream/one\n#EXTINF:-1,Two\nhttp://upstream/two\n"))
+	}))
+	defer upstream.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := &config.Config{M3UURL: upstream.URL + "/iptv.m3u", EPGURL: upstream.URL + "/epg.xml", BaseURL: "http://192.0.2.1:8080"}
+	store := data.NewStore()
+	refresher := data.NewRefresher(store, data.NewFetcher(cfg, logger), time.Hour, logger)
+
+	post := func(query string) int {
+		w := httptest.NewRecorder()
+		LineupPostHandler(refresher)(w, httptest.NewRequest(http.MethodPost, "/lineup.post"+query, nil))
+		return w.Code
+	}
+	status := func() LineupStatus {
+		w := httptest.NewRecorder()
+		LineupStatusHandler(refresher)(w, httptest.NewRequest(http.MethodGet, "/lineup_status.json", nil))
+		var s LineupStatus
+		if err := json.NewDecoder(w.Body).Decode(&s); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		return s
+	}
+
+	if code := post("?scan=bogus"); code != http.StatusBadRequest {
+		t.Errorf("scan=bogus status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if s := status(); s.ScanInProgress != 0 || s.ScanPossible != 1 || s.Source != "Cable" {
+		t.Errorf("idle status = %+v", s)
+	}
+	if code := post("?scan=start"); code != http.StatusOK {
+		t.Fatalf("scan=start status = %d", code)
+	}
+
+	// The scan waits on the guide after finding the channels
+	deadline := time.Now().Add(5 * time.Second)
+	for s := status(); s.Found != 2; s = status() {
+		if time.Now().After(deadline) {
+			t.Fatalf("scan status = %+v, want 2 channels found", s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := status(); s.ScanInProgress != 1 || s.Progress != 50 || s.Source != "" {
+		t.Errorf("scanning status = %+v", s)
+	}
+	if code := post("?scan=start"); code != http.StatusOK {
+		t.Errorf("second scan=start status = %d", code)
+	}
+	close(release)
+
+	for s := status(); s.ScanInProgress != 0; s = status() {
+		if time.Now().After(deadline) {
+			t.Fatalf("scan did not finish: %+v", s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, channels, ok := store.GetM3U(); !ok || len(channels) != 2 {
+		t.Errorf("store has %d channels after the scan, want 2", len(channels))
+	}
+}
@@ -5,17 +5,51 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/savid/iptv-proxy/pkg/logging"
 	"github.com/sirupsen/logrus"
 )
 
-// LoggingMiddleware logs all incoming HTTP requests at debug level.
+// RequestIDHeader carries the request ID. An ID sent by the client is kept,
+// so that requests can be correlated across proxies.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest client request ID that is kept.
+const maxRequestIDLength = 64
+
+// validRequestID reports whether a client request ID is short and made only
+// of letters, digits, '.', '_' and '-', so it is safe to write to the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// LoggingMiddleware assigns each request an ID, carried in its context and
+// response headers, and logs it. Successful requests are logged at debug
+// level, server errors as warnings.
 func LoggingMiddleware(logger *logrus.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 
+			requestID := r.Header.Get(RequestIDHeader)
+			if !validRequestID(requestID) {
+				requestID = logging.NewRequestID()
+			}
+			r = r.WithContext(logging.NewContext(r.Context(), logger, requestID))
+			w.Header().Set(RequestIDHeader, requestID)
+
 			// Log the incoming request
-			logger.WithFields(logrus.Fields{
+			logging.Entry(r.Context(), logger).WithFields(logrus.Fields{
 				"method":     r.Method,
 				"path":       r.URL.Path,
 				"query":      r.URL.RawQuery,
@@ -30,22 +64,71 @@ func LoggingMiddleware(logger *logrus.Logger) func(http.Handler) http.Handler {
 			// Call the next handler
 			next.ServeHTTP(rw, r)
 
-			// Log the response
-			logger.WithFields(logrus.Fields{
+			// Log the response with the fields the handlers added
+			entry := logging.Entry(r.Context(), logger).WithFields(logrus.Fields{
 				"method":   r.Method,
 				"path":     r.URL.Path,
 				"status":   rw.statusCode,
+				"bytes":    rw.bytes,
 				"duration": time.Since(start).String(),
 				"remote":   r.RemoteAddr,
-			}).Debug("Request completed")
+			})
+			if rw.statusCode >= http.StatusInternalServerError {
+				entry.Warn("Request failed")
+			} else {
+				entry.Debug("Request completed")
+			}
 		})
 	}
 }
 
-// responseWriter wraps http.ResponseWriter to capture the status code.
+// AccessLogMiddleware writes a record to the access log for every completed
+// request, e.g. every stream. It reads the request ID and session fields
+// from the request log, so it must run inside LoggingMiddleware.
+func AccessLogMiddleware(access *logging.AccessLog, logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+			next.ServeHTTP(rw, r)
+
+			fields := logging.Fields(r.Context())
+			record := logging.AccessRecord{
+				Time:      start,
+				RequestID: logging.RequestID(r.Context()),
+				Remote:    r.RemoteAddr,
+				Method:    r.Method,
+				URI:       r.URL.RequestURI(),
+				Proto:     r.Proto,
+				Status:    rw.statusCode,
+				Bytes:     rw.bytes,
+				Duration:  time.Since(start),
+				Referer:   r.Referer(),
+				UserAgent: r.UserAgent(),
+				Channel:   stringField(fields, "channel"),
+				Client:    stringField(fields, "client"),
+				Device:    stringField(fields, "device"),
+			}
+			if err := access.Log(record); err != nil {
+				logger.WithError(err).Warn("Failed to write access log")
+			}
+		})
+	}
+}
+
+// stringField returns a log field as a string, or an empty string.
+func stringField(fields logrus.Fields, key string) string {
+	value, _ := fields[key].(string)
+	return value
+}
+
+// responseWriter wraps http.ResponseWriter to capture the status code and
+// the number of bytes written.
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64
 	written    bool
 }
 
@@ -61,5 +144,19 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	if !rw.written {
 		rw.WriteHeader(http.StatusOK)
 	}
-	return rw.ResponseWriter.Write(b)
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher for handlers that flush live streams.
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
 }
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/savid/iptv-proxy/pkg/logging"
+	"github.com/sirupsen/logrus"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	var out bytes.Buffer
+	access, err := logging.NewAccessLog(&out, logging.AccessFormatJSON)
+	if err != nil {
+		t.Fatalf("NewAccessLog failed: %v", err)
+	}
+
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.AddFields(r.Context(), logrus.Fields{"channel": "News", "client": "plex"})
+		_, _ = w.Write([]byte("0123456789"))
+	})
+	handler := LoggingMiddleware(logger)(AccessLogMiddleware(access, logger)(stream))
+
+	req := httptest.NewRequest(http.MethodGet, "/stream/abc", nil)
+	req.Header.Set(RequestIDHeader, "client-id")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Header().Get(RequestIDHeader) != "client-id" {
+		t.Errorf("Expected the client request ID in the response, got %q", rec.Header().Get(RequestIDHeader))
+	}
+
+	var record logging.AccessRecord
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a JSON access record, got %q: %v", out.String(), err)
+	}
+	if record.RequestID != "client-id" || record.Bytes != 10 || record.Status != http.StatusOK ||
+		record.Channel != "News" || record.Client != "plex" || record.URI != "/stream/abc" {
+		t.Errorf("Unexpected access record %+v", record)
+	}
+}
+
+func TestLoggingMiddlewareReplacesInvalidRequestIDs(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	var got string
+	handler := LoggingMiddleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = logging.RequestID(r.Context())
+	}))
+
+	for _, id := range []string{
+		"abc 123",
+		"id\" other=\"x",
+		"line\nbreak",
+		"café",
+		strings.Repeat("a", 65),
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, id)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got == id || got == "" {
+			t.Errorf("Request ID %q was not replaced, got %q", id, got)
+		}
+		if rec.Header().Get(RequestIDHeader) != got {
+			t.Errorf("Expected response ID %q, got %q", got, rec.Header().Get(RequestIDHeader))
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "Req_1.a-B")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "Req_1.a-B" {
+		t.Errorf("Expected a valid request ID to be kept, got %q", got)
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/savid/iptv-proxy/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// TracingMiddleware starts a server span for each request, continuing the
+// trace of a traceparent header sent by the client. The spans of the fetch
+// and stream pipelines become its children.
+func TracingMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracing.StartServer(ctx, "HTTP "+r.Method,
+				attribute.String("http.method", r.Method),
+				attribute.String("url.path", spanPath(r.URL.Path)),
+				attribute.String("user_agent.original", r.UserAgent()),
+			)
+			defer span.End()
+
+			rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(rw, r.WithContext(ctx))
+
+			span.SetAttributes(
+				attribute.Int("http.status_code", rw.statusCode),
+				attribute.Int64("http.response_size", rw.bytes),
+			)
+			if rw.statusCode >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, fmt.Sprintf("status %d", rw.statusCode))
+			}
+		})
+	}
+}
+
+// upstreamRoutes are the routes whose path carries an encoded upstream URL.
+var upstreamRoutes = []string{"/stream/", "/catchup/"}
+
+// spanPath returns the path of a request for span attributes, replacing
+// encoded upstream URLs, which often carry account credentials, with a
+// placeholder.
+func spanPath(path string) string {
+	for _, route := range upstreamRoutes {
+		if strings.HasPrefix(path, route) && len(path) > len(route) {
+			return route + "{url}"
+		}
+	}
+	return path
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/savid/iptv-proxy/pkg/tracing/tracingtest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingMiddlewareContinuesTrace(t *testing.T) {
+	exporter := tracingtest.Record(t)
+	previous := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+	var handlerSpan trace.SpanContext
+	handler := TracingMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/stream/abc", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	span, ok := tracingtest.Find(exporter, "HTTP GET")
+	if !ok {
+		t.Fatalf("spans = %v, want the request span", tracingtest.Names(exporter))
+	}
+	if got := span.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID = %s, want the client's trace", got)
+	}
+	if span.SpanKind != trace.SpanKindServer {
+		t.Errorf("span kind = %s, want server", span.SpanKind)
+	}
+	if handlerSpan.SpanID() != span.SpanContext.SpanID() {
+		t.Errorf("handler context does not carry the request span")
+	}
+	if span.Status.Code != codes.Error {
+		t.Errorf("status = %+v, want an error for a 502", span.Status)
+	}
+}
+
+func TestSpanPathRedactsUpstreamURLs(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/stream/http%3A%2F%2Fuser%3Apass%40provider.example%2Flive%2F1.ts", want: "/stream/{url}"},
+		{path: "/catchup/http://provider.example/timeshift/user/pass/60/1.ts", want: "/catchup/{url}"},
+		{path: "/stream/", want: "/stream/"},
+		{path: "/lineup.json", want: "/lineup.json"},
+		{path: "/test/1", want: "/test/1"},
+	}
+
+	for _, tt := range tests {
+		if got := spanPath(tt.path); got != tt.want {
+			t.Errorf("spanPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
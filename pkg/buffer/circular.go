@@ -47,7 +47,7 @@ func (b *CircularBuffer) Write(p []byte) (int, error) {
 	written := 0
 	for written < len(p) {
 		// Wait if buffer is full
-		for b.Free() == 0 && !b.closed {
+		for b.free() == 0 && !b.closed {
 			b.cond.Wait()
 		}
 
@@ -56,7 +56,7 @@ func (b *CircularBuffer) Write(p []byte) (int, error) {
 		}
 
 		// Calculate how much we can write
-		free := b.Free()
+		free := b.free()
 		toWrite := len(p) - written
 		if toWrite > free {
 			toWrite = free
@@ -93,16 +93,16 @@ func (b *CircularBuffer) Read(p []byte) (int, error) {
 	defer b.mu.Unlock()
 
 	// Wait for data to be available
-	for b.Available() == 0 && !b.closed {
+	for b.available() == 0 && !b.closed {
 		b.cond.Wait()
 	}
 
-	if b.Available() == 0 && b.closed {
+	if b.available() == 0 && b.closed {
 		return 0, io.EOF
 	}
 
 	// Calculate how much we can read
-	available := b.Available()
+	available := b.available()
 	toRead := len(p)
 	if toRead > available {
 		toRead = available
@@ -133,17 +133,60 @@ func (b *CircularBuffer) Read(p []byte) (int, error) {
 	return read, nil
 }
 
+// Positions returns the total number of bytes read from and written to the
+// buffer, which are the stream offsets of the next read and write.
+func (b *CircularBuffer) Positions() (int64, int64) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.bytesRead, b.bytesWritten
+}
+
+// SkipTo discards buffered data up to the stream offset. It returns false
+// when the offset has already been read or has not been written yet.
+func (b *CircularBuffer) SkipTo(offset int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if offset < b.bytesRead || offset > b.bytesWritten {
+		return false
+	}
+
+	skip := offset - b.bytesRead
+	b.readPos = int((int64(b.readPos) + skip) % int64(b.size))
+	b.bytesRead = offset
+
+	// Signal writers that space is available
+	b.cond.Broadcast()
+	return true
+}
+
 // Available returns the number of bytes available for reading.
 func (b *CircularBuffer) Available() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.available()
+}
+
+// Free returns the number of bytes available for writing.
+func (b *CircularBuffer) Free() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.free()
+}
+
+// available returns the number of bytes available for reading. The caller
+// must hold b.mu.
+func (b *CircularBuffer) available() int {
 	if b.writePos >= b.readPos {
 		return b.writePos - b.readPos
 	}
 	return b.size - b.readPos + b.writePos
 }
 
-// Free returns the number of bytes available for writing.
-func (b *CircularBuffer) Free() int {
-	return b.size - b.Available() - 1 // Reserve 1 byte to distinguish full from empty
+// free returns the number of bytes available for writing. The caller must
+// hold b.mu.
+func (b *CircularBuffer) free() int {
+	return b.size - b.available() - 1 // Reserve 1 byte to distinguish full from empty
 }
 
 // Stats returns current buffer statistics.
@@ -151,7 +194,7 @@ func (b *CircularBuffer) Stats() types.BufferStats {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	available := b.Available()
+	available := b.available()
 	return types.BufferStats{
 		BytesBuffered: int64(available),
 		BytesConsumed: b.bytesRead,
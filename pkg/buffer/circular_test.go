@@ -0,0 +1,42 @@
+package buffer
+
+import (
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestCircularBufferConcurrentAccessors(t *testing.T) {
+	b := NewCircularBuffer(1024)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		chunk := make([]byte, 100)
+		for range 100 {
+			if _, err := b.Write(chunk); err != nil {
+				return
+			}
+		}
+		b.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		chunk := make([]byte, 64)
+		for {
+			if _, err := b.Read(chunk); err == io.EOF {
+				return
+			}
+		}
+	}()
+
+	// Available and Free are polled while the buffer is written and read,
+	// like the manager does while waiting for data
+	for range 1000 {
+		if available, free := b.Available(), b.Free(); available < 0 || free < 0 {
+			t.Fatalf("Available() = %d, Free() = %d, want non-negative", available, free)
+		}
+	}
+	wg.Wait()
+}
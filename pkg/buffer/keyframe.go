@@ -0,0 +1,94 @@
+package buffer
+
+import (
+	"sync"
+
+	"github.com/savid/iptv-proxy/pkg/mpegts"
+)
+
+// maxKeyframes bounds how many keyframe positions the index remembers.
+const maxKeyframes = 64
+
+// keyframe is the stream position of a packet starting a keyframe, with the
+// tables that describe the stream at that point.
+type keyframe struct {
+	offset int64
+	tables mpegts.Tables
+}
+
+// KeyframeIndex records where keyframes start in a transport stream as it
+// is written to a buffer, so that readers can join at a random access point.
+type KeyframeIndex struct {
+	detector *mpegts.KeyframeDetector
+	carry    []byte
+	offset   int64 // stream position of carry[0]
+
+	mu        sync.Mutex
+	keyframes []keyframe
+}
+
+// NewKeyframeIndex creates an empty index for a stream starting at offset 0.
+func NewKeyframeIndex() *KeyframeIndex {
+	return &KeyframeIndex{
+		detector: mpegts.NewKeyframeDetector(),
+	}
+}
+
+// Write indexes the next bytes of the stream. It never fails.
+func (k *KeyframeIndex) Write(p []byte) (int, error) {
+	k.carry = append(k.carry, p...)
+
+	i := 0
+	for len(k.carry)-i >= mpegts.PacketSize {
+		// Skip bytes until the next sync byte when the stream is not aligned
+		if k.carry[i] != mpegts.SyncByte {
+			i++
+			continue
+		}
+
+		packet := mpegts.Packet(k.carry[i : i+mpegts.PacketSize])
+		if k.detector.Inspect(packet) {
+			k.add(k.offset + int64(i))
+		}
+		i += mpegts.PacketSize
+	}
+
+	k.offset += int64(i)
+	k.carry = append(k.carry[:0], k.carry[i:]...)
+	return len(p), nil
+}
+
+// add records a keyframe, but only once the stream tables are known since
+// a player cannot start without them.
+func (k *KeyframeIndex) add(offset int64) {
+	tables, ok := k.detector.Tables()
+	if !ok {
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keyframes = append(k.keyframes, keyframe{offset: offset, tables: tables})
+	if len(k.keyframes) > maxKeyframes {
+		k.keyframes = k.keyframes[len(k.keyframes)-maxKeyframes:]
+	}
+}
+
+// Latest returns the position of the most recent keyframe between from and
+// to, with the tables to send before it.
+func (k *KeyframeIndex) Latest(from, to int64) (int64, mpegts.Tables, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for i := len(k.keyframes) - 1; i >= 0; i-- {
+		kf := k.keyframes[i]
+		if kf.offset < from {
+			break
+		}
+		if kf.offset <= to {
+			return kf.offset, kf.tables, true
+		}
+	}
+	return 0, mpegts.Tables{}, false
+}
@@ -0,0 +1,107 @@
+package buffer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/mpegts"
+	"github.com/savid/iptv-proxy/pkg/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testPMTPID   = 0x1000
+	testVideoPID = 0x100
+)
+
+// tsPacket builds a payload-only packet.
+func tsPacket(pid uint16, cc uint8, pusi bool, payload ...byte) []byte {
+	p := bytes.Repeat([]byte{0xFF}, mpegts.PacketSize)
+	p[0] = mpegts.SyncByte
+	p[1] = byte(pid>>8) & 0x1F
+	if pusi {
+		p[1] |= 0x40
+	}
+	p[2] = byte(pid)
+	p[3] = 0x10 | cc&0x0F
+	copy(p[4:], payload)
+	return p
+}
+
+// tables builds a PAT and a PMT with one H.264 stream.
+func tables() []byte {
+	pat := tsPacket(mpegts.PIDPAT, 0, true,
+		0x00, 0x00, 0xB0, 0x0D, 0x00, 0x01, 0xC1, 0x00, 0x00,
+		0x00, 0x01, 0xE0|testPMTPID>>8, testPMTPID&0xFF,
+		0x00, 0x00, 0x00, 0x00,
+	)
+	pmt := tsPacket(testPMTPID, 0, true,
+		0x00, 0x02, 0xB0, 0x12, 0x00, 0x01, 0xC1, 0x00, 0x00,
+		0xE0|testVideoPID>>8, testVideoPID&0xFF, 0xF0, 0x00,
+		0x1B, 0xE0|testVideoPID>>8, testVideoPID&0xFF, 0xF0, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	)
+	return append(pat, pmt...)
+}
+
+// frame builds a PES packet starting with an H.264 NAL unit of the given
+// type, followed by continuation packets.
+func frame(nal byte, cc uint8, count int) []byte {
+	out := tsPacket(testVideoPID, cc, true,
+		0x00, 0x00, 0x01, 0xE0, 0x00, 0x00, 0x80, 0x00, 0x00, // PES header
+		0x00, 0x00, 0x00, 0x01, nal,
+	)
+	for i := 1; i < count; i++ {
+		out = append(out, tsPacket(testVideoPID, cc+uint8(i), false, byte(i))...)
+	}
+	return out
+}
+
+func TestManagerJoinsAtKeyframe(t *testing.T) {
+	var stream []byte
+	stream = append(stream, tables()...)
+	stream = append(stream, frame(0x65, 0, 3)...) // IDR
+	stream = append(stream, frame(0x41, 3, 3)...) // P frame
+	keyframeAt := len(stream)
+	stream = append(stream, frame(0x67, 6, 3)...) // SPS before the next IDR
+	stream = append(stream, frame(0x41, 9, 3)...)
+
+	tests := []struct {
+		name string
+		join bool
+		want []byte
+	}{
+		{"joins at the latest keyframe", true, append(tables(), stream[keyframeAt:]...)},
+		{"disabled", false, stream},
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewBufferManager(types.BufferConfig{
+				Size:          1024 * 1024,
+				PrefetchRatio: 1,
+				MinThreshold:  len(stream),
+				MaxRetries:    1,
+				RetryDelay:    time.Millisecond,
+				KeyframeJoin:  tt.join,
+			}, logrus.NewEntry(logger))
+			if err := manager.Start(context.Background(), bytes.NewReader(stream)); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+
+			got, err := io.ReadAll(manager)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("read %d bytes, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
@@ -5,11 +5,11 @@ import (
 	"context"
 	"errors"
 	"io"
-	"log"
 	"sync"
 	"time"
 
 	"github.com/savid/iptv-proxy/pkg/types"
+	"github.com/sirupsen/logrus"
 )
 
 var (
@@ -22,7 +22,12 @@ type Manager struct {
 	buffer       *CircularBuffer
 	config       types.BufferConfig
 	retryManager *RetryManager
-	logger       *log.Logger
+	logger       *logrus.Entry
+
+	// Keyframe join, nil unless enabled in the configuration
+	index   *KeyframeIndex
+	joined  bool
+	pending []byte
 
 	// Prefetch control
 	prefetchActive bool
@@ -34,8 +39,14 @@ type Manager struct {
 }
 
 // NewBufferManager creates a new buffer manager with the specified configuration.
-func NewBufferManager(config types.BufferConfig, logger *log.Logger) *Manager {
+func NewBufferManager(config types.BufferConfig, logger *logrus.Entry) *Manager {
+	var index *KeyframeIndex
+	if config.KeyframeJoin {
+		index = NewKeyframeIndex()
+	}
+
 	return &Manager{
+		index:  index,
 		buffer: NewCircularBuffer(config.Size),
 		config: config,
 		retryManager: NewRetryManager(
@@ -86,10 +97,10 @@ func (m *Manager) prefetchLoop(ctx context.Context, reader io.Reader) {
 			n, err := m.retryManager.RetryRead(reader, buf)
 			if err != nil {
 				if errors.Is(err, io.EOF) {
-					m.logger.Printf("Source stream ended")
+					m.logger.Info("Source stream ended")
 					return
 				}
-				m.logger.Printf("Read error after retries: %v", err)
+				m.logger.Warnf("Read error after retries: %v", err)
 				return
 			}
 
@@ -98,11 +109,15 @@ func (m *Manager) prefetchLoop(ctx context.Context, reader io.Reader) {
 			for written < n {
 				nw, err := m.buffer.Write(buf[written:n])
 				if err != nil {
-					m.logger.Printf("Buffer write error: %v", err)
+					m.logger.Errorf("Buffer write error: %v", err)
 					return
 				}
 				written += nw
 			}
+
+			if m.index != nil {
+				_, _ = m.index.Write(buf[:n])
+			}
 		}
 	}
 }
@@ -114,6 +129,16 @@ func (m *Manager) Read(p []byte) (int, error) {
 		return 0, err
 	}
 
+	if !m.joined {
+		m.joined = true
+		m.join()
+	}
+	if len(m.pending) > 0 {
+		n := copy(p, m.pending)
+		m.pending = m.pending[n:]
+		return n, nil
+	}
+
 	n, err := m.buffer.Read(p)
 	if err != nil {
 		return 0, err
@@ -124,12 +149,33 @@ func (m *Manager) Read(p []byte) (int, error) {
 		m.mu.Lock()
 		m.underruns++
 		m.mu.Unlock()
-		m.logger.Printf("Buffer underrun detected (total: %d)", m.underruns)
+		m.logger.Warnf("Buffer underrun detected (total: %d)", m.underruns)
 	}
 
 	return n, nil
 }
 
+// join moves a new reader to the most recent buffered keyframe and queues
+// the stream tables ahead of it, so that players can start decoding at once
+// instead of discarding data until the next keyframe.
+func (m *Manager) join() {
+	if m.index == nil {
+		return
+	}
+
+	read, written := m.buffer.Positions()
+	offset, tables, ok := m.index.Latest(read, written)
+	if !ok || offset == read || !m.buffer.SkipTo(offset) {
+		return
+	}
+
+	m.pending = append(m.pending, tables.PAT...)
+	for _, pmt := range tables.PMTs {
+		m.pending = append(m.pending, pmt...)
+	}
+	m.logger.Infof("Joined stream at keyframe, skipped %d bytes", offset-read)
+}
+
 // WaitForData blocks until at least minBytes are available in the buffer.
 func (m *Manager) WaitForData(minBytes int) error {
 	timeout := time.After(30 * time.Second)
@@ -144,8 +190,12 @@ func (m *Manager) WaitForData(minBytes int) error {
 			if m.buffer.Available() >= minBytes {
 				return nil
 			}
-			if !m.isPrefetchActive() && m.buffer.Available() == 0 {
-				return io.EOF
+			if !m.isPrefetchActive() {
+				// The source has ended, so drain what is left
+				if m.buffer.Available() == 0 {
+					return io.EOF
+				}
+				return nil
 			}
 		}
 	}
@@ -0,0 +1,187 @@
+package data
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/epg"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+)
+
+// maxChangeHistory is the number of diffs kept by the store.
+const maxChangeHistory = 50
+
+// ChangeKind describes how a channel changed between two generations.
+type ChangeKind string
+
+const (
+	// ChangeAdded is a channel that is new to the playlist.
+	ChangeAdded ChangeKind = "added"
+	// ChangeRemoved is a channel that is no longer in the playlist.
+	ChangeRemoved ChangeKind = "removed"
+	// ChangeRenamed is a channel whose stream URL stayed but whose name
+	// changed.
+	ChangeRenamed ChangeKind = "renamed"
+	// ChangeURL is a channel whose name stayed but whose stream URL changed.
+	ChangeURL ChangeKind = "url_changed"
+	// ChangeLogo is a channel whose logo changed.
+	ChangeLogo ChangeKind = "logo_changed"
+	// ChangeCoverageDropped is a guide channel whose programmes now end
+	// earlier than before, or that lost all of its programmes.
+	ChangeCoverageDropped ChangeKind = "epg_coverage_dropped"
+)
+
+// Change is a single difference between two generations. Old and New hold
+// the changed value: the name, URL or logo, or for guide coverage the end of
+// the channel's last programme in RFC 3339, empty when it has none.
+type Change struct {
+	Kind    ChangeKind `json:"kind"`
+	Channel string     `json:"channel"`
+	Old     string     `json:"old,omitempty"`
+	New     string     `json:"new,omitempty"`
+}
+
+// Diff lists the changes of a playlist or guide generation against the one
+// it replaced.
+type Diff struct {
+	Source     Source    `json:"source"`
+	Generation int       `json:"generation"`
+	Time       time.Time `json:"time"`
+	Changes    []Change  `json:"changes"`
+}
+
+// coverage is how far the guide reaches for one channel.
+type coverage struct {
+	programmes int
+	end        time.Time
+}
+
+// diffChannels compares two playlists. Channels are matched by stream URL,
+// then by name, so a renamed channel keeps its URL and a moved channel keeps
+// its name.
+func diffChannels(previous, current []m3u.Channel) []Change {
+	byURL := make(map[string]int, len(previous))
+	byName := make(map[string]int, len(previous))
+	for i, channel := range previous {
+		if _, ok := byURL[channel.URL]; !ok {
+			byURL[channel.URL] = i
+		}
+		if _, ok := byName[channel.Name]; !ok {
+			byName[channel.Name] = i
+		}
+	}
+
+	var changes []Change
+	matched := make([]bool, len(previous))
+	for _, channel := range current {
+		i, ok := byURL[channel.URL]
+		if !ok || matched[i] {
+			i, ok = byName[channel.Name]
+		}
+		if !ok || matched[i] {
+			changes = append(changes, Change{Kind: ChangeAdded, Channel: channel.Name, New: channel.URL})
+			continue
+		}
+		matched[i] = true
+
+		old := previous[i]
+		if old.Name != channel.Name {
+			changes = append(changes, Change{Kind: ChangeRenamed, Channel: channel.Name, Old: old.Name, New: channel.Name})
+		}
+		if old.URL != channel.URL {
+			changes = append(changes, Change{Kind: ChangeURL, Channel: channel.Name, Old: old.URL, New: channel.URL})
+		}
+		if old.TVGLogo != channel.TVGLogo {
+			changes = append(changes, Change{Kind: ChangeLogo, Channel: channel.Name, Old: old.TVGLogo, New: channel.TVGLogo})
+		}
+	}
+
+	for i, channel := range previous {
+		if !matched[i] {
+			changes = append(changes, Change{Kind: ChangeRemoved, Channel: channel.Name, Old: channel.URL})
+		}
+	}
+	return changes
+}
+
+// guideCoverage summarises a filtered guide per channel. Unparseable guides
+// have no coverage.
+func guideCoverage(filtered []byte) map[string]coverage {
+	covered := make(map[string]coverage)
+	tv, err := epg.ParseStream(bytes.NewReader(filtered))
+	if err != nil {
+		return covered
+	}
+
+	for _, channel := range tv.Channels {
+		covered[channel.ID] = coverage{}
+	}
+	for _, programme := range tv.Programs {
+		c := covered[programme.Channel]
+		c.programmes++
+		if stop, err := epg.ParseTime(programme.Stop); err == nil && stop.After(c.end) {
+			c.end = stop
+		}
+		covered[programme.Channel] = c
+	}
+	return covered
+}
+
+// diffCoverage reports the channels whose guide now ends earlier than in
+// the previous generation.
+func diffCoverage(previous, current map[string]coverage) []Change {
+	var changes []Change
+	for id, before := range previous {
+		if before.programmes == 0 {
+			continue
+		}
+		after := current[id]
+		if after.programmes > 0 && !after.end.Before(before.end) {
+			continue
+		}
+
+		change := Change{Kind: ChangeCoverageDropped, Channel: id, Old: before.end.UTC().Format(time.RFC3339)}
+		if after.programmes > 0 {
+			change.New = after.end.UTC().Format(time.RFC3339)
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Channel < changes[j].Channel })
+	return changes
+}
+
+// recordChangeLocked adds a non-empty diff to the history and returns it
+// for the change handler.
+func (s *Store) recordChangeLocked(source Source, generation int, changes []Change) (Diff, bool) {
+	if len(changes) == 0 {
+		return Diff{}, false
+	}
+
+	diff := Diff{Source: source, Generation: generation, Time: time.Now(), Changes: changes}
+	s.changes = append(s.changes, diff)
+	if len(s.changes) > maxChangeHistory {
+		s.changes = s.changes[len(s.changes)-maxChangeHistory:]
+	}
+	return diff, s.onChange != nil
+}
+
+// SetChangeHandler sets a function called with every non-empty diff.
+func (s *Store) SetChangeHandler(handler func(Diff)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onChange = handler
+}
+
+// Changes returns the recent diffs, newest first.
+func (s *Store) Changes() []Diff {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	changes := make([]Diff, 0, len(s.changes))
+	for i := len(s.changes) - 1; i >= 0; i-- {
+		changes = append(changes, s.changes[i])
+	}
+	return changes
+}
@@ -0,0 +1,73 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/savid/iptv-proxy/pkg/m3u"
+)
+
+func TestDiffChannels(t *testing.T) {
+	previous := []m3u.Channel{
+		{Name: "ESPN", URL: "http://upstream/1", TVGLogo: "espn.png"},
+		{Name: "CNN", URL: "http://upstream/2"},
+		{Name: "BBC One", URL: "http://upstream/3"},
+		{Name: "Gone", URL: "http://upstream/4"},
+	}
+	current := []m3u.Channel{
+		{Name: "ESPN HD", URL: "http://upstream/1", TVGLogo: "espn-hd.png"},
+		{Name: "CNN", URL: "http://upstream/22"},
+		{Name: "BBC One", URL: "http://upstream/3"},
+		{Name: "New", URL: "http://upstream/5"},
+	}
+
+	want := []Change{
+		{Kind: ChangeRenamed, Channel: "ESPN HD", Old: "ESPN", New: "ESPN HD"},
+		{Kind: ChangeLogo, Channel: "ESPN HD", Old: "espn.png", New: "espn-hd.png"},
+		{Kind: ChangeURL, Channel: "CNN", Old: "http://upstream/2", New: "http://upstream/22"},
+		{Kind: ChangeAdded, Channel: "New", New: "http://upstream/5"},
+		{Kind: ChangeRemoved, Channel: "Gone", Old: "http://upstream/4"},
+	}
+	if got := diffChannels(previous, current); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffChannels() = %+v, want %+v", got, want)
+	}
+}
+
+func TestStoreChanges(t *testing.T) {
+	store := NewStore()
+	var notified []Diff
+	store.SetChangeHandler(func(diff Diff) { notified = append(notified, diff) })
+
+	// The first generation has nothing to compare against
+	store.SetM3U(nil, []m3u.Channel{{Name: "One", URL: "http://upstream/1"}})
+	store.SetM3U(nil, []m3u.Channel{{Name: "One", URL: "http://upstream/1"}})
+	store.SetM3U(nil, []m3u.Channel{{Name: "Two", URL: "http://upstream/1"}})
+
+	// guide lists a programme ending at the given stop time per channel
+	guide := func(stops map[string]string) []byte {
+		xml := `<tv><channel id="one"></channel><channel id="two"></channel>`
+		for channel, stop := range stops {
+			xml += `<programme channel="` + channel + `" start="20260101000000 +0000" stop="` + stop + `"><title>x</title></programme>`
+		}
+		return []byte(xml + `</tv>`)
+	}
+	store.SetEPG(nil, guide(map[string]string{"one": "20260102000000 +0000", "two": "20260102000000 +0000"}))
+	store.SetEPG(nil, guide(map[string]string{"one": "20260101120000 +0000"}))
+
+	changes := store.Changes()
+	if len(changes) != 2 || len(notified) != 2 {
+		t.Fatalf("Changes() = %+v, notified %d, want 2 diffs", changes, len(notified))
+	}
+
+	epgDiff, m3uDiff := changes[0], changes[1]
+	if m3uDiff.Source != SourceM3U || m3uDiff.Generation != 3 || len(m3uDiff.Changes) != 1 || m3uDiff.Changes[0].Kind != ChangeRenamed {
+		t.Errorf("m3u diff = %+v", m3uDiff)
+	}
+	want := []Change{
+		{Kind: ChangeCoverageDropped, Channel: "one", Old: "2026-01-02T00:00:00Z", New: "2026-01-01T12:00:00Z"},
+		{Kind: ChangeCoverageDropped, Channel: "two", Old: "2026-01-02T00:00:00Z"},
+	}
+	if epgDiff.Source != SourceEPG || epgDiff.Generation != 2 || !reflect.DeepEqual(epgDiff.Changes, want) {
+		t.Errorf("epg diff = %+v, want changes %+v", epgDiff, want)
+	}
+}
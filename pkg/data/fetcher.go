@@ -3,17 +3,20 @@ package data
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"time"
 
 	"github.com/savid/iptv-proxy/config"
 	"github.com/savid/iptv-proxy/pkg/epg"
 	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/savid/iptv-proxy/pkg/tracing"
+	"github.com/savid/iptv-proxy/pkg/upstream"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
@@ -24,7 +27,8 @@ var (
 // Fetcher handles fetching M3U and EPG data from remote sources.
 type Fetcher struct {
 	config *config.Config
-	client *http.Client
+	m3u    *upstream.Client
+	epg    *upstream.Client
 	logger *logrus.Logger
 }
 
@@ -35,29 +39,56 @@ type FetchResult struct {
 		Channels []m3u.Channel
 	}
 	EPG struct {
-		Raw      []byte
-		Filtered []byte
+		Raw        []byte
+		Filtered   []byte
+		Programmes int
 	}
 	Error error
 }
 
+// ProgressFunc receives the progress of a fetch in percent and the number
+// of channels found so far.
+type ProgressFunc func(percent, found int)
+
 // NewFetcher creates a new fetcher instance.
 func NewFetcher(cfg *config.Config, logger *logrus.Logger) *Fetcher {
 	return &Fetcher{
 		config: cfg,
-		client: &http.Client{
-			Timeout: 60 * time.Second,
-		},
+		m3u:    upstream.Default(),
+		epg:    upstream.Default(),
 		logger: logger,
 	}
 }
 
+// SetUpstream replaces the clients used to download the playlist and guide.
+func (f *Fetcher) SetUpstream(m3u, epg *upstream.Client) {
+	if m3u != nil {
+		f.m3u = m3u
+	}
+	if epg != nil {
+		f.epg = epg
+	}
+}
+
 // FetchAll fetches both M3U and EPG data, respecting their dependencies.
 func (f *Fetcher) FetchAll() (*FetchResult, error) {
+	return f.FetchAllContext(context.Background(), nil)
+}
+
+// FetchAllContext fetches both M3U and EPG data until the context is
+// cancelled, reporting progress to an optional progress function.
+func (f *Fetcher) FetchAllContext(ctx context.Context, progress ProgressFunc) (_ *FetchResult, err error) {
+	ctx, span := tracing.Start(ctx, "data.FetchAll")
+	defer func() { tracing.End(span, err) }()
+
 	result := &FetchResult{}
+	if progress == nil {
+		progress = func(int, int) {}
+	}
+	progress(0, 0)
 
 	// Fetch M3U first
-	m3uRaw, channels, err := f.fetchM3U()
+	m3uRaw, channels, err := f.fetchM3U(ctx)
 	if err != nil {
 		result.Error = fmt.Errorf("failed to fetch M3U: %w", err)
 		return result, result.Error
@@ -65,9 +96,10 @@ func (f *Fetcher) FetchAll() (*FetchResult, error) {
 
 	result.M3U.Raw = m3uRaw
 	result.M3U.Channels = channels
+	progress(50, len(channels))
 
 	// Fetch and filter EPG based on M3U channels
-	epgRaw, epgFiltered, err := f.fetchAndFilterEPG(channels)
+	epgRaw, epgFiltered, programmes, err := f.fetchAndFilterEPG(ctx, channels)
 	if err != nil {
 		result.Error = fmt.Errorf("failed to fetch EPG: %w", err)
 		return result, result.Error
@@ -75,84 +107,91 @@ func (f *Fetcher) FetchAll() (*FetchResult, error) {
 
 	result.EPG.Raw = epgRaw
 	result.EPG.Filtered = epgFiltered
+	result.EPG.Programmes = programmes
+	progress(100, len(channels))
 
 	return result, nil
 }
 
-func (f *Fetcher) fetchM3U() ([]byte, []m3u.Channel, error) {
-	f.logger.WithField("url", f.config.M3UURL).Info("Fetching M3U data")
+// FetchM3U fetches, parses and rewrites the playlist.
+func (f *Fetcher) FetchM3U(ctx context.Context) (raw []byte, channels []m3u.Channel, err error) {
+	return f.fetchM3U(ctx)
+}
 
-	// Set specific timeout for M3U fetch
-	client := &http.Client{Timeout: 30 * time.Second}
+// FetchEPG fetches the guide and filters it to the given channels,
+// returning the number of programmes kept.
+func (f *Fetcher) FetchEPG(ctx context.Context, channels []m3u.Channel) (raw, filtered []byte, programmes int, err error) {
+	return f.fetchAndFilterEPG(ctx, channels)
+}
 
-	resp, err := client.Get(f.config.M3UURL)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to fetch M3U: %w", err)
-	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
+func (f *Fetcher) fetchM3U(ctx context.Context) (_ []byte, _ []m3u.Channel, err error) {
+	ctx, span := tracing.Start(ctx, "data.FetchM3U")
+	defer func() { tracing.End(span, err) }()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, nil, fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)
-	}
+	f.logger.WithField("url", f.config.M3UURL).Info("Fetching M3U data")
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := f.download(ctx, f.m3u, f.config.M3UURL, "M3U")
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read M3U body: %w", err)
+		return nil, nil, err
 	}
 
 	// Parse M3U
+	_, parseSpan := tracing.Start(ctx, "m3u.Parse")
 	channels, err := m3u.Parse(body)
+	parseSpan.SetAttributes(attribute.Int("channels", len(channels)))
+	tracing.End(parseSpan, err)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to parse M3U: %w", err)
 	}
 
 	// Rewrite M3U URLs
+	_, rewriteSpan := tracing.Start(ctx, "m3u.Rewrite")
 	rewrittenM3U := m3u.Rewrite(channels, f.config.BaseURL)
+	tracing.End(rewriteSpan, nil)
 
 	f.logger.WithField("channels", len(channels)).Info("Successfully fetched and processed M3U")
 	return rewrittenM3U, channels, nil
 }
 
-func (f *Fetcher) fetchAndFilterEPG(channels []m3u.Channel) (raw, filtered []byte, err error) {
-	f.logger.WithField("url", f.config.EPGURL).Info("Fetching EPG data")
+func (f *Fetcher) fetchAndFilterEPG(ctx context.Context, channels []m3u.Channel) (raw, filtered []byte, programmes int, err error) {
+	ctx, span := tracing.Start(ctx, "data.FetchEPG")
+	defer func() { tracing.End(span, err) }()
 
-	resp, err := f.client.Get(f.config.EPGURL)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to fetch EPG: %w", err)
-	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, nil, fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)
-	}
+	f.logger.WithField("url", f.config.EPGURL).Info("Fetching EPG data")
 
 	// Read the raw EPG data
-	raw, err = io.ReadAll(resp.Body)
+	raw, err = f.download(ctx, f.epg, f.config.EPGURL, "EPG")
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read EPG body: %w", err)
+		return nil, nil, 0, err
 	}
 
 	// Parse EPG from raw data
+	_, parseSpan := tracing.Start(ctx, "epg.Parse")
 	tv, err := epg.ParseStream(bytes.NewReader(raw))
+	tracing.End(parseSpan, err)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to parse EPG: %w", err)
+		return nil, nil, 0, fmt.Errorf("failed to parse EPG: %w", err)
 	}
 
 	// Filter EPG based on M3U channels
+	_, filterSpan := tracing.Start(ctx, "epg.Filter")
 	filteredTV, channelMap := epg.Filter(tv, channels)
+	filterSpan.SetAttributes(
+		attribute.Int("channels", len(filteredTV.Channels)),
+		attribute.Int("programmes", len(filteredTV.Programs)),
+	)
+	tracing.End(filterSpan, nil)
 
 	// Encode filtered EPG to XML
+	_, encodeSpan := tracing.Start(ctx, "epg.Encode")
 	var buf bytes.Buffer
 	buf.WriteString(xml.Header)
 	encoder := xml.NewEncoder(&buf)
 	encoder.Indent("", "  ")
-	if err := encoder.Encode(filteredTV); err != nil {
-		return nil, nil, fmt.Errorf("failed to encode filtered EPG: %w", err)
+	err = encoder.Encode(filteredTV)
+	tracing.End(encodeSpan, err)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to encode filtered EPG: %w", err)
 	}
 
 	f.logger.WithFields(logrus.Fields{
@@ -161,5 +200,38 @@ func (f *Fetcher) fetchAndFilterEPG(channels []m3u.Channel) (raw, filtered []byt
 		"matched_channels":  len(channelMap),
 	}).Info("Successfully fetched and filtered EPG")
 
-	return raw, buf.Bytes(), nil
+	return raw, buf.Bytes(), len(filteredTV.Programs), nil
+}
+
+// download reads the body of a playlist or guide URL. The span records the
+// time to the response headers and the body size.
+func (f *Fetcher) download(ctx context.Context, client *upstream.Client, url, kind string) (_ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "data.Download", attribute.String("kind", kind))
+	defer func() { tracing.End(span, err) }()
+
+	req, err := client.NewRequest(ctx, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", kind, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", kind, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	span.AddEvent("response headers")
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)
+	}
+
+	// Read response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s body: %w", kind, err)
+	}
+	span.SetAttributes(attribute.Int("bytes", len(body)))
+
+	return body, nil
 }
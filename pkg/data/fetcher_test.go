@@ -0,0 +1,69 @@
+package data
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/tracing/tracingtest"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestFetchAllTracesStages(t *testing.T) {
+	exporter := tracingtest.Record(t)
+
+	var fetches atomic.Int32
+	upstream := testUpstream(t, nil, &fetches)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := &config.Config{M3UURL: upstream.URL + "/iptv.m3u", EPGURL: upstream.URL + "/epg.xml", BaseURL: "http://proxy"}
+
+	if _, err := NewFetcher(cfg, logger).FetchAllContext(context.Background(), nil); err != nil {
+		t.Fatalf("FetchAllContext() error = %v", err)
+	}
+
+	names := tracingtest.Names(exporter)
+	for _, want := range []string{
+		"data.FetchAll", "data.FetchM3U", "data.FetchEPG", "data.Download",
+		"m3u.Parse", "m3u.Rewrite", "epg.Parse", "epg.Filter", "epg.Encode",
+	} {
+		if !slices.Contains(names, want) {
+			t.Errorf("spans = %v, missing %s", names, want)
+		}
+	}
+
+	root, _ := tracingtest.Find(exporter, "data.FetchAll")
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID() != root.SpanContext.TraceID() {
+			t.Errorf("span %s is not part of the fetch trace", span.Name)
+		}
+	}
+}
+
+func TestFetchAllTracesFailures(t *testing.T) {
+	exporter := tracingtest.Record(t)
+
+	var fetches atomic.Int32
+	upstream := testUpstream(t, nil, &fetches)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := &config.Config{M3UURL: upstream.URL + "/missing.m3u", EPGURL: upstream.URL + "/epg.xml", BaseURL: "http://proxy"}
+
+	if _, err := NewFetcher(cfg, logger).FetchAllContext(context.Background(), nil); err == nil {
+		t.Fatalf("FetchAllContext() error = nil, want the failed playlist")
+	}
+
+	for _, name := range []string{"data.FetchAll", "data.FetchM3U", "data.Download"} {
+		span, ok := tracingtest.Find(exporter, name)
+		if !ok || span.Status.Code != codes.Error {
+			t.Errorf("span %s status = %+v, want an error", name, span.Status)
+		}
+	}
+	if _, ok := tracingtest.Find(exporter, "data.FetchEPG"); ok {
+		t.Errorf("guide was fetched after the playlist failed")
+	}
+}
@@ -0,0 +1,160 @@
+package data
+
+import (
+	"sort"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/m3u"
+)
+
+// ChannelStatus describes the result of the most recent channel health check.
+type ChannelStatus string
+
+const (
+	// ChannelStatusUnknown means the channel has not been checked yet.
+	ChannelStatusUnknown ChannelStatus = "unknown"
+	// ChannelStatusOK means the last check succeeded.
+	ChannelStatusOK ChannelStatus = "ok"
+	// ChannelStatusFailing means the last check failed.
+	ChannelStatusFailing ChannelStatus = "failing"
+)
+
+// ChannelHealth contains the health check history for a single channel.
+type ChannelHealth struct {
+	Name                string        `json:"name"`
+	URL                 string        `json:"url"`
+	Status              ChannelStatus `json:"status"`
+	HTTPStatus          int           `json:"http_status,omitempty"`
+	VideoCodec          string        `json:"video_codec,omitempty"`
+	AudioCodec          string        `json:"audio_codec,omitempty"`
+	Error               string        `json:"error,omitempty"`
+	Latency             time.Duration `json:"latency"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	LastChecked         time.Time     `json:"last_checked"`
+	LastSuccess         time.Time     `json:"last_success,omitzero"`
+	Hidden              bool          `json:"hidden"`
+}
+
+// SetHideDeadAfter sets the number of consecutive failed checks after which a
+// channel is hidden from playlists. Zero disables hiding.
+func (s *Store) SetHideDeadAfter(failures int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hideDeadAfter = failures
+}
+
+// RecordChannelHealth stores the outcome of a health check for a channel.
+// Consecutive failures are tracked across calls.
+func (s *Store) RecordChannelHealth(result ChannelHealth) ChannelHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.channelHealth == nil {
+		s.channelHealth = make(map[string]ChannelHealth)
+	}
+
+	previous, exists := s.channelHealth[result.URL]
+	if result.Status == ChannelStatusOK {
+		result.ConsecutiveFailures = 0
+		result.LastSuccess = result.LastChecked
+	} else {
+		result.ConsecutiveFailures = previous.ConsecutiveFailures + 1
+		if exists {
+			result.LastSuccess = previous.LastSuccess
+		}
+	}
+
+	s.channelHealth[result.URL] = result
+	return result
+}
+
+// GetChannelHealth returns the health of a channel by its upstream URL.
+func (s *Store) GetChannelHealth(url string) (ChannelHealth, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	health, ok := s.channelHealth[url]
+	if ok {
+		health.Hidden = s.isHiddenLocked(health)
+	}
+	return health, ok
+}
+
+// ChannelHealthReport returns the health of every channel in the current playlist.
+// Channels that have not been checked yet are reported with an unknown status.
+func (s *Store) ChannelHealthReport() []ChannelHealth {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.m3uData == nil {
+		return []ChannelHealth{}
+	}
+
+	report := make([]ChannelHealth, 0, len(s.m3uData.Channels))
+	for _, channel := range s.m3uData.Channels {
+		health, ok := s.channelHealth[channel.URL]
+		if !ok {
+			health = ChannelHealth{
+				Name:   channel.Name,
+				URL:    channel.URL,
+				Status: ChannelStatusUnknown,
+			}
+		}
+		health.Hidden = s.isHiddenLocked(health)
+		report = append(report, health)
+	}
+
+	sort.SliceStable(report, func(i, j int) bool {
+		return report[i].ConsecutiveFailures > report[j].ConsecutiveFailures
+	})
+
+	return report
+}
+
+// VisibleChannels returns the playlist channels that are not hidden because of
+// failed health checks, and whether any channel was hidden.
+func (s *Store) VisibleChannels() ([]m3u.Channel, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.m3uData == nil {
+		return nil, false
+	}
+
+	if s.hideDeadAfter <= 0 || len(s.channelHealth) == 0 {
+		return s.m3uData.Channels, false
+	}
+
+	visible := make([]m3u.Channel, 0, len(s.m3uData.Channels))
+	for _, channel := range s.m3uData.Channels {
+		if health, ok := s.channelHealth[channel.URL]; ok && s.isHiddenLocked(health) {
+			continue
+		}
+		visible = append(visible, channel)
+	}
+
+	return visible, len(visible) != len(s.m3uData.Channels)
+}
+
+// pruneChannelHealthLocked drops the health of channels that are no longer
+// in the playlist.
+func (s *Store) pruneChannelHealthLocked(channels []m3u.Channel) {
+	if len(s.channelHealth) == 0 {
+		return
+	}
+
+	current := make(map[string]struct{}, len(channels))
+	for _, channel := range channels {
+		current[channel.URL] = struct{}{}
+	}
+	for url := range s.channelHealth {
+		if _, ok := current[url]; !ok {
+			delete(s.channelHealth, url)
+		}
+	}
+}
+
+func (s *Store) isHiddenLocked(health ChannelHealth) bool {
+	return s.hideDeadAfter > 0 && health.ConsecutiveFailures >= s.hideDeadAfter
+}
@@ -2,17 +2,117 @@ package data
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
 	"time"
 
+	"github.com/savid/iptv-proxy/pkg/events"
+	"github.com/savid/iptv-proxy/pkg/tracing"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+const (
+	// initialBackoff is the delay before retrying the first failed refresh.
+	initialBackoff = 30 * time.Second
+	// maxBackoff bounds the delay between retries of failing refreshes.
+	maxBackoff = 5 * time.Minute
+	// backoffJitter is the fraction by which retry delays are randomised.
+	backoffJitter = 0.2
+)
+
+var (
+	// ErrScanInProgress is returned when a refresh is requested while one is
+	// already running.
+	ErrScanInProgress = errors.New("scan already in progress")
+	// ErrInvalidSource is returned for an unknown refresh source.
+	ErrInvalidSource = errors.New("invalid refresh source")
+)
+
+// Source selects the data a refresh fetches.
+type Source string
+
+const (
+	// SourceAll refreshes the playlist, then the guide.
+	SourceAll Source = "all"
+	// SourceM3U refreshes only the playlist.
+	SourceM3U Source = "m3u"
+	// SourceEPG refreshes only the guide, filtered to the current playlist.
+	SourceEPG Source = "epg"
+)
+
+// ParseSource parses a refresh source; empty selects SourceAll.
+func ParseSource(value string) (Source, error) {
+	switch source := Source(value); source {
+	case "":
+		return SourceAll, nil
+	case SourceAll, SourceM3U, SourceEPG:
+		return source, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidSource, value)
+	}
+}
+
+// ScanStatus reports the progress of the running refresh, shown to clients
+// as a lineup scan.
+type ScanStatus struct {
+	InProgress bool
+	// Progress is the progress of the running refresh in percent.
+	Progress int
+	// Found is the number of channels found by the running refresh.
+	Found int
+}
+
+// SourceStatus reports the refresh history of the playlist or the guide.
+type SourceStatus struct {
+	LastAttempt         time.Time     `json:"last_attempt,omitzero"`
+	LastSuccess         time.Time     `json:"last_success,omitzero"`
+	LastFailure         time.Time     `json:"last_failure,omitzero"`
+	LastError           string        `json:"last_error,omitempty"`
+	Duration            time.Duration `json:"duration"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	// Channels is the number of channels of the last playlist.
+	Channels int `json:"channels,omitempty"`
+	// Programmes is the number of programmes of the last filtered guide.
+	Programmes int `json:"programmes,omitempty"`
+}
+
+// RefreshStatus reports the state of the refresh cycle.
+type RefreshStatus struct {
+	InProgress  bool         `json:"in_progress"`
+	NextRefresh time.Time    `json:"next_refresh,omitzero"`
+	M3U         SourceStatus `json:"m3u"`
+	EPG         SourceStatus `json:"epg"`
+}
+
+// flight is a running refresh shared by every caller that requests it.
+type flight struct {
+	source Source
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// covers reports whether the flight refreshes the given source.
+func (f *flight) covers(source Source) bool {
+	return f.source == SourceAll || f.source == source
+}
+
 // Refresher manages periodic data refresh cycles in the background.
 type Refresher struct {
 	store    *Store
 	fetcher  *Fetcher
 	interval time.Duration
 	logger   *logrus.Logger
+	events   *events.Bus
+
+	mu       sync.Mutex
+	current  *flight
+	scan     ScanStatus
+	status   RefreshStatus
+	failures int
 }
 
 // NewRefresher creates a new refresh manager.
@@ -25,56 +125,251 @@ func NewRefresher(store *Store, fetcher *Fetcher, interval time.Duration, logger
 	}
 }
 
+// SetEvents publishes refresh failures and recoveries to bus.
+func (r *Refresher) SetEvents(bus *events.Bus) {
+	r.events = bus
+}
+
 // Start begins the refresh cycle in a goroutine, stopping when the context is cancelled.
 func (r *Refresher) Start(ctx context.Context) {
 	ticker := time.NewTicker(r.interval)
 	defer ticker.Stop()
+	r.setNextRefresh(r.interval)
 
 	for {
 		select {
 		case <-ctx.Done():
 			r.logger.Info("Refresh manager shutting down")
+			r.AbortScan()
 			return
 		case <-ticker.C:
-			err := r.refresh()
-			nextInterval := r.scheduleNextRefresh(err)
-			if nextInterval != r.interval {
-				// Reset ticker with new interval for backoff
-				ticker.Reset(nextInterval)
+			err := r.Refresh(ctx, SourceAll)
+			if ctx.Err() != nil {
+				continue
 			}
+			nextInterval := r.scheduleNextRefresh(err)
+			// Reset ticker with new interval for backoff
+			ticker.Reset(nextInterval)
+			r.setNextRefresh(nextInterval)
 		}
 	}
 }
 
-func (r *Refresher) refresh() error {
-	r.logger.Info("Starting data refresh")
+// Refresh fetches a source immediately and waits for the result. Callers
+// requesting a source that is already being refreshed share that refresh.
+func (r *Refresher) Refresh(ctx context.Context, source Source) error {
+	for {
+		f, joined := r.join(source)
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if !joined || f.covers(source) {
+			return f.err
+		}
+		// The refresh that was running fetched another source
+	}
+}
 
-	result, err := r.fetcher.FetchAll()
-	if err != nil {
-		r.logger.WithError(err).Error("Failed to refresh data")
-		return err
+// Scan starts a refresh in the background, returning ErrScanInProgress
+// when one is already running.
+func (r *Refresher) Scan() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.current != nil {
+		return ErrScanInProgress
 	}
+	r.launchLocked(SourceAll)
+	return nil
+}
+
+// AbortScan cancels the running refresh, if any, keeping the current data.
+func (r *Refresher) AbortScan() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	// Update store only on successful fetch
-	r.store.SetM3U(result.M3U.Raw, result.M3U.Channels)
-	r.store.SetEPG(result.EPG.Raw, result.EPG.Filtered)
+	if r.current != nil {
+		r.current.cancel()
+	}
+}
 
-	r.logger.Info("Data refresh completed successfully")
+// ScanStatus returns the progress of the running refresh.
+func (r *Refresher) ScanStatus() ScanStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.scan
+}
+
+// Status returns the refresh history of the playlist and the guide.
+func (r *Refresher) Status() RefreshStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := r.status
+	status.InProgress = r.current != nil
+	return status
+}
+
+// join returns the running refresh, or starts one for the source. The
+// boolean reports whether an already running refresh was joined.
+func (r *Refresher) join(source Source) (*flight, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.current != nil {
+		return r.current, true
+	}
+	return r.launchLocked(source), false
+}
+
+// launchLocked starts a refresh of the source in the background. The
+// refresh outlives the request that started it and stops with AbortScan.
+func (r *Refresher) launchLocked(source Source) *flight {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &flight{source: source, cancel: cancel, done: make(chan struct{})}
+	r.current = f
+	r.scan = ScanStatus{InProgress: true}
+
+	go func() {
+		defer cancel()
+		f.err = r.refresh(ctx, source)
+
+		r.mu.Lock()
+		r.current = nil
+		r.scan.InProgress = false
+		r.mu.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+// progress records the progress of the running refresh.
+func (r *Refresher) progress(percent, found int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.scan.Progress = percent
+	r.scan.Found = found
+}
+
+// refresh fetches a source and stores it. A playlist is kept even when the
+// guide that follows it fails.
+func (r *Refresher) refresh(ctx context.Context, source Source) (err error) {
+	ctx, span := tracing.Start(ctx, "data.Refresh", attribute.String("source", string(source)))
+	defer func() { tracing.End(span, err) }()
+
+	r.logger.WithField("source", source).Info("Starting data refresh")
+	r.progress(0, 0)
+
+	_, channels, _ := r.store.GetM3U()
+	if source != SourceEPG {
+		started := time.Now()
+		raw, fetched, err := r.fetcher.FetchM3U(ctx)
+		r.record(SourceM3U, &r.status.M3U, started, err, func(s *SourceStatus) { s.Channels = len(fetched) })
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to refresh M3U data")
+			return fmt.Errorf("failed to fetch M3U: %w", err)
+		}
+		r.store.SetM3U(raw, fetched)
+		channels = fetched
+	}
+
+	if source != SourceM3U {
+		r.progress(50, len(channels))
+		started := time.Now()
+		raw, filtered, programmes, err := r.fetcher.FetchEPG(ctx, channels)
+		r.record(SourceEPG, &r.status.EPG, started, err, func(s *SourceStatus) { s.Programmes = programmes })
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to refresh EPG data")
+			return fmt.Errorf("failed to fetch EPG: %w", err)
+		}
+		r.store.SetEPG(raw, filtered)
+	}
+
+	r.progress(100, len(channels))
+	r.logger.WithField("source", source).Info("Data refresh completed successfully")
 	return nil
 }
 
+// record updates the status of a source after a fetch that started at
+// started, applying counts on success, and publishes failures and
+// recoveries.
+func (r *Refresher) record(source Source, status *SourceStatus, started time.Time, err error, counts func(*SourceStatus)) {
+	r.mu.Lock()
+	now := time.Now()
+	failures := status.ConsecutiveFailures
+	status.LastAttempt = started
+	status.Duration = now.Sub(started)
+	if err != nil {
+		status.LastFailure = now
+		status.LastError = err.Error()
+		status.ConsecutiveFailures++
+	} else {
+		status.LastSuccess = now
+		status.LastError = ""
+		status.ConsecutiveFailures = 0
+		counts(status)
+	}
+	r.mu.Unlock()
+
+	switch {
+	case err != nil:
+		r.events.Publish(events.Event{
+			Type:    events.TypeRefreshFailed,
+			Subject: string(source),
+			Message: err.Error(),
+			Fields:  map[string]any{"consecutive_failures": failures + 1},
+		})
+	case failures > 0:
+		r.events.Publish(events.Event{
+			Type:    events.TypeRefreshRecovered,
+			Subject: string(source),
+			Message: fmt.Sprintf("Refreshed %s after %d failed attempts", source, failures),
+		})
+	}
+}
+
+// setNextRefresh records when the next scheduled refresh runs.
+func (r *Refresher) setNextRefresh(after time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status.NextRefresh = time.Now().Add(after)
+}
+
+// scheduleNextRefresh returns the delay before the next scheduled refresh:
+// the refresh interval after a success, or an exponentially growing,
+// jittered backoff after consecutive failures.
 func (r *Refresher) scheduleNextRefresh(lastError error) time.Duration {
 	if lastError == nil {
 		// Success - use normal interval
+		r.failures = 0
 		return r.interval
 	}
 
-	// Error - implement exponential backoff with max 5 minutes
-	backoffDuration := r.interval / 2
-	if backoffDuration > 5*time.Minute {
-		backoffDuration = 5 * time.Minute
+	r.failures++
+	backoffDuration := backoff(r.failures, r.interval)
+	r.logger.WithFields(logrus.Fields{
+		"interval": backoffDuration,
+		"failures": r.failures,
+	}).Warn("Using backoff interval due to refresh error")
+	return backoffDuration
+}
+
+// backoff returns the delay before retrying after failures consecutive
+// failed refreshes. It doubles from initialBackoff up to maxBackoff, never
+// exceeds the refresh interval, and is randomised by backoffJitter so that
+// proxies sharing a provider do not retry in step.
+func backoff(failures int, interval time.Duration) time.Duration {
+	delay := initialBackoff
+	for i := 1; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
 	}
+	delay = min(delay, maxBackoff, interval)
 
-	r.logger.WithField("interval", backoffDuration).Warn("Using backoff interval due to refresh error")
-	return backoffDuration
+	jitter := time.Duration(float64(delay) * backoffJitter * (2*rand.Float64() - 1)) //nolint:gosec // jitter does not need a secure source
+	return delay + jitter
 }
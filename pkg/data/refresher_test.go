@@ -0,0 +1,174 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/sirupsen/logrus"
+)
+
+// testUpstream serves a two channel playlist and an empty guide, counting
+// playlist fetches. The playlist waits on release when it is not nil.
+func testUpstream(t *testing.T, release chan struct{}, m3uFetches *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/iptv.m3u":
+			m3uFetches.Add(1)
+			if release != nil {
+				<-release
+			}
+			_, _ = w.Write([]byte("#EXTM3U\n#EXTINF:-1,One\nhttp://upstream/one\n#EXTINF:-1,Two\nhttp://upstream/two\n"))
+		case "/epg.xml":
+			_, _ = w.Write([]byte(`<tv><programme channel="One" start="20260101000000 +0000" stop="20260101010000 +0000"><title>News</title></programme></tv>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testRefresher(t *testing.T, upstream string) (*Refresher, *Store) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := &config.Config{M3UURL: upstream + "/iptv.m3u", EPGURL: upstream + "/epg.xml", BaseURL: "http://proxy"}
+	store := NewStore()
+	return NewRefresher(store, NewFetcher(cfg, logger), time.Hour, logger), store
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		interval time.Duration
+		want     time.Duration
+	}{
+		{1, time.Hour, 30 * time.Second},
+		{2, time.Hour, time.Minute},
+		{4, time.Hour, 4 * time.Minute},
+		{10, time.Hour, 5 * time.Minute},
+		{10, time.Minute, time.Minute},
+	}
+
+	for _, tt := range tests {
+		for range 20 {
+			got := backoff(tt.failures, tt.interval)
+			low := time.Duration(float64(tt.want) * (1 - backoffJitter))
+			high := time.Duration(float64(tt.want) * (1 + backoffJitter))
+			if got < low || got > high {
+				t.Errorf("backoff(%d, %s) = %s, want %s ±20%%", tt.failures, tt.interval, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestRefreshSingleFlight(t *testing.T) {
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	refresher, store := testRefresher(t, testUpstream(t, release, &fetches).URL)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = refresher.Refresh(context.Background(), SourceAll)
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !refresher.Status().InProgress || fetches.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("refresh did not start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Let late callers join the running refresh
+	time.Sleep(50 * time.Millisecond)
+	if err := refresher.Scan(); !errors.Is(err, ErrScanInProgress) {
+		t.Errorf("Scan() error = %v, want ErrScanInProgress", err)
+	}
+	close(release)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("Refresh() error = %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("playlist fetched %d times, want 1", n)
+	}
+	if _, channels, ok := store.GetM3U(); !ok || len(channels) != 2 {
+		t.Errorf("store has %d channels, want 2", len(channels))
+	}
+}
+
+func TestRefreshStatus(t *testing.T) {
+	var fetches atomic.Int32
+	upstream := testUpstream(t, nil, &fetches)
+	refresher, store := testRefresher(t, upstream.URL)
+
+	if err := refresher.Refresh(context.Background(), SourceAll); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	status := refresher.Status()
+	if status.InProgress || status.M3U.Channels != 2 || status.EPG.Programmes == 0 || status.M3U.LastSuccess.IsZero() {
+		t.Errorf("status = %+v", status)
+	}
+
+	// A guide refresh keeps the playlist and filters to its channels
+	if err := refresher.Refresh(context.Background(), SourceEPG); err != nil {
+		t.Fatalf("Refresh(epg) error = %v", err)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("playlist fetched %d times, want 1", n)
+	}
+
+	// A failing playlist keeps the previous data and reports the error
+	refresher.fetcher.config.M3UURL = upstream.URL + "/missing.m3u"
+	if err := refresher.Refresh(context.Background(), SourceM3U); !errors.Is(err, ErrUnexpectedStatus) {
+		t.Errorf("Refresh(m3u) error = %v, want ErrUnexpectedStatus", err)
+	}
+	status = refresher.Status()
+	if status.M3U.ConsecutiveFailures != 1 || status.M3U.LastError == "" || status.M3U.Channels != 2 || status.EPG.ConsecutiveFailures != 0 {
+		t.Errorf("status after failure = %+v", status)
+	}
+	if _, channels, _ := store.GetM3U(); len(channels) != 2 {
+		t.Errorf("store has %d channels after a failed refresh, want 2", len(channels))
+	}
+
+	// Times that never happened are left out rather than reported as year 1
+	raw, err := json.Marshal(refresher.Status())
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var encoded struct {
+		M3U map[string]any `json:"m3u"`
+		EPG map[string]any `json:"epg"`
+	}
+	if err := json.Unmarshal(raw, &encoded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if _, ok := encoded.EPG["last_failure"]; ok {
+		t.Errorf("epg status = %s, want no last_failure", raw)
+	}
+	if _, ok := encoded.M3U["last_failure"]; !ok {
+		t.Errorf("m3u status = %s, want the last_failure", raw)
+	}
+
+	if _, err := ParseSource("xmltv"); !errors.Is(err, ErrInvalidSource) {
+		t.Errorf("ParseSource() error = %v, want ErrInvalidSource", err)
+	}
+}
@@ -14,6 +14,13 @@ type Store struct {
 	epgData             *EPGData
 	lastSync            time.Time
 	testChannelsEnabled bool
+	channelHealth       map[string]ChannelHealth
+	hideDeadAfter       int
+	m3uGeneration       int
+	epgGeneration       int
+	coverage            map[string]coverage
+	changes             []Diff
+	onChange            func(Diff)
 }
 
 // M3UData contains M3U playlist data and metadata.
@@ -35,30 +42,57 @@ func NewStore() *Store {
 	return &Store{}
 }
 
-// SetM3U stores M3U data in the store.
+// SetM3U stores M3U data in the store, recording how the channels changed
+// since the previous playlist.
 func (s *Store) SetM3U(raw []byte, channels []m3u.Channel) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
+	var changes []Change
+	if s.m3uData != nil {
+		changes = diffChannels(s.m3uData.Channels, channels)
+	}
+	s.m3uGeneration++
 	s.m3uData = &M3UData{
 		Raw:       raw,
 		Channels:  channels,
 		UpdatedAt: time.Now(),
 	}
 	s.lastSync = time.Now()
+	s.pruneChannelHealthLocked(channels)
+	diff, notify := s.recordChangeLocked(SourceM3U, s.m3uGeneration, changes)
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	if notify {
+		onChange(diff)
+	}
 }
 
-// SetEPG stores EPG data in the store.
+// SetEPG stores EPG data in the store, recording the channels whose guide
+// coverage dropped since the previous guide.
 func (s *Store) SetEPG(raw []byte, filtered []byte) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	// Parse outside the lock; guides can be large
+	covered := guideCoverage(filtered)
 
+	s.mu.Lock()
+	var changes []Change
+	if s.epgData != nil {
+		changes = diffCoverage(s.coverage, covered)
+	}
+	s.epgGeneration++
+	s.coverage = covered
 	s.epgData = &EPGData{
 		Raw:       raw,
 		Filtered:  filtered,
 		UpdatedAt: time.Now(),
 	}
 	s.lastSync = time.Now()
+	diff, notify := s.recordChangeLocked(SourceEPG, s.epgGeneration, changes)
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	if notify {
+		onChange(diff)
+	}
 }
 
 // GetM3U retrieves M3U data from the store. Returns false if no data is available.
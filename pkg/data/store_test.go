@@ -1,6 +1,7 @@
 package data
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -123,3 +124,98 @@ func TestStoreConcurrency(_ *testing.T) {
 		<-done
 	}
 }
+
+func TestStoreChannelHealth(t *testing.T) {
+	store := NewStore()
+	store.SetHideDeadAfter(2)
+
+	channels := []m3u.Channel{
+		{Name: "Alive", URL: "http://example.com/alive"},
+		{Name: "Dead", URL: "http://example.com/dead"},
+	}
+	store.SetM3U([]byte("#EXTM3U\n"), channels)
+
+	// Nothing hidden before any checks
+	if _, hidden := store.VisibleChannels(); hidden {
+		t.Error("No channels should be hidden before health checks")
+	}
+
+	now := time.Now()
+	store.RecordChannelHealth(ChannelHealth{URL: channels[0].URL, Status: ChannelStatusOK, LastChecked: now})
+	store.RecordChannelHealth(ChannelHealth{URL: channels[1].URL, Status: ChannelStatusFailing, LastChecked: now})
+
+	// One failure is below the threshold
+	if _, hidden := store.VisibleChannels(); hidden {
+		t.Error("Channel should not be hidden after a single failure")
+	}
+
+	result := store.RecordChannelHealth(ChannelHealth{URL: channels[1].URL, Status: ChannelStatusFailing, LastChecked: now})
+	if result.ConsecutiveFailures != 2 {
+		t.Errorf("Expected 2 consecutive failures, got %d", result.ConsecutiveFailures)
+	}
+
+	visible, hidden := store.VisibleChannels()
+	if !hidden || len(visible) != 1 || visible[0].Name != "Alive" {
+		t.Errorf("Expected only the alive channel to be visible, got %v", visible)
+	}
+
+	health, ok := store.GetChannelHealth(channels[1].URL)
+	if !ok || !health.Hidden {
+		t.Error("Dead channel should be reported as hidden")
+	}
+
+	// A successful check resets the failure count
+	store.RecordChannelHealth(ChannelHealth{URL: channels[1].URL, Status: ChannelStatusOK, LastChecked: now})
+	if _, hidden := store.VisibleChannels(); hidden {
+		t.Error("Recovered channel should be visible again")
+	}
+
+	report := store.ChannelHealthReport()
+	if len(report) != len(channels) {
+		t.Errorf("Expected %d report entries, got %d", len(channels), len(report))
+	}
+}
+
+func TestSetM3UPrunesChannelHealth(t *testing.T) {
+	store := NewStore()
+	channels := []m3u.Channel{
+		{Name: "Kept", URL: "http://example.com/kept"},
+		{Name: "Removed", URL: "http://example.com/removed"},
+	}
+	store.SetM3U([]byte("#EXTM3U\n"), channels)
+
+	now := time.Now()
+	for _, channel := range channels {
+		store.RecordChannelHealth(ChannelHealth{URL: channel.URL, Status: ChannelStatusFailing, LastChecked: now})
+	}
+
+	store.SetM3U([]byte("#EXTM3U\n"), channels[:1])
+
+	if _, ok := store.GetChannelHealth(channels[0].URL); !ok {
+		t.Error("Health of a channel still in the playlist should be kept")
+	}
+	if _, ok := store.GetChannelHealth(channels[1].URL); ok {
+		t.Error("Health of a removed channel should be dropped")
+	}
+
+	// A channel that returns starts from a clean history
+	store.SetM3U([]byte("#EXTM3U\n"), channels)
+	result := store.RecordChannelHealth(ChannelHealth{URL: channels[1].URL, Status: ChannelStatusFailing, LastChecked: now})
+	if result.ConsecutiveFailures != 1 {
+		t.Errorf("Expected 1 consecutive failure for a returning channel, got %d", result.ConsecutiveFailures)
+	}
+}
+
+func TestChannelHealthOmitsUnsetLastSuccess(t *testing.T) {
+	raw, err := json.Marshal(ChannelHealth{URL: "http://example.com/dead", Status: ChannelStatusFailing})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var encoded map[string]any
+	if err := json.Unmarshal(raw, &encoded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if _, ok := encoded["last_success"]; ok {
+		t.Errorf("health = %s, want no last_success", raw)
+	}
+}
@@ -0,0 +1,214 @@
+// Package devices defines the virtual HDHomeRun tuners advertised by the
+// proxy, each with its own identity and channel subset.
+package devices
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+)
+
+// DefaultName is the friendly name of the default tuner.
+const DefaultName = "IPTV-Proxy"
+
+var (
+	// ErrInvalidDevice is returned when a virtual device definition is invalid.
+	ErrInvalidDevice = errors.New("invalid virtual device")
+	// ErrDuplicateDevice is returned when two devices share an ID, path or port.
+	ErrDuplicateDevice = errors.New("duplicate virtual device")
+)
+
+// Device is an emulated HDHomeRun tuner. Virtual devices are served under
+// their own base path or port and list the channels matching any of their
+// channel names, groups or pattern. Without an ID, a virtual device gets one
+// derived from the proxy's identity and its name.
+type Device struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Path       string   `json:"path,omitempty"`
+	Port       int      `json:"port,omitempty"`
+	TunerCount int      `json:"tuner_count,omitempty"`
+	Channels   []string `json:"channels,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
+	Pattern    string   `json:"pattern,omitempty"`
+
+	// BaseURL is the URL the device is served at.
+	BaseURL string `json:"-"`
+	// UUID is the UUID of the device's UPnP unique device name.
+	UUID string `json:"-"`
+
+	re *regexp.Regexp
+}
+
+// deviceFile is the JSON layout of the devices file.
+type deviceFile struct {
+	Devices []*Device `json:"devices"`
+}
+
+// Default returns the tuner that serves every channel at the base URL.
+func Default(cfg *config.Config, identity Identity) *Device {
+	return &Device{
+		ID:         identity.DeviceID,
+		Name:       DefaultName,
+		TunerCount: cfg.TunerCount,
+		BaseURL:    strings.TrimRight(cfg.BaseURL, "/"),
+		UUID:       identity.UUID,
+	}
+}
+
+// Load returns the default tuner, with the proxy's identity, followed by the
+// virtual devices of the configured devices file.
+func Load(cfg *config.Config, identity Identity) ([]*Device, error) {
+	if cfg.Devices == "" {
+		return []*Device{Default(cfg, identity)}, nil
+	}
+
+	raw, err := os.ReadFile(cfg.Devices) // #nosec G304 - path is provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read devices file: %w", err)
+	}
+	return Parse(raw, cfg, identity)
+}
+
+// Parse parses and validates JSON virtual devices, returning them after the
+// default tuner.
+func Parse(raw []byte, cfg *config.Config, identity Identity) ([]*Device, error) {
+	var file deviceFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse devices: %w", err)
+	}
+
+	base, err := url.Parse(strings.TrimRight(cfg.BaseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+
+	all := []*Device{Default(cfg, identity)}
+	ids := map[string]bool{identity.DeviceID: true}
+	paths := make(map[string]bool)
+	ports := map[int]bool{cfg.Port: true}
+
+	for i, device := range file.Devices {
+		if err := device.resolve(cfg, identity, *base); err != nil {
+			return nil, fmt.Errorf("device %d: %w", i, err)
+		}
+
+		switch {
+		case ids[device.ID]:
+			return nil, fmt.Errorf("%w: id %s", ErrDuplicateDevice, device.ID)
+		case device.Port == 0 && paths[device.Path]:
+			return nil, fmt.Errorf("%w: path %s", ErrDuplicateDevice, device.Path)
+		case device.Port != 0 && ports[device.Port]:
+			return nil, fmt.Errorf("%w: port %d", ErrDuplicateDevice, device.Port)
+		}
+		ids[device.ID] = true
+		if device.Port != 0 {
+			ports[device.Port] = true
+		} else {
+			paths[device.Path] = true
+		}
+		all = append(all, device)
+	}
+	return all, nil
+}
+
+// resolve validates a device and fills its defaults and base URL.
+func (d *Device) resolve(cfg *config.Config, identity Identity, base url.URL) error {
+	if d.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidDevice)
+	}
+	derived := identity.Derive(d.Name)
+	d.UUID = derived.UUID
+	switch {
+	case d.ID == "":
+		d.ID = derived.DeviceID
+	case !ValidDeviceID(d.ID):
+		return fmt.Errorf("%w: id %s is not 8 hex digits with a valid checksum", ErrInvalidDevice, d.ID)
+	default:
+		d.ID = strings.ToUpper(d.ID)
+	}
+	if d.TunerCount == 0 {
+		d.TunerCount = cfg.TunerCount
+	}
+	if d.TunerCount < 0 {
+		return fmt.Errorf("%w: tuner_count must not be negative", ErrInvalidDevice)
+	}
+	if d.Pattern != "" {
+		re, err := regexp.Compile(d.Pattern)
+		if err != nil {
+			return fmt.Errorf("%w: pattern: %w", ErrInvalidDevice, err)
+		}
+		d.re = re
+	}
+
+	switch {
+	case d.Port != 0:
+		if d.Port < 1 || d.Port > 65535 {
+			return fmt.Errorf("%w: port %d", ErrInvalidDevice, d.Port)
+		}
+		d.Path = ""
+		base.Host = net.JoinHostPort(base.Hostname(), strconv.Itoa(d.Port))
+		d.BaseURL = base.String()
+	case d.Path != "":
+		d.Path = "/" + strings.Trim(d.Path, "/")
+		if d.Path == "/" || reservedPath(d.Path) {
+			return fmt.Errorf("%w: path %s is used by the proxy", ErrInvalidDevice, d.Path)
+		}
+		d.BaseURL = base.String() + d.Path
+	default:
+		return fmt.Errorf("%w: path or port is required", ErrInvalidDevice)
+	}
+	return nil
+}
+
+// reservedPath reports whether a base path would shadow a proxy route.
+func reservedPath(path string) bool {
+	switch strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0] {
+	case "api", "stream", "catchup", "test", "test-icon", "debug", "plex-debug", "health":
+		return true
+	}
+	return false
+}
+
+// UDN is the UPnP unique device name of the device.
+func (d *Device) UDN() string {
+	return "uuid:" + d.UUID
+}
+
+// LineupURL is the URL of the device's channel lineup.
+func (d *Device) LineupURL() string {
+	return d.BaseURL + "/lineup.json"
+}
+
+// Filtered reports whether the device lists a subset of the channels.
+func (d *Device) Filtered() bool {
+	return len(d.Channels) > 0 || len(d.Groups) > 0 || d.re != nil
+}
+
+// Matches reports whether the device lists a channel. Devices without
+// channel names, groups or pattern list every channel.
+func (d *Device) Matches(channel m3u.Channel) bool {
+	if !d.Filtered() {
+		return true
+	}
+	for _, name := range d.Channels {
+		if strings.EqualFold(name, channel.Name) {
+			return true
+		}
+	}
+	for _, group := range d.Groups {
+		if strings.EqualFold(group, channel.Group) {
+			return true
+		}
+	}
+	return d.re != nil && d.re.MatchString(channel.Name)
+}
@@ -0,0 +1,193 @@
+package devices
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+)
+
+// testIdentity is a fixed proxy identity.
+var testIdentity = Identity{DeviceID: "12345674", UUID: "6f1c2a4e-8d3b-4c1a-9e2f-0123456789ab"} //nolint:gochecknoglobals // test fixture
+
+func testConfig() *config.Config {
+	return &config.Config{BaseURL: "http://192.0.2.1:8080/", Port: 8080, TunerCount: 2}
+}
+
+func TestParse(t *testing.T) {
+	raw := []byte(`{"devices": [
+		{"id": "1050A1BD", "name": "Sports", "path": "sports/", "tuner_count": 4, "groups": ["US Sports"], "pattern": "(?i)espn"},
+		{"id": "1050a1c4", "name": "Kids", "port": 8081, "channels": ["Cartoons"]}
+	]}`)
+
+	all, err := Parse(raw, testConfig(), testIdentity)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(all) != 3 || all[0].ID != testIdentity.DeviceID || all[0].UUID != testIdentity.UUID {
+		t.Fatalf("Parse() = %d devices, want the default and two virtual devices", len(all))
+	}
+
+	sports, kids := all[1], all[2]
+	if sports.Path != "/sports" || sports.BaseURL != "http://192.0.2.1:8080/sports" || sports.TunerCount != 4 {
+		t.Errorf("sports = %+v", sports)
+	}
+	if kids.BaseURL != "http://192.0.2.1:8081" || kids.TunerCount != 2 || kids.LineupURL() != "http://192.0.2.1:8081/lineup.json" {
+		t.Errorf("kids = %+v", kids)
+	}
+	if kids.ID != "1050A1C4" {
+		t.Errorf("ID = %s, want it upper case", kids.ID)
+	}
+	if kids.UDN() != "uuid:"+testIdentity.Derive("Kids").UUID || kids.UUID == sports.UUID {
+		t.Errorf("UDN() = %s, want a UUID derived from the name", kids.UDN())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want error
+	}{
+		{"missing name", `{"devices": [{"path": "/a"}]}`, ErrInvalidDevice},
+		{"bad checksum", `{"devices": [{"name": "A", "id": "1050A1B2", "path": "/a"}]}`, ErrInvalidDevice},
+		{"not hex", `{"devices": [{"name": "A", "id": "IPTV-001", "path": "/a"}]}`, ErrInvalidDevice},
+		{"missing path and port", `{"devices": [{"name": "A"}]}`, ErrInvalidDevice},
+		{"reserved path", `{"devices": [{"name": "A", "path": "/api"}]}`, ErrInvalidDevice},
+		{"bad pattern", `{"devices": [{"name": "A", "path": "/a", "pattern": "("}]}`, ErrInvalidDevice},
+		{"bad port", `{"devices": [{"name": "A", "port": 70000}]}`, ErrInvalidDevice},
+		{"duplicate id", `{"devices": [{"name": "A", "id": "1050A1BD", "path": "/a"}, {"name": "B", "id": "1050A1BD", "path": "/b"}]}`, ErrDuplicateDevice},
+		{"default id", `{"devices": [{"name": "A", "id": "12345674", "path": "/a"}]}`, ErrDuplicateDevice},
+		{"duplicate path", `{"devices": [{"name": "A", "path": "/a"}, {"name": "B", "path": "a"}]}`, ErrDuplicateDevice},
+		{"main port", `{"devices": [{"name": "A", "port": 8080}]}`, ErrDuplicateDevice},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.raw), testConfig(), testIdentity); !errors.Is(err, tt.want) {
+				t.Errorf("Parse() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	all, err := Parse([]byte(`{"devices": [
+		{"name": "Sports", "path": "/sports", "groups": ["us sports"], "pattern": "ESPN"},
+		{"name": "Kids", "path": "/kids", "channels": ["Cartoons"]}
+	]}`), testConfig(), testIdentity)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		channel m3u.Channel
+		want    [3]bool
+	}{
+		{m3u.Channel{Name: "Fox Sports", Group: "US Sports"}, [3]bool{true, true, false}},
+		{m3u.Channel{Name: "ESPN 2", Group: "Other"}, [3]bool{true, true, false}},
+		{m3u.Channel{Name: "cartoons", Group: "Kids"}, [3]bool{true, false, true}},
+		{m3u.Channel{Name: "News", Group: "News"}, [3]bool{true, false, false}},
+	}
+
+	for _, tt := range tests {
+		for i, device := range all {
+			if got := device.Matches(tt.channel); got != tt.want[i] {
+				t.Errorf("%s.Matches(%s) = %v, want %v", device.Name, tt.channel.Name, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestValidDeviceID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"12345674", true},
+		{"1050a1bd", true},
+		{"12345675", false},
+		{"1234567", false},
+		{"1234567G", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidDeviceID(tt.id); got != tt.want {
+			t.Errorf("ValidDeviceID(%s) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestLoadIdentityPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "identity.json")
+
+	first, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatalf("LoadIdentity() error = %v", err)
+	}
+	if !ValidDeviceID(first.DeviceID) || !validUUID(first.UUID) {
+		t.Errorf("generated identity %+v is invalid", first)
+	}
+
+	second, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatalf("LoadIdentity() error = %v", err)
+	}
+	if second != first {
+		t.Errorf("identity changed across loads: %+v, then %+v", first, second)
+	}
+
+	// Generated identities differ between proxies
+	other, err := NewIdentity()
+	if err != nil {
+		t.Fatalf("NewIdentity() error = %v", err)
+	}
+	if other.DeviceID == first.DeviceID || other.UUID == first.UUID {
+		t.Errorf("two generated identities collide")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"device_id": "12345675", "uuid": "x"}`), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := LoadIdentity(path); !errors.Is(err, ErrInvalidIdentity) {
+		t.Errorf("LoadIdentity() error = %v, want ErrInvalidIdentity", err)
+	}
+}
+
+func TestLoadIdentityOrNewFallsBack(t *testing.T) {
+	// A regular file in place of the directory makes the identity unwritable
+	parent := filepath.Join(t.TempDir(), "state")
+	if err := os.WriteFile(parent, nil, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	identity, err := LoadIdentityOrNew(filepath.Join(parent, "identity.json"))
+	if err == nil {
+		t.Error("LoadIdentityOrNew() error = nil, want the write failure")
+	}
+	if !ValidDeviceID(identity.DeviceID) || !validUUID(identity.UUID) {
+		t.Errorf("fallback identity %+v is invalid", identity)
+	}
+
+	path := filepath.Join(t.TempDir(), "identity.json")
+	first, err := LoadIdentityOrNew(path)
+	if err != nil {
+		t.Fatalf("LoadIdentityOrNew() error = %v", err)
+	}
+	if second, _ := LoadIdentity(path); second != first {
+		t.Errorf("persisted identity = %+v, want %+v", second, first)
+	}
+}
+
+func TestDeriveIsStable(t *testing.T) {
+	a, b := testIdentity.Derive("Sports"), testIdentity.Derive("Sports")
+	if a != b {
+		t.Errorf("Derive() is not stable: %+v, %+v", a, b)
+	}
+	if !ValidDeviceID(a.DeviceID) || a == testIdentity.Derive("News") {
+		t.Errorf("Derive() = %+v, want a valid ID unique to the name", a)
+	}
+}
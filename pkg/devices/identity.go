@@ -0,0 +1,174 @@
+package devices
+
+import (
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // name-based UUIDs are defined with SHA-1
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// identityFile is the name of the identity file in the user configuration
+// directory when no path is configured.
+const identityFile = "identity.json"
+
+// ErrInvalidIdentity is returned when a persisted identity is invalid.
+var ErrInvalidIdentity = errors.New("invalid device identity")
+
+// checksumTable is the nibble lookup table of the HDHomeRun device ID
+// checksum.
+var checksumTable = [16]uint32{0xA, 0x5, 0xF, 0x6, 0x7, 0xC, 0x1, 0xB, 0x9, 0x2, 0x8, 0xD, 0x4, 0x3, 0xE, 0x0} //nolint:gochecknoglobals // lookup table
+
+// Identity is the generated identity of the proxy, kept across restarts so
+// that clients recognise the tuner.
+type Identity struct {
+	// DeviceID is the 8 hex digit HDHomeRun device ID.
+	DeviceID string `json:"device_id"`
+	// UUID is the UUID of the UPnP unique device name.
+	UUID string `json:"uuid"`
+}
+
+// LoadIdentity reads the identity file, creating it with a new identity on
+// first run. An empty path uses identity.json in the user configuration
+// directory.
+func LoadIdentity(path string) (Identity, error) {
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return Identity{}, fmt.Errorf("failed to find configuration directory: %w", err)
+		}
+		path = filepath.Join(dir, "iptv-proxy", identityFile)
+	}
+
+	raw, err := os.ReadFile(path) // #nosec G304 - path is provided by the operator
+	switch {
+	case err == nil:
+		var identity Identity
+		if err := json.Unmarshal(raw, &identity); err != nil {
+			return Identity{}, fmt.Errorf("%w: %w", ErrInvalidIdentity, err)
+		}
+		if !ValidDeviceID(identity.DeviceID) || !validUUID(identity.UUID) {
+			return Identity{}, fmt.Errorf("%w: %s", ErrInvalidIdentity, path)
+		}
+		return identity, nil
+	case !errors.Is(err, os.ErrNotExist):
+		return Identity{}, fmt.Errorf("failed to read identity: %w", err)
+	}
+
+	identity, err := NewIdentity()
+	if err != nil {
+		return Identity{}, err
+	}
+	raw, err = json.MarshalIndent(identity, "", "  ")
+	if err != nil {
+		return Identity{}, fmt.Errorf("failed to encode identity: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return Identity{}, fmt.Errorf("failed to create identity directory: %w", err)
+	}
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		return Identity{}, fmt.Errorf("failed to write identity: %w", err)
+	}
+	return identity, nil
+}
+
+// LoadIdentityOrNew reads the identity file like LoadIdentity. When the file
+// cannot be read, parsed or written, it returns a new identity that is only
+// kept in memory, along with the error so that the caller can warn that
+// clients will see a new tuner after a restart.
+func LoadIdentityOrNew(path string) (Identity, error) {
+	identity, err := LoadIdentity(path)
+	if err == nil {
+		return identity, nil
+	}
+
+	identity, genErr := NewIdentity()
+	if genErr != nil {
+		return Identity{}, errors.Join(err, genErr)
+	}
+	return identity, err
+}
+
+// NewIdentity generates a random device ID and UUID.
+func NewIdentity() (Identity, error) {
+	var seed [20]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return Identity{}, fmt.Errorf("failed to generate identity: %w", err)
+	}
+
+	id := withChecksum(binary.BigEndian.Uint32(seed[:4]))
+	uuid := seed[4:]
+	uuid[6] = uuid[6]&0x0F | 0x40 // version 4
+	uuid[8] = uuid[8]&0x3F | 0x80 // RFC 4122 variant
+	return Identity{DeviceID: formatDeviceID(id), UUID: formatUUID(uuid)}, nil
+}
+
+// Derive returns a stable identity for a virtual device, derived from the
+// proxy's identity and a name.
+func (i Identity) Derive(name string) Identity {
+	sum := sha1.Sum([]byte(i.UUID + "/" + name)) //nolint:gosec // not used for security
+	uuid := sum[:16]
+	uuid[6] = uuid[6]&0x0F | 0x50 // version 5
+	uuid[8] = uuid[8]&0x3F | 0x80 // RFC 4122 variant
+
+	return Identity{
+		DeviceID: formatDeviceID(withChecksum(binary.BigEndian.Uint32(sum[16:]))),
+		UUID:     formatUUID(uuid),
+	}
+}
+
+// ValidDeviceID reports whether id is 8 hex digits with a valid HDHomeRun
+// checksum.
+func ValidDeviceID(id string) bool {
+	if len(id) != 8 {
+		return false
+	}
+	value, err := strconv.ParseUint(id, 16, 32)
+	if err != nil {
+		return false
+	}
+	return checksum(uint32(value)) == 0
+}
+
+// checksum XORs the table lookups of the odd nibbles, from the most
+// significant, with the even nibbles. Valid IDs give 0.
+func checksum(id uint32) uint32 {
+	var sum uint32
+	for shift := 28; shift >= 0; shift -= 8 {
+		sum ^= checksumTable[(id>>shift)&0x0F]
+		sum ^= (id >> (shift - 4)) & 0x0F
+	}
+	return sum
+}
+
+// withChecksum replaces the last nibble of id so that its checksum is valid.
+func withChecksum(id uint32) uint32 {
+	id &^= 0x0F
+	return id | checksum(id)
+}
+
+// formatDeviceID formats a device ID as 8 upper case hex digits.
+func formatDeviceID(id uint32) string {
+	return fmt.Sprintf("%08X", id)
+}
+
+// formatUUID formats 16 bytes as a UUID.
+func formatUUID(b []byte) string {
+	h := hex.EncodeToString(b[:16])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// validUUID reports whether s is a formatted UUID.
+func validUUID(s string) bool {
+	if len(s) != 36 || strings.Count(s, "-") != 4 {
+		return false
+	}
+	_, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	return err == nil
+}
@@ -0,0 +1,661 @@
+// Package dvr schedules and records programmes from the EPG for clients
+// that have no DVR of their own.
+package dvr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/data"
+	"github.com/savid/iptv-proxy/pkg/tuner"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrInvalidJob is returned when a recording job is malformed.
+	ErrInvalidJob = errors.New("invalid recording job")
+	// ErrUnknownChannel is returned when a job names a channel that is not in the playlist.
+	ErrUnknownChannel = errors.New("unknown channel")
+	// ErrUnknownProgramme is returned when a job names a programme that is not in the EPG.
+	ErrUnknownProgramme = errors.New("unknown programme")
+	// ErrJobNotFound is returned when a job ID does not exist.
+	ErrJobNotFound = errors.New("job not found")
+	// ErrRecordingNotFound is returned when a recording ID does not exist.
+	ErrRecordingNotFound = errors.New("recording not found")
+	// ErrConflict is returned when a recording would need more tuners than are available.
+	ErrConflict = errors.New("recording conflicts with other recordings")
+	// ErrNoTuner is recorded when no tuner is free when a recording starts.
+	ErrNoTuner = errors.New("no free tuner")
+	// ErrStreamStatus is recorded when the stream pipeline answers with an error status.
+	ErrStreamStatus = errors.New("stream returned an error status")
+)
+
+const (
+	// stateFile holds the jobs and recordings in the DVR directory.
+	stateFile = "dvr.json"
+	// defaultTick is how often the scheduler starts due recordings.
+	defaultTick = 5 * time.Second
+	// defaultRetryDelay is the wait before reconnecting a recording whose stream ended early.
+	defaultRetryDelay = 5 * time.Second
+)
+
+// JobKind selects how a job finds what to record.
+type JobKind string
+
+const (
+	// JobManual records a channel for a fixed time range.
+	JobManual JobKind = "manual"
+	// JobProgramme records one EPG programme.
+	JobProgramme JobKind = "programme"
+	// JobSeries records every EPG programme whose title matches a pattern.
+	JobSeries JobKind = "series"
+)
+
+// Status is the state of a recording.
+type Status string
+
+const (
+	// StatusScheduled is a recording waiting for its start time.
+	StatusScheduled Status = "scheduled"
+	// StatusRecording is a recording in progress.
+	StatusRecording Status = "recording"
+	// StatusCompleted is a finished recording.
+	StatusCompleted Status = "completed"
+	// StatusFailed is a recording that could not be made.
+	StatusFailed Status = "failed"
+	// StatusConflict is a recording that was not scheduled because no tuner is free for it.
+	StatusConflict Status = "conflict"
+	// StatusCancelled is a recording stopped through the API.
+	StatusCancelled Status = "cancelled"
+)
+
+// Job describes what to record. Padding values are durations such as "2m";
+// empty values use the configured defaults.
+type Job struct {
+	ID            string    `json:"id"`
+	Kind          JobKind   `json:"kind"`
+	Channel       string    `json:"channel,omitempty"`
+	Start         time.Time `json:"start,omitzero"`
+	End           time.Time `json:"end,omitzero"`
+	Title         string    `json:"title,omitempty"`
+	ProgrammeID   string    `json:"programme_id,omitempty"`
+	TitlePattern  string    `json:"title_pattern,omitempty"`
+	PaddingBefore string    `json:"padding_before,omitempty"`
+	PaddingAfter  string    `json:"padding_after,omitempty"`
+	KeepLast      int       `json:"keep_last,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Recording is a single scheduled, running or finished recording.
+type Recording struct {
+	ID          string    `json:"id"`
+	JobID       string    `json:"job_id"`
+	Channel     string    `json:"channel"`
+	URL         string    `json:"url"`
+	ProgrammeID string    `json:"programme_id,omitempty"`
+	Title       string    `json:"title"`
+	SubTitle    string    `json:"sub_title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	RecordStart time.Time `json:"record_start"`
+	RecordEnd   time.Time `json:"record_end"`
+	Status      Status    `json:"status"`
+	Path        string    `json:"path,omitempty"`
+	Bytes       int64     `json:"bytes"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at,omitzero"`
+	FinishedAt  time.Time `json:"finished_at,omitzero"`
+
+	// written counts the bytes of a running recording without the
+	// scheduler lock; syncBytes copies it to Bytes.
+	written *atomic.Int64
+}
+
+// active reports whether the recording still needs a tuner.
+func (r *Recording) active() bool {
+	return r.Status == StatusScheduled || r.Status == StatusRecording
+}
+
+// syncBytes updates Bytes with what a running recording has written.
+func (r *Recording) syncBytes() {
+	if r.written != nil {
+		r.Bytes = r.written.Load()
+	}
+}
+
+// overlaps reports whether the padded time ranges of two recordings overlap.
+func (r *Recording) overlaps(other *Recording) bool {
+	return r.RecordStart.Before(other.RecordEnd) && other.RecordStart.Before(r.RecordEnd)
+}
+
+// state is the persisted scheduler state.
+type state struct {
+	Jobs       []*Job       `json:"jobs"`
+	Recordings []*Recording `json:"recordings"`
+}
+
+// Scheduler expands jobs into recordings and records them through the
+// stream handler, one tuner per recording.
+type Scheduler struct {
+	store         *data.Store
+	tuners        *tuner.Pool
+	handler       http.Handler
+	dir           string
+	paddingBefore time.Duration
+	paddingAfter  time.Duration
+	retention     time.Duration
+	tick          time.Duration
+	retryDelay    time.Duration
+	now           func() time.Time
+	logger        *logrus.Logger
+
+	mu         sync.Mutex
+	ctx        context.Context
+	jobs       map[string]*Job
+	recordings map[string]*Recording
+	cancels    map[string]context.CancelFunc
+	guideSync  time.Time
+	wg         sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler that stores recordings and its state in
+// the configured DVR directory and records by serving stream requests on
+// handler.
+func NewScheduler(
+	cfg *config.Config,
+	store *data.Store,
+	tuners *tuner.Pool,
+	handler http.Handler,
+	logger *logrus.Logger,
+) (*Scheduler, error) {
+	s := &Scheduler{
+		store:         store,
+		tuners:        tuners,
+		handler:       handler,
+		dir:           cfg.DVRDir,
+		paddingBefore: cfg.DVRPaddingBefore,
+		paddingAfter:  cfg.DVRPaddingAfter,
+		retention:     cfg.DVRRetention,
+		tick:          defaultTick,
+		retryDelay:    defaultRetryDelay,
+		now:           time.Now,
+		logger:        logger,
+		ctx:           context.Background(),
+		jobs:          make(map[string]*Job),
+		recordings:    make(map[string]*Recording),
+		cancels:       make(map[string]context.CancelFunc),
+	}
+
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create DVR directory: %w", err)
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Start runs the scheduler until the context is cancelled, then stops all
+// recordings.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+
+	s.runOnce()
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("DVR scheduler shutting down")
+			s.wg.Wait()
+			return
+		case <-ticker.C:
+			s.runOnce()
+		}
+	}
+}
+
+// runOnce refreshes series from a new guide, starts due recordings and
+// applies retention. The state is saved only when it changed.
+func (s *Scheduler) runOnce() {
+	s.refreshSeries()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	now := s.now()
+	for _, rec := range s.recordings {
+		if rec.Status != StatusScheduled {
+			continue
+		}
+		switch {
+		case !now.Before(rec.RecordEnd):
+			s.finishLocked(rec, StatusFailed, "missed: the scheduler was not running")
+			changed = true
+		case !now.Before(rec.RecordStart):
+			s.startLocked(rec)
+			changed = true
+		}
+	}
+
+	if s.applyRetentionLocked() || changed {
+		s.saveLocked()
+	}
+}
+
+// AddJob validates a job and schedules its recordings. Manual and programme
+// jobs are rejected with ErrConflict when no tuner is free for them; series
+// recordings that conflict are kept with the conflict status.
+func (s *Scheduler) AddJob(job Job) (Job, []Recording, error) {
+	if err := s.validate(&job); err != nil {
+		return Job{}, nil, err
+	}
+	job.ID = newID()
+	job.CreatedAt = s.now()
+
+	// The guide is parsed without the lock so that running recordings
+	// and API requests are not held up
+	g, err := s.loadGuide()
+	if err != nil && job.Kind != JobManual {
+		return Job{}, nil, err
+	}
+
+	planned, err := s.expand(&job, g)
+	if err != nil {
+		return Job{}, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job.Kind != JobSeries {
+		var conflicts []Recording
+		for _, rec := range planned {
+			for _, other := range s.conflictsLocked(rec) {
+				conflicts = append(conflicts, *other)
+			}
+		}
+		if len(conflicts) > 0 {
+			return Job{}, conflicts, ErrConflict
+		}
+	}
+
+	s.jobs[job.ID] = &job
+	added := s.scheduleLocked(planned)
+	s.saveLocked()
+
+	s.logger.WithFields(logrus.Fields{
+		"job":        job.ID,
+		"kind":       job.Kind,
+		"recordings": len(added),
+	}).Info("DVR job added")
+	return job, added, nil
+}
+
+// DeleteJob removes a job with its pending recordings and stops the
+// running ones. Finished recordings are kept.
+func (s *Scheduler) DeleteJob(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[id]; !ok {
+		return ErrJobNotFound
+	}
+	delete(s.jobs, id)
+
+	for recID, rec := range s.recordings {
+		if rec.JobID != id {
+			continue
+		}
+		switch rec.Status {
+		case StatusScheduled, StatusConflict:
+			delete(s.recordings, recID)
+		case StatusRecording:
+			s.cancelLocked(rec)
+		}
+	}
+	s.saveLocked()
+	return nil
+}
+
+// DeleteRecording stops a running recording, unschedules a pending one or
+// deletes a finished one with its file.
+func (s *Scheduler) DeleteRecording(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.recordings[id]
+	if !ok {
+		return ErrRecordingNotFound
+	}
+
+	if rec.Status == StatusRecording {
+		s.cancelLocked(rec)
+	} else {
+		s.removeLocked(rec)
+	}
+	s.saveLocked()
+	return nil
+}
+
+// Jobs returns all jobs ordered by creation time.
+func (s *Scheduler) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// Recordings returns all recordings ordered by start time.
+func (s *Scheduler) Recordings() []Recording {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recordings := make([]Recording, 0, len(s.recordings))
+	for _, rec := range s.recordings {
+		rec.syncBytes()
+		recordings = append(recordings, *rec)
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		if recordings[i].RecordStart.Equal(recordings[j].RecordStart) {
+			return recordings[i].Channel < recordings[j].Channel
+		}
+		return recordings[i].RecordStart.Before(recordings[j].RecordStart)
+	})
+	return recordings
+}
+
+// validate checks a job before it is scheduled.
+func (s *Scheduler) validate(job *Job) error {
+	for _, padding := range []string{job.PaddingBefore, job.PaddingAfter} {
+		if padding == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(padding); err != nil || d < 0 {
+			return fmt.Errorf("%w: padding %q", ErrInvalidJob, padding)
+		}
+	}
+	if job.KeepLast < 0 {
+		return fmt.Errorf("%w: keep_last must not be negative", ErrInvalidJob)
+	}
+
+	switch job.Kind {
+	case JobManual:
+		if job.Channel == "" {
+			return fmt.Errorf("%w: channel is required", ErrInvalidJob)
+		}
+		if !job.Start.Before(job.End) || !job.End.After(s.now()) {
+			return fmt.Errorf("%w: start must be before end and end in the future", ErrInvalidJob)
+		}
+	case JobProgramme:
+		if job.ProgrammeID == "" {
+			return fmt.Errorf("%w: programme_id is required", ErrInvalidJob)
+		}
+	case JobSeries:
+		if job.TitlePattern == "" {
+			return fmt.Errorf("%w: title_pattern is required", ErrInvalidJob)
+		}
+		if _, err := regexp.Compile(job.TitlePattern); err != nil {
+			return fmt.Errorf("%w: title_pattern: %w", ErrInvalidJob, err)
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidJob, job.Kind)
+	}
+	return nil
+}
+
+// padding returns the padding of a job, falling back to the defaults.
+func (s *Scheduler) padding(job *Job) (time.Duration, time.Duration) {
+	before, after := s.paddingBefore, s.paddingAfter
+	if d, err := time.ParseDuration(job.PaddingBefore); err == nil {
+		before = d
+	}
+	if d, err := time.ParseDuration(job.PaddingAfter); err == nil {
+		after = d
+	}
+	return before, after
+}
+
+// refreshSeries schedules newly listed programmes of series jobs once the
+// guide has been refreshed. The guide is parsed without the lock.
+func (s *Scheduler) refreshSeries() {
+	synced := s.store.LastSync()
+	s.mu.Lock()
+	stale := !synced.Equal(s.guideSync)
+	s.mu.Unlock()
+	if !stale {
+		return
+	}
+
+	g, err := s.loadGuide()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.guideSync = synced
+	if err != nil {
+		return
+	}
+
+	changed := false
+	for _, job := range s.jobs {
+		if job.Kind != JobSeries {
+			continue
+		}
+		planned, err := s.expand(job, g)
+		if err != nil {
+			s.logger.WithError(err).WithField("job", job.ID).Warn("Failed to refresh DVR series")
+			continue
+		}
+		if added := s.scheduleLocked(planned); len(added) > 0 {
+			changed = true
+			s.logger.WithFields(logrus.Fields{
+				"job":        job.ID,
+				"recordings": len(added),
+			}).Info("Scheduled new series recordings")
+		}
+	}
+	if changed {
+		s.saveLocked()
+	}
+}
+
+// scheduleLocked adds planned recordings that are not scheduled yet,
+// marking those that conflict.
+func (s *Scheduler) scheduleLocked(planned []*Recording) []Recording {
+	var added []Recording
+	for _, rec := range planned {
+		if s.existsLocked(rec) {
+			continue
+		}
+		if len(s.conflictsLocked(rec)) > 0 {
+			rec.Status = StatusConflict
+			rec.Error = ErrConflict.Error()
+		}
+		s.recordings[rec.ID] = rec
+		added = append(added, *rec)
+	}
+	return added
+}
+
+// existsLocked reports whether the job already has a recording of the same
+// programme or time range.
+func (s *Scheduler) existsLocked(rec *Recording) bool {
+	for _, other := range s.recordings {
+		if other.JobID != rec.JobID || other.Channel != rec.Channel {
+			continue
+		}
+		if other.ProgrammeID == rec.ProgrammeID && other.Start.Equal(rec.Start) {
+			return true
+		}
+	}
+	return false
+}
+
+// conflictsLocked returns the active recordings overlapping rec when
+// recording all of them at some point would need more tuners than exist.
+func (s *Scheduler) conflictsLocked(rec *Recording) []*Recording {
+	var overlapping []*Recording
+	for _, other := range s.recordings {
+		if other.active() && other.overlaps(rec) {
+			overlapping = append(overlapping, other)
+		}
+	}
+
+	// The number of recordings at once peaks at the start of one of them
+	points := []time.Time{rec.RecordStart}
+	for _, other := range overlapping {
+		if other.RecordStart.After(rec.RecordStart) {
+			points = append(points, other.RecordStart)
+		}
+	}
+	for _, point := range points {
+		count := 1
+		for _, other := range overlapping {
+			if !point.Before(other.RecordStart) && point.Before(other.RecordEnd) {
+				count++
+			}
+		}
+		if count > s.tuners.Capacity() {
+			return overlapping
+		}
+	}
+	return nil
+}
+
+// applyRetentionLocked deletes finished recordings beyond the keep limit of
+// their job and those older than the retention period, reporting whether it
+// deleted any.
+func (s *Scheduler) applyRetentionLocked() bool {
+	deleted := false
+	byJob := make(map[string][]*Recording)
+	for _, rec := range s.recordings {
+		if rec.Status != StatusCompleted {
+			continue
+		}
+		byJob[rec.JobID] = append(byJob[rec.JobID], rec)
+	}
+	for jobID, recordings := range byJob {
+		job, ok := s.jobs[jobID]
+		if !ok || job.KeepLast == 0 || len(recordings) <= job.KeepLast {
+			continue
+		}
+		sort.Slice(recordings, func(i, j int) bool { return recordings[i].Start.After(recordings[j].Start) })
+		for _, rec := range recordings[job.KeepLast:] {
+			s.logger.WithField("path", rec.Path).Info("Deleting recording beyond the job's keep limit")
+			s.removeLocked(rec)
+			deleted = true
+		}
+	}
+
+	if s.retention <= 0 {
+		return deleted
+	}
+	cutoff := s.now().Add(-s.retention)
+	for _, rec := range s.recordings {
+		if !rec.active() && rec.Status != StatusConflict && rec.FinishedAt.Before(cutoff) {
+			s.logger.WithField("path", rec.Path).Info("Deleting recording past the retention period")
+			s.removeLocked(rec)
+			deleted = true
+		}
+	}
+	return deleted
+}
+
+// removeLocked deletes a recording and its file.
+func (s *Scheduler) removeLocked(rec *Recording) {
+	if rec.Path != "" {
+		if err := os.Remove(rec.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.logger.WithError(err).WithField("path", rec.Path).Warn("Failed to delete recording file")
+		}
+	}
+	delete(s.recordings, rec.ID)
+}
+
+// load reads the persisted state. Recordings that were running when the
+// process stopped are marked failed.
+func (s *Scheduler) load() error {
+	raw, err := os.ReadFile(filepath.Join(s.dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read DVR state: %w", err)
+	}
+
+	var st state
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return fmt.Errorf("failed to parse DVR state: %w", err)
+	}
+	for _, job := range st.Jobs {
+		s.jobs[job.ID] = job
+	}
+	for _, rec := range st.Recordings {
+		if rec.Status == StatusRecording {
+			rec.Status = StatusFailed
+			rec.Error = "interrupted: the proxy stopped during the recording"
+		}
+		s.recordings[rec.ID] = rec
+	}
+	return nil
+}
+
+// saveLocked writes the state to the DVR directory.
+func (s *Scheduler) saveLocked() {
+	st := state{
+		Jobs:       make([]*Job, 0, len(s.jobs)),
+		Recordings: make([]*Recording, 0, len(s.recordings)),
+	}
+	for _, job := range s.jobs {
+		st.Jobs = append(st.Jobs, job)
+	}
+	for _, rec := range s.recordings {
+		rec.syncBytes()
+		st.Recordings = append(st.Recordings, rec)
+	}
+
+	raw, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to encode DVR state")
+		return
+	}
+
+	// Write and rename so that a crash never leaves a truncated file
+	path := filepath.Join(s.dir, stateFile)
+	if err := os.WriteFile(path+".tmp", raw, 0o600); err != nil {
+		s.logger.WithError(err).Error("Failed to save DVR state")
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		s.logger.WithError(err).Error("Failed to save DVR state")
+	}
+}
+
+// newID returns a random identifier.
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
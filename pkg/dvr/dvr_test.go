@@ -0,0 +1,363 @@
+package dvr
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/data"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/savid/iptv-proxy/pkg/tuner"
+	"github.com/sirupsen/logrus"
+)
+
+const testGuide = `<?xml version="1.0" encoding="UTF-8"?>
+<tv>
+  <channel id="news.uk"><display-name>News</display-name></channel>
+  <channel id="films.uk"><display-name>Films</display-name></channel>
+  <programme channel="news.uk" start="20260101110000 +0000" stop="20260101120000 +0000">
+    <title>Evening News</title>
+    <sub-title>Part 1</sub-title>
+  </programme>
+  <programme channel="news.uk" start="20260101120000 +0000" stop="20260101130000 +0000">
+    <title>Evening News</title>
+    <sub-title>Part 2</sub-title>
+  </programme>
+  <programme channel="films.uk" start="20260101113000 +0000" stop="20260101123000 +0000">
+    <title>The Movie</title>
+  </programme>
+</tv>`
+
+// testNow is the scheduler clock in the tests, before every programme.
+var testNow = time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC) //nolint:gochecknoglobals // test fixture
+
+// newTestScheduler creates a scheduler over a two-channel guide.
+func newTestScheduler(t *testing.T, capacity int, handler http.Handler) *Scheduler {
+	t.Helper()
+
+	store := data.NewStore()
+	store.SetM3U(nil, []m3u.Channel{
+		{Name: "News", URL: "http://upstream/news"},
+		{Name: "Films", URL: "http://upstream/films"},
+	})
+	store.SetEPG([]byte(testGuide), []byte(testGuide))
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cfg := &config.Config{
+		DVRDir:           t.TempDir(),
+		DVRPaddingBefore: time.Minute,
+		DVRPaddingAfter:  2 * time.Minute,
+	}
+	s, err := NewScheduler(cfg, store, tuner.NewPool(capacity), handler, logger)
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+	s.now = func() time.Time { return testNow }
+	s.retryDelay = 10 * time.Millisecond
+	return s
+}
+
+// programmeID finds the ID of the first programme with a title.
+func programmeID(t *testing.T, s *Scheduler, title string) string {
+	t.Helper()
+	g, err := s.loadGuide()
+	if err != nil {
+		t.Fatalf("loadGuide() error = %v", err)
+	}
+	for _, p := range g.programmes {
+		if p.Title == title {
+			return p.ID()
+		}
+	}
+	t.Fatalf("programme %q not found", title)
+	return ""
+}
+
+func TestAddProgrammeJobAppliesPadding(t *testing.T) {
+	s := newTestScheduler(t, 2, http.NotFoundHandler())
+
+	_, recordings, err := s.AddJob(Job{
+		Kind:         JobProgramme,
+		ProgrammeID:  programmeID(t, s, "The Movie"),
+		PaddingAfter: "5m",
+	})
+	if err != nil {
+		t.Fatalf("AddJob() error = %v", err)
+	}
+	if len(recordings) != 1 {
+		t.Fatalf("AddJob() scheduled %d recordings, want 1", len(recordings))
+	}
+
+	rec := recordings[0]
+	if rec.Channel != "Films" || rec.URL != "http://upstream/films" {
+		t.Errorf("recording channel = %q (%q), want Films", rec.Channel, rec.URL)
+	}
+	if want := time.Date(2026, 1, 1, 11, 29, 0, 0, time.UTC); !rec.RecordStart.Equal(want) {
+		t.Errorf("RecordStart = %v, want %v", rec.RecordStart, want)
+	}
+	if want := time.Date(2026, 1, 1, 12, 35, 0, 0, time.UTC); !rec.RecordEnd.Equal(want) {
+		t.Errorf("RecordEnd = %v, want %v", rec.RecordEnd, want)
+	}
+}
+
+func TestGuideListsUpcomingProgrammes(t *testing.T) {
+	s := newTestScheduler(t, 2, http.NotFoundHandler())
+	s.now = func() time.Time { return time.Date(2026, 1, 1, 12, 10, 0, 0, time.UTC) }
+
+	listings, err := s.Guide()
+	if err != nil {
+		t.Fatalf("Guide() error = %v", err)
+	}
+	if len(listings) != 2 || listings[0].Title != "The Movie" || listings[1].SubTitle != "Part 2" {
+		t.Errorf("Guide() = %+v, want The Movie and Part 2", listings)
+	}
+}
+
+func TestAddJobErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		job  Job
+		want error
+	}{
+		{"unknown kind", Job{Kind: "weekly"}, ErrInvalidJob},
+		{"manual without channel", Job{Kind: JobManual, Start: testNow, End: testNow.Add(time.Hour)}, ErrInvalidJob},
+		{"manual in the past", Job{Kind: JobManual, Channel: "News", Start: testNow.Add(-2 * time.Hour), End: testNow.Add(-time.Hour)}, ErrInvalidJob},
+		{"manual unknown channel", Job{Kind: JobManual, Channel: "Sport", Start: testNow, End: testNow.Add(time.Hour)}, ErrUnknownChannel},
+		{"unknown programme", Job{Kind: JobProgramme, ProgrammeID: "missing"}, ErrUnknownProgramme},
+		{"bad pattern", Job{Kind: JobSeries, TitlePattern: "("}, ErrInvalidJob},
+		{"bad padding", Job{Kind: JobSeries, TitlePattern: "News", PaddingBefore: "soon"}, ErrInvalidJob},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScheduler(t, 2, http.NotFoundHandler())
+			if _, _, err := s.AddJob(tt.job); !errors.Is(err, tt.want) {
+				t.Errorf("AddJob() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddJobDetectsConflicts(t *testing.T) {
+	s := newTestScheduler(t, 1, http.NotFoundHandler())
+
+	if _, _, err := s.AddJob(Job{Kind: JobProgramme, ProgrammeID: programmeID(t, s, "The Movie")}); err != nil {
+		t.Fatalf("AddJob() error = %v", err)
+	}
+
+	// A second overlapping recording does not fit on one tuner
+	_, conflicts, err := s.AddJob(Job{
+		Kind:    JobManual,
+		Channel: "News",
+		Start:   time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+		End:     time.Date(2026, 1, 1, 12, 15, 0, 0, time.UTC),
+	})
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("AddJob() error = %v, want ErrConflict", err)
+	}
+	if len(conflicts) != 1 || conflicts[0].Title != "The Movie" {
+		t.Errorf("conflicts = %+v, want The Movie", conflicts)
+	}
+
+	// Series recordings that conflict are kept but marked
+	_, recordings, err := s.AddJob(Job{Kind: JobSeries, TitlePattern: "^Evening", Channel: "News"})
+	if err != nil {
+		t.Fatalf("AddJob() error = %v", err)
+	}
+	if len(recordings) != 2 {
+		t.Fatalf("series scheduled %d recordings, want 2", len(recordings))
+	}
+	for _, rec := range recordings {
+		if rec.Status != StatusConflict {
+			t.Errorf("%s %s status = %s, want conflict", rec.Title, rec.SubTitle, rec.Status)
+		}
+	}
+}
+
+func TestSeriesJobMatchesTitlePattern(t *testing.T) {
+	s := newTestScheduler(t, 2, http.NotFoundHandler())
+
+	_, recordings, err := s.AddJob(Job{Kind: JobSeries, TitlePattern: "(?i)news"})
+	if err != nil {
+		t.Fatalf("AddJob() error = %v", err)
+	}
+	if len(recordings) != 2 {
+		t.Fatalf("series scheduled %d recordings, want 2", len(recordings))
+	}
+	for _, rec := range recordings {
+		if rec.Channel != "News" || rec.Status != StatusScheduled {
+			t.Errorf("recording = %+v, want a scheduled News recording", rec)
+		}
+	}
+
+	// Refreshing the guide does not schedule the same programmes again
+	s.mu.Lock()
+	s.guideSync = time.Unix(1, 0)
+	s.mu.Unlock()
+	s.refreshSeries()
+	if got := len(s.Recordings()); got != 2 {
+		t.Errorf("after refresh %d recordings, want 2", got)
+	}
+}
+
+func TestRecordingWritesFile(t *testing.T) {
+	var leased bool
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leased = tuner.FromContext(r.Context()) != nil
+		for {
+			if _, err := w.Write([]byte("data")); err != nil {
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	})
+	s := newTestScheduler(t, 1, handler)
+	s.now = time.Now
+
+	now := time.Now()
+	_, recordings, err := s.AddJob(Job{
+		Kind:          JobManual,
+		Channel:       "News",
+		Title:         "Breaking: Story",
+		Start:         now,
+		End:           now.Add(100 * time.Millisecond),
+		PaddingBefore: "0s",
+		PaddingAfter:  "0s",
+	})
+	if err != nil {
+		t.Fatalf("AddJob() error = %v", err)
+	}
+
+	s.runOnce()
+	s.wg.Wait()
+
+	rec := s.Recordings()[0]
+	if rec.ID != recordings[0].ID || rec.Status != StatusCompleted {
+		t.Fatalf("recording status = %s (%s), want completed", rec.Status, rec.Error)
+	}
+	if !leased {
+		t.Errorf("stream request did not carry the recording's tuner lease")
+	}
+	if s.tuners.InUse() != 0 {
+		t.Errorf("tuner still in use after the recording finished")
+	}
+
+	want := filepath.Join(s.dir, "Breaking_ Story", "Breaking_ Story - "+now.Local().Format("2006-01-02 1504")+".ts")
+	if rec.Path != want {
+		t.Errorf("Path = %q, want %q", rec.Path, want)
+	}
+	info, err := os.Stat(rec.Path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Size() == 0 || info.Size() != rec.Bytes {
+		t.Errorf("file size = %d, recorded bytes = %d", info.Size(), rec.Bytes)
+	}
+}
+
+func TestRetentionKeepsLastRecordings(t *testing.T) {
+	s := newTestScheduler(t, 2, http.NotFoundHandler())
+
+	job, _, err := s.AddJob(Job{Kind: JobSeries, TitlePattern: "^Evening", KeepLast: 1})
+	if err != nil {
+		t.Fatalf("AddJob() error = %v", err)
+	}
+
+	s.mu.Lock()
+	for _, rec := range s.recordings {
+		path := filepath.Join(s.dir, rec.SubTitle+".ts")
+		if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		rec.Path = path
+		rec.Status = StatusCompleted
+	}
+	s.applyRetentionLocked()
+	s.mu.Unlock()
+
+	recordings := s.Recordings()
+	if len(recordings) != 1 || recordings[0].SubTitle != "Part 2" || recordings[0].JobID != job.ID {
+		t.Fatalf("recordings = %+v, want only the latest episode", recordings)
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, "Part 1.ts")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file of the deleted recording still exists")
+	}
+
+	// State survives a restart
+	restarted, err := NewScheduler(&config.Config{DVRDir: s.dir}, s.store, s.tuners, s.handler, s.logger)
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+	if len(restarted.Jobs()) != 1 {
+		t.Errorf("restarted scheduler has %d jobs, want 1", len(restarted.Jobs()))
+	}
+}
+
+func TestRunOnceSavesOnlyChanges(t *testing.T) {
+	s := newTestScheduler(t, 1, http.NotFoundHandler())
+	if _, _, err := s.AddJob(Job{Kind: JobSeries, TitlePattern: "^Evening"}); err != nil {
+		t.Fatalf("AddJob() error = %v", err)
+	}
+	s.runOnce()
+
+	path := filepath.Join(s.dir, stateFile)
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	// Nothing is due, so the state is not written again
+	s.runOnce()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("state saved without changes, Stat() error = %v", err)
+	}
+
+	// A recording that was missed changes the state
+	s.now = func() time.Time { return testNow.Add(24 * time.Hour) }
+	s.runOnce()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("state not saved after recordings were missed: %v", err)
+	}
+}
+
+func TestFileWriterDoesNotWaitForScheduler(t *testing.T) {
+	s := newTestScheduler(t, 1, http.NotFoundHandler())
+	file, err := os.Create(filepath.Join(s.dir, "recording.ts"))
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	rec := &Recording{written: new(atomic.Int64)}
+	w := &fileWriter{written: rec.written, file: file, header: make(http.Header)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = w.Write([]byte("data"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write() blocked on the scheduler lock")
+	}
+
+	rec.syncBytes()
+	if rec.Bytes != 4 {
+		t.Errorf("Bytes = %d, want 4", rec.Bytes)
+	}
+}
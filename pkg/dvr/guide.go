@@ -0,0 +1,200 @@
+package dvr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/epg"
+)
+
+// ErrNoGuide is returned when no EPG has been loaded yet.
+var ErrNoGuide = errors.New("no guide data loaded")
+
+// guide is the EPG joined with the playlist.
+type guide struct {
+	programmes []epg.Programme
+	// names maps EPG channel IDs to playlist channel names
+	names map[string]string
+	// urls maps playlist channel names to upstream URLs
+	urls map[string]string
+}
+
+// loadGuide parses the filtered EPG from the store.
+func (s *Scheduler) loadGuide() (*guide, error) {
+	g := &guide{
+		names: make(map[string]string),
+		urls:  make(map[string]string),
+	}
+
+	if _, channels, ok := s.store.GetM3U(); ok {
+		for _, ch := range channels {
+			if _, exists := g.urls[ch.Name]; !exists {
+				g.urls[ch.Name] = ch.URL
+			}
+		}
+	}
+
+	raw, ok := s.store.GetEPG()
+	if !ok {
+		return g, ErrNoGuide
+	}
+	tv, err := epg.ParseStream(bytes.NewReader(raw))
+	if err != nil {
+		return g, fmt.Errorf("failed to parse EPG: %w", err)
+	}
+	for _, ch := range tv.Channels {
+		g.names[ch.ID] = ch.DisplayName
+	}
+	g.programmes = tv.Programs
+	return g, nil
+}
+
+// expand turns a job into the recordings it needs. Programmes that have
+// already ended are skipped.
+func (s *Scheduler) expand(job *Job, g *guide) ([]*Recording, error) {
+	before, after := s.padding(job)
+	now := s.now()
+
+	switch job.Kind {
+	case JobManual:
+		url, ok := g.urls[job.Channel]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownChannel, job.Channel)
+		}
+		title := job.Title
+		if title == "" {
+			title = job.Channel
+		}
+		rec := &Recording{
+			Channel: job.Channel,
+			URL:     url,
+			Title:   title,
+			Start:   job.Start,
+			End:     job.End,
+		}
+		return []*Recording{s.newRecording(job, rec, before, after)}, nil
+
+	case JobProgramme:
+		for _, p := range g.programmes {
+			if p.ID() != job.ProgrammeID {
+				continue
+			}
+			rec, err := g.recording(p)
+			if err != nil {
+				return nil, err
+			}
+			if !rec.End.After(now) {
+				return nil, fmt.Errorf("%w: programme %s has already ended", ErrInvalidJob, job.ProgrammeID)
+			}
+			return []*Recording{s.newRecording(job, rec, before, after)}, nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProgramme, job.ProgrammeID)
+
+	case JobSeries:
+		pattern, err := regexp.Compile(job.TitlePattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: title_pattern: %w", ErrInvalidJob, err)
+		}
+		var planned []*Recording
+		for _, p := range g.programmes {
+			if !pattern.MatchString(p.Title) {
+				continue
+			}
+			if job.Channel != "" && g.names[p.Channel] != job.Channel {
+				continue
+			}
+			rec, err := g.recording(p)
+			if err != nil || !rec.End.After(now) {
+				continue
+			}
+			planned = append(planned, s.newRecording(job, rec, before, after))
+		}
+		return planned, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown kind %q", ErrInvalidJob, job.Kind)
+}
+
+// recording fills a recording from a programme.
+func (g *guide) recording(p epg.Programme) (*Recording, error) {
+	start, err := p.StartTime()
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad start time %q", ErrUnknownProgramme, p.Start)
+	}
+	end, err := p.StopTime()
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad stop time %q", ErrUnknownProgramme, p.Stop)
+	}
+
+	name := g.names[p.Channel]
+	url, ok := g.urls[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownChannel, p.Channel)
+	}
+
+	return &Recording{
+		Channel:     name,
+		URL:         url,
+		ProgrammeID: p.ID(),
+		Title:       p.Title,
+		SubTitle:    p.SubTitle,
+		Description: p.Description,
+		Start:       start,
+		End:         end,
+	}, nil
+}
+
+// newRecording completes a planned recording for a job.
+func (s *Scheduler) newRecording(job *Job, rec *Recording, before, after time.Duration) *Recording {
+	rec.ID = newID()
+	rec.JobID = job.ID
+	rec.RecordStart = rec.Start.Add(-before)
+	rec.RecordEnd = rec.End.Add(after)
+	rec.Status = StatusScheduled
+	return rec
+}
+
+// Listing is an upcoming guide programme that can be recorded.
+type Listing struct {
+	ProgrammeID string    `json:"programme_id"`
+	Channel     string    `json:"channel"`
+	Title       string    `json:"title"`
+	SubTitle    string    `json:"sub_title,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+}
+
+// Guide lists the programmes that have not ended yet on playlist channels,
+// ordered by start time.
+func (s *Scheduler) Guide() ([]Listing, error) {
+	s.mu.Lock()
+	now := s.now()
+	s.mu.Unlock()
+
+	g, err := s.loadGuide()
+	if err != nil {
+		return nil, err
+	}
+
+	listings := make([]Listing, 0, len(g.programmes))
+	for _, p := range g.programmes {
+		rec, err := g.recording(p)
+		if err != nil || !rec.End.After(now) {
+			continue
+		}
+		listings = append(listings, Listing{
+			ProgrammeID: rec.ProgrammeID,
+			Channel:     rec.Channel,
+			Title:       rec.Title,
+			SubTitle:    rec.SubTitle,
+			Start:       rec.Start,
+			End:         rec.End,
+		})
+	}
+	sort.SliceStable(listings, func(i, j int) bool { return listings[i].Start.Before(listings[j].Start) })
+	return listings, nil
+}
@@ -0,0 +1,238 @@
+package dvr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/tuner"
+	"github.com/savid/iptv-proxy/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// startLocked acquires a tuner and starts recording in the background.
+func (s *Scheduler) startLocked(rec *Recording) {
+	lease, ok := s.tuners.TryAcquire(tuner.PurposeRecording)
+	if !ok {
+		s.finishLocked(rec, StatusFailed, ErrNoTuner.Error())
+		return
+	}
+
+	path, err := s.createFile(rec)
+	if err != nil {
+		lease.Release()
+		s.finishLocked(rec, StatusFailed, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithDeadline(s.ctx, rec.RecordEnd)
+	s.cancels[rec.ID] = cancel
+	rec.written = new(atomic.Int64)
+	rec.Status = StatusRecording
+	rec.Path = path
+	rec.StartedAt = s.now()
+
+	s.logger.WithFields(logrus.Fields{
+		"channel": rec.Channel,
+		"title":   rec.Title,
+		"path":    path,
+		"until":   rec.RecordEnd,
+	}).Info("DVR recording started")
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer lease.Release()
+		defer cancel()
+		s.record(tuner.NewContext(ctx, lease), rec)
+	}()
+}
+
+// record serves stream requests for the channel into the recording file
+// until the end time, reconnecting when the stream ends early.
+func (s *Scheduler) record(ctx context.Context, rec *Recording) {
+	s.mu.Lock()
+	path, url := rec.Path, rec.URL
+	s.mu.Unlock()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600) //nolint:gosec // path is built by the scheduler
+	if err != nil {
+		s.finish(rec, StatusFailed, err.Error())
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	w := &fileWriter{written: rec.written, file: file, header: make(http.Header)}
+	var lastErr error
+	for ctx.Err() == nil {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/stream/"+utils.EncodeURL(url), nil)
+		if err != nil {
+			lastErr = err
+			break
+		}
+
+		w.status = 0
+		s.handler.ServeHTTP(w, req)
+		if w.err != nil {
+			lastErr = w.err
+			break
+		}
+		if w.status != 0 && w.status != http.StatusOK {
+			lastErr = fmt.Errorf("%w: %d", ErrStreamStatus, w.status)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		s.logger.WithField("channel", rec.Channel).Warn("DVR stream ended early, reconnecting")
+		select {
+		case <-ctx.Done():
+		case <-time.After(s.retryDelay):
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cancels, rec.ID)
+	rec.syncBytes()
+
+	switch {
+	case rec.Status == StatusCancelled:
+		// Stopped through the API
+	case rec.Bytes == 0 && lastErr != nil:
+		s.finishLocked(rec, StatusFailed, lastErr.Error())
+	case rec.Bytes == 0:
+		s.finishLocked(rec, StatusFailed, "no data received")
+	default:
+		s.finishLocked(rec, StatusCompleted, "")
+	}
+	s.applyRetentionLocked()
+	s.saveLocked()
+}
+
+// finish records the outcome of a recording.
+func (s *Scheduler) finish(rec *Recording, status Status, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cancels, rec.ID)
+	s.finishLocked(rec, status, message)
+	s.saveLocked()
+}
+
+// finishLocked records the outcome of a recording.
+func (s *Scheduler) finishLocked(rec *Recording, status Status, message string) {
+	rec.syncBytes()
+	rec.Status = status
+	rec.Error = message
+	rec.FinishedAt = s.now()
+
+	entry := s.logger.WithFields(logrus.Fields{
+		"channel": rec.Channel,
+		"title":   rec.Title,
+		"status":  status,
+		"bytes":   rec.Bytes,
+	})
+	if message != "" {
+		entry = entry.WithField("error", message)
+	}
+	entry.Info("DVR recording finished")
+}
+
+// cancelLocked stops a running recording, keeping what was recorded.
+func (s *Scheduler) cancelLocked(rec *Recording) {
+	rec.Status = StatusCancelled
+	rec.FinishedAt = s.now()
+	if cancel, ok := s.cancels[rec.ID]; ok {
+		cancel()
+	}
+}
+
+// createFile creates the recording file, named from the programme:
+// "<title>/<title> - <date> - <sub-title>.ts".
+func (s *Scheduler) createFile(rec *Recording) (string, error) {
+	title := sanitizeName(rec.Title)
+	if title == "" {
+		title = sanitizeName(rec.Channel)
+	}
+	name := title + " - " + rec.Start.Local().Format("2006-01-02 1504")
+	if sub := sanitizeName(rec.SubTitle); sub != "" {
+		name += " - " + sub
+	}
+
+	dir := filepath.Join(s.dir, title)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create recording directory: %w", err)
+	}
+
+	// Never overwrite an earlier recording of the same programme
+	path := filepath.Join(dir, name+".ts")
+	for n := 2; ; n++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600) //nolint:gosec // path is built by the scheduler
+		if err == nil {
+			return path, file.Close()
+		}
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("failed to create recording file: %w", err)
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s (%d).ts", name, n))
+	}
+}
+
+// sanitizeName makes a programme title safe to use as a file name.
+func sanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20, strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		default:
+			return r
+		}
+	}, name)
+	return strings.Trim(strings.TrimSpace(name), ".")
+}
+
+// fileWriter receives a stream response and appends the body to the
+// recording file. It counts the bytes without the scheduler lock, so that
+// recordings never wait for the scheduler.
+type fileWriter struct {
+	written *atomic.Int64
+	file    *os.File
+	header  http.Header
+	status  int
+	err     error
+}
+
+func (w *fileWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *fileWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status != http.StatusOK {
+		// Error bodies are not part of the recording
+		return len(p), nil
+	}
+
+	n, err := w.file.Write(p)
+	w.written.Add(int64(n))
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// Flush implements http.Flusher for handlers that flush as they stream.
+func (w *fileWriter) Flush() {}
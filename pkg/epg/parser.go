@@ -2,8 +2,12 @@
 package epg
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/xml"
 	"io"
+	"strings"
+	"time"
 )
 
 // TV represents the root element of an EPG XML document.
@@ -31,7 +35,39 @@ type Programme struct {
 	Start       string `xml:"start,attr"`
 	Stop        string `xml:"stop,attr"`
 	Title       string `xml:"title"`
+	SubTitle    string `xml:"sub-title,omitempty"`
 	Description string `xml:"desc"`
+	EpisodeNum  string `xml:"episode-num,omitempty"`
+}
+
+// TimeFormat is the XMLTV timestamp layout.
+const TimeFormat = "20060102150405 -0700"
+
+// ID returns a stable identifier for the programme derived from its
+// channel and start time.
+func (p Programme) ID() string {
+	hash := sha256.Sum256([]byte(p.Channel + "|" + p.Start))
+	return hex.EncodeToString(hash[:8])
+}
+
+// StartTime parses the programme start time.
+func (p Programme) StartTime() (time.Time, error) {
+	return ParseTime(p.Start)
+}
+
+// StopTime parses the programme stop time.
+func (p Programme) StopTime() (time.Time, error) {
+	return ParseTime(p.Stop)
+}
+
+// ParseTime parses an XMLTV timestamp. The timezone offset is optional and
+// defaults to UTC.
+func ParseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if len(value) <= len("20060102150405") {
+		return time.Parse("20060102150405", value)
+	}
+	return time.Parse(TimeFormat, value)
 }
 
 // ParseStream parses EPG XML data from an io.Reader.
@@ -0,0 +1,226 @@
+// Package events delivers operational incidents, such as failed refreshes
+// or dead channels, to notification sinks.
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultDedupWindow is how long a repeated event is suppressed.
+	defaultDedupWindow = 10 * time.Minute
+	// defaultRateLimit is the maximum number of events delivered per minute.
+	defaultRateLimit = 30
+	// queueSize is the number of events waiting for delivery before new
+	// ones are dropped.
+	queueSize = 100
+	// sendTimeout bounds the delivery of an event to one sink.
+	sendTimeout = 10 * time.Second
+)
+
+// Type identifies the kind of incident.
+type Type string
+
+const (
+	// TypeRefreshFailed is a failed playlist or guide refresh.
+	TypeRefreshFailed Type = "refresh_failed"
+	// TypeRefreshRecovered is a successful refresh after failures.
+	TypeRefreshRecovered Type = "refresh_recovered"
+	// TypeTunersBusy is emitted when every advertised tuner is in use.
+	TypeTunersBusy Type = "tuners_busy"
+	// TypeHardwareFallback is a transcode restarted on another device after
+	// a hardware failure.
+	TypeHardwareFallback Type = "hardware_fallback"
+	// TypeStreamFailed is a stream that failed after its retries.
+	TypeStreamFailed Type = "stream_failed"
+	// TypeChannelDead is a channel that started failing health checks.
+	TypeChannelDead Type = "channel_dead"
+)
+
+// resolves maps recovery events to the failure they end, so that the next
+// failure is reported even within the dedup window.
+var resolves = map[Type]Type{TypeRefreshRecovered: TypeRefreshFailed} //nolint:gochecknoglobals // lookup table
+
+// Event is an operational incident.
+type Event struct {
+	Type Type `json:"type"`
+	// Subject is what the event is about, e.g. a channel or a device.
+	// Events with the same type and subject are deduplicated.
+	Subject string         `json:"subject,omitempty"`
+	Message string         `json:"message"`
+	Time    time.Time      `json:"time"`
+	Fields  map[string]any `json:"fields,omitempty"`
+}
+
+// Title is a short summary of the event for notifications.
+func (e Event) Title() string {
+	titles := map[Type]string{
+		TypeRefreshFailed:    "Refresh failed",
+		TypeRefreshRecovered: "Refresh recovered",
+		TypeTunersBusy:       "All tuners busy",
+		TypeHardwareFallback: "Hardware fallback",
+		TypeStreamFailed:     "Stream failed",
+		TypeChannelDead:      "Channel dead",
+	}
+	title, ok := titles[e.Type]
+	if !ok {
+		title = string(e.Type)
+	}
+	if e.Subject != "" {
+		title += ": " + e.Subject
+	}
+	return title
+}
+
+// Recovery reports whether the event ends an incident rather than starting
+// one.
+func (e Event) Recovery() bool {
+	_, ok := resolves[e.Type]
+	return ok
+}
+
+// Sink delivers events, e.g. to a webhook or a push notification service.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, event Event) error
+}
+
+// Bus queues events and delivers them to every sink, dropping repeats
+// within the dedup window and events beyond the rate limit. A nil bus
+// discards events, so components can publish without checking.
+type Bus struct {
+	sinks  []Sink
+	queue  chan Event
+	logger *logrus.Logger
+
+	mu          sync.Mutex
+	dedupWindow time.Duration
+	rateLimit   int
+	lastSeen    map[string]time.Time
+	accepted    []time.Time
+	now         func() time.Time
+}
+
+// NewBus creates an event bus without sinks.
+func NewBus(logger *logrus.Logger) *Bus {
+	return &Bus{
+		queue:       make(chan Event, queueSize),
+		logger:      logger,
+		dedupWindow: defaultDedupWindow,
+		rateLimit:   defaultRateLimit,
+		lastSeen:    make(map[string]time.Time),
+		now:         time.Now,
+	}
+}
+
+// AddSink adds a sink that receives every delivered event. Sinks must be
+// added before Run.
+func (b *Bus) AddSink(sink Sink) {
+	b.sinks = append(b.sinks, sink)
+}
+
+// SetDedupWindow sets how long an event with the same type and subject as
+// an earlier one is suppressed. Zero disables deduplication.
+func (b *Bus) SetDedupWindow(window time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.dedupWindow = window
+}
+
+// SetRateLimit sets the maximum number of events accepted per minute. Zero
+// disables the limit.
+func (b *Bus) SetRateLimit(perMinute int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rateLimit = perMinute
+}
+
+// Publish queues an event for delivery. It never blocks: duplicates, events
+// beyond the rate limit and events arriving while the queue is full are
+// dropped.
+func (b *Bus) Publish(event Event) {
+	if b == nil || len(b.sinks) == 0 {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = b.now()
+	}
+	if !b.admit(event) {
+		b.logger.WithFields(logrus.Fields{
+			"type":    event.Type,
+			"subject": event.Subject,
+		}).Debug("Event suppressed")
+		return
+	}
+
+	select {
+	case b.queue <- event:
+	default:
+		b.logger.WithField("type", event.Type).Warn("Event queue full, dropping event")
+	}
+}
+
+// admit applies deduplication and rate limiting to an event.
+func (b *Bus) admit(event Event) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := event.Time
+	key := string(event.Type) + "\x00" + event.Subject
+	if last, ok := b.lastSeen[key]; ok && b.dedupWindow > 0 && now.Sub(last) < b.dedupWindow {
+		return false
+	}
+
+	if b.rateLimit > 0 {
+		recent := b.accepted[:0]
+		for _, t := range b.accepted {
+			if now.Sub(t) < time.Minute {
+				recent = append(recent, t)
+			}
+		}
+		b.accepted = recent
+		if len(b.accepted) >= b.rateLimit {
+			return false
+		}
+		b.accepted = append(b.accepted, now)
+	}
+
+	b.lastSeen[key] = now
+	if failure, ok := resolves[event.Type]; ok {
+		delete(b.lastSeen, string(failure)+"\x00"+event.Subject)
+	}
+	return true
+}
+
+// Run delivers queued events to the sinks until the context is cancelled.
+func (b *Bus) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-b.queue:
+			b.deliver(ctx, event)
+		}
+	}
+}
+
+// deliver sends an event to every sink, logging failures.
+func (b *Bus) deliver(ctx context.Context, event Event) {
+	for _, sink := range b.sinks {
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err := sink.Send(sendCtx, event)
+		cancel()
+		if err != nil {
+			b.logger.WithError(err).WithFields(logrus.Fields{
+				"sink": sink.Name(),
+				"type": event.Type,
+			}).Warn("Failed to deliver event")
+		}
+	}
+}
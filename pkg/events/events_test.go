@@ -0,0 +1,211 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recordingSink keeps the events it receives.
+type recordingSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Send(_ context.Context, event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// drain delivers every queued event.
+func drain(bus *Bus) {
+	for {
+		select {
+		case event := <-bus.queue:
+			bus.deliver(context.Background(), event)
+		default:
+			return
+		}
+	}
+}
+
+func TestBusDedup(t *testing.T) {
+	sink := &recordingSink{}
+	bus := NewBus(testLogger())
+	bus.AddSink(sink)
+	bus.SetRateLimit(0)
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	publish := func(typ Type, subject string, after time.Duration) {
+		bus.Publish(Event{Type: typ, Subject: subject, Time: start.Add(after)})
+	}
+	publish(TypeRefreshFailed, "m3u", 0)
+	publish(TypeRefreshFailed, "m3u", time.Minute) // duplicate
+	publish(TypeRefreshFailed, "epg", time.Minute) // other subject
+	publish(TypeRefreshRecovered, "m3u", 2*time.Minute)
+	publish(TypeRefreshFailed, "m3u", 3*time.Minute) // failure after recovery
+	publish(TypeChannelDead, "ESPN", 0)
+	publish(TypeChannelDead, "ESPN", 11*time.Minute) // after the window
+	drain(bus)
+
+	want := []string{"refresh_failed/m3u", "refresh_failed/epg", "refresh_recovered/m3u", "refresh_failed/m3u", "channel_dead/ESPN", "channel_dead/ESPN"}
+	if len(sink.events) != len(want) {
+		t.Fatalf("delivered %d events, want %d: %+v", len(sink.events), len(want), sink.events)
+	}
+	for i, event := range sink.events {
+		if got := string(event.Type) + "/" + event.Subject; got != want[i] {
+			t.Errorf("event %d = %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestBusRateLimit(t *testing.T) {
+	sink := &recordingSink{}
+	bus := NewBus(testLogger())
+	bus.AddSink(sink)
+	bus.SetRateLimit(2)
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, subject := range []string{"a", "b", "c"} {
+		bus.Publish(Event{Type: TypeStreamFailed, Subject: subject, Time: start.Add(time.Duration(i) * time.Second)})
+	}
+	bus.Publish(Event{Type: TypeStreamFailed, Subject: "d", Time: start.Add(time.Minute)})
+	drain(bus)
+
+	if len(sink.events) != 3 || sink.events[2].Subject != "d" {
+		t.Errorf("delivered %+v, want a, b and d", sink.events)
+	}
+
+	// A nil bus discards events
+	var none *Bus
+	none.Publish(Event{Type: TypeTunersBusy})
+}
+
+func TestWebhookSinkPost(t *testing.T) {
+	var received map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "not JSON", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	payload := map[string]any{"source": "m3u", "generation": 2}
+	if err := NewWebhookSink(server.URL).Post(context.Background(), payload); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if received["source"] != "m3u" || received["generation"] != float64(2) {
+		t.Errorf("webhook received %+v", received)
+	}
+}
+
+func TestHTTPSinks(t *testing.T) {
+	type request struct {
+		path    string
+		headers http.Header
+		body    []byte
+	}
+	var (
+		mu       sync.Mutex
+		received []request
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, request{r.URL.Path, r.Header, body})
+		mu.Unlock()
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	event := Event{Type: TypeRefreshFailed, Subject: "m3u", Message: "upstream returned 503", Time: time.Now()}
+	sinks := []Sink{
+		NewWebhookSink(server.URL + "/hook"),
+		NewNtfySink(server.URL+"/topic", "secret"),
+		NewGotifySink(server.URL+"/", "app-token"),
+	}
+	for _, sink := range sinks {
+		if err := sink.Send(context.Background(), event); err != nil {
+			t.Errorf("%s Send() error = %v", sink.Name(), err)
+		}
+	}
+	if err := NewWebhookSink(server.URL+"/fail").Send(context.Background(), event); !errors.Is(err, ErrUnexpectedStatus) {
+		t.Errorf("Send() error = %v, want ErrUnexpectedStatus", err)
+	}
+	if len(received) != 4 {
+		t.Fatalf("received %d requests, want 4", len(received))
+	}
+
+	var hook Event
+	if err := json.Unmarshal(received[0].body, &hook); err != nil || hook.Type != TypeRefreshFailed || hook.Subject != "m3u" {
+		t.Errorf("webhook body = %s", received[0].body)
+	}
+
+	ntfy := received[1]
+	if string(ntfy.body) != event.Message || ntfy.headers.Get("Title") != "Refresh failed: m3u" ||
+		ntfy.headers.Get("Priority") != "high" || ntfy.headers.Get("Authorization") != "Bearer secret" {
+		t.Errorf("ntfy request = %s %v", ntfy.body, ntfy.headers)
+	}
+
+	gotify := received[2]
+	var message struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.Unmarshal(gotify.body, &message); err != nil || gotify.path != "/message" ||
+		gotify.headers.Get("X-Gotify-Key") != "app-token" || message.Priority != 8 || message.Message != event.Message {
+		t.Errorf("gotify request = %s %s %v", gotify.path, gotify.body, gotify.headers)
+	}
+}
+
+func TestRunDelivers(t *testing.T) {
+	delivered := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var event Event
+		_ = json.NewDecoder(r.Body).Decode(&event)
+		delivered <- event
+	}))
+	defer server.Close()
+
+	bus := NewBus(testLogger())
+	bus.AddSink(NewLogSink(testLogger()))
+	bus.AddSink(NewWebhookSink(server.URL))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bus.Run(ctx)
+
+	bus.Publish(Event{Type: TypeTunersBusy, Message: "All 2 tuners are in use"})
+	select {
+	case event := <-delivered:
+		if event.Type != TypeTunersBusy || event.Time.IsZero() {
+			t.Errorf("delivered %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not delivered")
+	}
+}
@@ -0,0 +1,178 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrUnexpectedStatus is returned when a sink responds with a non-2xx status.
+var ErrUnexpectedStatus = errors.New("unexpected status code")
+
+// WebhookSink posts events as JSON to a URL.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink creates a sink posting events as JSON to url.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{}}
+}
+
+// Name identifies the sink in logs.
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Send posts the event.
+func (s *WebhookSink) Send(ctx context.Context, event Event) error {
+	return s.Post(ctx, event)
+}
+
+// Post posts any JSON payload to the webhook, so that reports sent outside
+// the bus, such as playlist and guide diffs, share the sink.
+func (s *WebhookSink) Post(ctx context.Context, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return post(s.client, req)
+}
+
+// NtfySink publishes events to an ntfy topic URL, e.g.
+// https://ntfy.sh/iptv-proxy, with the title, priority and tags in headers.
+type NtfySink struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewNtfySink creates a sink publishing to an ntfy topic URL. A non-empty
+// token is sent as a bearer token.
+func NewNtfySink(url, token string) *NtfySink {
+	return &NtfySink{url: url, token: token, client: &http.Client{}}
+}
+
+// Name identifies the sink in logs.
+func (s *NtfySink) Name() string {
+	return "ntfy"
+}
+
+// Send publishes the event.
+func (s *NtfySink) Send(ctx context.Context, event Event) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, strings.NewReader(event.Message))
+	if err != nil {
+		return fmt.Errorf("failed to create ntfy request: %w", err)
+	}
+	req.Header.Set("Title", event.Title())
+	req.Header.Set("Tags", string(event.Type))
+	if event.Recovery() {
+		req.Header.Set("Priority", "default")
+	} else {
+		req.Header.Set("Priority", "high")
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	return post(s.client, req)
+}
+
+// GotifySink sends events to the message API of a Gotify server.
+type GotifySink struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewGotifySink creates a sink for the Gotify server at url, authenticated
+// with an application token.
+func NewGotifySink(url, token string) *GotifySink {
+	return &GotifySink{url: strings.TrimRight(url, "/"), token: token, client: &http.Client{}}
+}
+
+// Name identifies the sink in logs.
+func (s *GotifySink) Name() string {
+	return "gotify"
+}
+
+// Send posts the event as a Gotify message.
+func (s *GotifySink) Send(ctx context.Context, event Event) error {
+	priority := 8
+	if event.Recovery() {
+		priority = 4
+	}
+	body, err := json.Marshal(map[string]any{
+		"title":    event.Title(),
+		"message":  event.Message,
+		"priority": priority,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+"/message", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create gotify request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", s.token)
+	return post(s.client, req)
+}
+
+// LogSink writes events to the log.
+type LogSink struct {
+	logger *logrus.Logger
+}
+
+// NewLogSink creates a sink logging events, failures as warnings.
+func NewLogSink(logger *logrus.Logger) *LogSink {
+	return &LogSink{logger: logger}
+}
+
+// Name identifies the sink in logs.
+func (s *LogSink) Name() string {
+	return "log"
+}
+
+// Send logs the event.
+func (s *LogSink) Send(_ context.Context, event Event) error {
+	entry := s.logger.WithFields(logrus.Fields(event.Fields)).WithFields(logrus.Fields{
+		"event":   event.Type,
+		"subject": event.Subject,
+	})
+	if event.Recovery() {
+		entry.Info(event.Message)
+	} else {
+		entry.Warn(event.Message)
+	}
+	return nil
+}
+
+// post sends a request, failing on a non-2xx response.
+func post(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send event: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)
+	}
+	return nil
+}
@@ -2,15 +2,15 @@
 package hardware
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/savid/iptv-proxy/pkg/process"
 	"github.com/savid/iptv-proxy/pkg/types"
 )
 
@@ -31,16 +31,25 @@ var (
 
 // Detector identifies available hardware acceleration devices.
 type Detector struct {
+	runner process.Runner
 	logger *log.Logger
 }
 
 // NewDetector creates a new hardware detector instance.
 func NewDetector(logger *log.Logger) *Detector {
 	return &Detector{
+		runner: process.NewExecRunner(),
 		logger: logger,
 	}
 }
 
+// SetRunner replaces the runner used to call nvidia-smi, vainfo and ffmpeg.
+func (d *Detector) SetRunner(runner process.Runner) {
+	if runner != nil {
+		d.runner = runner
+	}
+}
+
 // DetectGPUs scans the system for available GPU hardware.
 func (d *Detector) DetectGPUs() []types.HardwareInfo {
 	var gpus []types.HardwareInfo
@@ -105,8 +114,7 @@ func (d *Detector) DetectAllDevices() ([]types.HardwareInfo, error) {
 // CheckNVIDIA detects NVIDIA GPU availability using nvidia-smi.
 func (d *Detector) CheckNVIDIA() (*types.HardwareInfo, error) {
 	// Check if nvidia-smi exists
-	cmd := exec.Command("nvidia-smi", "--query-gpu=name,uuid", "--format=csv,noheader")
-	output, err := cmd.Output()
+	output, _, err := d.runner.Output(context.Background(), "nvidia-smi", "--query-gpu=name,uuid", "--format=csv,noheader")
 	if err != nil {
 		return nil, fmt.Errorf("nvidia-smi not available: %w", err)
 	}
@@ -152,8 +160,7 @@ func (d *Detector) CheckAllNVIDIA() []types.HardwareInfo {
 	var gpus []types.HardwareInfo
 
 	// Check if nvidia-smi exists
-	cmd := exec.Command("nvidia-smi", "--query-gpu=index,name,uuid", "--format=csv,noheader")
-	output, err := cmd.Output()
+	output, _, err := d.runner.Output(context.Background(), "nvidia-smi", "--query-gpu=index,name,uuid", "--format=csv,noheader")
 	if err != nil {
 		return gpus
 	}
@@ -250,13 +257,13 @@ func (d *Detector) CheckAllIntel() []types.HardwareInfo {
 
 // checkIntelNode checks if a specific node is an Intel GPU.
 func (d *Detector) checkIntelNode(node string) *types.HardwareInfo {
-	cmd := exec.Command("vainfo", "--display", "drm", "--device", node) // #nosec G204 - node comes from filepath.Glob
-	output, err := cmd.CombinedOutput()
+	stdout, stderr, err := d.runner.Output(context.Background(), "vainfo", "--display", "drm", "--device", node)
 	if err != nil {
 		return nil
 	}
 
-	outputStr := string(output)
+	// vainfo reports the driver on stderr and the profiles on stdout
+	outputStr := string(stderr) + string(stdout)
 	if !d.isIntelGPU(outputStr) {
 		return nil
 	}
@@ -379,13 +386,13 @@ func (d *Detector) CheckAllAMD() []types.HardwareInfo {
 
 // checkAMDNode checks if a specific node is an AMD GPU.
 func (d *Detector) checkAMDNode(node string) *types.HardwareInfo {
-	cmd := exec.Command("vainfo", "--display", "drm", "--device", node) // #nosec G204 - node comes from filepath.Glob
-	output, err := cmd.CombinedOutput()
+	stdout, stderr, err := d.runner.Output(context.Background(), "vainfo", "--display", "drm", "--device", node)
 	if err != nil {
 		return nil
 	}
 
-	outputStr := string(output)
+	// vainfo reports the driver on stderr and the profiles on stdout
+	outputStr := string(stderr) + string(stdout)
 	if !d.isAMDGPU(outputStr) {
 		return nil
 	}
@@ -450,11 +457,7 @@ func (d *Detector) TestHardwareCodec(hw types.HardwareInfo, codec string) bool {
 	// Output to null
 	args = append(args, "-f", "null", "-")
 
-	cmd := exec.Command("ffmpeg", args...) // #nosec G204 - args are internally constructed
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
+	_, _, err := d.runner.Output(context.Background(), "ffmpeg", args...)
 	if err != nil {
 		d.logger.Printf("Hardware codec %s test failed: %v", codec, err)
 		return false
@@ -0,0 +1,167 @@
+// Package hardware provides GPU detection and selection for transcoding.
+package hardware
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/savid/iptv-proxy/pkg/types"
+)
+
+// cudaFilterDevice is the name of the CUDA device used by NVIDIA filters.
+const cudaFilterDevice = "cu"
+
+// GetFilterArgs returns the video filter arguments for the profile on the
+// selected hardware. Scaling, deinterlacing and frame rate conversion run on
+// the GPU when the hardware supports it, otherwise the CPU filters are used.
+// An empty slice is returned when no filtering is needed.
+func (s *Selector) GetFilterArgs(hw types.HardwareInfo, profile types.TranscodingProfile) []string {
+	if profile.VideoCodec == codecCopy || profile.VideoCodec == "" {
+		return []string{}
+	}
+
+	var chain []string
+	args := []string{}
+
+	switch {
+	case hw.Type == types.HardwareNVIDIA && needsFiltering(profile):
+		args = append(args,
+			"-init_hw_device", fmt.Sprintf("cuda=%s:%d", cudaFilterDevice, max(hw.DeviceID, 0)),
+			"-filter_hw_device", cudaFilterDevice,
+		)
+		chain = nvidiaFilterChain(profile)
+	case usesVAAPI(hw, profile.VideoCodec):
+		// VA-API encoders only accept frames in GPU memory, so the upload is
+		// needed even without scaling or deinterlacing
+		chain = vaapiFilterChain(profile)
+	default:
+		chain = cpuFilterChain(profile)
+	}
+
+	if len(chain) == 0 {
+		return args
+	}
+
+	return append(args, "-vf", strings.Join(chain, ","))
+}
+
+// needsFiltering reports whether the profile requires any video filter.
+func needsFiltering(profile types.TranscodingProfile) bool {
+	return profile.MaxWidth > 0 || profile.MaxHeight > 0 || profile.Framerate > 0 ||
+		(profile.Deinterlace != "" && profile.Deinterlace != types.DeinterlaceNone)
+}
+
+// usesVAAPI reports whether the hardware encodes the codec through VA-API.
+// Only h264 and h265 have VA-API encoders; other codecs are encoded in
+// software and need software frames.
+func usesVAAPI(hw types.HardwareInfo, videoCodec string) bool {
+	switch videoCodec {
+	case codecH264, codecH265, codecHEVC:
+	default:
+		return false
+	}
+
+	switch hw.Type {
+	case types.HardwareIntel:
+		return true
+	case types.HardwareAMD:
+		return strings.Contains(hw.DevicePath, "/dev/dri")
+	default:
+		return false
+	}
+}
+
+// cpuFilterChain builds a software filter chain.
+func cpuFilterChain(profile types.TranscodingProfile) []string {
+	chain := []string{}
+
+	switch profile.Deinterlace {
+	case types.DeinterlaceYadif:
+		chain = append(chain, "yadif=mode=send_frame:parity=auto:deint=interlaced")
+	case types.DeinterlaceBwdif:
+		chain = append(chain, "bwdif=mode=send_frame:parity=auto:deint=interlaced")
+	}
+
+	if scale := scaleArgs(profile); scale != "" {
+		chain = append(chain, "scale="+scale)
+	}
+
+	if fps := fpsFilter(profile); fps != "" {
+		chain = append(chain, fps)
+	}
+
+	return chain
+}
+
+// nvidiaFilterChain builds a CUDA filter chain. Frames are uploaded once and
+// stay in GPU memory for deinterlacing, scaling and encoding.
+func nvidiaFilterChain(profile types.TranscodingProfile) []string {
+	chain := []string{"format=nv12", "hwupload_cuda"}
+
+	switch profile.Deinterlace {
+	case types.DeinterlaceYadif:
+		chain = append(chain, "yadif_cuda=mode=send_frame:parity=auto:deint=interlaced")
+	case types.DeinterlaceBwdif:
+		chain = append(chain, "bwdif_cuda=mode=send_frame:parity=auto:deint=interlaced")
+	}
+
+	if scale := scaleArgs(profile); scale != "" {
+		chain = append(chain, "scale_cuda="+scale)
+	}
+
+	if fps := fpsFilter(profile); fps != "" {
+		chain = append(chain, fps)
+	}
+
+	return chain
+}
+
+// vaapiFilterChain builds a VA-API filter chain for Intel and AMD GPUs.
+func vaapiFilterChain(profile types.TranscodingProfile) []string {
+	chain := []string{"format=nv12", "hwupload"}
+
+	switch profile.Deinterlace {
+	case types.DeinterlaceYadif:
+		chain = append(chain, "deinterlace_vaapi=mode=default:rate=frame:auto=1")
+	case types.DeinterlaceBwdif:
+		chain = append(chain, "deinterlace_vaapi=mode=motion_adaptive:rate=frame:auto=1")
+	}
+
+	if scale := scaleArgs(profile); scale != "" {
+		chain = append(chain, "scale_vaapi="+scale)
+	}
+
+	if fps := fpsFilter(profile); fps != "" {
+		chain = append(chain, fps)
+	}
+
+	return chain
+}
+
+// scaleArgs returns scale filter options that limit the output resolution
+// while keeping the source aspect ratio. Sources smaller than the limit are
+// not upscaled. The same options are understood by scale, scale_cuda and
+// scale_vaapi.
+func scaleArgs(profile types.TranscodingProfile) string {
+	switch {
+	case profile.MaxWidth > 0 && profile.MaxHeight > 0:
+		return fmt.Sprintf("w='min(iw,%d)':h='min(ih,%d)':force_original_aspect_ratio=decrease:force_divisible_by=2",
+			profile.MaxWidth, profile.MaxHeight)
+	case profile.MaxWidth > 0:
+		return fmt.Sprintf("w='min(iw,%d)':h=-2", profile.MaxWidth)
+	case profile.MaxHeight > 0:
+		return fmt.Sprintf("w=-2:h='min(ih,%d)'", profile.MaxHeight)
+	default:
+		return ""
+	}
+}
+
+// fpsFilter returns a frame rate conversion filter. The fps filter only
+// drops or duplicates frames, so it works on both software and GPU frames.
+func fpsFilter(profile types.TranscodingProfile) string {
+	if profile.Framerate <= 0 {
+		return ""
+	}
+	return "fps=" + strconv.FormatFloat(profile.Framerate, 'f', -1, 64)
+}
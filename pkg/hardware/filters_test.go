@@ -0,0 +1,149 @@
+package hardware
+
+import (
+	"io"
+	"log"
+	"slices"
+	"testing"
+
+	"github.com/savid/iptv-proxy/pkg/types"
+)
+
+func TestGetFilterArgs(t *testing.T) {
+	selector := NewSelector(nil, types.HardwareAuto, log.New(io.Discard, "", 0))
+
+	cpu := types.HardwareInfo{Type: types.HardwareCPU}
+	nvidia := types.HardwareInfo{Type: types.HardwareNVIDIA, DeviceID: 1}
+	intel := types.HardwareInfo{Type: types.HardwareIntel, DevicePath: "/dev/dri/renderD128"}
+	amdVAAPI := types.HardwareInfo{Type: types.HardwareAMD, DevicePath: "/dev/dri/renderD129"}
+	amdAMF := types.HardwareInfo{Type: types.HardwareAMD}
+
+	scaled := types.TranscodingProfile{VideoCodec: "h264", MaxWidth: 1280, MaxHeight: 720}
+	full := types.TranscodingProfile{
+		VideoCodec:  "h264",
+		MaxHeight:   720,
+		Framerate:   29.97,
+		Deinterlace: types.DeinterlaceYadif,
+	}
+
+	tests := []struct {
+		name    string
+		hw      types.HardwareInfo
+		profile types.TranscodingProfile
+		want    []string
+	}{
+		{
+			name:    "copy has no filters",
+			hw:      nvidia,
+			profile: types.TranscodingProfile{VideoCodec: "copy", MaxHeight: 720},
+			want:    []string{},
+		},
+		{
+			name:    "cpu without filters",
+			hw:      cpu,
+			profile: types.TranscodingProfile{VideoCodec: "h264"},
+			want:    []string{},
+		},
+		{
+			name:    "cpu scale",
+			hw:      cpu,
+			profile: scaled,
+			want: []string{"-vf",
+				"scale=w='min(iw,1280)':h='min(ih,720)':force_original_aspect_ratio=decrease:force_divisible_by=2"},
+		},
+		{
+			name:    "cpu deinterlace scale and framerate",
+			hw:      cpu,
+			profile: full,
+			want: []string{"-vf",
+				"yadif=mode=send_frame:parity=auto:deint=interlaced,scale=w=-2:h='min(ih,720)',fps=29.97"},
+		},
+		{
+			name:    "cpu bwdif",
+			hw:      cpu,
+			profile: types.TranscodingProfile{VideoCodec: "h264", Deinterlace: types.DeinterlaceBwdif},
+			want:    []string{"-vf", "bwdif=mode=send_frame:parity=auto:deint=interlaced"},
+		},
+		{
+			name:    "nvidia without filters",
+			hw:      nvidia,
+			profile: types.TranscodingProfile{VideoCodec: "h264"},
+			want:    []string{},
+		},
+		{
+			name:    "nvidia cuda chain",
+			hw:      nvidia,
+			profile: full,
+			want: []string{
+				"-init_hw_device", "cuda=cu:1",
+				"-filter_hw_device", "cu",
+				"-vf", "format=nv12,hwupload_cuda,yadif_cuda=mode=send_frame:parity=auto:deint=interlaced," +
+					"scale_cuda=w=-2:h='min(ih,720)',fps=29.97",
+			},
+		},
+		{
+			name:    "intel upload without filters",
+			hw:      intel,
+			profile: types.TranscodingProfile{VideoCodec: "h264"},
+			want:    []string{"-vf", "format=nv12,hwupload"},
+		},
+		{
+			name:    "intel vaapi chain",
+			hw:      intel,
+			profile: full,
+			want: []string{"-vf", "format=nv12,hwupload,deinterlace_vaapi=mode=default:rate=frame:auto=1," +
+				"scale_vaapi=w=-2:h='min(ih,720)',fps=29.97"},
+		},
+		{
+			name:    "amd vaapi motion adaptive",
+			hw:      amdVAAPI,
+			profile: types.TranscodingProfile{VideoCodec: "h265", Deinterlace: types.DeinterlaceBwdif},
+			want:    []string{"-vf", "format=nv12,hwupload,deinterlace_vaapi=mode=motion_adaptive:rate=frame:auto=1"},
+		},
+		{
+			name:    "intel vp9 uses software filters",
+			hw:      intel,
+			profile: types.TranscodingProfile{VideoCodec: "vp9", MaxHeight: 720},
+			want:    []string{"-vf", "scale=w=-2:h='min(ih,720)'"},
+		},
+		{
+			name:    "intel mpeg2 without filters has no upload",
+			hw:      intel,
+			profile: types.TranscodingProfile{VideoCodec: "mpeg2"},
+			want:    []string{},
+		},
+		{
+			name:    "amd amf falls back to cpu filters",
+			hw:      amdAMF,
+			profile: scaled,
+			want: []string{"-vf",
+				"scale=w='min(iw,1280)':h='min(ih,720)':force_original_aspect_ratio=decrease:force_divisible_by=2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selector.GetFilterArgs(tt.hw, tt.profile)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("GetFilterArgs() =\n  %q\nwant\n  %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFFmpegArgsIncludesFilters(t *testing.T) {
+	selector := NewSelector(nil, types.HardwareAuto, log.New(io.Discard, "", 0))
+
+	args := selector.GetFFmpegArgs(
+		types.HardwareInfo{Type: types.HardwareCPU},
+		types.TranscodingProfile{VideoCodec: "h264", AudioCodec: "aac", MaxWidth: 1920},
+	)
+
+	i := slices.Index(args, "-vf")
+	if i < 0 || i+1 >= len(args) {
+		t.Fatalf("Expected -vf in args, got %v", args)
+	}
+	if want := "scale=w='min(iw,1920)':h=-2"; args[i+1] != want {
+		t.Errorf("Expected filter %q, got %q", want, args[i+1])
+	}
+}
@@ -0,0 +1,285 @@
+// Package hardware provides GPU detection and selection for transcoding.
+package hardware
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/types"
+)
+
+// DeviceLoad reports the active sessions on a hardware device.
+type DeviceLoad struct {
+	Type     types.HardwareType `json:"type"`
+	DeviceID int                `json:"device_id"`
+	Name     string             `json:"name"`
+	Active   int                `json:"active"`
+	Limit    int                `json:"limit"`
+	Full     bool               `json:"full"`
+	Healthy  bool               `json:"healthy"`
+	// UnhealthyUntil is set while the device is cooling down after a failure.
+	UnhealthyUntil *time.Time `json:"unhealthy_until,omitempty"`
+}
+
+// Allocation is a transcode session placed on a device. Release must be
+// called when the session ends.
+type Allocation struct {
+	Hardware  types.HardwareInfo
+	scheduler *Scheduler
+	tracked   bool
+	once      sync.Once
+}
+
+// Release frees the session slot on the device. It is safe to call more than once.
+func (a *Allocation) Release() {
+	if a == nil || !a.tracked {
+		return
+	}
+	a.once.Do(func() {
+		a.scheduler.release(a.Hardware)
+	})
+}
+
+// Scheduler places transcode sessions on hardware devices. It tracks active
+// sessions per device, honors per-device session caps, spreads sessions to the
+// least-loaded capable GPU and falls back to the CPU when every GPU is full.
+type Scheduler struct {
+	selector *Selector
+	limits   map[string]int
+	mu       sync.Mutex
+	active   map[string]int
+	logger   *log.Logger
+}
+
+// NewScheduler creates a scheduler for the devices known to the selector.
+// Limits are keyed by hardware type ("nvidia") or device ("nvidia:1"); a
+// device key wins over its type. Zero or missing limits mean unlimited.
+func NewScheduler(selector *Selector, limits map[string]int, logger *log.Logger) *Scheduler {
+	if limits == nil {
+		limits = map[string]int{}
+	}
+	return &Scheduler{
+		selector: selector,
+		limits:   limits,
+		active:   make(map[string]int),
+		logger:   logger,
+	}
+}
+
+// Acquire places a new session. The device type and ID pin the session to a
+// device ("auto" lets the scheduler choose, "none" forces the CPU). A pinned
+// device that is missing is treated like "auto", and when the requested
+// devices are full the session runs on the CPU.
+func (s *Scheduler) Acquire(deviceType string, deviceID int, videoCodec string) (*Allocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	devices := s.selector.availableGPUs
+	if len(devices) == 0 {
+		return nil, ErrNoHardware
+	}
+
+	// Copied video and forced software encoding never need a GPU
+	if videoCodec == codecCopy || deviceType == "none" {
+		return s.cpuLocked(devices)
+	}
+
+	var candidates []types.HardwareInfo
+	switch {
+	case deviceType != "auto" && deviceType != "":
+		device, ok := findDevice(devices, types.HardwareType(deviceType), deviceID)
+		if !ok {
+			s.logger.Printf("Device %s:%d not found, using auto selection", deviceType, deviceID)
+			candidates = s.capableLocked(devices, "", videoCodec)
+			break
+		}
+		candidates = []types.HardwareInfo{device}
+	case s.selector.preferred != types.HardwareAuto && s.selector.preferred != "":
+		candidates = s.capableLocked(devices, s.selector.preferred, videoCodec)
+		if len(candidates) == 0 {
+			s.logger.Printf("Preferred hardware %s not available, using auto selection", s.selector.preferred)
+			candidates = s.capableLocked(devices, "", videoCodec)
+		}
+	default:
+		candidates = s.capableLocked(devices, "", videoCodec)
+	}
+
+	if device, ok := s.leastLoadedLocked(candidates); ok {
+		return s.allocateLocked(device), nil
+	}
+
+	if len(candidates) > 0 {
+		s.logger.Printf("All capable GPUs are at their session limit or unhealthy, falling back to CPU")
+	}
+	return s.cpuLocked(devices)
+}
+
+// Load returns the active sessions and limits of every known device.
+func (s *Scheduler) Load() []DeviceLoad {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loads := make([]DeviceLoad, 0, len(s.selector.availableGPUs))
+	for _, device := range s.selector.availableGPUs {
+		key := deviceKey(device)
+		limit := s.limitLocked(device)
+		load := DeviceLoad{
+			Type:     device.Type,
+			DeviceID: device.DeviceID,
+			Name:     device.DeviceName,
+			Active:   s.active[key],
+			Limit:    limit,
+			Full:     limit > 0 && s.active[key] >= limit,
+			Healthy:  true,
+		}
+		if until := s.selector.UnhealthyUntil(device); !until.IsZero() {
+			load.Healthy = false
+			load.UnhealthyUntil = &until
+		}
+		loads = append(loads, load)
+	}
+	return loads
+}
+
+// capableLocked returns the available GPUs that can encode the codec,
+// optionally restricted to one hardware type.
+func (s *Scheduler) capableLocked(devices []types.HardwareInfo, hwType types.HardwareType, videoCodec string) []types.HardwareInfo {
+	var capable []types.HardwareInfo
+	for _, device := range devices {
+		if device.Type == types.HardwareCPU || !device.Available {
+			continue
+		}
+		if hwType != "" && device.Type != hwType {
+			continue
+		}
+		if videoCodec != "" && !supportsCodec(device, videoCodec) {
+			continue
+		}
+		capable = append(capable, device)
+	}
+	return capable
+}
+
+// leastLoadedLocked returns the healthy candidate with the lowest utilization
+// that is below its session limit. Ties prefer NVIDIA, then Intel, then AMD, then
+// the lowest device ID.
+func (s *Scheduler) leastLoadedLocked(candidates []types.HardwareInfo) (types.HardwareInfo, bool) {
+	var open []types.HardwareInfo
+	for _, device := range candidates {
+		limit := s.limitLocked(device)
+		if limit > 0 && s.active[deviceKey(device)] >= limit {
+			continue
+		}
+		if !s.selector.IsHealthy(device) {
+			continue
+		}
+		open = append(open, device)
+	}
+	if len(open) == 0 {
+		return types.HardwareInfo{}, false
+	}
+
+	sort.SliceStable(open, func(i, j int) bool {
+		li, lj := s.utilizationLocked(open[i]), s.utilizationLocked(open[j])
+		if li != lj {
+			return li < lj
+		}
+		if pi, pj := typePriority(open[i].Type), typePriority(open[j].Type); pi != pj {
+			return pi < pj
+		}
+		return open[i].DeviceID < open[j].DeviceID
+	})
+
+	return open[0], true
+}
+
+// utilizationLocked returns the fraction of a device's capacity in use. For
+// unlimited devices the session count itself is used.
+func (s *Scheduler) utilizationLocked(device types.HardwareInfo) float64 {
+	active := float64(s.active[deviceKey(device)])
+	if limit := s.limitLocked(device); limit > 0 {
+		return active / float64(limit)
+	}
+	return active
+}
+
+// limitLocked returns the session limit for a device.
+func (s *Scheduler) limitLocked(device types.HardwareInfo) int {
+	if limit, ok := s.limits[deviceKey(device)]; ok {
+		return limit
+	}
+	return s.limits[string(device.Type)]
+}
+
+// cpuLocked allocates a session on the CPU.
+func (s *Scheduler) cpuLocked(devices []types.HardwareInfo) (*Allocation, error) {
+	for _, device := range devices {
+		if device.Type == types.HardwareCPU {
+			return s.allocateLocked(device), nil
+		}
+	}
+	return nil, ErrNoSuitableHardware
+}
+
+// allocateLocked records a new session on the device.
+func (s *Scheduler) allocateLocked(device types.HardwareInfo) *Allocation {
+	s.active[deviceKey(device)]++
+	s.logger.Printf("Scheduled transcode on %s (%d active)", deviceKey(device), s.active[deviceKey(device)])
+	return &Allocation{Hardware: device, scheduler: s, tracked: true}
+}
+
+// release removes a session from the device.
+func (s *Scheduler) release(device types.HardwareInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := deviceKey(device)
+	if s.active[key] > 0 {
+		s.active[key]--
+	}
+}
+
+// findDevice returns the available device with the given type and ID.
+func findDevice(devices []types.HardwareInfo, hwType types.HardwareType, deviceID int) (types.HardwareInfo, bool) {
+	for _, device := range devices {
+		if device.Type == hwType && device.DeviceID == deviceID && device.Available {
+			return device, true
+		}
+	}
+	return types.HardwareInfo{}, false
+}
+
+// supportsCodec reports whether the device can encode the video codec.
+func supportsCodec(device types.HardwareInfo, videoCodec string) bool {
+	if videoCodec == codecHEVC {
+		videoCodec = codecH265
+	}
+	for _, capability := range device.Capabilities {
+		if capability == videoCodec {
+			return true
+		}
+	}
+	return false
+}
+
+// typePriority orders hardware types for auto selection.
+func typePriority(hwType types.HardwareType) int {
+	switch hwType {
+	case types.HardwareNVIDIA:
+		return 0
+	case types.HardwareIntel:
+		return 1
+	case types.HardwareAMD:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// deviceKey identifies a device as "type:id".
+func deviceKey(device types.HardwareInfo) string {
+	return fmt.Sprintf("%s:%d", device.Type, device.DeviceID)
+}
@@ -0,0 +1,203 @@
+package hardware
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/types"
+)
+
+func newTestScheduler(limits map[string]int, devices ...types.HardwareInfo) *Scheduler {
+	logger := log.New(io.Discard, "", 0)
+	selector := NewSelector(nil, types.HardwareAuto, logger)
+	selector.availableGPUs = append([]types.HardwareInfo{
+		{Type: types.HardwareCPU, Capabilities: []string{"h264", "h265", "mpeg2"}, Available: true},
+	}, devices...)
+	return NewScheduler(selector, limits, logger)
+}
+
+func gpu(hwType types.HardwareType, id int, capabilities ...string) types.HardwareInfo {
+	return types.HardwareInfo{Type: hwType, DeviceID: id, Capabilities: capabilities, Available: true}
+}
+
+func TestSchedulerLeastLoaded(t *testing.T) {
+	scheduler := newTestScheduler(nil,
+		gpu(types.HardwareNVIDIA, 0, "h264", "h265"),
+		gpu(types.HardwareNVIDIA, 1, "h264", "h265"),
+	)
+
+	want := []int{0, 1, 0, 1}
+	for i, id := range want {
+		allocation, err := scheduler.Acquire("auto", 0, "h264")
+		if err != nil {
+			t.Fatalf("Acquire %d failed: %v", i, err)
+		}
+		if allocation.Hardware.Type != types.HardwareNVIDIA || allocation.Hardware.DeviceID != id {
+			t.Errorf("Acquire %d placed on %s:%d, want nvidia:%d",
+				i, allocation.Hardware.Type, allocation.Hardware.DeviceID, id)
+		}
+	}
+}
+
+func TestSchedulerSessionLimitFallsBackToCPU(t *testing.T) {
+	scheduler := newTestScheduler(map[string]int{"nvidia": 1, "intel:0": 1},
+		gpu(types.HardwareNVIDIA, 0, "h264"),
+		gpu(types.HardwareIntel, 0, "h264"),
+	)
+
+	var placed []types.HardwareType
+	var allocations []*Allocation
+	for i := 0; i < 3; i++ {
+		allocation, err := scheduler.Acquire("auto", 0, "h264")
+		if err != nil {
+			t.Fatalf("Acquire %d failed: %v", i, err)
+		}
+		placed = append(placed, allocation.Hardware.Type)
+		allocations = append(allocations, allocation)
+	}
+
+	want := []types.HardwareType{types.HardwareNVIDIA, types.HardwareIntel, types.HardwareCPU}
+	for i := range want {
+		if placed[i] != want[i] {
+			t.Errorf("Session %d placed on %s, want %s", i, placed[i], want[i])
+		}
+	}
+
+	for _, load := range scheduler.Load() {
+		if load.Type == types.HardwareNVIDIA && (!load.Full || load.Active != 1 || load.Limit != 1) {
+			t.Errorf("Unexpected NVIDIA load: %+v", load)
+		}
+	}
+
+	// Releasing the NVIDIA session frees its slot
+	allocations[0].Release()
+	allocations[0].Release()
+	allocation, err := scheduler.Acquire("auto", 0, "h264")
+	if err != nil {
+		t.Fatalf("Acquire after release failed: %v", err)
+	}
+	if allocation.Hardware.Type != types.HardwareNVIDIA {
+		t.Errorf("Expected NVIDIA after release, got %s", allocation.Hardware.Type)
+	}
+}
+
+func TestSchedulerPinnedDevice(t *testing.T) {
+	scheduler := newTestScheduler(map[string]int{"nvidia:1": 1},
+		gpu(types.HardwareNVIDIA, 0, "h264"),
+		gpu(types.HardwareNVIDIA, 1, "h264"),
+	)
+
+	allocation, err := scheduler.Acquire("nvidia", 1, "h264")
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if allocation.Hardware.DeviceID != 1 {
+		t.Errorf("Expected nvidia:1, got nvidia:%d", allocation.Hardware.DeviceID)
+	}
+
+	// The pinned device is full, so the next session runs on the CPU
+	allocation, err = scheduler.Acquire("nvidia", 1, "h264")
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if allocation.Hardware.Type != types.HardwareCPU {
+		t.Errorf("Expected CPU fallback, got %s", allocation.Hardware.Type)
+	}
+}
+
+func TestSchedulerMissingPinnedDevice(t *testing.T) {
+	scheduler := newTestScheduler(nil, gpu(types.HardwareNVIDIA, 0, "h264"))
+
+	// A pinned GPU that disappeared falls back to the other devices
+	allocation, err := scheduler.Acquire("nvidia", 7, "h264")
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if allocation.Hardware.Type != types.HardwareNVIDIA || allocation.Hardware.DeviceID != 0 {
+		t.Errorf("Expected nvidia:0, got %s:%d", allocation.Hardware.Type, allocation.Hardware.DeviceID)
+	}
+
+	// Without another capable GPU the session runs on the CPU
+	allocation, err = scheduler.Acquire("intel", 0, "h265")
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if allocation.Hardware.Type != types.HardwareCPU {
+		t.Errorf("Expected CPU fallback, got %s", allocation.Hardware.Type)
+	}
+}
+
+func TestSchedulerCapabilities(t *testing.T) {
+	scheduler := newTestScheduler(nil,
+		gpu(types.HardwareNVIDIA, 0, "h264"),
+		gpu(types.HardwareIntel, 0, "h264", "h265"),
+	)
+
+	tests := []struct {
+		name       string
+		deviceType string
+		codec      string
+		want       types.HardwareType
+	}{
+		{"hevc skips devices without h265", "auto", "h265", types.HardwareIntel},
+		{"ffprobe codec names are accepted", "auto", "hevc", types.HardwareIntel},
+		{"no gpu encodes mpeg2", "auto", "mpeg2", types.HardwareCPU},
+		{"copy needs no gpu", "auto", "copy", types.HardwareCPU},
+		{"none forces cpu", "none", "h264", types.HardwareCPU},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocation, err := scheduler.Acquire(tt.deviceType, 0, tt.codec)
+			if err != nil {
+				t.Fatalf("Acquire failed: %v", err)
+			}
+			defer allocation.Release()
+			if allocation.Hardware.Type != tt.want {
+				t.Errorf("Placed on %s, want %s", allocation.Hardware.Type, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerSkipsUnhealthyDevices(t *testing.T) {
+	nvidia := gpu(types.HardwareNVIDIA, 0, "h264")
+	intel := gpu(types.HardwareIntel, 0, "h264")
+	scheduler := newTestScheduler(nil, nvidia, intel)
+
+	scheduler.selector.MarkUnhealthy(nvidia, time.Hour)
+	scheduler.selector.MarkUnhealthy(types.HardwareInfo{Type: types.HardwareCPU}, time.Hour)
+
+	allocation, err := scheduler.Acquire("auto", 0, "h264")
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if allocation.Hardware.Type != types.HardwareIntel {
+		t.Errorf("Expected intel while nvidia cools down, got %s", allocation.Hardware.Type)
+	}
+
+	// A pinned device that is cooling down falls back to the CPU
+	allocation, err = scheduler.Acquire("nvidia", 0, "h264")
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if allocation.Hardware.Type != types.HardwareCPU {
+		t.Errorf("Expected CPU fallback for unhealthy pinned device, got %s", allocation.Hardware.Type)
+	}
+
+	for _, load := range scheduler.Load() {
+		unhealthy := load.Type == types.HardwareNVIDIA
+		if load.Healthy == unhealthy || (load.UnhealthyUntil != nil) != unhealthy {
+			t.Errorf("Unexpected health for %s: %+v", load.Type, load)
+		}
+	}
+
+	// Expired cooldowns make the device available again
+	scheduler.selector.MarkUnhealthy(intel, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if !scheduler.selector.IsHealthy(intel) {
+		t.Error("Expected intel to be healthy after cooldown")
+	}
+}
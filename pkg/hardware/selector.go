@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/savid/iptv-proxy/pkg/types"
 )
@@ -39,6 +41,8 @@ type Selector struct {
 	detector      *Detector
 	preferred     types.HardwareType
 	availableGPUs []types.HardwareInfo
+	healthMu      sync.Mutex
+	unhealthy     map[string]time.Time
 	logger        *log.Logger
 }
 
@@ -47,13 +51,55 @@ func NewSelector(detector *Detector, preferred types.HardwareType, logger *log.L
 	return &Selector{
 		detector:  detector,
 		preferred: preferred,
+		unhealthy: make(map[string]time.Time),
 		logger:    logger,
 	}
 }
 
-// Initialize detects available hardware and prepares the selector.
+// MarkUnhealthy excludes a device from selection for the cooldown period
+// after it failed at runtime. The CPU is never marked unhealthy.
+func (s *Selector) MarkUnhealthy(hw types.HardwareInfo, cooldown time.Duration) {
+	if hw.Type == types.HardwareCPU || cooldown <= 0 {
+		return
+	}
+
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	until := time.Now().Add(cooldown)
+	s.unhealthy[deviceKey(hw)] = until
+	s.logger.Printf("Marked %s unhealthy until %s", deviceKey(hw), until.Format(time.RFC3339))
+}
+
+// IsHealthy reports whether a device is outside any failure cooldown.
+func (s *Selector) IsHealthy(hw types.HardwareInfo) bool {
+	return s.UnhealthyUntil(hw).IsZero()
+}
+
+// UnhealthyUntil returns when a device's failure cooldown ends, or the zero
+// time if the device is healthy.
+func (s *Selector) UnhealthyUntil(hw types.HardwareInfo) time.Time {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	until, ok := s.unhealthy[deviceKey(hw)]
+	if !ok {
+		return time.Time{}
+	}
+	if time.Now().After(until) {
+		delete(s.unhealthy, deviceKey(hw))
+		return time.Time{}
+	}
+	return until
+}
+
+// Initialize detects all available hardware devices and prepares the selector.
 func (s *Selector) Initialize() error {
-	s.availableGPUs = s.detector.DetectGPUs()
+	devices, err := s.detector.DetectAllDevices()
+	if err != nil {
+		return fmt.Errorf("failed to detect hardware: %w", err)
+	}
+	s.availableGPUs = devices
 
 	if len(s.availableGPUs) == 0 {
 		return ErrNoHardware
@@ -61,7 +107,7 @@ func (s *Selector) Initialize() error {
 
 	s.logger.Printf("Available hardware acceleration:")
 	for _, gpu := range s.availableGPUs {
-		s.logger.Printf("  - %s: %v", gpu.Type, gpu.Capabilities)
+		s.logger.Printf("  - %s:%d (%s): %v", gpu.Type, gpu.DeviceID, gpu.DeviceName, gpu.Capabilities)
 	}
 
 	return nil
@@ -135,6 +181,9 @@ func (s *Selector) GetFFmpegArgs(hw types.HardwareInfo, profile types.Transcodin
 	videoArgs := s.getVideoCodecArgs(hw, profile.VideoCodec)
 	args = append(args, videoArgs...)
 
+	// Add scaling, deinterlacing and frame rate filters
+	args = append(args, s.GetFilterArgs(hw, profile)...)
+
 	// Add audio codec arguments
 	audioArgs := s.getAudioCodecArgs(profile.AudioCodec)
 	args = append(args, audioArgs...)
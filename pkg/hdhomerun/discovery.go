@@ -0,0 +1,139 @@
+package hdhomerun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DiscoveryPort is the UDP port HDHomeRun clients send discover requests to.
+const DiscoveryPort = 65001
+
+// ErrNoDevices is returned when a responder is created without devices.
+var ErrNoDevices = errors.New("no devices to announce")
+
+// Device is an emulated tuner answered for in discover replies.
+type Device struct {
+	DeviceID   uint32
+	TunerCount int
+	BaseURL    string
+	LineupURL  string
+	DeviceAuth string
+}
+
+// ParseDeviceID reads a hexadecimal device ID. IDs that are not hexadecimal
+// are hashed so that the same string always gives the same ID.
+func ParseDeviceID(id string) uint32 {
+	if value, err := strconv.ParseUint(id, 16, 32); err == nil {
+		return uint32(value)
+	}
+	return crc32.ChecksumIEEE([]byte(id))
+}
+
+// Responder answers discover requests for its devices.
+type Responder struct {
+	conn    net.PacketConn
+	devices []Device
+	logger  *logrus.Logger
+
+	once sync.Once
+}
+
+// Listen opens the discovery port on a local address, e.g. "0.0.0.0".
+func Listen(host string) (net.PacketConn, error) {
+	conn, err := net.ListenPacket("udp4", net.JoinHostPort(host, strconv.Itoa(DiscoveryPort)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for HDHomeRun discovery: %w", err)
+	}
+	return conn, nil
+}
+
+// NewResponder creates a responder that reads discover requests from conn.
+func NewResponder(conn net.PacketConn, devices []Device, logger *logrus.Logger) (*Responder, error) {
+	if len(devices) == 0 {
+		return nil, ErrNoDevices
+	}
+	return &Responder{
+		conn:    conn,
+		devices: devices,
+		logger:  logger,
+	}, nil
+}
+
+// Serve answers discover requests until the context is cancelled.
+func (r *Responder) Serve(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		r.Close()
+	}()
+
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				r.logger.WithError(err).Warn("HDHomeRun discovery read failed")
+			}
+			return
+		}
+
+		request, err := Unmarshal(buf[:n])
+		if err != nil || request.Type != TypeDiscoverRequest {
+			continue
+		}
+
+		for _, reply := range r.replies(request) {
+			if _, err := r.conn.WriteTo(reply, addr); err != nil {
+				r.logger.WithError(err).Debug("Failed to send HDHomeRun discover reply")
+			}
+		}
+	}
+}
+
+// Close stops the responder.
+func (r *Responder) Close() {
+	r.once.Do(func() { _ = r.conn.Close() })
+}
+
+// replies builds the replies of the devices matching a request.
+func (r *Responder) replies(request *Packet) [][]byte {
+	deviceType, ok := request.Uint32(TagDeviceType)
+	if ok && deviceType != DeviceTypeTuner && deviceType != DeviceTypeWildcard {
+		return nil
+	}
+	deviceID, hasID := request.Uint32(TagDeviceID)
+
+	var out [][]byte
+	for _, device := range r.devices {
+		if hasID && deviceID != DeviceIDWildcard && deviceID != device.DeviceID {
+			continue
+		}
+		reply, err := device.reply().Marshal()
+		if err != nil {
+			r.logger.WithError(err).Warn("Failed to encode HDHomeRun discover reply")
+			continue
+		}
+		out = append(out, reply)
+	}
+	return out
+}
+
+// reply builds the discover reply of a device.
+func (d Device) reply() *Packet {
+	p := &Packet{Type: TypeDiscoverReply}
+	p.AddUint32(TagDeviceType, DeviceTypeTuner)
+	p.AddUint32(TagDeviceID, d.DeviceID)
+	p.AddUint8(TagTunerCount, uint8(min(max(d.TunerCount, 0), 0xFF))) //nolint:gosec // clamped to a byte
+	if d.DeviceAuth != "" {
+		p.AddString(TagDeviceAuth, d.DeviceAuth)
+	}
+	p.AddString(TagBaseURL, d.BaseURL)
+	p.AddString(TagLineupURL, d.LineupURL)
+	return p
+}
@@ -0,0 +1,179 @@
+package hdhomerun
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// discoverRequest is the wildcard discover request sent by libhdhomerun.
+const discoverRequest = "0002000c0104000000010204ffffffff4e507f35"
+
+func TestUnmarshalDiscoverRequest(t *testing.T) {
+	data, _ := hex.DecodeString(discoverRequest)
+
+	p, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if p.Type != TypeDiscoverRequest {
+		t.Errorf("Type = 0x%04X, want discover request", p.Type)
+	}
+	if v, ok := p.Uint32(TagDeviceType); !ok || v != DeviceTypeTuner {
+		t.Errorf("device type = 0x%08X, want tuner", v)
+	}
+	if v, ok := p.Uint32(TagDeviceID); !ok || v != DeviceIDWildcard {
+		t.Errorf("device ID = 0x%08X, want wildcard", v)
+	}
+
+	// Encoding the decoded packet gives the same bytes
+	encoded, err := p.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if !bytes.Equal(encoded, data) {
+		t.Errorf("Marshal() = %x, want %s", encoded, discoverRequest)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	valid, _ := hex.DecodeString(discoverRequest)
+	corrupt := append([]byte(nil), valid...)
+	corrupt[len(corrupt)-1] ^= 0xFF
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrShortPacket},
+		{"truncated", valid[:10], ErrShortPacket},
+		{"bad crc", corrupt, ErrBadCRC},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Unmarshal(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("Unmarshal() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLongFieldRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	p := &Packet{Type: TypeDiscoverReply}
+	p.AddString(TagLineupURL, long)
+
+	data, err := p.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	// 300 bytes need the two byte length: 300&0x7F|0x80, 300>>7
+	if data[5] != 0xAC || data[6] != 0x02 {
+		t.Errorf("length bytes = %02X %02X, want AC 02", data[5], data[6])
+	}
+
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if value, _ := decoded.Field(TagLineupURL); string(value) != long {
+		t.Errorf("decoded value has %d bytes, want 300", len(value))
+	}
+}
+
+func TestParseDeviceID(t *testing.T) {
+	if id := ParseDeviceID("1041A1B2"); id != 0x1041A1B2 {
+		t.Errorf("ParseDeviceID(hex) = %08X", id)
+	}
+	if ParseDeviceID("not-hex") != ParseDeviceID("not-hex") {
+		t.Errorf("ParseDeviceID() is not stable for non-hex IDs")
+	}
+}
+
+func TestResponderReplies(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	responder, err := NewResponder(conn, []Device{{
+		DeviceID:   0x1041A1B2,
+		TunerCount: 4,
+		BaseURL:    "http://192.0.2.1:8080",
+		LineupURL:  "http://192.0.2.1:8080/lineup.json",
+	}}, logger)
+	if err != nil {
+		t.Fatalf("NewResponder() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go responder.Serve(ctx)
+
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	discover := func(deviceType, deviceID uint32) *Packet {
+		t.Helper()
+		request := &Packet{Type: TypeDiscoverRequest}
+		request.AddUint32(TagDeviceType, deviceType)
+		request.AddUint32(TagDeviceID, deviceID)
+		data, _ := request.Marshal()
+		if _, err := client.WriteTo(data, conn.LocalAddr()); err != nil {
+			t.Fatalf("WriteTo() error = %v", err)
+		}
+
+		buf := make([]byte, 1500)
+		_ = client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := client.ReadFrom(buf)
+		if err != nil {
+			return nil
+		}
+		reply, err := Unmarshal(buf[:n])
+		if err != nil {
+			t.Fatalf("Unmarshal(reply) error = %v", err)
+		}
+		return reply
+	}
+
+	reply := discover(DeviceTypeWildcard, DeviceIDWildcard)
+	if reply == nil || reply.Type != TypeDiscoverReply {
+		t.Fatalf("no discover reply")
+	}
+	if id, _ := reply.Uint32(TagDeviceID); id != 0x1041A1B2 {
+		t.Errorf("device ID = %08X", id)
+	}
+	if count, _ := reply.Field(TagTunerCount); len(count) != 1 || count[0] != 4 {
+		t.Errorf("tuner count = %v, want 4", count)
+	}
+	if url, _ := reply.Field(TagBaseURL); string(url) != "http://192.0.2.1:8080" {
+		t.Errorf("base URL = %q", url)
+	}
+	if url, _ := reply.Field(TagLineupURL); string(url) != "http://192.0.2.1:8080/lineup.json" {
+		t.Errorf("lineup URL = %q", url)
+	}
+
+	if discover(DeviceTypeTuner, 0x1041A1B2) == nil {
+		t.Errorf("no reply to a request for the device ID")
+	}
+	if discover(DeviceTypeTuner, 0x12345678) != nil {
+		t.Errorf("reply to a request for another device ID")
+	}
+	if discover(0x00000005, DeviceIDWildcard) != nil {
+		t.Errorf("reply to a request for another device type")
+	}
+}
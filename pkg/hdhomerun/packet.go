@@ -0,0 +1,170 @@
+// Package hdhomerun implements the SiliconDust HDHomeRun discovery protocol
+// used by native HDHomeRun apps and Channels DVR to find tuners on UDP
+// port 65001.
+package hdhomerun
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// Packet types.
+const (
+	TypeDiscoverRequest uint16 = 0x0002
+	TypeDiscoverReply   uint16 = 0x0003
+)
+
+// Tags of the TLV fields in discover packets.
+const (
+	TagDeviceType uint8 = 0x01
+	TagDeviceID   uint8 = 0x02
+	TagTunerCount uint8 = 0x10
+	TagLineupURL  uint8 = 0x27
+	TagBaseURL    uint8 = 0x2A
+	TagDeviceAuth uint8 = 0x2B
+)
+
+const (
+	// DeviceTypeTuner is the device type of tuners.
+	DeviceTypeTuner uint32 = 0x00000001
+	// DeviceTypeWildcard matches every device type in a discover request.
+	DeviceTypeWildcard uint32 = 0xFFFFFFFF
+	// DeviceIDWildcard matches every device ID in a discover request.
+	DeviceIDWildcard uint32 = 0xFFFFFFFF
+
+	// headerSize is the size of the type and length fields.
+	headerSize = 4
+	// crcSize is the size of the trailing CRC32.
+	crcSize = 4
+	// maxTLVLength is the longest value a TLV length can encode.
+	maxTLVLength = 0x7FFF
+)
+
+var (
+	// ErrShortPacket is returned for packets shorter than their header says.
+	ErrShortPacket = errors.New("packet too short")
+	// ErrBadCRC is returned when the CRC32 of a packet does not match.
+	ErrBadCRC = errors.New("packet CRC mismatch")
+	// ErrValueTooLong is returned when a TLV value does not fit its length field.
+	ErrValueTooLong = errors.New("TLV value too long")
+)
+
+// TLV is a tagged field of a packet.
+type TLV struct {
+	Tag   uint8
+	Value []byte
+}
+
+// Packet is a decoded HDHomeRun control packet.
+type Packet struct {
+	Type   uint16
+	Fields []TLV
+}
+
+// Field returns the value of the first field with a tag.
+func (p *Packet) Field(tag uint8) ([]byte, bool) {
+	for _, field := range p.Fields {
+		if field.Tag == tag {
+			return field.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Uint32 returns the value of a four byte field.
+func (p *Packet) Uint32(tag uint8) (uint32, bool) {
+	value, ok := p.Field(tag)
+	if !ok || len(value) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(value), true
+}
+
+// AddUint8 appends a one byte field.
+func (p *Packet) AddUint8(tag, value uint8) {
+	p.Fields = append(p.Fields, TLV{Tag: tag, Value: []byte{value}})
+}
+
+// AddUint32 appends a four byte field.
+func (p *Packet) AddUint32(tag uint8, value uint32) {
+	p.Fields = append(p.Fields, TLV{Tag: tag, Value: binary.BigEndian.AppendUint32(nil, value)})
+}
+
+// AddString appends a string field.
+func (p *Packet) AddString(tag uint8, value string) {
+	p.Fields = append(p.Fields, TLV{Tag: tag, Value: []byte(value)})
+}
+
+// Marshal encodes the packet: type and payload length (big endian), the TLV
+// fields, then the CRC32 of everything before it (little endian).
+func (p *Packet) Marshal() ([]byte, error) {
+	var payload []byte
+	for _, field := range p.Fields {
+		if len(field.Value) > maxTLVLength {
+			return nil, fmt.Errorf("%w: tag 0x%02X has %d bytes", ErrValueTooLong, field.Tag, len(field.Value))
+		}
+		payload = append(payload, field.Tag)
+		payload = appendLength(payload, len(field.Value))
+		payload = append(payload, field.Value...)
+	}
+
+	out := make([]byte, 0, headerSize+len(payload)+crcSize)
+	out = binary.BigEndian.AppendUint16(out, p.Type)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(payload))) //nolint:gosec // UDP payloads are far smaller
+	out = append(out, payload...)
+	return binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(out)), nil
+}
+
+// Unmarshal decodes a packet and checks its CRC32.
+func Unmarshal(data []byte) (*Packet, error) {
+	if len(data) < headerSize+crcSize {
+		return nil, ErrShortPacket
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	end := headerSize + length
+	if len(data) < end+crcSize {
+		return nil, fmt.Errorf("%w: payload of %d bytes in %d", ErrShortPacket, length, len(data))
+	}
+	if crc32.ChecksumIEEE(data[:end]) != binary.LittleEndian.Uint32(data[end:end+crcSize]) {
+		return nil, ErrBadCRC
+	}
+
+	p := &Packet{Type: binary.BigEndian.Uint16(data[0:2])}
+	payload := data[headerSize:end]
+	for len(payload) > 0 {
+		tag := payload[0]
+		size, n, ok := readLength(payload[1:])
+		if !ok || len(payload) < 1+n+size {
+			return nil, fmt.Errorf("%w: field 0x%02X", ErrShortPacket, tag)
+		}
+		value := payload[1+n : 1+n+size]
+		p.Fields = append(p.Fields, TLV{Tag: tag, Value: append([]byte(nil), value...)})
+		payload = payload[1+n+size:]
+	}
+	return p, nil
+}
+
+// appendLength encodes a TLV length: one byte up to 127, otherwise two bytes
+// with the low seven bits first and the high bit of the first byte set.
+func appendLength(out []byte, length int) []byte {
+	if length <= 0x7F {
+		return append(out, byte(length))
+	}
+	return append(out, byte(length&0x7F)|0x80, byte(length>>7))
+}
+
+// readLength decodes a TLV length and returns the number of bytes it used.
+func readLength(data []byte) (int, int, bool) {
+	if len(data) == 0 {
+		return 0, 0, false
+	}
+	if data[0]&0x80 == 0 {
+		return int(data[0]), 1, true
+	}
+	if len(data) < 2 {
+		return 0, 0, false
+	}
+	return int(data[0]&0x7F) | int(data[1])<<7, 2, true
+}
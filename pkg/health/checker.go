@@ -0,0 +1,309 @@
+// Package health provides background health checking of playlist channels.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/data"
+	"github.com/savid/iptv-proxy/pkg/events"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/savid/iptv-proxy/pkg/streaming/transcode"
+	"github.com/savid/iptv-proxy/pkg/tuner"
+	"github.com/savid/iptv-proxy/pkg/upstream"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// tsPacketSize is the size of an MPEG-TS packet.
+	tsPacketSize = 188
+	// tsSyncByte is the first byte of every MPEG-TS packet.
+	tsSyncByte = 0x47
+	// syncPackets is the number of consecutive packets that must be aligned.
+	syncPackets = 3
+	// maxProbeBytes bounds how much of the stream is read while looking for sync.
+	maxProbeBytes = 64 * 1024
+)
+
+var (
+	// ErrUnexpectedStatus is returned when the upstream responds with a non-200 status.
+	ErrUnexpectedStatus = errors.New("unexpected status code")
+	// ErrNoMPEGTSSync is returned when the stream does not start with MPEG-TS packets.
+	ErrNoMPEGTSSync = errors.New("no MPEG-TS sync found")
+	// ErrNoVideoStream is returned when codec analysis finds no video stream.
+	ErrNoVideoStream = errors.New("no video stream found")
+)
+
+// Checker periodically probes channels and records the results in the store.
+type Checker struct {
+	store       *data.Store
+	pool        *tuner.Pool
+	client      *upstream.Client
+	interval    time.Duration
+	timeout     time.Duration
+	concurrency int
+	analyze     bool
+	events      *events.Bus
+	logger      *logrus.Logger
+}
+
+// NewChecker creates a new channel health checker.
+func NewChecker(cfg *config.Config, store *data.Store, pool *tuner.Pool, logger *logrus.Logger) *Checker {
+	return &Checker{
+		store:       store,
+		pool:        pool,
+		client:      upstream.Default(),
+		interval:    cfg.HealthCheckInterval,
+		timeout:     cfg.HealthCheckTimeout,
+		concurrency: cfg.HealthCheckConcurrency,
+		analyze:     cfg.HealthCheckAnalyze,
+		logger:      logger,
+	}
+}
+
+// SetUpstream replaces the client used to request channel streams.
+func (c *Checker) SetUpstream(client *upstream.Client) {
+	if client != nil {
+		c.client = client
+	}
+}
+
+// SetEvents publishes an event to bus when a working channel starts
+// failing or is hidden.
+func (c *Checker) SetEvents(bus *events.Bus) {
+	c.events = bus
+}
+
+// Start runs health check cycles until the context is cancelled.
+func (c *Checker) Start(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	c.CheckAll(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger.Info("Health checker shutting down")
+			return
+		case <-ticker.C:
+			c.CheckAll(ctx)
+		}
+	}
+}
+
+// CheckAll probes every channel in the current playlist once.
+// Channels are skipped when no spare tuner is available.
+func (c *Checker) CheckAll(ctx context.Context) {
+	_, channels, ok := c.store.GetM3U()
+	if !ok {
+		return
+	}
+
+	c.logger.WithField("channels", len(channels)).Debug("Starting channel health check cycle")
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		failing int
+		skipped int
+	)
+
+	sem := make(chan struct{}, c.concurrency)
+	for _, channel := range channels {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+
+		lease, ok := c.acquireTuner(ctx)
+		if !ok {
+			<-sem
+			mu.Lock()
+			skipped++
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(channel m3u.Channel) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer lease.Release()
+
+			before, known := c.store.GetChannelHealth(channel.URL)
+			result := c.store.RecordChannelHealth(c.Check(ctx, channel))
+			if after, _ := c.store.GetChannelHealth(channel.URL); wentDead(before, known, after) {
+				c.events.Publish(events.Event{
+					Type:    events.TypeChannelDead,
+					Subject: channel.Name,
+					Message: fmt.Sprintf("Channel %s failed %d health checks: %s", channel.Name, after.ConsecutiveFailures, after.Error),
+					Fields:  map[string]any{"hidden": after.Hidden},
+				})
+			}
+			if result.Status != data.ChannelStatusOK {
+				mu.Lock()
+				failing++
+				mu.Unlock()
+				c.logger.WithFields(logrus.Fields{
+					"channel":  channel.Name,
+					"failures": result.ConsecutiveFailures,
+					"error":    result.Error,
+				}).Debug("Channel health check failed")
+			}
+		}(channel)
+	}
+
+	wg.Wait()
+
+	c.logger.WithFields(logrus.Fields{
+		"channels": len(channels),
+		"failing":  failing,
+		"skipped":  skipped,
+	}).Info("Channel health check cycle completed")
+}
+
+// acquireTuner obtains a spare tuner for a probe. If the tuners are only busy
+// with other probes it waits for one of them to finish; if viewers are using
+// every tuner it gives up so that checks never compete with clients.
+func (c *Checker) acquireTuner(ctx context.Context) (*tuner.Lease, bool) {
+	for {
+		if lease, ok := c.pool.TryAcquire(tuner.PurposeHealthCheck); ok {
+			return lease, true
+		}
+
+		if c.pool.InUseFor(tuner.PurposeHealthCheck) == 0 {
+			return nil, false
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// Check probes a single channel and returns the result.
+func (c *Checker) Check(ctx context.Context, channel m3u.Channel) data.ChannelHealth {
+	result := data.ChannelHealth{
+		Name:        channel.Name,
+		URL:         channel.URL,
+		Status:      data.ChannelStatusOK,
+		LastChecked: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	status, err := c.probeHTTP(ctx, channel.URL)
+	result.HTTPStatus = status
+	result.Latency = time.Since(start)
+	if err != nil {
+		result.Status = data.ChannelStatusFailing
+		result.Error = err.Error()
+		return result
+	}
+
+	if c.analyze {
+		codecs, err := transcode.AnalyzeStream(channel.URL, c.client.InputOptions()...)
+		if err == nil && codecs.VideoCodec == "" {
+			err = ErrNoVideoStream
+		}
+		if err != nil {
+			result.Status = data.ChannelStatusFailing
+			result.Error = err.Error()
+			return result
+		}
+		result.VideoCodec = codecs.VideoCodec
+		result.AudioCodec = codecs.AudioCodec
+	}
+
+	return result
+}
+
+// probeHTTP requests the stream and verifies that it begins with MPEG-TS packets.
+func (c *Checker) probeHTTP(ctx context.Context, url string) (int, error) {
+	resp, err := c.client.Get(ctx, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch stream: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)
+	}
+
+	if err := verifyMPEGTS(resp.Body); err != nil {
+		return resp.StatusCode, err
+	}
+
+	return resp.StatusCode, nil
+}
+
+// verifyMPEGTS reads from the stream until it finds several consecutive
+// packets aligned on the MPEG-TS sync byte.
+func verifyMPEGTS(r io.Reader) error {
+	buf := make([]byte, 0, maxProbeBytes)
+	chunk := make([]byte, 4096)
+
+	for len(buf) < maxProbeBytes {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+
+		if hasSync(buf) {
+			return nil
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("failed to read stream: %w", err)
+		}
+	}
+
+	return ErrNoMPEGTSSync
+}
+
+// hasSync reports whether buf contains syncPackets aligned MPEG-TS packets.
+func hasSync(buf []byte) bool {
+	for offset := 0; offset < tsPacketSize && offset < len(buf); offset++ {
+		if offset+syncPackets*tsPacketSize > len(buf) {
+			return false
+		}
+
+		aligned := true
+		for i := 0; i < syncPackets; i++ {
+			if buf[offset+i*tsPacketSize] != tsSyncByte {
+				aligned = false
+				break
+			}
+		}
+		if aligned {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wentDead reports whether a health check turned a working channel into a
+// failing one, or hid the channel.
+func wentDead(before data.ChannelHealth, known bool, after data.ChannelHealth) bool {
+	if after.Hidden && !before.Hidden {
+		return true
+	}
+	return known && before.Status == data.ChannelStatusOK && after.Status != data.ChannelStatusOK
+}
@@ -0,0 +1,146 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/data"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/savid/iptv-proxy/pkg/tuner"
+	"github.com/sirupsen/logrus"
+)
+
+// tsPackets returns n null MPEG-TS packets.
+func tsPackets(n int) []byte {
+	packet := make([]byte, tsPacketSize)
+	packet[0] = tsSyncByte
+	packet[1] = 0x1F
+	packet[2] = 0xFF
+	packet[3] = 0x10
+	return bytes.Repeat(packet, n)
+}
+
+func newTestChecker(store *data.Store, pool *tuner.Pool) *Checker {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cfg := &config.Config{
+		HealthCheckInterval:    time.Minute,
+		HealthCheckConcurrency: 2,
+		HealthCheckTimeout:     5 * time.Second,
+	}
+	return NewChecker(cfg, store, pool, logger)
+}
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ts":
+			// Leading garbage before the first sync byte
+			_, _ = w.Write([]byte{0x00, 0x01})
+			_, _ = w.Write(tsPackets(10))
+		case "/html":
+			_, _ = w.Write(bytes.Repeat([]byte("<html></html>"), 100))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	checker := newTestChecker(data.NewStore(), tuner.NewPool(2))
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus data.ChannelStatus
+		wantHTTP   int
+	}{
+		{name: "valid MPEG-TS", path: "/ts", wantStatus: data.ChannelStatusOK, wantHTTP: http.StatusOK},
+		{name: "not MPEG-TS", path: "/html", wantStatus: data.ChannelStatusFailing, wantHTTP: http.StatusOK},
+		{name: "not found", path: "/missing", wantStatus: data.ChannelStatusFailing, wantHTTP: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := checker.Check(context.Background(), m3u.Channel{Name: tt.name, URL: server.URL + tt.path})
+			if result.Status != tt.wantStatus {
+				t.Errorf("Expected status %s, got %s (error: %s)", tt.wantStatus, result.Status, result.Error)
+			}
+			if result.HTTPStatus != tt.wantHTTP {
+				t.Errorf("Expected HTTP status %d, got %d", tt.wantHTTP, result.HTTPStatus)
+			}
+		})
+	}
+}
+
+func TestCheckAllRespectsTuners(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(tsPackets(10))
+	}))
+	defer server.Close()
+
+	store := data.NewStore()
+	store.SetM3U([]byte("#EXTM3U\n"), []m3u.Channel{
+		{Name: "One", URL: server.URL + "/1"},
+		{Name: "Two", URL: server.URL + "/2"},
+	})
+
+	// All tuners are busy with viewers, so nothing should be probed
+	pool := tuner.NewPool(1)
+	lease := pool.Acquire(tuner.PurposeStream)
+
+	checker := newTestChecker(store, pool)
+	checker.CheckAll(context.Background())
+
+	if _, ok := store.GetChannelHealth(server.URL + "/1"); ok {
+		t.Error("Channel should not be checked while all tuners are in use")
+	}
+
+	// Once the tuner is free, every channel is probed
+	lease.Release()
+	checker.CheckAll(context.Background())
+
+	for _, path := range []string{"/1", "/2"} {
+		health, ok := store.GetChannelHealth(server.URL + path)
+		if !ok || health.Status != data.ChannelStatusOK {
+			t.Errorf("Expected channel %s to be healthy, got %+v", path, health)
+		}
+	}
+
+	if pool.InUse() != 0 {
+		t.Errorf("Expected all tuners to be released, got %d in use", pool.InUse())
+	}
+}
+
+func TestWentDead(t *testing.T) {
+	ok := data.ChannelHealth{Status: data.ChannelStatusOK}
+	failing := data.ChannelHealth{Status: data.ChannelStatusFailing, ConsecutiveFailures: 1}
+	hidden := data.ChannelHealth{Status: data.ChannelStatusFailing, ConsecutiveFailures: 3, Hidden: true}
+
+	tests := []struct {
+		name   string
+		before data.ChannelHealth
+		known  bool
+		after  data.ChannelHealth
+		want   bool
+	}{
+		{"ok to failing", ok, true, failing, true},
+		{"first check fails", data.ChannelHealth{}, false, failing, false},
+		{"still failing", failing, true, failing, false},
+		{"failing to hidden", failing, true, hidden, true},
+		{"still hidden", hidden, true, hidden, false},
+		{"recovered", failing, true, ok, false},
+	}
+
+	for _, tt := range tests {
+		if got := wentDead(tt.before, tt.known, tt.after); got != tt.want {
+			t.Errorf("%s: wentDead() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
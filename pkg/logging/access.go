@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// AccessFormatCombined writes the Apache combined log format, followed by
+	// the duration in seconds and the request ID.
+	AccessFormatCombined = "combined"
+	// AccessFormatJSON writes one JSON object per completed stream.
+	AccessFormatJSON = "json"
+)
+
+// ErrInvalidAccessFormat is returned for an unknown access log format.
+var ErrInvalidAccessFormat = errors.New("invalid access log format")
+
+// AccessRecord describes a completed stream.
+type AccessRecord struct {
+	Time      time.Time     `json:"time"`
+	RequestID string        `json:"request_id,omitempty"`
+	Remote    string        `json:"remote"`
+	Method    string        `json:"method"`
+	URI       string        `json:"uri"`
+	Proto     string        `json:"proto"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"duration"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	// Channel, Client and Device describe the session when the stream
+	// handler resolved them.
+	Channel string `json:"channel,omitempty"`
+	Client  string `json:"client,omitempty"`
+	Device  string `json:"device,omitempty"`
+}
+
+// AccessLog writes a line per completed stream.
+type AccessLog struct {
+	format string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAccessLog creates an access log writing records to w in the given
+// format.
+func NewAccessLog(w io.Writer, format string) (*AccessLog, error) {
+	switch format {
+	case AccessFormatCombined, AccessFormatJSON:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAccessFormat, format)
+	}
+	return &AccessLog{format: format, w: w}, nil
+}
+
+// Log writes a record.
+func (a *AccessLog) Log(record AccessRecord) error {
+	var line []byte
+	if a.format == AccessFormatJSON {
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode access record: %w", err)
+		}
+		line = append(encoded, '\n')
+	} else {
+		line = []byte(combined(record))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.w.Write(line); err != nil {
+		return fmt.Errorf("failed to write access log: %w", err)
+	}
+	return nil
+}
+
+// combined formats a record in the combined log format.
+func combined(record AccessRecord) string {
+	host, _, err := net.SplitHostPort(record.Remote)
+	if err != nil {
+		host = record.Remote
+	}
+	size := "-"
+	if record.Bytes > 0 {
+		size = strconv.FormatInt(record.Bytes, 10)
+	}
+	requestID := record.RequestID
+	if requestID == "" {
+		requestID = "-"
+	}
+
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s %q %q %.3f %s\n",
+		host,
+		record.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		record.Method, record.URI, record.Proto,
+		record.Status, size,
+		dash(record.Referer), dash(record.UserAgent),
+		record.Duration.Seconds(), requestID)
+}
+
+// dash returns "-" for empty values, as in the combined log format.
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,157 @@
+// Package logging configures structured log output and carries
+// request-scoped log fields, such as the request ID and the channel being
+// streamed, through request contexts.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// FormatText writes human-readable log lines.
+	FormatText = "text"
+	// FormatJSON writes one JSON object per log line.
+	FormatJSON = "json"
+)
+
+// ErrInvalidFormat is returned for an unknown log format.
+var ErrInvalidFormat = errors.New("invalid log format")
+
+// Configure sets the output format and level of a logger.
+func Configure(logger *logrus.Logger, format, level string) error {
+	switch format {
+	case FormatText, "":
+		logger.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: "2006-01-02 15:04:05",
+		})
+	case FormatJSON:
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidFormat, format)
+	}
+
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("failed to parse log level: %w", err)
+	}
+	logger.SetLevel(parsed)
+	return nil
+}
+
+// contextKey keys the request log in a context.
+type contextKey struct{}
+
+// requestLog is the log entry of a request. Handlers add fields to it as
+// they learn about the request, e.g. the channel once it is resolved.
+type requestLog struct {
+	id string
+
+	mu    sync.Mutex
+	entry *logrus.Entry
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewContext returns a context carrying a request log with the given ID.
+// Everything logged through the context is tagged with the request ID.
+func NewContext(ctx context.Context, logger *logrus.Logger, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestLog{
+		id:    id,
+		entry: logger.WithField("request_id", id),
+	})
+}
+
+// RequestID returns the request ID of the context, or an empty string.
+func RequestID(ctx context.Context) string {
+	if rl, ok := ctx.Value(contextKey{}).(*requestLog); ok {
+		return rl.id
+	}
+	return ""
+}
+
+// AddFields adds fields to every later log line of the request. It does
+// nothing for contexts without a request log.
+func AddFields(ctx context.Context, fields logrus.Fields) {
+	rl, ok := ctx.Value(contextKey{}).(*requestLog)
+	if !ok {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.entry = rl.entry.WithFields(fields)
+}
+
+// Fields returns the fields of the request log, including the request ID.
+func Fields(ctx context.Context) logrus.Fields {
+	rl, ok := ctx.Value(contextKey{}).(*requestLog)
+	if !ok {
+		return logrus.Fields{}
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	fields := make(logrus.Fields, len(rl.entry.Data))
+	for key, value := range rl.entry.Data {
+		fields[key] = value
+	}
+	return fields
+}
+
+// Entry returns the log entry of the request, or an entry of fallback for
+// contexts without a request log.
+func Entry(ctx context.Context, fallback *logrus.Logger) *logrus.Entry {
+	rl, ok := ctx.Value(contextKey{}).(*requestLog)
+	if !ok {
+		return logrus.NewEntry(fallback)
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.entry
+}
+
+// StdLogger returns a standard library logger for components that log with
+// *log.Logger, such as the streaming pipeline. Its lines are logged at info
+// level through the request log, with the fields present at the time of
+// each line. Contexts without a request log get fallback.
+func StdLogger(ctx context.Context, fallback *log.Logger) *log.Logger {
+	rl, ok := ctx.Value(contextKey{}).(*requestLog)
+	if !ok {
+		return fallback
+	}
+	return log.New(&entryWriter{log: rl}, "", 0)
+}
+
+// entryWriter writes each line of a standard library logger to a request
+// log.
+type entryWriter struct {
+	log *requestLog
+}
+
+// Write logs p as one line.
+func (w *entryWriter) Write(p []byte) (int, error) {
+	w.log.mu.Lock()
+	entry := w.log.entry
+	w.log.mu.Unlock()
+
+	entry.Info(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestStdLoggerFields(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	if err := Configure(logger, FormatJSON, "info"); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+
+	ctx := NewContext(context.Background(), logger, "abc123")
+	std := StdLogger(ctx, nil)
+	AddFields(ctx, logrus.Fields{"channel": "News", "device": "nvidia:0"})
+	std.Printf("FFmpeg: frame=%d", 10)
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a JSON line, got %q: %v", out.String(), err)
+	}
+	expected := map[string]any{
+		"msg":        "FFmpeg: frame=10",
+		"request_id": "abc123",
+		"channel":    "News",
+		"device":     "nvidia:0",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("Expected %s=%v, got %v", key, value, line[key])
+		}
+	}
+
+	if RequestID(ctx) != "abc123" {
+		t.Errorf("Expected request ID abc123, got %q", RequestID(ctx))
+	}
+	fallback := log.New(&out, "", 0)
+	if StdLogger(context.Background(), fallback) != fallback {
+		t.Error("Expected the fallback logger without a request log")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		format string
+		level  string
+		err    error
+	}{
+		{format: FormatText, level: "debug"},
+		{format: FormatJSON, level: "warn"},
+		{format: "xml", level: "info", err: ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		err := Configure(logrus.New(), tt.format, tt.level)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Configure(%q, %q): expected error %v, got %v", tt.format, tt.level, tt.err, err)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	record := AccessRecord{
+		Time:      time.Date(2024, 3, 1, 20, 15, 0, 0, time.UTC),
+		RequestID: "abc123",
+		Remote:    "192.168.1.20:53211",
+		Method:    "GET",
+		URI:       "/stream/aHR0cA",
+		Proto:     "HTTP/1.1",
+		Status:    200,
+		Bytes:     1048576,
+		Duration:  90 * time.Second,
+		UserAgent: "Plex Media Server",
+		Channel:   "News",
+	}
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format:   AccessFormatCombined,
+			expected: `192.168.1.20 - - [01/Mar/2024:20:15:00 +0000] "GET /stream/aHR0cA HTTP/1.1" 200 1048576 "-" "Plex Media Server" 90.000 abc123` + "\n",
+		},
+		{
+			format:   AccessFormatJSON,
+			expected: `"channel":"News"`,
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		access, err := NewAccessLog(&out, tt.format)
+		if err != nil {
+			t.Fatalf("NewAccessLog(%q) failed: %v", tt.format, err)
+		}
+		if err := access.Log(record); err != nil {
+			t.Fatalf("Log failed: %v", err)
+		}
+		if !strings.Contains(out.String(), tt.expected) {
+			t.Errorf("%s: expected %q in %q", tt.format, tt.expected, out.String())
+		}
+	}
+
+	if _, err := NewAccessLog(&bytes.Buffer{}, "common"); !errors.Is(err, ErrInvalidAccessFormat) {
+		t.Errorf("Expected ErrInvalidAccessFormat, got %v", err)
+	}
+}
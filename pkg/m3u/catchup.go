@@ -0,0 +1,202 @@
+package m3u
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Catch-up types advertised with the catchup attribute.
+const (
+	// CatchupDefault builds archive URLs from the catchup-source template.
+	CatchupDefault = "default"
+	// CatchupAppend appends the catchup-source template to the channel URL.
+	CatchupAppend = "append"
+	// CatchupShift adds utc and lutc query parameters to the channel URL.
+	CatchupShift = "shift"
+	// CatchupFlussonic rewrites the channel URL to a Flussonic archive URL.
+	CatchupFlussonic = "flussonic"
+	// CatchupXtream rewrites an Xtream Codes live URL to its timeshift URL.
+	CatchupXtream = "xc"
+)
+
+var (
+	// ErrNoCatchup is returned when a channel has no catch-up archive.
+	ErrNoCatchup = errors.New("channel has no catch-up archive")
+	// ErrUnsupportedCatchup is returned for unknown catch-up types or channel
+	// URLs that do not fit the catch-up type.
+	ErrUnsupportedCatchup = errors.New("unsupported catch-up")
+	// ErrCatchupRange is returned when the requested programme is outside
+	// the archive.
+	ErrCatchupRange = errors.New("programme is outside the catch-up archive")
+)
+
+// catchupPlaceholder matches template placeholders such as {utc},
+// ${start}, {duration:60} and {utc:Y-m-d}.
+var catchupPlaceholder = regexp.MustCompile(`\$?\{([A-Za-z]+)(?::([^}]*))?\}`) //nolint:gochecknoglobals // compiled once
+
+// flussonicPath matches the stream file of a Flussonic URL.
+var flussonicPath = regexp.MustCompile(`^(.*)/(video|index|mono|[\w-]+)\.m3u8$`) //nolint:gochecknoglobals // compiled once
+
+// HasCatchup reports whether the channel advertises a catch-up archive.
+func (c Channel) HasCatchup() bool {
+	return c.Catchup != ""
+}
+
+// ArchiveURL builds the upstream URL of the archive for a programme that
+// started at start and lasts duration, as seen at now.
+func (c Channel) ArchiveURL(start time.Time, duration time.Duration, now time.Time) (string, error) {
+	if !c.HasCatchup() {
+		return "", ErrNoCatchup
+	}
+	if duration <= 0 || !start.Before(now) {
+		return "", fmt.Errorf("%w: start must be in the past", ErrCatchupRange)
+	}
+	if c.CatchupDays > 0 && start.Before(now.AddDate(0, 0, -c.CatchupDays)) {
+		return "", fmt.Errorf("%w: archive keeps %d days", ErrCatchupRange, c.CatchupDays)
+	}
+
+	switch c.Catchup {
+	case CatchupDefault:
+		source := c.CatchupSource
+		if source == "" {
+			source = c.URL
+		}
+		return expandCatchup(source, start, duration, now), nil
+
+	case CatchupAppend:
+		if c.CatchupSource == "" {
+			return "", fmt.Errorf("%w: append needs catchup-source", ErrUnsupportedCatchup)
+		}
+		return expandCatchup(c.URL+c.CatchupSource, start, duration, now), nil
+
+	case CatchupShift:
+		return expandCatchup(appendQuery(c.URL, "utc={utc}&lutc={lutc}"), start, duration, now), nil
+
+	case CatchupFlussonic, "flussonic-hls", "flussonic-ts", "fs":
+		return flussonicURL(c.URL, start, duration)
+
+	case CatchupXtream:
+		return xtreamURL(c.URL, start, duration)
+	}
+
+	return "", fmt.Errorf("%w: type %q", ErrUnsupportedCatchup, c.Catchup)
+}
+
+// expandCatchup replaces the placeholders of a catch-up template.
+func expandCatchup(template string, start time.Time, duration time.Duration, now time.Time) string {
+	start, now = start.UTC(), now.UTC()
+	end := start.Add(duration)
+
+	return catchupPlaceholder.ReplaceAllStringFunc(template, func(match string) string {
+		parts := catchupPlaceholder.FindStringSubmatch(match)
+		name, arg := parts[1], parts[2]
+
+		switch name {
+		case "utc", "start":
+			if arg != "" {
+				return formatCatchupTime(start, arg)
+			}
+			return strconv.FormatInt(start.Unix(), 10)
+		case "utcend", "end":
+			if arg != "" {
+				return formatCatchupTime(end, arg)
+			}
+			return strconv.FormatInt(end.Unix(), 10)
+		case "lutc", "now", "timestamp":
+			if arg != "" {
+				return formatCatchupTime(now, arg)
+			}
+			return strconv.FormatInt(now.Unix(), 10)
+		case "duration":
+			return strconv.FormatInt(int64(duration.Seconds())/catchupDivisor(arg), 10)
+		case "offset":
+			return strconv.FormatInt(int64(now.Sub(start).Seconds())/catchupDivisor(arg), 10)
+		case "Y", "m", "d", "H", "M", "S":
+			return formatCatchupTime(start, name)
+		}
+		return match
+	})
+}
+
+// catchupDivisor parses the unit of {duration:N} and {offset:N}.
+func catchupDivisor(arg string) int64 {
+	if n, err := strconv.ParseInt(arg, 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return 1
+}
+
+// formatCatchupTime formats t with the Y, m, d, H, M and S letters used by
+// catch-up templates.
+func formatCatchupTime(t time.Time, layout string) string {
+	replacer := strings.NewReplacer(
+		"Y", fmt.Sprintf("%04d", t.Year()),
+		"m", fmt.Sprintf("%02d", int(t.Month())),
+		"d", fmt.Sprintf("%02d", t.Day()),
+		"H", fmt.Sprintf("%02d", t.Hour()),
+		"M", fmt.Sprintf("%02d", t.Minute()),
+		"S", fmt.Sprintf("%02d", t.Second()),
+	)
+	return replacer.Replace(layout)
+}
+
+// appendQuery adds query parameters to a URL.
+func appendQuery(rawURL, query string) string {
+	if strings.Contains(rawURL, "?") {
+		return rawURL + "&" + query
+	}
+	return rawURL + "?" + query
+}
+
+// flussonicURL rewrites a Flussonic live URL to its archive URL:
+// .../index.m3u8 becomes .../index-<start>-<duration>.m3u8 and .../mpegts
+// becomes .../timeshift_abs-<start>.ts.
+func flussonicURL(rawURL string, start time.Time, duration time.Duration) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnsupportedCatchup, err)
+	}
+
+	from := strconv.FormatInt(start.Unix(), 10)
+	switch {
+	case strings.HasSuffix(u.Path, "/mpegts"):
+		u.Path = strings.TrimSuffix(u.Path, "mpegts") + "timeshift_abs-" + from + ".ts"
+	case flussonicPath.MatchString(u.Path):
+		u.Path = flussonicPath.ReplaceAllString(u.Path, "$1/$2-"+from+"-"+strconv.FormatInt(int64(duration.Seconds()), 10)+".m3u8")
+	default:
+		return "", fmt.Errorf("%w: not a Flussonic URL: %s", ErrUnsupportedCatchup, rawURL)
+	}
+	return u.String(), nil
+}
+
+// xtreamURL rewrites an Xtream Codes live URL,
+// http://host/[live/]user/pass/id[.ext], to its timeshift URL,
+// http://host/timeshift/user/pass/<minutes>/<Y-m-d:H-M>/id.ts.
+func xtreamURL(rawURL string, start time.Time, duration time.Duration) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnsupportedCatchup, err)
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) == 4 && parts[0] == "live" {
+		parts = parts[1:]
+	}
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: not an Xtream Codes URL: %s", ErrUnsupportedCatchup, rawURL)
+	}
+
+	user, pass := parts[0], parts[1]
+	id := strings.TrimSuffix(parts[2], ".m3u8")
+	id = strings.TrimSuffix(id, ".ts")
+	minutes := int64((duration + time.Minute - 1) / time.Minute)
+
+	u.Path = fmt.Sprintf("/timeshift/%s/%s/%d/%s/%s.ts",
+		user, pass, minutes, formatCatchupTime(start.UTC(), "Y-m-d:H-M"), id)
+	return u.String(), nil
+}
@@ -0,0 +1,152 @@
+package m3u
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveURL(t *testing.T) {
+	start := time.Date(2026, 3, 4, 19, 30, 0, 0, time.UTC)
+	now := start.Add(3 * time.Hour)
+	duration := 90 * time.Minute
+
+	tests := []struct {
+		name    string
+		channel Channel
+		want    string
+		wantErr error
+	}{
+		{
+			name: "default template",
+			channel: Channel{
+				URL:           "http://provider/live/1.ts",
+				Catchup:       CatchupDefault,
+				CatchupSource: "http://provider/archive/1.ts?start={utc}&end={utcend}&d=${duration}&o={offset:60}",
+			},
+			want: "http://provider/archive/1.ts?start=1772652600&end=1772658000&d=5400&o=180",
+		},
+		{
+			name: "default date placeholders",
+			channel: Channel{
+				Catchup:       CatchupDefault,
+				CatchupSource: "http://provider/1/{Y}/{m}/{d}/{H}{M}.ts?to={utcend:YmdHMS}&m={duration:60}",
+			},
+			want: "http://provider/1/2026/03/04/1930.ts?to=20260304210000&m=90",
+		},
+		{
+			name: "append",
+			channel: Channel{
+				URL:           "http://provider/live/1.m3u8",
+				Catchup:       CatchupAppend,
+				CatchupSource: "?utc={utc}&lutc={lutc}",
+			},
+			want: "http://provider/live/1.m3u8?utc=1772652600&lutc=1772663400",
+		},
+		{
+			name:    "shift with query",
+			channel: Channel{URL: "http://provider/live/1.ts?token=a", Catchup: CatchupShift},
+			want:    "http://provider/live/1.ts?token=a&utc=1772652600&lutc=1772663400",
+		},
+		{
+			name:    "flussonic hls",
+			channel: Channel{URL: "http://provider/ch1/index.m3u8?token=a", Catchup: CatchupFlussonic},
+			want:    "http://provider/ch1/index-1772652600-5400.m3u8?token=a",
+		},
+		{
+			name:    "flussonic mpegts",
+			channel: Channel{URL: "http://provider/ch1/mpegts?token=a", Catchup: "fs"},
+			want:    "http://provider/ch1/timeshift_abs-1772652600.ts?token=a",
+		},
+		{
+			name:    "xtream codes",
+			channel: Channel{URL: "http://provider:8080/live/user/pass/123.ts", Catchup: CatchupXtream},
+			want:    "http://provider:8080/timeshift/user/pass/90/2026-03-04:19-30/123.ts",
+		},
+		{
+			name:    "xtream codes bad url",
+			channel: Channel{URL: "http://provider/123.ts", Catchup: CatchupXtream},
+			wantErr: ErrUnsupportedCatchup,
+		},
+		{
+			name:    "no catch-up",
+			channel: Channel{URL: "http://provider/live/1.ts"},
+			wantErr: ErrNoCatchup,
+		},
+		{
+			name:    "unknown type",
+			channel: Channel{URL: "http://provider/live/1.ts", Catchup: "vod"},
+			wantErr: ErrUnsupportedCatchup,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.channel.ArchiveURL(start, duration, now)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ArchiveURL() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ArchiveURL() error = %v", err)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("ArchiveURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArchiveURLRange(t *testing.T) {
+	channel := Channel{URL: "http://provider/live/1.ts", Catchup: CatchupShift, CatchupDays: 2}
+	now := time.Date(2026, 3, 4, 19, 30, 0, 0, time.UTC)
+
+	if _, err := channel.ArchiveURL(now.AddDate(0, 0, -3), time.Hour, now); !errors.Is(err, ErrCatchupRange) {
+		t.Errorf("ArchiveURL() three days ago error = %v, want ErrCatchupRange", err)
+	}
+	if _, err := channel.ArchiveURL(now.Add(time.Hour), time.Hour, now); !errors.Is(err, ErrCatchupRange) {
+		t.Errorf("ArchiveURL() in the future error = %v, want ErrCatchupRange", err)
+	}
+}
+
+func TestParseCatchupAttributes(t *testing.T) {
+	data := []byte(`#EXTM3U
+#EXTINF:-1 tvg-id="one" catchup="Flussonic" catchup-days="7",One
+http://provider/one/index.m3u8
+#EXTINF:-1 tvg-id="two" catchup-type="default" timeshift="3" catchup-source="http://provider/two?s={utc}",Two
+http://provider/two
+#EXTINF:-1 tvg-id="three",Three
+http://provider/three
+`)
+
+	channels, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if channels[0].Catchup != CatchupFlussonic || channels[0].CatchupDays != 7 {
+		t.Errorf("channel one catch-up = %q/%d, want flussonic/7", channels[0].Catchup, channels[0].CatchupDays)
+	}
+	if channels[1].Catchup != CatchupDefault || channels[1].CatchupDays != 3 ||
+		channels[1].CatchupSource != "http://provider/two?s={utc}" {
+		t.Errorf("channel two catch-up = %+v", channels[1])
+	}
+	if channels[2].HasCatchup() {
+		t.Errorf("channel three has catch-up %q", channels[2].Catchup)
+	}
+
+	// The rewritten playlist points catch-up at the proxy
+	rewritten := string(Rewrite(channels, "http://proxy:8080/"))
+	want := `#EXTINF:-1 tvg-id="two" timeshift="3" catchup-source="http://proxy:8080/catchup/http%3A%2F%2Fprovider%2Ftwo?start={utc}&duration={duration}" catchup="default",Two`
+	if !strings.Contains(rewritten, want) {
+		t.Errorf("Rewrite() = %s, want line %s", rewritten, want)
+	}
+	if !strings.Contains(rewritten, `#EXTINF:-1 tvg-id="one" catchup="default" catchup-days="7" catchup-source="http://proxy:8080/catchup/`) {
+		t.Errorf("Rewrite() did not add catchup-source to channel one: %s", rewritten)
+	}
+	if !strings.Contains(rewritten, `#EXTINF:-1 tvg-id="three",Three`) {
+		t.Errorf("Rewrite() changed a channel without catch-up: %s", rewritten)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -25,6 +26,16 @@ type Channel struct {
 	TVGLogo  string
 	Group    string
 	Original string
+	// Catchup is the catch-up type, e.g. "default", "append", "shift",
+	// "flussonic" or "xc"; empty when the channel has no archive.
+	Catchup string
+	// CatchupDays is how many days the archive keeps, 0 when unknown.
+	CatchupDays int
+	// CatchupSource is the archive URL template of the channel.
+	CatchupSource string
+	// DRM reports whether the entry carries a DRM license, e.g. in a
+	// #KODIPROP inputstream.adaptive.license_key line.
+	DRM bool
 }
 
 // Parse extracts channel information from M3U playlist data.
@@ -34,6 +45,8 @@ func Parse(data []byte) ([]Channel, error) {
 	scanner := bufio.NewScanner(reader)
 
 	var currentChannel *Channel
+	// Players accept #KODIPROP lines before or after #EXTINF
+	pendingDRM := false
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -54,16 +67,25 @@ func Parse(data []byte) ([]Channel, error) {
 
 			currentChannel = &Channel{
 				Original: line,
+				DRM:      pendingDRM,
 			}
+			pendingDRM = false
 
 			currentChannel.TVGName = extractAttribute(line, "tvg-name")
 			currentChannel.TVGLogo = extractAttribute(line, "tvg-logo")
 			currentChannel.Group = extractAttribute(line, "group-title")
+			parseCatchup(currentChannel, line)
 
 			parts := strings.SplitN(line, ",", 2)
 			if len(parts) == 2 {
 				currentChannel.Name = strings.TrimSpace(parts[1])
 			}
+		} else if isDRMProperty(line) {
+			if currentChannel != nil {
+				currentChannel.DRM = true
+			} else {
+				pendingDRM = true
+			}
 		} else if !strings.HasPrefix(line, "#") && currentChannel != nil {
 			currentChannel.URL = line
 			channels = append(channels, *currentChannel)
@@ -82,6 +104,32 @@ func Parse(data []byte) ([]Channel, error) {
 	return channels, nil
 }
 
+// isDRMProperty reports whether a line sets a DRM license for the entry.
+func isDRMProperty(line string) bool {
+	if !strings.HasPrefix(line, "#KODIPROP:") {
+		return false
+	}
+	property := strings.ToLower(line)
+	return strings.Contains(property, "license_key") || strings.Contains(property, "license_type")
+}
+
+// parseCatchup reads the catch-up attributes of an #EXTINF line.
+func parseCatchup(channel *Channel, line string) {
+	channel.Catchup = strings.ToLower(extractAttribute(line, "catchup"))
+	if channel.Catchup == "" {
+		channel.Catchup = strings.ToLower(extractAttribute(line, "catchup-type"))
+	}
+	channel.CatchupSource = extractAttribute(line, "catchup-source")
+
+	days := extractAttribute(line, "catchup-days")
+	if days == "" {
+		days = extractAttribute(line, "timeshift")
+	}
+	if n, err := strconv.Atoi(days); err == nil && n > 0 {
+		channel.CatchupDays = n
+	}
+}
+
 func extractAttribute(line, attr string) string {
 	pattern := fmt.Sprintf(`%s="([^"]*)"`, regexp.QuoteMeta(attr))
 	re := regexp.MustCompile(pattern)
@@ -115,3 +115,28 @@ http://test.com/stream`,
 		})
 	}
 }
+
+func TestParseDRM(t *testing.T) {
+	input := `#EXTM3U
+#KODIPROP:inputstream.adaptive.license_type=clearkey
+#EXTINF:-1,Before
+http://test.com/before
+#EXTINF:-1,After
+#KODIPROP:inputstream.adaptive.license_key=https://license.example/key
+http://test.com/after
+#EXTINF:-1,Clear
+#KODIPROP:inputstream.adaptive.manifest_type=hls
+http://test.com/clear`
+
+	channels, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := []bool{true, true, false}
+	for i, channel := range channels {
+		if channel.DRM != want[i] {
+			t.Errorf("%s DRM = %v, want %v", channel.Name, channel.DRM, want[i])
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/savid/iptv-proxy/pkg/testchannels"
@@ -19,7 +20,11 @@ func Rewrite(channels []Channel, baseURL string) []byte {
 	baseURL = strings.TrimRight(baseURL, "/")
 
 	for _, channel := range channels {
-		buf.WriteString(channel.Original)
+		extinf := channel.Original
+		if channel.HasCatchup() {
+			extinf = rewriteCatchup(extinf, channel.URL, baseURL)
+		}
+		buf.WriteString(extinf)
 		buf.WriteString("\n")
 
 		rewrittenURL := rewriteURL(channel.URL, baseURL)
@@ -44,6 +49,42 @@ func rewriteURL(originalURL, baseURL string) string {
 	return fmt.Sprintf("%s/stream/%s", baseURL, encodedURL)
 }
 
+// rewriteCatchup points the catch-up attributes of an #EXTINF line at the
+// proxy's /catchup endpoint, which builds the provider's archive URL.
+func rewriteCatchup(extinf, originalURL, baseURL string) string {
+	source := fmt.Sprintf("%s/catchup/%s?start={utc}&duration={duration}", baseURL, utils.EncodeURL(originalURL))
+	extinf = setAttribute(extinf, "catchup", CatchupDefault)
+	extinf = setAttribute(extinf, "catchup-type", "")
+	return setAttribute(extinf, "catchup-source", source)
+}
+
+// setAttribute replaces an attribute of an #EXTINF line, adds it before the
+// channel name when missing, or removes it when value is empty.
+func setAttribute(extinf, attr, value string) string {
+	re := regexp.MustCompile(`\s` + regexp.QuoteMeta(attr) + `="[^"]*"`)
+	if loc := re.FindStringIndex(extinf); loc != nil {
+		if value == "" {
+			return extinf[:loc[0]] + extinf[loc[1]:]
+		}
+		return extinf[:loc[0]] + " " + attr + `="` + value + `"` + extinf[loc[1]:]
+	}
+	if value == "" {
+		return extinf
+	}
+
+	// The channel name follows the first comma outside quotes
+	quoted := false
+	for i, r := range extinf {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			return extinf[:i] + " " + attr + `="` + value + `"` + extinf[i:]
+		}
+	}
+	return extinf
+}
+
 // AppendTestChannels adds test channels to the M3U content.
 func AppendTestChannels(m3uContent string, baseURL string) string {
 	var buf bytes.Buffer
@@ -0,0 +1,163 @@
+package mpegts
+
+import "sort"
+
+// Elementary stream types from the PMT that are inspected for keyframes.
+const (
+	streamTypeMPEG2Video = 0x02
+	streamTypeH264       = 0x1B
+	streamTypeHEVC       = 0x24
+
+	flagRandomAccess = 0x40
+)
+
+// RandomAccess reports whether the random access indicator is set, which
+// encoders use to mark packets starting a keyframe.
+func (p Packet) RandomAccess() bool {
+	return p.adaptationLength() > 0 && p[5]&flagRandomAccess != 0
+}
+
+// streamTypes returns the elementary stream types listed in a PMT packet.
+func (p Packet) streamTypes() (map[uint16]uint8, bool) {
+	section, ok := p.section()
+	if !ok || section[0] != tableIDPMT || len(section) < 16 {
+		return nil, false
+	}
+
+	// Skip the 12 byte header and the program descriptors, stop before the CRC
+	programInfo := int(section[10]&0x0F)<<8 | int(section[11])
+	streams := make(map[uint16]uint8)
+	for i := 12 + programInfo; i+5 <= len(section)-4; {
+		pid := uint16(section[i+1]&0x1F)<<8 | uint16(section[i+2])
+		streams[pid] = section[i]
+		i += 5 + (int(section[i+3]&0x0F)<<8 | int(section[i+4]))
+	}
+	return streams, true
+}
+
+// KeyframeDetector finds the packets where a decoder can start: those with
+// the random access indicator or, for encoders that do not set it, those
+// starting a PES packet with an H.264 IDR, HEVC IRAP or MPEG-2 sequence
+// header. It also keeps the latest tables so a joining client can be sent
+// them ahead of the keyframe.
+type KeyframeDetector struct {
+	pat     Packet
+	pmtPIDs map[uint16]bool
+	pmts    map[uint16]Packet
+	video   map[uint16]uint8
+}
+
+// NewKeyframeDetector creates a detector with no known tables.
+func NewKeyframeDetector() *KeyframeDetector {
+	return &KeyframeDetector{
+		pmtPIDs: make(map[uint16]bool),
+		pmts:    make(map[uint16]Packet),
+		video:   make(map[uint16]uint8),
+	}
+}
+
+// Inspect examines the next packet of the stream and reports whether it
+// starts a keyframe.
+func (d *KeyframeDetector) Inspect(packet Packet) bool {
+	pid := packet.PID()
+
+	switch {
+	case pid == PIDPAT:
+		if pids, ok := packet.programMapPIDs(); ok {
+			d.pat = append(Packet(nil), packet...)
+			d.pmtPIDs = make(map[uint16]bool, len(pids))
+			for _, pmtPID := range pids {
+				d.pmtPIDs[pmtPID] = true
+			}
+		}
+		return false
+	case d.pmtPIDs[pid]:
+		if streams, ok := packet.streamTypes(); ok {
+			d.pmts[pid] = append(Packet(nil), packet...)
+			d.video = make(map[uint16]uint8)
+			for esPID, streamType := range streams {
+				switch streamType {
+				case streamTypeMPEG2Video, streamTypeH264, streamTypeHEVC:
+					d.video[esPID] = streamType
+				}
+			}
+		}
+		return false
+	case pid == PIDNull:
+		return false
+	}
+
+	streamType, isVideo := d.video[pid]
+	if len(d.video) > 0 && !isVideo {
+		return false
+	}
+	if packet.RandomAccess() {
+		return true
+	}
+	if !isVideo || !packet.PayloadUnitStart() {
+		return false
+	}
+	return startsKeyframe(streamType, pesData(packet.Payload()))
+}
+
+// Tables returns the latest PAT and PMTs, and whether all of them are known.
+func (d *KeyframeDetector) Tables() (Tables, bool) {
+	if d.pat == nil || len(d.pmtPIDs) == 0 {
+		return Tables{}, false
+	}
+	pids := make([]uint16, 0, len(d.pmtPIDs))
+	for pid := range d.pmtPIDs {
+		if _, ok := d.pmts[pid]; !ok {
+			return Tables{}, false
+		}
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+
+	tables := Tables{PAT: d.pat}
+	for _, pid := range pids {
+		tables.PMTs = append(tables.PMTs, d.pmts[pid])
+	}
+	return tables, true
+}
+
+// pesData returns the elementary stream data after the PES header.
+func pesData(payload []byte) []byte {
+	if len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return nil
+	}
+	start := 9 + int(payload[8])
+	if start >= len(payload) {
+		return nil
+	}
+	return payload[start:]
+}
+
+// startsKeyframe scans the start codes in data for one that begins a
+// keyframe of the given stream type.
+func startsKeyframe(streamType uint8, data []byte) bool {
+	for i := 0; i+3 < len(data); i++ {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			continue
+		}
+		code := data[i+3]
+		switch streamType {
+		case streamTypeH264:
+			// IDR slice, or the SPS that precedes it
+			if nal := code & 0x1F; nal == 5 || nal == 7 {
+				return true
+			}
+		case streamTypeHEVC:
+			// IRAP slices (BLA, IDR, CRA), or the VPS and SPS that precede them
+			if nal := code >> 1 & 0x3F; (nal >= 16 && nal <= 21) || nal == 32 || nal == 33 {
+				return true
+			}
+		case streamTypeMPEG2Video:
+			if code == 0xB3 { // sequence header
+				return true
+			}
+		}
+		i += 2
+	}
+	return false
+}
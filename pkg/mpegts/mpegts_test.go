@@ -0,0 +1,332 @@
+package mpegts
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/savid/iptv-proxy/pkg/process/processtest"
+)
+
+const (
+	testPMTPID   uint16 = 0x1000
+	testVideoPID uint16 = 0x100
+)
+
+// testPacket builds a payload-only packet.
+func testPacket(pid uint16, cc uint8, pusi bool, payload ...byte) []byte {
+	p := make([]byte, PacketSize)
+	p[0] = SyncByte
+	p[1] = byte(pid>>8) & 0x1F
+	if pusi {
+		p[1] |= flagPayloadUnitStart
+	}
+	p[2] = byte(pid)
+	p[3] = flagPayload | cc&0x0F
+	copy(p[4:], payload)
+	for i := 4 + len(payload); i < PacketSize; i++ {
+		p[i] = 0xFF
+	}
+	return p
+}
+
+// testAdaptationPacket builds a packet with a small adaptation field and payload.
+func testAdaptationPacket(pid uint16, cc uint8) []byte {
+	p := testPacket(pid, cc, true)
+	p[3] |= flagAdaptation
+	p[4] = 1    // adaptation field length
+	p[5] = 0x10 // PCR flag, no PCR bytes needed for the test
+	return p
+}
+
+// testPAT builds a PAT with one program pointing at testPMTPID.
+func testPAT(cc uint8) []byte {
+	return testPacket(PIDPAT, cc, true,
+		0x00,                   // pointer field
+		tableIDPAT, 0xB0, 0x0D, // section length 13
+		0x00, 0x01, 0xC1, 0x00, 0x00, // ts id, version, section numbers
+		0x00, 0x01, 0xE0|byte(testPMTPID>>8), byte(testPMTPID&0xFF), // program 1
+		0x00, 0x00, 0x00, 0x00, // CRC
+	)
+}
+
+// testPMT builds a PMT with one H.264 stream on testVideoPID.
+func testPMT(cc uint8) []byte {
+	return testPacket(testPMTPID, cc, true,
+		0x00,
+		tableIDPMT, 0xB0, 0x12, // section length 18
+		0x00, 0x01, 0xC1, 0x00, 0x00,
+		0xE0|byte(testVideoPID>>8), byte(testVideoPID&0xFF), // PCR PID
+		0xF0, 0x00, // program info length
+		0x1B, 0xE0|byte(testVideoPID>>8), byte(testVideoPID&0xFF), 0xF0, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	)
+}
+
+// videoPackets builds count video packets starting at continuity counter cc.
+func videoPackets(count int, cc uint8) []byte {
+	var out []byte
+	for i := range count {
+		out = append(out, testPacket(testVideoPID, cc+uint8(i), i == 0, byte(i))...)
+	}
+	return out
+}
+
+// readPackets splits output into packets.
+func readPackets(t *testing.T, data []byte) []Packet {
+	t.Helper()
+	if len(data)%PacketSize != 0 {
+		t.Fatalf("output length %d is not a multiple of %d", len(data), PacketSize)
+	}
+	var packets []Packet
+	for i := 0; i < len(data); i += PacketSize {
+		packets = append(packets, Packet(data[i:i+PacketSize]))
+	}
+	return packets
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"aligned", processtest.MPEGTS(4), true},
+		{"offset by garbage", append([]byte{1, 2, 3}, processtest.MPEGTS(4)...), true},
+		{"too short", processtest.MPEGTS(2), false},
+		{"playlist", []byte("#EXTM3U\n#EXT-X-VERSION:3\n" + string(make([]byte, DetectSize))), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.data); got != tt.want {
+				t.Errorf("Detect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderResync(t *testing.T) {
+	clean := processtest.MPEGTS(10)
+
+	var input []byte
+	input = append(input, 0x00, 0x47, 0x12)              // garbage with a false sync byte
+	input = append(input, clean[:3*PacketSize]...)       // packets 0-2
+	input = append(input, clean[3*PacketSize:][:100]...) // truncated packet 3
+	input = append(input, clean[4*PacketSize:]...)       // packets 4-9
+	input = append(input, clean[:50]...)                 // trailing partial packet
+
+	reader := NewReader(bytes.NewReader(input))
+
+	var got []byte
+	for {
+		packet, err := reader.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadPacket() error = %v", err)
+		}
+		got = append(got, packet...)
+	}
+
+	want := append(append([]byte(nil), clean[:3*PacketSize]...), clean[4*PacketSize:]...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("read %d bytes, want the %d bytes of the 9 intact packets", len(got), len(want))
+	}
+	if reader.Resyncs() != 2 {
+		t.Errorf("Resyncs() = %d, want 2", reader.Resyncs())
+	}
+	if reader.DroppedBytes() != 3+100+50 {
+		t.Errorf("DroppedBytes() = %d, want %d", reader.DroppedBytes(), 3+100+50)
+	}
+}
+
+func TestReaderOneByteReads(t *testing.T) {
+	clean := processtest.MPEGTS(5)
+	input := append([]byte{0xAA, 0xBB}, clean...)
+
+	reader := NewReader(iotestOneByte{bytes.NewReader(input)})
+
+	count := 0
+	for {
+		_, err := reader.ReadPacket()
+		if err != nil {
+			break
+		}
+		count++
+	}
+	if count != 5 {
+		t.Errorf("read %d packets, want 5", count)
+	}
+}
+
+// iotestOneByte returns one byte per read.
+type iotestOneByte struct {
+	r io.Reader
+}
+
+func (o iotestOneByte) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+func TestRepairerContinuityErrors(t *testing.T) {
+	var input []byte
+	input = append(input, testPacket(testVideoPID, 0, true)...)
+	input = append(input, testPacket(testVideoPID, 1, false)...)
+	input = append(input, testPacket(testVideoPID, 1, false)...) // duplicate is allowed
+	input = append(input, testPacket(testVideoPID, 5, false)...) // jump
+	input = append(input, testPacket(testVideoPID, 6, false)...)
+	input = append(input, testPacket(PIDNull, 9, false)...) // null packets are ignored
+
+	repairer := NewRepairer(bytes.NewReader(input), nil, "")
+	out, err := io.ReadAll(repairer)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+
+	if !bytes.Equal(out, input) {
+		t.Errorf("output differs from a clean input")
+	}
+	stats := repairer.Stats()
+	if stats.ContinuityErrors != 1 {
+		t.Errorf("ContinuityErrors = %d, want 1", stats.ContinuityErrors)
+	}
+	if stats.Packets != 6 {
+		t.Errorf("Packets = %d, want 6", stats.Packets)
+	}
+}
+
+func TestRepairerInjectsCachedTables(t *testing.T) {
+	cache := NewTableCache()
+
+	// The first client learns the tables from the upstream
+	first := append(append(testPAT(0), testPMT(0)...), videoPackets(3, 0)...)
+	if _, err := io.ReadAll(NewRepairer(bytes.NewReader(first), cache, "ch1")); err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if _, ok := cache.Get("ch1"); !ok {
+		t.Fatalf("tables were not cached")
+	}
+
+	// The next client joins between tables and gets them injected
+	repairer := NewRepairer(bytes.NewReader(videoPackets(3, 7)), cache, "ch1")
+	out, err := io.ReadAll(repairer)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+
+	packets := readPackets(t, out)
+	if len(packets) != 5 {
+		t.Fatalf("got %d packets, want PAT, PMT and 3 video packets", len(packets))
+	}
+	if packets[0].PID() != PIDPAT || packets[1].PID() != testPMTPID {
+		t.Errorf("first packets have PIDs %#x, %#x, want PAT and PMT", packets[0].PID(), packets[1].PID())
+	}
+	if repairer.Stats().InjectedTables != 1 {
+		t.Errorf("InjectedTables = %d, want 1", repairer.Stats().InjectedTables)
+	}
+
+	// Streams without cached tables are left alone
+	other := NewRepairer(bytes.NewReader(videoPackets(3, 0)), cache, "ch2")
+	out, _ = io.ReadAll(other)
+	if len(readPackets(t, out)) != 3 {
+		t.Errorf("uncached stream got tables injected")
+	}
+}
+
+func TestRepairerSplice(t *testing.T) {
+	cache := NewTableCache()
+
+	first := append(append(testPAT(3), testPMT(3)...), videoPackets(4, 0)...)
+	repairer := NewRepairer(bytes.NewReader(first), cache, "ch1")
+
+	before, err := io.ReadAll(repairer)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+
+	// The new upstream starts mid-stream: no tables and unrelated counters
+	second := append(videoPackets(2, 9), testAdaptationPacket(0x101, 4)...)
+	repairer.Splice(bytes.NewReader(second))
+
+	after, err := io.ReadAll(repairer)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+
+	packets := readPackets(t, after)
+	// PAT, PMT, discontinuity packet, 2 video packets, audio packet
+	if len(packets) != 6 {
+		t.Fatalf("got %d packets after the splice, want 6", len(packets))
+	}
+
+	beforePackets := readPackets(t, before)
+	if packets[0].PID() != PIDPAT || packets[1].PID() != testPMTPID {
+		t.Errorf("tables were not injected after the splice")
+	}
+	// Table PIDs stay continuous across the splice
+	lastPAT := beforePackets[0].ContinuityCounter()
+	if packets[0].ContinuityCounter() != (lastPAT+1)&0x0F {
+		t.Errorf("PAT continuity counter = %d, want %d", packets[0].ContinuityCounter(), (lastPAT+1)&0x0F)
+	}
+
+	// The video packet has no adaptation field, so a discontinuity packet precedes it
+	if packets[2].PID() != testVideoPID || !packets[2].Discontinuity() || packets[2].HasPayload() {
+		t.Errorf("missing adaptation-only discontinuity packet before video")
+	}
+	if packets[3].Discontinuity() || packets[4].Discontinuity() {
+		t.Errorf("discontinuity set on more than the first video packet")
+	}
+	// The audio packet has an adaptation field that carries the indicator
+	if packets[5].PID() != 0x101 || !packets[5].Discontinuity() {
+		t.Errorf("discontinuity indicator not set on audio packet")
+	}
+
+	stats := repairer.Stats()
+	if stats.ContinuityErrors != 0 {
+		t.Errorf("ContinuityErrors = %d, want 0 across a splice", stats.ContinuityErrors)
+	}
+	if stats.Splices != 1 {
+		t.Errorf("Splices = %d, want 1", stats.Splices)
+	}
+}
+
+func TestKeyframeDetector(t *testing.T) {
+	pes := func(nal ...byte) []byte {
+		return append([]byte{0x00, 0x00, 0x01, 0xE0, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x01}, nal...)
+	}
+	randomAccess := testAdaptationPacket(testVideoPID, 0)
+	randomAccess[5] |= flagRandomAccess
+
+	tests := []struct {
+		name   string
+		packet []byte
+		want   bool
+	}{
+		{"IDR slice", testPacket(testVideoPID, 0, true, pes(0x65)...), true},
+		{"SPS", testPacket(testVideoPID, 0, true, pes(0x67)...), true},
+		{"non-IDR slice", testPacket(testVideoPID, 0, true, pes(0x41)...), false},
+		{"continuation packet", testPacket(testVideoPID, 0, false, 0x00, 0x00, 0x01, 0x65), false},
+		{"random access indicator", randomAccess, true},
+		{"other PID", testPacket(0x101, 0, true, pes(0x65)...), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector := NewKeyframeDetector()
+			detector.Inspect(testPAT(0))
+			detector.Inspect(testPMT(0))
+			if _, ok := detector.Tables(); !ok {
+				t.Fatalf("Tables() not known after PAT and PMT")
+			}
+			if got := detector.Inspect(tt.packet); got != tt.want {
+				t.Errorf("Inspect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
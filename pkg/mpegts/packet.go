@@ -0,0 +1,158 @@
+// Package mpegts inspects and repairs MPEG transport streams without
+// invoking ffmpeg.
+package mpegts
+
+// Transport stream constants.
+const (
+	// PacketSize is the size of a transport stream packet.
+	PacketSize = 188
+	// SyncByte starts every transport stream packet.
+	SyncByte = 0x47
+	// PIDPAT carries the program association table.
+	PIDPAT uint16 = 0x0000
+	// PIDNull carries stuffing packets.
+	PIDNull uint16 = 0x1FFF
+
+	tableIDPAT = 0x00
+	tableIDPMT = 0x02
+
+	flagPayloadUnitStart = 0x40
+	flagAdaptation       = 0x20
+	flagPayload          = 0x10
+	flagDiscontinuity    = 0x80
+)
+
+// Packet is a single 188 byte transport stream packet.
+type Packet []byte
+
+// PID returns the packet identifier.
+func (p Packet) PID() uint16 {
+	return uint16(p[1]&0x1F)<<8 | uint16(p[2])
+}
+
+// PayloadUnitStart reports whether a PES packet or table section starts in this packet.
+func (p Packet) PayloadUnitStart() bool {
+	return p[1]&flagPayloadUnitStart != 0
+}
+
+// HasAdaptation reports whether the packet carries an adaptation field.
+func (p Packet) HasAdaptation() bool {
+	return p[3]&flagAdaptation != 0
+}
+
+// HasPayload reports whether the packet carries payload.
+func (p Packet) HasPayload() bool {
+	return p[3]&flagPayload != 0
+}
+
+// ContinuityCounter returns the 4-bit continuity counter.
+func (p Packet) ContinuityCounter() uint8 {
+	return p[3] & 0x0F
+}
+
+// SetContinuityCounter replaces the continuity counter.
+func (p Packet) SetContinuityCounter(cc uint8) {
+	p[3] = p[3]&0xF0 | cc&0x0F
+}
+
+// adaptationLength returns the adaptation field length, or 0 without one.
+func (p Packet) adaptationLength() int {
+	if !p.HasAdaptation() {
+		return 0
+	}
+	return int(p[4])
+}
+
+// Discontinuity reports whether the discontinuity indicator is set.
+func (p Packet) Discontinuity() bool {
+	return p.adaptationLength() > 0 && p[5]&flagDiscontinuity != 0
+}
+
+// SetDiscontinuity sets the discontinuity indicator. It returns false when
+// the packet has no adaptation field flags byte to carry it.
+func (p Packet) SetDiscontinuity() bool {
+	if p.adaptationLength() == 0 {
+		return false
+	}
+	p[5] |= flagDiscontinuity
+	return true
+}
+
+// Payload returns the packet payload after any adaptation field.
+func (p Packet) Payload() []byte {
+	if !p.HasPayload() {
+		return nil
+	}
+	start := 4
+	if p.HasAdaptation() {
+		start += 1 + p.adaptationLength()
+	}
+	if start >= PacketSize {
+		return nil
+	}
+	return p[start:]
+}
+
+// NewDiscontinuityPacket returns an adaptation-only packet that signals a
+// discontinuity on pid. Adaptation-only packets do not advance the
+// continuity counter, so cc should be the last counter sent on the PID.
+func NewDiscontinuityPacket(pid uint16, cc uint8) Packet {
+	p := make(Packet, PacketSize)
+	p[0] = SyncByte
+	p[1] = byte(pid>>8) & 0x1F
+	p[2] = byte(pid)
+	p[3] = flagAdaptation | cc&0x0F
+	p[4] = PacketSize - 5
+	p[5] = flagDiscontinuity
+	for i := 6; i < PacketSize; i++ {
+		p[i] = 0xFF
+	}
+	return p
+}
+
+// section returns the table section starting in the payload when it fits
+// in this packet, and whether it does.
+func (p Packet) section() ([]byte, bool) {
+	if !p.PayloadUnitStart() {
+		return nil, false
+	}
+	payload := p.Payload()
+	if len(payload) < 1 {
+		return nil, false
+	}
+	start := 1 + int(payload[0])
+	if start+3 > len(payload) {
+		return nil, false
+	}
+	section := payload[start:]
+	length := int(section[1]&0x0F)<<8 | int(section[2])
+	if 3+length > len(section) {
+		return nil, false
+	}
+	return section[:3+length], true
+}
+
+// programMapPIDs returns the PMT PIDs listed in a PAT packet.
+func (p Packet) programMapPIDs() ([]uint16, bool) {
+	section, ok := p.section()
+	if !ok || section[0] != tableIDPAT || len(section) < 12 {
+		return nil, false
+	}
+
+	// Program loop between the 8 byte header and the 4 byte CRC
+	var pids []uint16
+	for i := 8; i+4 <= len(section)-4; i += 4 {
+		program := uint16(section[i])<<8 | uint16(section[i+1])
+		if program == 0 {
+			continue // network information PID
+		}
+		pids = append(pids, uint16(section[i+2]&0x1F)<<8|uint16(section[i+3]))
+	}
+	return pids, true
+}
+
+// isPMT reports whether the packet starts a complete PMT section.
+func (p Packet) isPMT() bool {
+	section, ok := p.section()
+	return ok && section[0] == tableIDPMT
+}
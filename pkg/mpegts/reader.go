@@ -0,0 +1,166 @@
+package mpegts
+
+import (
+	"io"
+)
+
+// readerBufferSize holds enough packets to find sync and batch output.
+const readerBufferSize = 64 * PacketSize
+
+// syncConfirmations is how many following sync bytes confirm a resync point.
+const syncConfirmations = 2
+
+// Reader splits a byte stream into aligned transport stream packets. It
+// resynchronizes on the sync byte after garbage or partial packets.
+type Reader struct {
+	src     io.Reader
+	buf     []byte
+	start   int
+	end     int
+	err     error
+	resyncs int64
+	dropped int64
+}
+
+// NewReader creates a packet reader over src.
+func NewReader(src io.Reader) *Reader {
+	return &Reader{
+		src: src,
+		buf: make([]byte, readerBufferSize),
+	}
+}
+
+// Reset switches to a new source. Any partial packet from the previous
+// source is dropped.
+func (r *Reader) Reset(src io.Reader) {
+	r.drop(r.end - r.start)
+	r.src = src
+	r.start = 0
+	r.end = 0
+	r.err = nil
+}
+
+// Resyncs returns how many times the reader lost and regained packet sync.
+func (r *Reader) Resyncs() int64 {
+	return r.resyncs
+}
+
+// DroppedBytes returns how many bytes were discarded while resynchronizing.
+func (r *Reader) DroppedBytes() int64 {
+	return r.dropped
+}
+
+// Buffered reports whether a complete packet can be returned without reading
+// from the source.
+func (r *Reader) Buffered() bool {
+	return r.end-r.start > PacketSize
+}
+
+// ReadPacket returns the next packet. The packet is only valid until the
+// next call. At the end of the source any trailing partial packet is dropped
+// and the source error is returned.
+func (r *Reader) ReadPacket() (Packet, error) {
+	resyncing := false
+	for {
+		available := r.end - r.start
+		switch {
+		case available > PacketSize || (r.err != nil && available == PacketSize):
+			// A packet is accepted when it starts with the sync byte and the
+			// next packet does too, which rejects truncated packets
+			if r.buf[r.start] == SyncByte && (available == PacketSize || r.buf[r.start+PacketSize] == SyncByte) {
+				packet := Packet(r.buf[r.start : r.start+PacketSize])
+				r.start += PacketSize
+				return packet, nil
+			}
+
+			if !resyncing {
+				resyncing = true
+				r.resyncs++
+			}
+			if r.resync() {
+				continue
+			}
+		case r.err != nil:
+			r.drop(available)
+			return nil, r.err
+		}
+
+		if r.err == nil {
+			r.fill()
+		}
+	}
+}
+
+// syncState is the result of checking a candidate sync point.
+type syncState int
+
+const (
+	syncRejected syncState = iota
+	syncConfirmed
+	syncNeedMore
+)
+
+// resync drops bytes up to the next sync byte confirmed by the following
+// packets. It reports whether the reader is positioned at a sync point.
+func (r *Reader) resync() bool {
+	for i := r.start + 1; i < r.end; i++ {
+		if r.buf[i] != SyncByte {
+			continue
+		}
+		switch r.confirm(i) {
+		case syncRejected:
+			continue
+		case syncConfirmed:
+			r.drop(i - r.start)
+			return true
+		case syncNeedMore:
+			r.drop(i - r.start)
+			return false
+		}
+	}
+	r.drop(r.end - r.start)
+	return false
+}
+
+// confirm checks that the packets following a candidate also start with
+// the sync byte.
+func (r *Reader) confirm(i int) syncState {
+	for n := 1; n <= syncConfirmations; n++ {
+		next := i + n*PacketSize
+		if next >= r.end {
+			if r.err == nil {
+				return syncNeedMore
+			}
+			// At the end of the source a complete final packet is enough
+			if i+PacketSize <= r.end {
+				return syncConfirmed
+			}
+			return syncRejected
+		}
+		if r.buf[next] != SyncByte {
+			return syncRejected
+		}
+	}
+	return syncConfirmed
+}
+
+// drop discards n buffered bytes.
+func (r *Reader) drop(n int) {
+	r.start += n
+	r.dropped += int64(n)
+}
+
+// fill reads more data from the source, compacting the buffer first.
+func (r *Reader) fill() {
+	if r.start > 0 {
+		copy(r.buf, r.buf[r.start:r.end])
+		r.end -= r.start
+		r.start = 0
+	}
+
+	n, err := r.src.Read(r.buf[r.end:])
+	r.end += n
+	if err != nil {
+		r.err = err
+	}
+}
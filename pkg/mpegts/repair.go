@@ -0,0 +1,319 @@
+package mpegts
+
+import (
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/savid/iptv-proxy/pkg/types"
+)
+
+// detectPackets is how many consecutive packets identify a transport stream.
+const detectPackets = 3
+
+// DetectSize is how many bytes Detect needs to recognize a transport stream.
+const DetectSize = (detectPackets + 1) * PacketSize
+
+// Detect reports whether data looks like an MPEG transport stream, that is
+// whether consecutive sync bytes are found within the first packet.
+func Detect(data []byte) bool {
+	for offset := 0; offset < PacketSize; offset++ {
+		found := true
+		for n := range detectPackets {
+			i := offset + n*PacketSize
+			if i >= len(data) || data[i] != SyncByte {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// Tables are the PAT and PMT packets needed to start decoding a stream.
+type Tables struct {
+	PAT  Packet
+	PMTs []Packet
+}
+
+// TableCache keeps the last complete tables per stream so that a new client
+// connection can be started with them.
+type TableCache struct {
+	mu     sync.RWMutex
+	tables map[string]Tables
+}
+
+// NewTableCache creates an empty table cache.
+func NewTableCache() *TableCache {
+	return &TableCache{
+		tables: make(map[string]Tables),
+	}
+}
+
+// Get returns the cached tables for a stream.
+func (c *TableCache) Get(key string) (Tables, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	tables, ok := c.tables[key]
+	return tables, ok
+}
+
+// Set stores the tables for a stream.
+func (c *TableCache) Set(key string, tables Tables) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tables[key] = tables
+}
+
+// Repairer reads a transport stream and writes out aligned packets. It
+// injects cached PAT/PMT at the start and after every splice, marks splices
+// with the discontinuity indicator and counts continuity counter errors.
+type Repairer struct {
+	reader *Reader
+	cache  *TableCache
+	key    string
+
+	pending []byte
+	err     error
+
+	// Input side continuity per PID, -1 when unknown
+	lastCC map[uint16]int
+	// Output continuity of the PAT and PMT PIDs, which the repairer owns
+	tableCC map[uint16]uint8
+	// Last continuity counter written per PID
+	outCC map[uint16]uint8
+
+	pat     Packet
+	pmtPIDs map[uint16]bool
+	pmts    map[uint16]Packet
+
+	injectTables bool
+	spliced      bool
+	marked       map[uint16]bool
+
+	mu    sync.Mutex
+	stats types.TSStats
+}
+
+// NewRepairer creates a repairer over src. Tables are shared through cache
+// under key, normally the upstream URL; a nil cache disables injection.
+func NewRepairer(src io.Reader, cache *TableCache, key string) *Repairer {
+	return &Repairer{
+		reader:       NewReader(src),
+		cache:        cache,
+		key:          key,
+		lastCC:       make(map[uint16]int),
+		tableCC:      make(map[uint16]uint8),
+		outCC:        make(map[uint16]uint8),
+		pmtPIDs:      make(map[uint16]bool),
+		pmts:         make(map[uint16]Packet),
+		injectTables: true,
+		marked:       make(map[uint16]bool),
+	}
+}
+
+// Splice switches to a new upstream source. Cached tables are sent again and
+// the first packet of every PID from the new source carries the
+// discontinuity indicator.
+func (r *Repairer) Splice(src io.Reader) {
+	r.reader.Reset(src)
+	r.err = nil
+	r.lastCC = make(map[uint16]int)
+	r.marked = make(map[uint16]bool)
+	r.spliced = true
+	r.injectTables = true
+
+	r.mu.Lock()
+	r.stats.Splices++
+	r.mu.Unlock()
+}
+
+// Stats returns the inspection counters. It is safe to call concurrently with Read.
+func (r *Repairer) Stats() types.TSStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// Read writes repaired packets into p.
+func (r *Repairer) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 || (len(r.pending) < len(p) && r.reader.Buffered()) {
+		if r.err != nil {
+			break
+		}
+		packet, err := r.reader.ReadPacket()
+		r.updateReaderStats()
+		if err != nil {
+			r.err = err
+			break
+		}
+		r.process(packet)
+	}
+
+	if len(r.pending) == 0 {
+		return 0, r.err
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	if len(r.pending) == 0 {
+		r.pending = r.pending[:0:0]
+	}
+	return n, nil
+}
+
+// process inspects one packet and appends the output for it.
+func (r *Repairer) process(packet Packet) {
+	pid := packet.PID()
+
+	if r.injectTables {
+		r.injectTables = false
+		r.inject()
+	}
+
+	r.checkContinuity(packet)
+	isTable := r.learnTables(packet)
+
+	if isTable {
+		// Table PIDs are renumbered so injected copies never break continuity
+		packet.SetContinuityCounter(r.nextTableCC(pid))
+	} else if r.spliced && pid != PIDNull && !r.marked[pid] {
+		r.marked[pid] = true
+		if !packet.SetDiscontinuity() {
+			cc, ok := r.outCC[pid]
+			if !ok {
+				cc = (packet.ContinuityCounter() + 15) & 0x0F
+			}
+			r.pending = append(r.pending, NewDiscontinuityPacket(pid, cc)...)
+		}
+	}
+
+	r.outCC[pid] = packet.ContinuityCounter()
+	r.pending = append(r.pending, packet...)
+
+	r.mu.Lock()
+	r.stats.Packets++
+	r.mu.Unlock()
+}
+
+// checkContinuity counts continuity counter jumps on the input.
+func (r *Repairer) checkContinuity(packet Packet) {
+	pid := packet.PID()
+	if pid == PIDNull || !packet.HasPayload() {
+		return
+	}
+
+	cc := int(packet.ContinuityCounter())
+	last, known := r.lastCC[pid]
+	r.lastCC[pid] = cc
+	if !known || packet.Discontinuity() {
+		return
+	}
+
+	// A single duplicate packet is allowed by the standard
+	if cc != (last+1)&0x0F && cc != last {
+		r.mu.Lock()
+		r.stats.ContinuityErrors++
+		r.mu.Unlock()
+	}
+}
+
+// learnTables caches complete PAT and PMT packets and reports whether the
+// packet belongs to a table PID.
+func (r *Repairer) learnTables(packet Packet) bool {
+	pid := packet.PID()
+
+	switch {
+	case pid == PIDPAT:
+		if pids, ok := packet.programMapPIDs(); ok {
+			r.pat = append(Packet(nil), packet...)
+			r.pmtPIDs = make(map[uint16]bool, len(pids))
+			for _, pmtPID := range pids {
+				r.pmtPIDs[pmtPID] = true
+			}
+			for pmtPID := range r.pmts {
+				if !r.pmtPIDs[pmtPID] {
+					delete(r.pmts, pmtPID)
+				}
+			}
+			r.storeTables()
+		}
+		return true
+	case r.pmtPIDs[pid]:
+		if packet.isPMT() {
+			r.pmts[pid] = append(Packet(nil), packet...)
+			r.storeTables()
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// storeTables saves the tables once the PAT and all its PMTs are known.
+func (r *Repairer) storeTables() {
+	if r.cache == nil || r.pat == nil || len(r.pmts) != len(r.pmtPIDs) {
+		return
+	}
+
+	tables := Tables{PAT: r.pat}
+	pids := make([]uint16, 0, len(r.pmts))
+	for pid := range r.pmts {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	for _, pid := range pids {
+		tables.PMTs = append(tables.PMTs, r.pmts[pid])
+	}
+
+	r.cache.Set(r.key, tables)
+}
+
+// inject writes the cached tables ahead of the next packet.
+func (r *Repairer) inject() {
+	if r.cache == nil {
+		return
+	}
+	tables, ok := r.cache.Get(r.key)
+	if !ok {
+		return
+	}
+
+	for _, table := range append([]Packet{tables.PAT}, tables.PMTs...) {
+		packet := append(Packet(nil), table...)
+		pid := packet.PID()
+		packet.SetContinuityCounter(r.nextTableCC(pid))
+		r.outCC[pid] = packet.ContinuityCounter()
+		r.pending = append(r.pending, packet...)
+
+		// Injected tables also describe the PIDs of the new source
+		r.learnTables(packet)
+	}
+
+	r.mu.Lock()
+	r.stats.InjectedTables++
+	r.mu.Unlock()
+}
+
+// nextTableCC returns the next output continuity counter for a table PID.
+func (r *Repairer) nextTableCC(pid uint16) uint8 {
+	cc, ok := r.tableCC[pid]
+	if ok {
+		cc = (cc + 1) & 0x0F
+	}
+	r.tableCC[pid] = cc
+	return cc
+}
+
+// updateReaderStats copies the reader counters into the stats.
+func (r *Repairer) updateReaderStats() {
+	r.mu.Lock()
+	r.stats.Resyncs = r.reader.Resyncs()
+	r.stats.DroppedBytes = r.reader.DroppedBytes()
+	r.mu.Unlock()
+}
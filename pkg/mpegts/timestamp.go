@@ -0,0 +1,35 @@
+package mpegts
+
+// Timestamp constants.
+const (
+	// ClockRate is the frequency of PTS values and the PCR base.
+	ClockRate = 90000
+	// TimestampWrap is where 33 bit PTS and PCR base values wrap around.
+	TimestampWrap = 1 << 33
+
+	flagPCR = 0x10
+	flagPTS = 0x80
+)
+
+// PCR returns the program clock reference base in 90kHz units, and whether
+// the packet carries one.
+func (p Packet) PCR() (uint64, bool) {
+	if p.adaptationLength() < 7 || p[5]&flagPCR == 0 {
+		return 0, false
+	}
+	return uint64(p[6])<<25 | uint64(p[7])<<17 | uint64(p[8])<<9 | uint64(p[9])<<1 | uint64(p[10])>>7, true
+}
+
+// PTS returns the presentation timestamp of a PES packet starting in this
+// packet, and whether there is one.
+func (p Packet) PTS() (uint64, bool) {
+	if !p.PayloadUnitStart() {
+		return 0, false
+	}
+	payload := p.Payload()
+	if len(payload) < 14 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 || payload[7]&flagPTS == 0 {
+		return 0, false
+	}
+	ts := payload[9:14]
+	return uint64(ts[0]>>1&0x07)<<30 | uint64(ts[1])<<22 | uint64(ts[2]>>1)<<15 | uint64(ts[3])<<7 | uint64(ts[4]>>1), true
+}
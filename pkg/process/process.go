@@ -0,0 +1,165 @@
+// Package process runs external programs such as ffmpeg, ffprobe and vainfo.
+// Components take a Runner instead of calling os/exec directly so that tests
+// can replace the real binaries with scripted fakes.
+package process
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// Spec describes a process to start.
+type Spec struct {
+	// Name is the program to run, resolved through PATH.
+	Name string
+	// Args are the program arguments.
+	Args []string
+	// Stdin requests a pipe to the process standard input.
+	Stdin bool
+}
+
+// Process is a started program. Stdout and Stderr must be drained by the
+// caller, and Wait must be called once to release its resources.
+type Process interface {
+	// Stdin returns the standard input pipe, or nil if it was not requested.
+	Stdin() io.WriteCloser
+	// Stdout returns the standard output pipe.
+	Stdout() io.ReadCloser
+	// Stderr returns the standard error pipe.
+	Stderr() io.ReadCloser
+	// Wait blocks until the process exits. A non-zero exit is reported as an *ExitError.
+	Wait() error
+	// Kill stops the process immediately.
+	Kill() error
+}
+
+// Runner starts processes.
+type Runner interface {
+	// Start launches a long-running process. Cancelling ctx kills it.
+	Start(ctx context.Context, spec Spec) (Process, error)
+	// Output runs a program to completion and returns its stdout and stderr.
+	Output(ctx context.Context, name string, args ...string) ([]byte, []byte, error)
+}
+
+// ExitError reports a process that exited with a non-zero status.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit status carried by err, or -1 if err does not
+// come from a process that exited.
+func ExitCode(err error) int {
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return -1
+}
+
+// ExecRunner runs real programs with os/exec.
+type ExecRunner struct{}
+
+// NewExecRunner creates a runner for real programs.
+func NewExecRunner() *ExecRunner {
+	return &ExecRunner{}
+}
+
+// Start launches the program described by spec.
+func (r *ExecRunner) Start(ctx context.Context, spec Spec) (Process, error) {
+	cmd := exec.CommandContext(ctx, spec.Name, spec.Args...) // #nosec G204 - callers build args internally
+
+	p := &execProcess{cmd: cmd}
+
+	var err error
+	if spec.Stdin {
+		p.stdin, err = cmd.StdinPipe()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+		}
+	}
+
+	p.stdout, err = cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	p.stderr, err = cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", spec.Name, err)
+	}
+
+	return p, nil
+}
+
+// Output runs the program to completion.
+func (r *ExecRunner) Output(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...) // #nosec G204 - callers build args internally
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	return stdout.Bytes(), stderr.Bytes(), convertExitError(err)
+}
+
+// execProcess is a Process backed by os/exec.
+type execProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr io.ReadCloser
+}
+
+func (p *execProcess) Stdin() io.WriteCloser {
+	return p.stdin
+}
+
+func (p *execProcess) Stdout() io.ReadCloser {
+	return p.stdout
+}
+
+func (p *execProcess) Stderr() io.ReadCloser {
+	return p.stderr
+}
+
+func (p *execProcess) Wait() error {
+	return convertExitError(p.cmd.Wait())
+}
+
+func (p *execProcess) Kill() error {
+	if p.cmd.Process == nil {
+		return nil
+	}
+	return p.cmd.Process.Kill()
+}
+
+// convertExitError maps *exec.ExitError to *ExitError so callers do not
+// depend on os/exec.
+func convertExitError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExitError{Code: exitErr.ExitCode(), Err: err}
+	}
+	return err
+}
@@ -0,0 +1,347 @@
+// Package processtest provides a scripted fake process runner so that code
+// calling ffmpeg, ffprobe or the GPU tools can be tested without the binaries.
+package processtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/process"
+)
+
+// ErrKilled is the error reported by Wait for a process stopped by Kill or
+// by cancelling its context.
+var ErrKilled = errors.New("signal: killed")
+
+// defaultChunkSize is the stdout write size when a script does not set one.
+const defaultChunkSize = 7 * PacketSize
+
+// Call records one program invocation.
+type Call struct {
+	Name string
+	Args []string
+}
+
+// Arg returns the value following flag, or "" if the flag is not present.
+func (c Call) Arg(flag string) string {
+	for i := 0; i < len(c.Args)-1; i++ {
+		if c.Args[i] == flag {
+			return c.Args[i+1]
+		}
+	}
+	return ""
+}
+
+// HasArg reports whether arg appears in the arguments.
+func (c Call) HasArg(arg string) bool {
+	for _, a := range c.Args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// Script describes how a fake process behaves. Stdout is written in chunks,
+// then Stderr, and the process exits with ExitCode.
+type Script struct {
+	// Stdout is the output written to the stdout pipe.
+	Stdout []byte
+	// ChunkSize is the size of each stdout write. Zero writes 7 TS packets at a time.
+	ChunkSize int
+	// Interval is the pause between stdout chunks.
+	Interval time.Duration
+	// Stderr is written after stdout, just before the process exits.
+	Stderr string
+	// ExitCode is the exit status. Non-zero codes make Wait return an *process.ExitError.
+	ExitCode int
+	// Hold keeps the process running after its output until it is killed.
+	Hold bool
+}
+
+// HandlerFunc decides how a fake process behaves for an invocation.
+type HandlerFunc func(call Call) Script
+
+// Runner is a process.Runner that plays scripts instead of running programs.
+type Runner struct {
+	handler HandlerFunc
+
+	mu      sync.Mutex
+	calls   []Call
+	started int
+	exited  int
+	waited  int
+	killed  int
+	idle    *sync.Cond
+}
+
+// NewRunner creates a fake runner. Programs without a script from the
+// handler exit with status 127 as if they were not installed.
+func NewRunner(handler HandlerFunc) *Runner {
+	r := &Runner{handler: handler}
+	r.idle = sync.NewCond(&r.mu)
+	return r
+}
+
+// Start launches a fake process.
+func (r *Runner) Start(ctx context.Context, spec process.Spec) (process.Process, error) {
+	script := r.record(Call{Name: spec.Name, Args: append([]string(nil), spec.Args...)})
+
+	r.mu.Lock()
+	r.started++
+	r.mu.Unlock()
+
+	p := &fakeProcess{
+		runner: r,
+		kill:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	p.stdoutR, p.stdoutW = io.Pipe()
+	p.stderrR, p.stderrW = io.Pipe()
+	if spec.Stdin {
+		var stdinR *io.PipeReader
+		stdinR, p.stdinW = io.Pipe()
+		go func() {
+			_, _ = io.Copy(io.Discard, stdinR)
+		}()
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = p.Kill()
+		case <-p.done:
+		}
+	}()
+	go p.run(script)
+
+	return p, nil
+}
+
+// Output runs a fake program to completion.
+func (r *Runner) Output(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	script := r.record(Call{Name: name, Args: append([]string(nil), args...)})
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if script.ExitCode != 0 {
+		return script.Stdout, []byte(script.Stderr), &process.ExitError{Code: script.ExitCode}
+	}
+	return script.Stdout, []byte(script.Stderr), nil
+}
+
+// Calls returns the recorded invocations of a program, or of every program
+// when name is empty.
+func (r *Runner) Calls(name string) []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var calls []Call
+	for _, call := range r.calls {
+		if name == "" || call.Name == name {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Started returns how many processes were started.
+func (r *Runner) Started() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.started
+}
+
+// Running returns how many started processes have not exited yet.
+func (r *Runner) Running() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.started - r.exited
+}
+
+// Killed returns how many processes were stopped by Kill or context cancellation.
+func (r *Runner) Killed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.killed
+}
+
+// WaitIdle blocks until every started process has exited and been waited
+// for, and reports whether that happened within the timeout.
+func (r *Runner) WaitIdle(timeout time.Duration) bool {
+	timer := time.AfterFunc(timeout, func() {
+		r.mu.Lock()
+		r.idle.Broadcast()
+		r.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.started != r.exited || r.started != r.waited {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		r.idle.Wait()
+	}
+	return true
+}
+
+// record stores the call and returns its script.
+func (r *Runner) record(call Call) Script {
+	r.mu.Lock()
+	r.calls = append(r.calls, call)
+	r.mu.Unlock()
+
+	if r.handler == nil {
+		return Script{Stderr: call.Name + ": command not found", ExitCode: 127}
+	}
+	return r.handler(call)
+}
+
+// update changes the process counters and wakes WaitIdle.
+func (r *Runner) update(fn func()) {
+	r.mu.Lock()
+	fn()
+	r.idle.Broadcast()
+	r.mu.Unlock()
+}
+
+// fakeProcess is a process.Process playing a Script.
+type fakeProcess struct {
+	runner  *Runner
+	stdinW  *io.PipeWriter
+	stdoutR *io.PipeReader
+	stdoutW *io.PipeWriter
+	stderrR *io.PipeReader
+	stderrW *io.PipeWriter
+
+	kill     chan struct{}
+	killOnce sync.Once
+	done     chan struct{}
+	waitOnce sync.Once
+	err      error
+}
+
+func (p *fakeProcess) Stdin() io.WriteCloser {
+	if p.stdinW == nil {
+		return nil
+	}
+	return p.stdinW
+}
+
+func (p *fakeProcess) Stdout() io.ReadCloser {
+	return p.stdoutR
+}
+
+func (p *fakeProcess) Stderr() io.ReadCloser {
+	return p.stderrR
+}
+
+func (p *fakeProcess) Wait() error {
+	<-p.done
+	p.waitOnce.Do(func() {
+		p.runner.update(func() { p.runner.waited++ })
+	})
+	return p.err
+}
+
+func (p *fakeProcess) Kill() error {
+	select {
+	case <-p.done:
+		return os.ErrProcessDone
+	default:
+	}
+
+	p.killOnce.Do(func() {
+		close(p.kill)
+		// Closing the write ends unblocks a pending write, like a signal
+		// interrupting the real process
+		_ = p.stdoutW.Close()
+		_ = p.stderrW.Close()
+	})
+	return nil
+}
+
+// run plays the script and records the exit status.
+func (p *fakeProcess) run(script Script) {
+	code := p.play(script)
+
+	_ = p.stderrW.Close()
+	_ = p.stdoutW.Close()
+
+	killed := p.wasKilled()
+	switch {
+	case killed:
+		p.err = &process.ExitError{Code: -1, Err: ErrKilled}
+	case code != 0:
+		p.err = &process.ExitError{Code: code}
+	}
+
+	p.runner.update(func() {
+		p.runner.exited++
+		if killed {
+			p.runner.killed++
+		}
+	})
+	close(p.done)
+}
+
+// play writes the scripted output and returns the exit code.
+func (p *fakeProcess) play(script Script) int {
+	chunkSize := script.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	for offset := 0; offset < len(script.Stdout); offset += chunkSize {
+		end := min(offset+chunkSize, len(script.Stdout))
+		if _, err := p.stdoutW.Write(script.Stdout[offset:end]); err != nil {
+			// The reader went away; a real process would die of SIGPIPE
+			return 1
+		}
+		if script.Interval > 0 && end < len(script.Stdout) {
+			select {
+			case <-p.kill:
+				return -1
+			case <-time.After(script.Interval):
+			}
+		}
+	}
+
+	if script.Hold {
+		<-p.kill
+		return -1
+	}
+
+	if script.Stderr != "" {
+		if _, err := io.WriteString(p.stderrW, script.Stderr); err != nil {
+			return -1
+		}
+	}
+
+	return script.ExitCode
+}
+
+// wasKilled reports whether Kill was called before the process exited.
+func (p *fakeProcess) wasKilled() bool {
+	select {
+	case <-p.kill:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsEncode reports whether an ffmpeg call is a streaming transcode writing
+// to stdout, as opposed to a hardware capability test.
+func IsEncode(call Call) bool {
+	return call.Name == "ffmpeg" && call.HasArg("pipe:1")
+}
@@ -0,0 +1,89 @@
+package processtest
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// PacketSize is the size of an MPEG-TS packet.
+const PacketSize = 188
+
+// videoPID is the elementary stream PID used by MPEGTS.
+const videoPID = 0x100
+
+// MPEGTS returns count valid MPEG-TS packets on a single PID with
+// incrementing continuity counters. Each payload is filled with the packet
+// index so that truncated or reordered output is easy to spot.
+func MPEGTS(count int) []byte {
+	out := make([]byte, 0, count*PacketSize)
+	for i := range count {
+		packet := make([]byte, PacketSize)
+		packet[0] = 0x47
+		packet[1] = byte(videoPID >> 8 & 0x1f)
+		packet[2] = byte(videoPID & 0xff)
+		if i == 0 {
+			packet[1] |= 0x40 // payload unit start
+		}
+		packet[3] = 0x10 | byte(i&0x0f) // payload only, continuity counter
+		for j := 4; j < PacketSize; j++ {
+			packet[j] = byte(i)
+		}
+		out = append(out, packet...)
+	}
+	return out
+}
+
+// ProbeJSON returns ffprobe -print_format json output describing a stream
+// with the given video and audio codecs. Empty codecs are left out.
+func ProbeJSON(videoCodec, audioCodec string) []byte {
+	type stream struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Width        int    `json:"width,omitempty"`
+		Height       int    `json:"height,omitempty"`
+		AvgFrameRate string `json:"avg_frame_rate,omitempty"`
+		Channels     int    `json:"channels,omitempty"`
+	}
+
+	var streams []stream
+	if videoCodec != "" {
+		streams = append(streams, stream{
+			CodecType:    "video",
+			CodecName:    videoCodec,
+			Width:        1920,
+			Height:       1080,
+			AvgFrameRate: "25/1",
+		})
+	}
+	if audioCodec != "" {
+		streams = append(streams, stream{CodecType: "audio", CodecName: audioCodec, Channels: 2})
+	}
+
+	out, err := json.Marshal(map[string]any{"streams": streams, "format": map[string]string{}})
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// InputDump returns the input description FFmpeg prints to stderr for a
+// 1080p stream with the given video and audio codecs, followed by the start
+// of its output description. Empty codecs are left out.
+func InputDump(videoCodec, audioCodec string) string {
+	var b strings.Builder
+	b.WriteString("Input #0, mpegts, from 'http://upstream.example/live/1.ts':\n")
+	b.WriteString("  Duration: N/A, start: 1.400000, bitrate: N/A\n")
+	b.WriteString("  Program 1\n")
+	if videoCodec != "" {
+		b.WriteString("  Stream #0:0[0x100]: Video: " + videoCodec +
+			" (High) ([27][0][0][0] / 0x001B), yuv420p(tv, bt709, progressive), 1920x1080 [SAR 1:1 DAR 16:9], 25 fps, 25 tbr, 90k tbn\n")
+	}
+	if audioCodec != "" {
+		b.WriteString("  Stream #0:1[0x101](eng): Audio: " + audioCodec +
+			" (LC) ([15][0][0][0] / 0x000F), 48000 Hz, stereo, fltp, 128 kb/s\n")
+	}
+	b.WriteString("Stream mapping:\n")
+	b.WriteString("  Stream #0:0 -> #0:0 (h264 (native) -> h264 (libx264))\n")
+	b.WriteString("Output #0, mpegts, to 'pipe:1':\n")
+	return b.String()
+}
@@ -0,0 +1,262 @@
+// Package ssdp answers SSDP discovery requests so that media servers such as
+// Plex and Jellyfin find the emulated tuner without entering its address.
+package ssdp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// MulticastAddr is the SSDP multicast group and port.
+	MulticastAddr = "239.255.255.250:1900"
+
+	// TargetAll matches every device in an M-SEARCH.
+	TargetAll = "ssdp:all"
+	// TargetRootDevice is the search target of UPnP root devices.
+	TargetRootDevice = "upnp:rootdevice"
+	// TargetMediaServer is the UPnP media server device type.
+	TargetMediaServer = "urn:schemas-upnp-org:device:MediaServer:1"
+	// TargetHDHomeRun is the device type searched for by HDHomeRun clients.
+	TargetHDHomeRun = "urn:schemas-silicondust-com:device:HDHomeRun:1"
+
+	// maxAge is how long clients may cache an announcement, in seconds.
+	maxAge = 1800
+	// defaultNotifyInterval is how often alive announcements are repeated.
+	defaultNotifyInterval = 5 * time.Minute
+	// maxResponseDelay caps the random delay requested with MX.
+	maxResponseDelay = 5 * time.Second
+	// serverHeader identifies the responder in SSDP messages.
+	serverHeader = "Linux/1.0 UPnP/1.0 iptv-proxy/1.0"
+)
+
+// ErrNoDevices is returned when a responder is created without devices.
+var ErrNoDevices = errors.New("no devices to announce")
+
+// Device is an announced UPnP device.
+type Device struct {
+	// UDN is the unique device name, e.g. "uuid:...".
+	UDN string
+	// Location is the URL of the device description.
+	Location string
+}
+
+// Responder answers M-SEARCH requests and sends NOTIFY announcements for its
+// devices.
+type Responder struct {
+	conn           net.PacketConn
+	group          net.Addr
+	devices        []Device
+	notifyInterval time.Duration
+	maxDelay       time.Duration
+	logger         *logrus.Logger
+
+	wg sync.WaitGroup
+}
+
+// Listen joins the SSDP multicast group on all interfaces.
+func Listen() (net.PacketConn, error) {
+	group, err := net.ResolveUDPAddr("udp4", MulticastAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve SSDP address: %w", err)
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join SSDP multicast group: %w", err)
+	}
+	return conn, nil
+}
+
+// NewResponder creates a responder that reads searches from conn and sends
+// announcements to the SSDP multicast group.
+func NewResponder(conn net.PacketConn, devices []Device, logger *logrus.Logger) (*Responder, error) {
+	if len(devices) == 0 {
+		return nil, ErrNoDevices
+	}
+	group, err := net.ResolveUDPAddr("udp4", MulticastAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve SSDP address: %w", err)
+	}
+
+	return &Responder{
+		conn:           conn,
+		group:          group,
+		devices:        devices,
+		notifyInterval: defaultNotifyInterval,
+		maxDelay:       maxResponseDelay,
+		logger:         logger,
+	}, nil
+}
+
+// SetNotifyInterval sets how often alive announcements are repeated.
+func (r *Responder) SetNotifyInterval(interval time.Duration) {
+	if interval > 0 {
+		r.notifyInterval = interval
+	}
+}
+
+// Serve answers searches and repeats announcements until the context is
+// cancelled, then announces that the devices are leaving and closes the
+// connection.
+func (r *Responder) Serve(ctx context.Context) {
+	r.notify("ssdp:alive")
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.notifyInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.notify("ssdp:alive")
+			}
+		}
+	}()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.readLoop(ctx)
+	}()
+
+	<-ctx.Done()
+	r.notify("ssdp:byebye")
+	_ = r.conn.Close()
+	r.wg.Wait()
+}
+
+// readLoop answers M-SEARCH requests until the connection is closed.
+func (r *Responder) readLoop(ctx context.Context) {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				r.logger.WithError(err).Warn("SSDP read failed")
+			}
+			return
+		}
+
+		target, mx, ok := parseSearch(buf[:n])
+		if !ok {
+			continue
+		}
+		responses := r.responses(target)
+		if len(responses) == 0 {
+			continue
+		}
+
+		r.logger.WithFields(logrus.Fields{
+			"from":   addr.String(),
+			"target": target,
+		}).Debug("Answering SSDP search")
+
+		delay := min(time.Duration(mx)*time.Second, r.maxDelay)
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			if delay > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(rand.N(delay)): //nolint:gosec // jitter does not need a secure source
+				}
+			}
+			for _, response := range responses {
+				if _, err := r.conn.WriteTo(response, addr); err != nil {
+					r.logger.WithError(err).Debug("Failed to send SSDP response")
+					return
+				}
+			}
+		}()
+	}
+}
+
+// parseSearch reads the search target and MX of an M-SEARCH request.
+func parseSearch(packet []byte) (string, int, bool) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(packet)))
+	if err != nil || req.Method != "M-SEARCH" {
+		return "", 0, false
+	}
+	if strings.Trim(req.Header.Get("MAN"), `"`) != "ssdp:discover" {
+		return "", 0, false
+	}
+	mx, _ := strconv.Atoi(req.Header.Get("MX"))
+	return req.Header.Get("ST"), mx, true
+}
+
+// types returns the notification types of a device.
+func types(device Device) []string {
+	return []string{TargetRootDevice, device.UDN, TargetMediaServer, TargetHDHomeRun}
+}
+
+// responses builds the search responses for a target.
+func (r *Responder) responses(target string) [][]byte {
+	var out [][]byte
+	for _, device := range r.devices {
+		for _, nt := range types(device) {
+			if target != TargetAll && !strings.EqualFold(target, nt) {
+				continue
+			}
+			out = append(out, []byte(fmt.Sprintf(
+				"HTTP/1.1 200 OK\r\n"+
+					"CACHE-CONTROL: max-age=%d\r\n"+
+					"DATE: %s\r\n"+
+					"EXT:\r\n"+
+					"LOCATION: %s\r\n"+
+					"SERVER: %s\r\n"+
+					"ST: %s\r\n"+
+					"USN: %s\r\n"+
+					"\r\n",
+				maxAge, time.Now().UTC().Format(http.TimeFormat), device.Location, serverHeader, nt, usn(device, nt))))
+		}
+	}
+	return out
+}
+
+// notify sends an announcement of every device and type to the multicast
+// group.
+func (r *Responder) notify(nts string) {
+	for _, device := range r.devices {
+		for _, nt := range types(device) {
+			message := fmt.Sprintf(
+				"NOTIFY * HTTP/1.1\r\n"+
+					"HOST: %s\r\n"+
+					"CACHE-CONTROL: max-age=%d\r\n"+
+					"LOCATION: %s\r\n"+
+					"NT: %s\r\n"+
+					"NTS: %s\r\n"+
+					"SERVER: %s\r\n"+
+					"USN: %s\r\n"+
+					"\r\n",
+				MulticastAddr, maxAge, device.Location, nt, nts, serverHeader, usn(device, nt))
+			if _, err := r.conn.WriteTo([]byte(message), r.group); err != nil {
+				r.logger.WithError(err).Debug("Failed to send SSDP notification")
+				return
+			}
+		}
+	}
+}
+
+// usn builds the unique service name of a device and type.
+func usn(device Device, nt string) string {
+	if nt == device.UDN {
+		return device.UDN
+	}
+	return device.UDN + "::" + nt
+}
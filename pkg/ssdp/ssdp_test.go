@@ -0,0 +1,165 @@
+package ssdp
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newTestResponder starts a responder on a loopback socket whose
+// announcements go to the returned group socket.
+func newTestResponder(t *testing.T) (net.Addr, net.PacketConn, context.CancelFunc, <-chan struct{}) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	group, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	t.Cleanup(func() { _ = group.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	responder, err := NewResponder(conn, []Device{{
+		UDN:      "uuid:test-device",
+		Location: "http://192.0.2.1:8080/",
+	}}, logger)
+	if err != nil {
+		t.Fatalf("NewResponder() error = %v", err)
+	}
+	responder.group = group.LocalAddr()
+	responder.maxDelay = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		responder.Serve(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return conn.LocalAddr(), group, cancel, done
+}
+
+// readMessages reads messages until none arrive for a short while.
+func readMessages(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+
+	var messages []string
+	buf := make([]byte, 2048)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return messages
+		}
+		messages = append(messages, string(buf[:n]))
+	}
+}
+
+func TestResponderAnswersSearch(t *testing.T) {
+	addr, _, _, _ := newTestResponder(t)
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{TargetMediaServer, 1},
+		{TargetHDHomeRun, 1},
+		{TargetRootDevice, 1},
+		{"uuid:test-device", 1},
+		{TargetAll, 4},
+		{"urn:schemas-upnp-org:device:Printer:1", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("ListenPacket() error = %v", err)
+			}
+			defer func() { _ = client.Close() }()
+
+			search := "M-SEARCH * HTTP/1.1\r\n" +
+				"HOST: 239.255.255.250:1900\r\n" +
+				"MAN: \"ssdp:discover\"\r\n" +
+				"MX: 1\r\n" +
+				"ST: " + tt.target + "\r\n\r\n"
+			if _, err := client.WriteTo([]byte(search), addr); err != nil {
+				t.Fatalf("WriteTo() error = %v", err)
+			}
+
+			responses := readMessages(t, client)
+			if len(responses) != tt.want {
+				t.Fatalf("got %d responses, want %d: %q", len(responses), tt.want, responses)
+			}
+			for _, response := range responses {
+				if !strings.HasPrefix(response, "HTTP/1.1 200 OK\r\n") ||
+					!strings.Contains(response, "LOCATION: http://192.0.2.1:8080/\r\n") ||
+					!strings.Contains(response, "USN: uuid:test-device") {
+					t.Errorf("unexpected response %q", response)
+				}
+			}
+			if tt.want == 1 && !strings.Contains(responses[0], "ST: "+tt.target+"\r\n") {
+				t.Errorf("response %q does not echo ST %s", responses[0], tt.target)
+			}
+		})
+	}
+}
+
+func TestResponderIgnoresOtherRequests(t *testing.T) {
+	addr, _, _, _ := newTestResponder(t)
+
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	for _, request := range []string{
+		"M-SEARCH * HTTP/1.1\r\nST: ssdp:all\r\nMX: 1\r\n\r\n",
+		"NOTIFY * HTTP/1.1\r\nNT: upnp:rootdevice\r\nNTS: ssdp:alive\r\n\r\n",
+		"garbage",
+	} {
+		if _, err := client.WriteTo([]byte(request), addr); err != nil {
+			t.Fatalf("WriteTo() error = %v", err)
+		}
+	}
+	if responses := readMessages(t, client); len(responses) != 0 {
+		t.Errorf("got responses to invalid searches: %q", responses)
+	}
+}
+
+func TestResponderAnnounces(t *testing.T) {
+	_, group, cancel, done := newTestResponder(t)
+
+	alive := readMessages(t, group)
+	if len(alive) != 4 {
+		t.Fatalf("got %d alive announcements, want 4", len(alive))
+	}
+	for _, message := range alive {
+		if !strings.HasPrefix(message, "NOTIFY * HTTP/1.1\r\n") || !strings.Contains(message, "NTS: ssdp:alive\r\n") {
+			t.Errorf("unexpected announcement %q", message)
+		}
+	}
+
+	cancel()
+	<-done
+	byebye := readMessages(t, group)
+	if len(byebye) != 4 {
+		t.Fatalf("got %d byebye announcements, want 4", len(byebye))
+	}
+	if !strings.Contains(byebye[0], "NTS: ssdp:byebye\r\n") {
+		t.Errorf("unexpected announcement %q", byebye[0])
+	}
+}
@@ -0,0 +1,155 @@
+// Package proxy provides HTTP stream proxying functionality for IPTV streams.
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/savid/iptv-proxy/pkg/streaming/transcode"
+	"github.com/savid/iptv-proxy/pkg/types"
+)
+
+// sessionRegistry tracks the active transcoding sessions.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	nextID   uint64
+	sessions map[string]*types.TranscodeSession
+	tsStats  map[string]func() types.TSStats
+}
+
+// newSessionRegistry creates an empty session registry.
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		sessions: make(map[string]*types.TranscodeSession),
+		tsStats:  make(map[string]func() types.TSStats),
+	}
+}
+
+// startCopy registers a session that copies the upstream without FFmpeg.
+// The stats function is polled when sessions are listed.
+func (r *sessionRegistry) startCopy(targetURL string, stats func() types.TSStats) types.TranscodeSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	session := &types.TranscodeSession{
+		ID:         fmt.Sprintf("s%d", r.nextID),
+		Channel:    targetURL,
+		Profile:    codecCopy,
+		Mode:       types.TranscodeModeCopy,
+		Hardware:   types.HardwareCPU,
+		VideoCodec: codecCopy,
+		AudioCodec: codecCopy,
+		StartTime:  time.Now(),
+	}
+
+	r.sessions[session.ID] = session
+	r.tsStats[session.ID] = stats
+	return *session
+}
+
+// start registers a new session and returns a copy of it.
+func (r *sessionRegistry) start(
+	targetURL string,
+	channel *m3u.Channel,
+	settings streamSettings,
+	hw types.HardwareInfo,
+	decision *transcode.Decision,
+) types.TranscodeSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	session := &types.TranscodeSession{
+		ID:         fmt.Sprintf("s%d", r.nextID),
+		Channel:    targetURL,
+		Profile:    settings.profileName,
+		Mode:       types.TranscodeMode(settings.mode),
+		Hardware:   hw.Type,
+		DeviceID:   hw.DeviceID,
+		VideoCodec: settings.videoCodec,
+		AudioCodec: settings.audioCodec,
+		StartTime:  time.Now(),
+	}
+	if channel != nil {
+		session.Channel = channel.Name
+	}
+	if settings.client != nil {
+		session.Client = settings.client.Name
+	}
+	if decision != nil {
+		session.Mode = types.TranscodeModeAuto
+		session.SourceVideoCodec = decision.SourceVideoCodec
+		session.SourceAudioCodec = decision.SourceAudioCodec
+		session.Decision = decision.String()
+	}
+
+	r.sessions[session.ID] = session
+	return *session
+}
+
+// addBytes records bytes written to the client for a session.
+func (r *sessionRegistry) addBytes(id string, n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if session, ok := r.sessions[id]; ok {
+		session.BytesWritten += n
+	}
+}
+
+// writer returns a writer to w that adds the bytes written to the session
+// as they are written, so that the sessions API shows live throughput.
+func (r *sessionRegistry) writer(id string, w io.Writer) *sessionWriter {
+	return &sessionWriter{registry: r, id: id, w: w}
+}
+
+// sessionWriter counts the bytes a session writes to its client.
+type sessionWriter struct {
+	registry *sessionRegistry
+	id       string
+	w        io.Writer
+}
+
+func (s *sessionWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	if n > 0 {
+		s.registry.addBytes(s.id, int64(n))
+	}
+	return n, err
+}
+
+// finish removes a session from the registry.
+func (r *sessionRegistry) finish(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, id)
+	delete(r.tsStats, id)
+}
+
+// list returns a snapshot of all sessions ordered by start time.
+func (r *sessionRegistry) list() []types.TranscodeSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sessions := make([]types.TranscodeSession, 0, len(r.sessions))
+	for id, session := range r.sessions {
+		snapshot := *session
+		if stats, ok := r.tsStats[id]; ok {
+			ts := stats()
+			snapshot.TS = &ts
+		}
+		sessions = append(sessions, snapshot)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartTime.Before(sessions[j].StartTime)
+	})
+
+	return sessions
+}
@@ -0,0 +1,266 @@
+// Package proxy provides HTTP stream proxying functionality for IPTV streams.
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/savid/iptv-proxy/pkg/streaming/transcode"
+)
+
+const (
+	modeTranscode     = "transcode"
+	modeAuto          = "auto"
+	defaultVideoCodec = "h264"
+	defaultAudioCodec = "aac"
+	qualityCustom     = "custom"
+	qualityMedium     = "medium"
+)
+
+// streamSettings holds the transcoding settings resolved for a single stream.
+type streamSettings struct {
+	profileName  string
+	mode         string
+	videoCodec   string
+	audioCodec   string
+	videoBitrate string
+	audioBitrate string
+	maxWidth     int
+	maxHeight    int
+	framerate    float64
+	deinterlace  string
+	deviceType   string
+	deviceID     int
+	container    string
+	client       *transcode.ClientProfile
+}
+
+// resolveSettings determines the settings for a stream. The global configuration
+// is the base, a profile selected by the rules file for the channel is applied on
+// top, and a profile requested with the "profile" query parameter wins over both.
+func (st *StreamTranscoder) resolveSettings(r *http.Request, channel *m3u.Channel) (streamSettings, error) {
+	settings := streamSettings{
+		profileName:  "default",
+		mode:         st.config.Mode,
+		videoCodec:   st.config.VideoCodec,
+		audioCodec:   st.config.AudioCodec,
+		videoBitrate: st.config.VideoBitrate,
+		audioBitrate: st.config.AudioBitrate,
+		maxWidth:     st.config.MaxWidth,
+		maxHeight:    st.config.MaxHeight,
+		framerate:    st.config.Framerate,
+		deinterlace:  st.config.Deinterlace,
+		deviceType:   "auto",
+		container:    transcode.ContainerMPEGTS,
+	}
+	if settings.mode == "" {
+		settings.mode = modeTranscode
+		if settings.videoCodec == codecCopy && settings.audioCodec == codecCopy {
+			settings.mode = codecCopy
+		}
+	}
+	if st.config.HardwareAccel == "none" || st.config.HardwareAccel == "" {
+		settings.deviceType = "none"
+	} else if st.config.HardwareDevice != "" {
+		deviceType, deviceID, err := config.ParseDevice(st.config.HardwareDevice)
+		if err != nil {
+			return settings, err
+		}
+		settings.deviceType = deviceType
+		settings.deviceID = deviceID
+	}
+
+	if channel != nil {
+		if name, override, ok := st.config.Rules.Match(*channel); ok {
+			settings.profileName = name
+			if err := st.applyOverride(&settings, override); err != nil {
+				return settings, err
+			}
+		}
+	}
+
+	if name := r.URL.Query().Get("profile"); name != "" {
+		override, ok := st.config.Rules.Profile(name)
+		if !ok {
+			return settings, fmt.Errorf("%w: %s", transcode.ErrUnknownProfile, name)
+		}
+		settings.profileName = name
+		if err := st.applyOverride(&settings, override); err != nil {
+			return settings, err
+		}
+	}
+
+	if settings.mode == codecCopy {
+		settings.videoCodec = codecCopy
+		settings.audioCodec = codecCopy
+	}
+
+	client, err := st.selectClient(r)
+	if err != nil {
+		return settings, err
+	}
+	if client != nil {
+		st.applyClient(&settings, client)
+	}
+
+	return settings, nil
+}
+
+// selectClient returns the client profile named by the "client" query
+// parameter, or the first profile matching the request's User-Agent.
+func (st *StreamTranscoder) selectClient(r *http.Request) (*transcode.ClientProfile, error) {
+	if name := r.URL.Query().Get("client"); name != "" {
+		client, ok := st.config.Clients.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", transcode.ErrUnknownClientProfile, name)
+		}
+		return &client, nil
+	}
+
+	if client, ok := st.config.Clients.Match(r.UserAgent()); ok {
+		return &client, nil
+	}
+
+	return nil, nil
+}
+
+// applyClient restricts the stream settings to what the client can play.
+// Transcoded tracks are switched to a codec the client supports and the
+// output resolution is capped to the client's limit. Copied streams are
+// passed through unchanged, since their codecs are not known without a probe;
+// auto mode checks them against the client.
+func (st *StreamTranscoder) applyClient(settings *streamSettings, client *transcode.ClientProfile) {
+	settings.client = client
+	if settings.mode == codecCopy {
+		return
+	}
+	settings.container = client.Container()
+
+	mapper := transcode.NewQualityMapper()
+	if codec := client.VideoEncoderCodec(settings.videoCodec); codec != settings.videoCodec {
+		settings.videoCodec = codec
+		settings.videoBitrate = mapper.GetVideoBitrate(qualityOrDefault(st.config.VideoQuality, ""), codec)
+	}
+	if codec := client.AudioEncoderCodec(settings.audioCodec); codec != settings.audioCodec {
+		settings.audioCodec = codec
+		settings.audioBitrate = mapper.GetAudioBitrate(qualityOrDefault(st.config.AudioQuality, ""), codec)
+	}
+
+	settings.maxWidth = minLimit(settings.maxWidth, client.MaxWidth)
+	settings.maxHeight = minLimit(settings.maxHeight, client.MaxHeight)
+}
+
+// minLimit returns the smaller of two resolution limits where zero means unlimited.
+func minLimit(a, b int) int {
+	switch {
+	case a == 0:
+		return b
+	case b == 0:
+		return a
+	default:
+		return min(a, b)
+	}
+}
+
+// applyOverride merges a profile override into the stream settings.
+func (st *StreamTranscoder) applyOverride(settings *streamSettings, override transcode.ProfileOverride) error {
+	mapper := transcode.NewQualityMapper()
+
+	if override.Mode != "" {
+		settings.mode = override.Mode
+	}
+
+	if settings.mode == modeTranscode || settings.mode == modeAuto {
+		// Switching a copy configuration to transcoding needs real codecs
+		if settings.videoCodec == codecCopy || settings.videoCodec == "" {
+			settings.videoCodec = st.transcodeVideoCodec()
+		}
+		if settings.audioCodec == codecCopy || settings.audioCodec == "" {
+			settings.audioCodec = st.transcodeAudioCodec()
+		}
+	}
+
+	videoChanged := override.VideoCodec != "" || override.VideoQuality != ""
+	if override.VideoCodec != "" {
+		settings.videoCodec = override.VideoCodec
+	}
+	switch {
+	case override.VideoBitrate != "":
+		settings.videoBitrate = override.VideoBitrate
+	case videoChanged || settings.videoBitrate == "":
+		settings.videoBitrate = mapper.GetVideoBitrate(qualityOrDefault(override.VideoQuality, st.config.VideoQuality), settings.videoCodec)
+	}
+
+	audioChanged := override.AudioCodec != "" || override.AudioQuality != ""
+	if override.AudioCodec != "" {
+		settings.audioCodec = override.AudioCodec
+	}
+	switch {
+	case override.AudioBitrate != "":
+		settings.audioBitrate = override.AudioBitrate
+	case audioChanged || settings.audioBitrate == "":
+		settings.audioBitrate = mapper.GetAudioBitrate(qualityOrDefault(override.AudioQuality, st.config.AudioQuality), settings.audioCodec)
+	}
+
+	if override.MaxWidth > 0 {
+		settings.maxWidth = override.MaxWidth
+	}
+	if override.MaxHeight > 0 {
+		settings.maxHeight = override.MaxHeight
+	}
+	if override.Framerate > 0 {
+		settings.framerate = override.Framerate
+	}
+	if override.Deinterlace != "" {
+		settings.deinterlace = override.Deinterlace
+	}
+
+	if override.HardwareDevice != "" {
+		deviceType, deviceID, err := config.ParseDevice(override.HardwareDevice)
+		if err != nil {
+			return err
+		}
+		settings.deviceType = deviceType
+		settings.deviceID = deviceID
+	}
+
+	return nil
+}
+
+// clientName returns the selected client profile name, or "none".
+func (s streamSettings) clientName() string {
+	if s.client == nil {
+		return "none"
+	}
+	return s.client.Name
+}
+
+// transcodeVideoCodec returns the video codec used when a profile enables transcoding.
+func (st *StreamTranscoder) transcodeVideoCodec() string {
+	if st.config.VideoCodec != "" && st.config.VideoCodec != codecCopy {
+		return st.config.VideoCodec
+	}
+	return defaultVideoCodec
+}
+
+// transcodeAudioCodec returns the audio codec used when a profile enables transcoding.
+func (st *StreamTranscoder) transcodeAudioCodec() string {
+	if st.config.AudioCodec != "" && st.config.AudioCodec != codecCopy {
+		return st.config.AudioCodec
+	}
+	return defaultAudioCodec
+}
+
+// qualityOrDefault returns the first usable quality preset.
+// Custom presets without a bitrate fall back to medium.
+func qualityOrDefault(quality, fallback string) string {
+	if quality != "" && quality != qualityCustom {
+		return quality
+	}
+	if fallback != "" && fallback != qualityCustom {
+		return fallback
+	}
+	return qualityMedium
+}
@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/savid/iptv-proxy/pkg/streaming/transcode"
+)
+
+func TestResolveSettings(t *testing.T) {
+	rules, err := transcode.ParseRuleSet([]byte(`{
+  "profiles": {
+    "sports4k": {"video_codec": "h265", "max_height": 1080, "hardware_device": "nvidia:1"},
+    "news": {"mode": "copy"},
+    "mobile": {"mode": "transcode", "video_quality": "low", "max_height": 480}
+  },
+  "rules": [
+    {"group": "Sports", "profile": "sports4k"},
+    {"group": "News", "profile": "news"}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	st := &StreamTranscoder{config: &TranscoderConfig{
+		Mode:          "transcode",
+		VideoCodec:    "h264",
+		AudioCodec:    "aac",
+		VideoQuality:  "medium",
+		AudioQuality:  "medium",
+		VideoBitrate:  "4M",
+		AudioBitrate:  "192k",
+		HardwareAccel: "auto",
+		Rules:         rules,
+	}}
+
+	tests := []struct {
+		name        string
+		url         string
+		channel     *m3u.Channel
+		wantProfile string
+		wantVideo   string
+		wantBitrate string
+		wantHeight  int
+		wantDevice  string
+	}{
+		{
+			name:        "global defaults",
+			url:         "/stream/x",
+			channel:     &m3u.Channel{Name: "Kids TV", Group: "Kids"},
+			wantProfile: "default",
+			wantVideo:   "h264",
+			wantBitrate: "4M",
+			wantDevice:  "auto",
+		},
+		{
+			name:        "group rule",
+			url:         "/stream/x",
+			channel:     &m3u.Channel{Name: "ESPN 4K", Group: "Sports"},
+			wantProfile: "sports4k",
+			wantVideo:   "h265",
+			wantBitrate: "4M",
+			wantHeight:  1080,
+			wantDevice:  "nvidia",
+		},
+		{
+			name:        "copy rule",
+			url:         "/stream/x",
+			channel:     &m3u.Channel{Name: "BBC News", Group: "News"},
+			wantProfile: "news",
+			wantVideo:   "copy",
+			wantBitrate: "4M",
+			wantDevice:  "auto",
+		},
+		{
+			name:        "query parameter wins over rule",
+			url:         "/stream/x?profile=mobile",
+			channel:     &m3u.Channel{Name: "BBC News", Group: "News"},
+			wantProfile: "mobile",
+			wantVideo:   "h264",
+			wantBitrate: "2M",
+			wantHeight:  480,
+			wantDevice:  "auto",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := st.resolveSettings(httptest.NewRequest("GET", tt.url, nil), tt.channel)
+			if err != nil {
+				t.Fatalf("resolveSettings failed: %v", err)
+			}
+			if settings.profileName != tt.wantProfile {
+				t.Errorf("Expected profile %q, got %q", tt.wantProfile, settings.profileName)
+			}
+			if settings.videoCodec != tt.wantVideo {
+				t.Errorf("Expected video codec %q, got %q", tt.wantVideo, settings.videoCodec)
+			}
+			if settings.videoBitrate != tt.wantBitrate {
+				t.Errorf("Expected video bitrate %q, got %q", tt.wantBitrate, settings.videoBitrate)
+			}
+			if settings.maxHeight != tt.wantHeight {
+				t.Errorf("Expected max height %d, got %d", tt.wantHeight, settings.maxHeight)
+			}
+			if settings.deviceType != tt.wantDevice {
+				t.Errorf("Expected device %q, got %q", tt.wantDevice, settings.deviceType)
+			}
+		})
+	}
+
+	_, err = st.resolveSettings(httptest.NewRequest("GET", "/stream/x?profile=missing", nil), nil)
+	if !errors.Is(err, transcode.ErrUnknownProfile) {
+		t.Errorf("Expected ErrUnknownProfile, got %v", err)
+	}
+}
+
+func TestResolveSettingsClientProfile(t *testing.T) {
+	clients, err := transcode.ParseClientProfiles([]byte(`{"profiles": [{"name": "plex", "user_agents": ["Plex"]}]}`))
+	if err != nil {
+		t.Fatalf("ParseClientProfiles() error = %v", err)
+	}
+	st := &StreamTranscoder{config: &TranscoderConfig{
+		Mode:          "transcode",
+		VideoCodec:    "h265",
+		AudioCodec:    "aac",
+		VideoQuality:  "medium",
+		AudioQuality:  "medium",
+		VideoBitrate:  "3M",
+		AudioBitrate:  "192k",
+		HardwareAccel: "auto",
+		Clients:       clients,
+	}}
+
+	tests := []struct {
+		name          string
+		mode          string
+		url           string
+		userAgent     string
+		wantClient    string
+		wantVideo     string
+		wantContainer string
+		wantHeight    int
+	}{
+		{
+			name:          "unknown client keeps settings",
+			url:           "/stream/x",
+			userAgent:     "curl/8.0",
+			wantClient:    "none",
+			wantVideo:     "h265",
+			wantContainer: "mpegts",
+		},
+		{
+			name:          "plex supports hevc",
+			url:           "/stream/x",
+			userAgent:     "PlexMediaServer/1.40.0",
+			wantClient:    "plex",
+			wantVideo:     "h265",
+			wantContainer: "mpegts",
+		},
+		{
+			name:          "browser is not matched by user agent",
+			url:           "/stream/x",
+			userAgent:     "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0",
+			wantClient:    "none",
+			wantVideo:     "h265",
+			wantContainer: "mpegts",
+		},
+		{
+			name:          "browser by name gets h264 in mp4",
+			url:           "/stream/x?client=browser",
+			userAgent:     "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0",
+			wantClient:    "browser",
+			wantVideo:     "h264",
+			wantContainer: "mp4",
+			wantHeight:    1080,
+		},
+		{
+			name:          "copy mode keeps the container",
+			mode:          "copy",
+			url:           "/stream/x?client=browser",
+			wantClient:    "browser",
+			wantVideo:     "copy",
+			wantContainer: "mpegts",
+		},
+		{
+			name:          "query parameter wins over user agent",
+			url:           "/stream/x?client=browser",
+			userAgent:     "PlexMediaServer/1.40.0",
+			wantClient:    "browser",
+			wantVideo:     "h264",
+			wantContainer: "mp4",
+			wantHeight:    1080,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st.config.Mode = "transcode"
+			if tt.mode != "" {
+				st.config.Mode = tt.mode
+			}
+			r := httptest.NewRequest("GET", tt.url, nil)
+			r.Header.Set("User-Agent", tt.userAgent)
+
+			settings, err := st.resolveSettings(r, nil)
+			if err != nil {
+				t.Fatalf("resolveSettings failed: %v", err)
+			}
+			if settings.clientName() != tt.wantClient {
+				t.Errorf("Expected client %q, got %q", tt.wantClient, settings.clientName())
+			}
+			if settings.videoCodec != tt.wantVideo {
+				t.Errorf("Expected video codec %q, got %q", tt.wantVideo, settings.videoCodec)
+			}
+			if settings.container != tt.wantContainer {
+				t.Errorf("Expected container %q, got %q", tt.wantContainer, settings.container)
+			}
+			if settings.maxHeight != tt.wantHeight {
+				t.Errorf("Expected max height %d, got %d", tt.wantHeight, settings.maxHeight)
+			}
+		})
+	}
+
+	_, err = st.resolveSettings(httptest.NewRequest("GET", "/stream/x?client=missing", nil), nil)
+	if !errors.Is(err, transcode.ErrUnknownClientProfile) {
+		t.Errorf("Expected ErrUnknownClientProfile, got %v", err)
+	}
+}
@@ -2,6 +2,8 @@
 package proxy
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,6 +11,15 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/savid/iptv-proxy/pkg/logging"
+	"github.com/savid/iptv-proxy/pkg/mpegts"
+	"github.com/savid/iptv-proxy/pkg/tracing"
+	"github.com/savid/iptv-proxy/pkg/types"
+	"github.com/savid/iptv-proxy/pkg/upstream"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -16,6 +27,10 @@ var (
 	ErrUnsupportedScheme = errors.New("unsupported URL scheme")
 	// ErrMissingHost is returned when the URL has no host.
 	ErrMissingHost = errors.New("missing host in URL")
+	// ErrUpstreamStatus is returned when a reconnect gets a non-OK response.
+	ErrUpstreamStatus = errors.New("upstream returned unexpected status")
+	// ErrNoSpareTuner is returned when a background probe finds every tuner in use.
+	ErrNoSpareTuner = errors.New("no spare tuner for probe")
 )
 
 // getHopHeaders returns HTTP headers that should not be forwarded when proxying.
@@ -32,42 +47,95 @@ func getHopHeaders() []string {
 	}
 }
 
+// Upstream reconnect settings for live copy streams.
+const (
+	// maxUpstreamReconnects bounds consecutive reconnects to a live upstream.
+	maxUpstreamReconnects = 3
+	// upstreamReconnectDelay is the pause before a reconnect, multiplied by the attempt.
+	upstreamReconnectDelay = 500 * time.Millisecond
+)
+
+// CopyStreamer proxies upstream streams to clients without transcoding.
+// MPEG-TS streams pass through the mpegts repair layer, and live upstreams
+// that drop are reconnected and spliced into the same client response.
+type CopyStreamer struct {
+	client         *upstream.Client
+	tables         *mpegts.TableCache
+	sessions       *sessionRegistry
+	reconnectDelay time.Duration
+	logger         *logrus.Logger
+}
+
+// defaultStreamer serves Stream, sharing its connection pool across requests.
+var defaultStreamer = NewCopyStreamer(discardLogger())
+
+// discardLogger returns a logger that writes nowhere.
+func discardLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// NewCopyStreamer creates a copy streamer using the default upstream client.
+// Lines of a request are logged through its request log, if any.
+func NewCopyStreamer(logger *logrus.Logger) *CopyStreamer {
+	return &CopyStreamer{
+		client:         upstream.Default(),
+		tables:         mpegts.NewTableCache(),
+		sessions:       newSessionRegistry(),
+		reconnectDelay: upstreamReconnectDelay,
+		logger:         logger,
+	}
+}
+
 // Stream handles proxying of HTTP streams from a target URL to the client.
 // It validates the target URL, copies headers, and streams the response body.
 func Stream(w http.ResponseWriter, r *http.Request, targetURL string) error {
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
-		},
-		Timeout: 120 * time.Second,
-	}
+	return defaultStreamer.Stream(w, r, targetURL)
+}
 
-	if err := validateURL(targetURL); err != nil {
-		return err
+// SetUpstream replaces the client used to request upstreams.
+func (s *CopyStreamer) SetUpstream(client *upstream.Client) {
+	if client != nil {
+		s.client = client
 	}
+}
 
-	req, err := http.NewRequestWithContext(r.Context(), "GET", targetURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
+// Sessions returns a snapshot of the active copy sessions.
+func (s *CopyStreamer) Sessions() []types.TranscodeSession {
+	return s.sessions.list()
+}
+
+// Stream proxies the target URL to the client. MPEG-TS bodies are repaired
+// packet by packet; anything else is copied unchanged.
+func (s *CopyStreamer) Stream(w http.ResponseWriter, r *http.Request, targetURL string) error {
+	return s.stream(w, r, targetURL, false)
+}
 
-	copyHeaders(req.Header, r.Header)
+// StreamArchive proxies a catch-up archive. Archives end when the programme
+// does, so they are never reconnected, even without a Content-Length.
+func (s *CopyStreamer) StreamArchive(w http.ResponseWriter, r *http.Request, targetURL string) error {
+	return s.stream(w, r, targetURL, true)
+}
 
-	// Only set User-Agent if client didn't provide one
-	if req.Header.Get("User-Agent") == "" {
-		req.Header.Set("User-Agent", "IPTV-Proxy/1.0")
-	}
+// stream proxies the target URL, reconnecting live upstreams that drop.
+func (s *CopyStreamer) stream(w http.ResponseWriter, r *http.Request, targetURL string, archive bool) (err error) {
+	ctx, span := tracing.Start(r.Context(), "proxy.Stream", attribute.String("upstream.host", upstreamHost(targetURL)))
+	defer func() { tracing.End(span, err) }()
+	r = r.WithContext(ctx)
 
-	// Only set Accept if client didn't provide one
-	if req.Header.Get("Accept") == "" {
-		req.Header.Set("Accept", "*/*")
+	if err := validateURL(targetURL); err != nil {
+		return err
 	}
 
-	resp, err := httpClient.Do(req)
+	_, connectSpan := tracing.Start(ctx, "upstream.Connect")
+	resp, err := s.fetch(r, targetURL)
+	if err == nil {
+		connectSpan.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	}
+	tracing.End(connectSpan, err)
 	if err != nil {
-		return fmt.Errorf("failed to fetch stream: %w", err)
+		return err
 	}
 	defer func() {
 		_ = resp.Body.Close()
@@ -87,19 +155,102 @@ func Stream(w http.ResponseWriter, r *http.Request, targetURL string) error {
 
 	w.WriteHeader(resp.StatusCode)
 
-	ctx := r.Context()
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
-		_, _ = io.Copy(w, resp.Body)
-	}()
+	_, firstByteSpan := tracing.Start(ctx, "upstream.FirstByte")
+	body := bufio.NewReaderSize(resp.Body, 64*1024)
+	peek, _ := body.Peek(mpegts.DetectSize)
+	firstByteSpan.SetAttributes(attribute.Bool("mpegts", mpegts.Detect(peek)))
+	tracing.End(firstByteSpan, nil)
+	if resp.StatusCode != http.StatusOK || !mpegts.Detect(peek) {
+		_, err := io.Copy(w, body)
+		return s.streamResult(r.Context(), err)
+	}
+
+	repairer := mpegts.NewRepairer(body, s.tables, targetURL)
+	session := s.sessions.startCopy(targetURL, repairer.Stats)
+	defer s.sessions.finish(session.ID)
+
+	// Only live streams are reconnected; archives and bodies with a known
+	// length are recordings that simply ended
+	live := !archive && resp.ContentLength < 0
 
-	select {
-	case <-ctx.Done():
+	buf := make([]byte, 32*1024)
+	reconnects := 0
+	for {
+		n, readErr := repairer.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return s.streamResult(r.Context(), err)
+			}
+			s.sessions.addBytes(session.ID, int64(n))
+			reconnects = 0
+		}
+		if readErr == nil {
+			continue
+		}
+
+		if r.Context().Err() != nil || !live {
+			return s.streamResult(r.Context(), readErr)
+		}
+
+		logging.Entry(r.Context(), s.logger).Warnf("Upstream stream ended (%v), reconnecting: %s", readErr, targetURL)
+		span.AddEvent("upstream reconnect", trace.WithAttributes(attribute.String("error", readErr.Error())))
+		_ = resp.Body.Close()
+
+		next, err := s.reconnect(r, targetURL, &reconnects)
+		if err != nil {
+			return s.streamResult(r.Context(), err)
+		}
+		resp = next
+		repairer.Splice(bufio.NewReaderSize(resp.Body, 64*1024))
+	}
+}
+
+// fetch requests the upstream with the configured headers and the client's
+// allowlisted headers.
+func (s *CopyStreamer) fetch(r *http.Request, targetURL string) (*http.Response, error) {
+	resp, err := s.client.Get(r.Context(), targetURL, r.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stream: %w", err)
+	}
+	return resp, nil
+}
+
+// reconnect fetches the upstream again, retrying with a growing delay until
+// the reconnect limit is reached.
+func (s *CopyStreamer) reconnect(r *http.Request, targetURL string, attempt *int) (*http.Response, error) {
+	var lastErr error
+	for *attempt < maxUpstreamReconnects {
+		*attempt++
+
+		select {
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		case <-time.After(time.Duration(*attempt) * s.reconnectDelay):
+		}
+
+		resp, err := s.fetch(r, targetURL)
+		if err == nil && resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		if err == nil {
+			_ = resp.Body.Close()
+			err = fmt.Errorf("%w: %s", ErrUpstreamStatus, resp.Status)
+		}
+		logging.Entry(r.Context(), s.logger).Warnf("Upstream reconnect %d/%d failed: %v", *attempt, maxUpstreamReconnects, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// streamResult maps the end of a copy to the error returned to the handler.
+func (s *CopyStreamer) streamResult(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
 		return ctx.Err()
-	case <-done:
+	}
+	if err == nil || errors.Is(err, io.EOF) {
 		return nil
 	}
+	return err
 }
 
 func copyHeaders(dst, src http.Header) {
@@ -120,6 +271,16 @@ func copyHeaders(dst, src http.Header) {
 	}
 }
 
+// upstreamHost returns the host of an upstream URL for span attributes,
+// leaving out the path and query that often carry account credentials.
+func upstreamHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
 func validateURL(rawURL string) error {
 	u, err := url.Parse(rawURL)
 	if err != nil {
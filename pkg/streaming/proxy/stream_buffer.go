@@ -22,6 +22,7 @@ func DefaultBufferConfig() types.BufferConfig {
 func DefaultTranscoderConfig() *TranscoderConfig {
 	bufConfig := DefaultBufferConfig()
 	return &TranscoderConfig{
+		Mode:                "copy",
 		VideoCodec:          "copy",
 		AudioCodec:          "copy",
 		VideoBitrate:        "copy",
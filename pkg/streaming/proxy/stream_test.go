@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/mpegts"
+	"github.com/savid/iptv-proxy/pkg/process/processtest"
+	"github.com/savid/iptv-proxy/pkg/tracing/tracingtest"
+)
+
+func TestCopyStreamerRepairsAndReconnects(t *testing.T) {
+	packets := processtest.MPEGTS(20)
+
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		switch hits.Add(1) {
+		case 1:
+			// Flushing first makes the body chunked, like a live stream
+			w.(http.Flusher).Flush()
+			// Garbage, ten packets, then a truncated packet as the connection drops
+			_, _ = w.Write([]byte{0x00, 0x01, 0x02})
+			_, _ = w.Write(packets[:10*mpegts.PacketSize])
+			_, _ = w.Write(packets[10*mpegts.PacketSize:][:90])
+		case 2:
+			// The upstream comes back mid-stream with unrelated counters
+			w.(http.Flusher).Flush()
+			_, _ = w.Write(packets[15*mpegts.PacketSize:])
+		default:
+			http.Error(w, "gone", http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	streamer := NewCopyStreamer(discardLogger())
+	streamer.reconnectDelay = time.Millisecond
+
+	req := httptest.NewRequest(http.MethodGet, "/stream/x", nil)
+	rec := httptest.NewRecorder()
+	if err := streamer.Stream(rec, req, upstream.URL); err == nil {
+		t.Errorf("Stream() error = nil, want the failed reconnect")
+	}
+
+	out := rec.Body.Bytes()
+	if len(out)%mpegts.PacketSize != 0 {
+		t.Fatalf("output length %d is not packet aligned", len(out))
+	}
+
+	// 10 packets, a discontinuity packet for the splice and 5 packets
+	if got := len(out) / mpegts.PacketSize; got != 16 {
+		t.Fatalf("got %d packets, want 16", got)
+	}
+	if !bytes.Equal(out[:10*mpegts.PacketSize], packets[:10*mpegts.PacketSize]) {
+		t.Errorf("packets before the splice were modified")
+	}
+	splice := mpegts.Packet(out[10*mpegts.PacketSize:][:mpegts.PacketSize])
+	if !splice.Discontinuity() || splice.HasPayload() {
+		t.Errorf("splice is not marked with a discontinuity packet")
+	}
+	if !bytes.Equal(out[11*mpegts.PacketSize:], packets[15*mpegts.PacketSize:]) {
+		t.Errorf("packets after the splice were modified")
+	}
+
+	// Data after the first reconnect resets the attempts, so the final drop
+	// is retried the full number of times
+	if hits.Load() != 2+maxUpstreamReconnects {
+		t.Errorf("upstream requests = %d, want %d", hits.Load(), 2+maxUpstreamReconnects)
+	}
+	if len(streamer.Sessions()) != 0 {
+		t.Errorf("session was not removed after the stream ended")
+	}
+}
+
+func TestCopyStreamerPassesThroughOtherContent(t *testing.T) {
+	playlist := []byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\nsegment0.ts\n")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		_, _ = w.Write(playlist)
+	}))
+	defer upstream.Close()
+
+	streamer := NewCopyStreamer(discardLogger())
+	req := httptest.NewRequest(http.MethodGet, "/stream/x", nil)
+	rec := httptest.NewRecorder()
+	if err := streamer.Stream(rec, req, upstream.URL); err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+
+	if !bytes.Equal(rec.Body.Bytes(), playlist) {
+		t.Errorf("body = %q, want the playlist unchanged", rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/vnd.apple.mpegurl" {
+		t.Errorf("Content-Type = %q, want the upstream type", got)
+	}
+}
+
+func TestCopyStreamerTracesStages(t *testing.T) {
+	exporter := tracingtest.Record(t)
+
+	packets := processtest.MPEGTS(10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		_, _ = w.Write(packets)
+	}))
+	defer upstream.Close()
+
+	streamer := NewCopyStreamer(discardLogger())
+	req := httptest.NewRequest(http.MethodGet, "/stream/x", nil)
+	rec := httptest.NewRecorder()
+	if err := streamer.Stream(rec, req, upstream.URL+"/live/user/pass/1.ts"); err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+
+	stream, ok := tracingtest.Find(exporter, "proxy.Stream")
+	if !ok {
+		t.Fatalf("spans = %v, want proxy.Stream", tracingtest.Names(exporter))
+	}
+	for _, attr := range stream.Attributes {
+		if attr.Key == "upstream.host" && strings.Contains(attr.Value.AsString(), "pass") {
+			t.Errorf("upstream.host = %q leaks the URL path", attr.Value.AsString())
+		}
+	}
+	for _, name := range []string{"upstream.Connect", "upstream.FirstByte"} {
+		span, ok := tracingtest.Find(exporter, name)
+		if !ok {
+			t.Errorf("spans = %v, missing %s", tracingtest.Names(exporter), name)
+			continue
+		}
+		if span.Parent.SpanID() != stream.SpanContext.SpanID() {
+			t.Errorf("%s is not a child of proxy.Stream", name)
+		}
+	}
+}
+
+func TestCopyStreamerForwardsAllowedHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Content-Type", "video/mp2t")
+		_, _ = w.Write(processtest.MPEGTS(5))
+	}))
+	defer upstream.Close()
+
+	streamer := NewCopyStreamer(discardLogger())
+	req := httptest.NewRequest(http.MethodGet, "/stream/x?X-Plex-Token=secret", nil)
+	req.Header.Set("X-Plex-Token", "secret")
+	req.Header.Set("User-Agent", "PlexMediaServer/1.40")
+	req.Header.Set("Range", "bytes=0-")
+	rec := httptest.NewRecorder()
+	if err := streamer.Stream(rec, req, upstream.URL); err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+
+	if got.Get("X-Plex-Token") != "" {
+		t.Errorf("X-Plex-Token was forwarded upstream")
+	}
+	if got.Get("User-Agent") != "PlexMediaServer/1.40" {
+		t.Errorf("User-Agent = %q, want the client's user agent as none is configured", got.Get("User-Agent"))
+	}
+	if got.Get("Range") != "bytes=0-" {
+		t.Errorf("Range = %q, want the client's range", got.Get("Range"))
+	}
+}
@@ -2,6 +2,8 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -11,73 +13,170 @@ import (
 
 	"github.com/savid/iptv-proxy/config"
 	"github.com/savid/iptv-proxy/pkg/buffer"
+	"github.com/savid/iptv-proxy/pkg/events"
 	"github.com/savid/iptv-proxy/pkg/hardware"
+	"github.com/savid/iptv-proxy/pkg/logging"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/savid/iptv-proxy/pkg/process"
 	"github.com/savid/iptv-proxy/pkg/streaming/transcode"
+	"github.com/savid/iptv-proxy/pkg/tracing"
+	"github.com/savid/iptv-proxy/pkg/tuner"
 	"github.com/savid/iptv-proxy/pkg/types"
+	"github.com/savid/iptv-proxy/pkg/upstream"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Constants.
 const (
 	adaptive  = "adaptive"
 	codecCopy = "copy"
+
+	// defaultProbeCacheTTL is used when no probe cache TTL is configured.
+	defaultProbeCacheTTL = 6 * time.Hour
+	// defaultHardwareCooldown is used when no hardware failure cooldown is configured.
+	defaultHardwareCooldown = 5 * time.Minute
+	// maxStartAttempts bounds how many devices a session tries before giving up.
+	maxStartAttempts = 4
 )
 
 // StreamTranscoder handles transcoding and proxying of IPTV streams.
 type StreamTranscoder struct {
-	selector *hardware.Selector
-	config   *TranscoderConfig
-	logger   *log.Logger
+	selector  *hardware.Selector
+	scheduler *hardware.Scheduler
+	config    *TranscoderConfig
+	probes    *transcode.ProbeCache
+	sessions  *sessionRegistry
+	runner    process.Runner
+	upstream  *upstream.Client
+	events    *events.Bus
+	tuners    *tuner.Pool
+	logger    *logrus.Logger
 }
 
 // TranscoderConfig holds configuration for the stream transcoder.
 type TranscoderConfig struct {
+	Mode                string
 	VideoCodec          string
 	AudioCodec          string
+	VideoQuality        string
+	AudioQuality        string
 	VideoBitrate        string
 	AudioBitrate        string
 	HardwareAccel       string
+	HardwareDevice      string
+	SessionLimits       map[string]int
+	HardwareCooldown    time.Duration
+	Rules               *transcode.RuleSet
+	Clients             *transcode.ClientProfileSet
+	MaxWidth            int
+	MaxHeight           int
+	Framerate           float64
+	Deinterlace         string
 	BufferSize          int
 	BufferPrefetchRatio float64
 	MinThreshold        int
 	MaxRetries          int
 	RetryDelay          time.Duration
+	ProbeCacheTTL       time.Duration
+	// Runner starts ffmpeg and ffprobe. Nil uses the real binaries.
+	Runner process.Runner
 }
 
-// NewStreamTranscoder creates a new stream transcoder instance.
-func NewStreamTranscoder(cfg *TranscoderConfig, logger *log.Logger) (*StreamTranscoder, error) {
+// NewStreamTranscoder creates a new stream transcoder instance. Lines of a
+// stream are logged through its request log, if any.
+func NewStreamTranscoder(cfg *TranscoderConfig, logger *logrus.Logger) (*StreamTranscoder, error) {
+	runner := cfg.Runner
+	if runner == nil {
+		runner = process.NewExecRunner()
+	}
+
 	// Initialize hardware detector and selector
-	detector := hardware.NewDetector(logger)
-	selector := hardware.NewSelector(detector, types.HardwareType(cfg.HardwareAccel), logger)
+	stdLogger := log.New(logger.Writer(), "", 0)
+	detector := hardware.NewDetector(stdLogger)
+	detector.SetRunner(runner)
+	selector := hardware.NewSelector(detector, types.HardwareType(cfg.HardwareAccel), stdLogger)
 
 	if err := selector.Initialize(); err != nil {
 		return nil, fmt.Errorf("failed to initialize hardware selector: %w", err)
 	}
 
-	return &StreamTranscoder{
-		selector: selector,
-		config:   cfg,
-		logger:   logger,
-	}, nil
-}
+	probeCacheTTL := cfg.ProbeCacheTTL
+	if probeCacheTTL <= 0 {
+		probeCacheTTL = defaultProbeCacheTTL
+	}
 
-// TranscodeStream handles transcoding of a stream from the given URL.
-func (st *StreamTranscoder) TranscodeStream(w http.ResponseWriter, r *http.Request, targetURL string) error {
-	ctx := r.Context()
+	st := &StreamTranscoder{
+		selector:  selector,
+		scheduler: hardware.NewScheduler(selector, cfg.SessionLimits, stdLogger),
+		config:    cfg,
+		sessions:  newSessionRegistry(),
+		runner:    runner,
+		upstream:  upstream.Default(),
+		logger:    logger,
+	}
+	st.probes = transcode.NewProbeCache(probeCacheTTL, func(ctx context.Context, url string) (transcode.SourceInfo, error) {
+		// A probe opens its own upstream connection, so it needs a spare tuner
+		if st.tuners != nil {
+			lease, ok := st.tuners.TryAcquire(tuner.PurposeProbe)
+			if !ok {
+				return transcode.SourceInfo{}, ErrNoSpareTuner
+			}
+			defer lease.Release()
+		}
+		return transcode.ProbeSourceWith(ctx, runner, url, st.upstream.InputOptions()...)
+	})
+	return st, nil
+}
 
-	// Select hardware based on configuration
-	// For backward compatibility with old config, use "auto" if hardware accel is set
-	deviceType := "auto"
-	deviceID := 0
-	if st.config.HardwareAccel == "none" || st.config.HardwareAccel == "" {
-		deviceType = "none"
+// SetUpstream sets the client whose headers and proxy FFmpeg and ffprobe
+// use for upstreams. It must be called before streams are served.
+func (st *StreamTranscoder) SetUpstream(client *upstream.Client) {
+	if client != nil {
+		st.upstream = client
 	}
+}
+
+// SetEvents publishes an event to bus when a session falls back to another
+// device after a hardware failure.
+func (st *StreamTranscoder) SetEvents(bus *events.Bus) {
+	st.events = bus
+}
+
+// SetTuners makes background probes hold a spare tuner of pool, skipping
+// them while every tuner is in use. It must be called before streams are
+// served.
+func (st *StreamTranscoder) SetTuners(pool *tuner.Pool) {
+	st.tuners = pool
+}
 
-	hw, err := st.selector.SelectHardware(deviceType, deviceID)
+// TranscodeStream handles transcoding of a stream from the given URL.
+// The channel, when known, is used to select a per-channel transcoding profile.
+func (st *StreamTranscoder) TranscodeStream(w http.ResponseWriter, r *http.Request, targetURL string, channel *m3u.Channel) (err error) {
+	ctx, span := tracing.Start(r.Context(), "proxy.TranscodeStream", attribute.String("upstream.host", upstreamHost(targetURL)))
+	defer func() { tracing.End(span, err) }()
+	// Lines of the session, including FFmpeg output, carry the request fields
+	logger := logging.Entry(ctx, st.logger)
+
+	settings, err := st.resolveSettings(r, channel)
 	if err != nil {
-		return fmt.Errorf("failed to select hardware: %w", err)
+		return err
+	}
+	fields := logrus.Fields{"client": settings.clientName()}
+	if channel != nil {
+		fields["channel"] = channel.Name
+		span.SetAttributes(attribute.String("channel", channel.Name))
 	}
+	logging.AddFields(ctx, fields)
+	span.SetAttributes(attribute.String("profile", settings.profileName), attribute.String("client", settings.clientName()))
 
-	st.logger.Printf("Transcoding stream with video=%s, audio=%s, hardware=%s", st.config.VideoCodec, st.config.AudioCodec, hw.Type)
+	// Tune-ins never wait for ffprobe: the source is known from earlier
+	// sessions and background probes, or safe defaults are used
+	source, known := st.probes.Get(targetURL)
+	span.SetAttributes(attribute.Bool("source.known", known))
+
+	// In auto mode decide per track whether the source can be copied
+	decision := st.decide(ctx, &settings, source, known, logger)
 
 	// Create buffer configuration
 	bufferConfig := types.BufferConfig{
@@ -86,18 +185,17 @@ func (st *StreamTranscoder) TranscodeStream(w http.ResponseWriter, r *http.Reque
 		MinThreshold:  st.config.MinThreshold,
 		MaxRetries:    st.config.MaxRetries,
 		RetryDelay:    st.config.RetryDelay,
+		KeyframeJoin:  settings.container == transcode.ContainerMPEGTS,
 	}
 
-	// Probe the stream to get information
-	streamInfo, err := transcode.ProbeStream(targetURL)
-	if err != nil {
-		st.logger.Printf("Failed to probe stream, using defaults: %v", err)
-		// Continue with defaults
+	streamInfo := transcode.DefaultStreamInfo
+	if known {
+		streamInfo = source.Stream
 	}
 
 	// Get video and audio bitrates
-	videoBitrate := st.config.VideoBitrate
-	audioBitrate := st.config.AudioBitrate
+	videoBitrate := settings.videoBitrate
+	audioBitrate := settings.audioBitrate
 
 	// Apply adaptive bitrate if configured
 	if videoBitrate == adaptive || audioBitrate == adaptive {
@@ -113,13 +211,6 @@ func (st *StreamTranscoder) TranscodeStream(w http.ResponseWriter, r *http.Reque
 	// Create quality mapper
 	qualityMapper := transcode.NewQualityMapper()
 
-	// Create profile using the new config structure
-	// Determine transcode mode based on codecs
-	transcodeMode := "transcode"
-	if st.config.VideoCodec == codecCopy && st.config.AudioCodec == codecCopy {
-		transcodeMode = codecCopy
-	}
-
 	// Create config for profile creation
 	cfg := struct {
 		TranscodeMode      string
@@ -130,9 +221,9 @@ func (st *StreamTranscoder) TranscodeStream(w http.ResponseWriter, r *http.Reque
 		CustomVideoBitrate string
 		CustomAudioBitrate string
 	}{
-		TranscodeMode:      transcodeMode,
-		VideoCodec:         st.config.VideoCodec,
-		AudioCodec:         st.config.AudioCodec,
+		TranscodeMode:      settings.mode,
+		VideoCodec:         settings.videoCodec,
+		AudioCodec:         settings.audioCodec,
 		VideoQuality:       "custom", // Use custom since we have specific bitrates
 		AudioQuality:       "custom", // Use custom since we have specific bitrates
 		CustomVideoBitrate: videoBitrate,
@@ -149,61 +240,218 @@ func (st *StreamTranscoder) TranscodeStream(w http.ResponseWriter, r *http.Reque
 		CustomVideoBitrate: cfg.CustomVideoBitrate,
 		CustomAudioBitrate: cfg.CustomAudioBitrate,
 	}, qualityMapper)
+	profile.Name = settings.profileName
+	profile.MaxWidth = settings.maxWidth
+	profile.MaxHeight = settings.maxHeight
+	profile.Framerate = settings.framerate
+	profile.Deinterlace = settings.deinterlace
+	*profile = transcode.ApplyContainer(*profile, settings.container)
 
-	// Apply hardware acceleration to profile
-	appliedProfile := transcode.ApplyHardware(*profile, hw)
-
-	// Create FFmpeg transcoder directly
-	transcoder := transcode.NewFFmpegTranscoder(
-		appliedProfile,
-		hw,
-		bufferConfig,
-		st.selector,
-		targetURL,
-		st.logger,
+	// Start transcoding on the scheduled device. Hardware failures before any
+	// output is produced restart the session on the next device or the CPU.
+	var (
+		allocation *hardware.Allocation
+		transcoder *transcode.FFmpegTranscoder
+		first      []byte
 	)
+	for attempt := 1; ; attempt++ {
+		allocation, err = st.scheduler.Acquire(settings.deviceType, settings.deviceID, settings.videoCodec)
+		if err != nil {
+			return fmt.Errorf("failed to select hardware: %w", err)
+		}
+
+		logger.Infof("Transcoding stream with profile=%s, client=%s, video=%s, audio=%s, container=%s, hardware=%s:%d",
+			settings.profileName, settings.clientName(), settings.videoCodec, settings.audioCodec, settings.container,
+			allocation.Hardware.Type, allocation.Hardware.DeviceID)
+
+		logging.AddFields(ctx, logrus.Fields{
+			"device": fmt.Sprintf("%s:%d", allocation.Hardware.Type, allocation.Hardware.DeviceID),
+		})
+		transcoder, first, err = st.startTranscoder(ctx, *profile, allocation.Hardware, bufferConfig, targetURL, logger)
+		if err == nil {
+			break
+		}
+		allocation.Release()
 
-	// Start transcoding
-	if err := transcoder.Start(ctx); err != nil {
-		return fmt.Errorf("failed to start transcoder: %w", err)
+		var startupErr *transcode.StartupError
+		if !errors.As(err, &startupErr) || !startupErr.Kind.IsHardwareFailure() ||
+			allocation.Hardware.Type == types.HardwareCPU || attempt >= maxStartAttempts {
+			return err
+		}
+
+		st.selector.MarkUnhealthy(allocation.Hardware, st.hardwareCooldown())
+		logger.Warnf("Hardware %s:%d failed (%s), restarting session on another device",
+			allocation.Hardware.Type, allocation.Hardware.DeviceID, startupErr.Kind)
+		st.events.Publish(events.Event{
+			Type:    events.TypeHardwareFallback,
+			Subject: fmt.Sprintf("%s:%d", allocation.Hardware.Type, allocation.Hardware.DeviceID),
+			Message: fmt.Sprintf("Hardware %s:%d failed (%s), restarting session on another device",
+				allocation.Hardware.Type, allocation.Hardware.DeviceID, startupErr.Kind),
+			Fields: map[string]any{"url": targetURL},
+		})
 	}
+	// Channels not yet probed, expired or changed are probed once the
+	// session has closed its upstream connection
+	defer st.probes.Refresh(targetURL)
+	// The tuner held for the session is handed back first, so that the
+	// probe can take it
+	defer tuner.FromContext(ctx).Release()
+	defer allocation.Release()
 	defer func() {
 		if err := transcoder.Close(); err != nil {
-			st.logger.Printf("Error closing transcoder: %v", err)
+			logger.Warnf("Error closing transcoder: %v", err)
 		}
 	}()
+	hw := allocation.Hardware
+
+	span.SetAttributes(attribute.String("device", fmt.Sprintf("%s:%d", hw.Type, hw.DeviceID)))
 
 	// Create buffer manager
-	bufferManager := buffer.NewBufferManager(bufferConfig, st.logger)
+	bufferManager := buffer.NewBufferManager(bufferConfig, logger)
 
-	// Start buffering from transcoder output
-	if err := bufferManager.Start(ctx, transcoder); err != nil {
+	// Start buffering from transcoder output, beginning with the startup bytes
+	if err := bufferManager.Start(ctx, io.MultiReader(bytes.NewReader(first), transcoder)); err != nil {
 		return fmt.Errorf("failed to start buffer manager: %w", err)
 	}
 	defer func() {
 		if err := bufferManager.Close(); err != nil {
-			st.logger.Printf("Error closing buffer manager: %v", err)
+			logger.Warnf("Error closing buffer manager: %v", err)
 		}
 	}()
 
+	// Time until the buffer holds enough to start the client. Reads wait
+	// for the same threshold, so its errors are returned by the copy below.
+	_, thresholdSpan := tracing.Start(ctx, "buffer.MinThreshold", attribute.Int("bytes", bufferConfig.MinThreshold))
+	_ = bufferManager.WaitForData(bufferConfig.MinThreshold)
+	tracing.End(thresholdSpan, nil)
+
 	// Set response headers
-	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Content-Type", transcode.ContainerContentType(settings.container))
 	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("X-Video-Codec", st.config.VideoCodec)
-	w.Header().Set("X-Audio-Codec", st.config.AudioCodec)
+	w.Header().Set("X-Transcode-Profile", settings.profileName)
+	w.Header().Set("X-Video-Codec", settings.videoCodec)
+	w.Header().Set("X-Audio-Codec", settings.audioCodec)
 	w.Header().Set("X-Hardware-Acceleration", string(hw.Type))
+	if settings.client != nil {
+		w.Header().Set("X-Client-Profile", settings.client.Name)
+	}
+	if decision != nil {
+		w.Header().Set("X-Transcode-Decision", decision.String())
+		w.Header().Set("X-Source-Video-Codec", decision.SourceVideoCodec)
+		w.Header().Set("X-Source-Audio-Codec", decision.SourceAudioCodec)
+	}
+
+	session := st.sessions.start(targetURL, channel, settings, hw, decision)
+	defer st.sessions.finish(session.ID)
+	logging.AddFields(ctx, logrus.Fields{"session": session.ID})
 
 	// Stream to client
-	_, err = io.Copy(w, bufferManager)
+	written, err := io.Copy(st.sessions.writer(session.ID, w), bufferManager)
+	span.SetAttributes(attribute.Int64("bytes", written))
 	if err != nil && !errors.Is(err, io.EOF) {
-		st.logger.Printf("Error streaming to client: %v", err)
+		logger.Warnf("Error streaming to client: %v", err)
 		return err
 	}
 
 	// Log final statistics
 	stats := bufferManager.Stats()
-	st.logger.Printf("Stream completed - bytes: %d, underruns: %d, retries: %d",
-		stats.BytesConsumed, stats.Underruns, stats.Retries)
+	logger.Infof("Stream completed - session: %s, profile: %s, decision: %s, bytes: %d, underruns: %d, retries: %d",
+		session.ID, session.Profile, session.Decision, stats.BytesConsumed, stats.Underruns, stats.Retries)
 
 	return nil
 }
+
+// startTranscoder starts FFmpeg on the given hardware and waits for its first
+// output. On failure the process is cleaned up and the error describes why it
+// exited, so the caller can retry on another device.
+func (st *StreamTranscoder) startTranscoder(
+	ctx context.Context,
+	profile types.TranscodingProfile,
+	hw types.HardwareInfo,
+	bufferConfig types.BufferConfig,
+	targetURL string,
+	logger *logrus.Entry,
+) (_ *transcode.FFmpegTranscoder, _ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "transcode.Start",
+		attribute.String("device", fmt.Sprintf("%s:%d", hw.Type, hw.DeviceID)))
+	defer func() { tracing.End(span, err) }()
+
+	transcoder := transcode.NewFFmpegTranscoder(
+		transcode.ApplyHardware(profile, hw),
+		hw,
+		bufferConfig,
+		st.selector,
+		targetURL,
+		logger,
+	)
+	transcoder.SetRunner(st.runner)
+	transcoder.SetInputOptions(st.upstream.InputOptions())
+	transcoder.SetInputHandler(func(source transcode.SourceInfo) {
+		if st.probes.Observe(targetURL, source) {
+			logger.Infof("Source stream changed to %s", source)
+		}
+	})
+
+	_, spawnSpan := tracing.Start(ctx, "ffmpeg.Spawn")
+	err = transcoder.Start(ctx)
+	tracing.End(spawnSpan, err)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start transcoder: %w", err)
+	}
+
+	_, outputSpan := tracing.Start(ctx, "ffmpeg.FirstOutput")
+	first, err := transcoder.WaitForOutput()
+	tracing.End(outputSpan, err)
+	if err != nil {
+		if closeErr := transcoder.Close(); closeErr != nil {
+			logger.Warnf("Transcoder exited: %v", closeErr)
+		}
+		return nil, nil, err
+	}
+
+	return transcoder, first, nil
+}
+
+// hardwareCooldown returns how long a failed device is excluded from scheduling.
+func (st *StreamTranscoder) hardwareCooldown() time.Duration {
+	if st.config.HardwareCooldown > 0 {
+		return st.config.HardwareCooldown
+	}
+	return defaultHardwareCooldown
+}
+
+// decide resolves auto mode into concrete per-track codecs using the known
+// source codecs of the channel. An unknown source is transcoded, which is
+// always safe. It returns nil when the stream is not in auto mode.
+func (st *StreamTranscoder) decide(ctx context.Context, settings *streamSettings, source transcode.SourceInfo, known bool, logger *logrus.Entry) *transcode.Decision {
+	if settings.mode != modeAuto {
+		return nil
+	}
+
+	_, span := tracing.Start(ctx, "transcode.Decide")
+	defer span.End()
+
+	decision := transcode.DecideCodecs(source.Codecs, known, settings.videoCodec, settings.audioCodec, settings.client)
+	decision.Cached = known
+	span.SetAttributes(attribute.String("decision", decision.String()), attribute.Bool("cached", known))
+
+	settings.videoCodec = decision.VideoCodec
+	settings.audioCodec = decision.AudioCodec
+	settings.mode = modeTranscode
+	if decision.VideoCodec == codecCopy && decision.AudioCodec == codecCopy {
+		settings.mode = codecCopy
+	}
+
+	logger.Infof("Auto transcode decision: %s (cached=%v)", decision.String(), known)
+	return &decision
+}
+
+// HardwareLoad returns the active sessions per hardware device.
+func (st *StreamTranscoder) HardwareLoad() []hardware.DeviceLoad {
+	return st.scheduler.Load()
+}
+
+// Sessions returns a snapshot of the active streaming sessions.
+func (st *StreamTranscoder) Sessions() []types.TranscodeSession {
+	return st.sessions.list()
+}
@@ -2,20 +2,26 @@
 package transcode
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"os/exec"
+
+	"github.com/savid/iptv-proxy/pkg/process"
 )
 
 // Codec constants.
 const (
-	codecH264 = "h264"
-	codecH265 = "h265"
-	codecCopy = "copy"
-	codecAAC  = "aac"
-	codecMP3  = "mp3"
-	auto      = "auto"
+	codecH264       = "h264"
+	codecH265       = "h265"
+	codecHEVC       = "hevc"
+	codecMPEG2Video = "mpeg2video"
+	codecCopy       = "copy"
+	codecAAC        = "aac"
+	codecAC3        = "ac3"
+	codecEAC3       = "eac3"
+	codecDTS        = "dts"
+	codecTrueHD     = "truehd"
+	codecMP3        = "mp3"
+	auto            = "auto"
 )
 
 // StreamCodecs contains codec information for a stream.
@@ -27,58 +33,32 @@ type StreamCodecs struct {
 	AudioChannels int
 }
 
-// AnalyzeStream probes a stream to get its codec information.
-func AnalyzeStream(url string) (StreamCodecs, error) {
-	cmd := exec.Command("ffprobe",
+// AnalyzeStream probes a stream to get its codec information. Input
+// options, such as the upstream headers, are passed before the URL.
+func AnalyzeStream(url string, inputOptions ...string) (StreamCodecs, error) {
+	return AnalyzeStreamWith(process.NewExecRunner(), url, inputOptions...)
+}
+
+// AnalyzeStreamWith probes a stream's codecs using the given process runner.
+func AnalyzeStreamWith(runner process.Runner, url string, inputOptions ...string) (StreamCodecs, error) {
+	args := []string{
 		"-v", "quiet",
 		"-print_format", "json",
 		"-show_streams",
 		"-analyzeduration", "1000000", // 1 second
 		"-probesize", "1000000", // 1MB
-		url,
-	)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return StreamCodecs{}, fmt.Errorf("ffprobe failed: %w, stderr: %s", err, stderr.String())
 	}
-
-	// Parse JSON output
-	var probeData struct {
-		Streams []struct {
-			CodecType string `json:"codec_type"`
-			CodecName string `json:"codec_name"`
-			Profile   string `json:"profile"`
-			Level     int    `json:"level"`
-			Channels  int    `json:"channels"`
-		} `json:"streams"`
+	args = append(args, inputOptions...)
+	stdout, stderr, err := runner.Output(context.Background(), "ffprobe", append(args, url)...)
+	if err != nil {
+		return StreamCodecs{}, fmt.Errorf("ffprobe failed: %w, stderr: %s", err, stderr)
 	}
 
-	if err := json.Unmarshal(stdout.Bytes(), &probeData); err != nil {
-		return StreamCodecs{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	output, err := parseProbeOutput(stdout)
+	if err != nil {
+		return StreamCodecs{}, err
 	}
-
-	codecs := StreamCodecs{}
-
-	// Find video and audio streams
-	for _, stream := range probeData.Streams {
-		switch stream.CodecType {
-		case "video":
-			codecs.VideoCodec = stream.CodecName
-			codecs.VideoProfile = stream.Profile
-			if stream.Level > 0 {
-				codecs.VideoLevel = fmt.Sprintf("%.1f", float64(stream.Level)/10.0)
-			}
-		case "audio":
-			codecs.AudioCodec = stream.CodecName
-			codecs.AudioChannels = stream.Channels
-		}
-	}
-
-	return codecs, nil
+	return output.streamCodecs(), nil
 }
 
 // GetOptimalCodecs returns the best video and audio codecs based on source.
@@ -89,8 +69,8 @@ func GetOptimalCodecs(codecs StreamCodecs, preferredVideoCodec, preferredAudioCo
 
 	// Auto-detect video codec if needed
 	if videoCodec == auto || videoCodec == "" {
-		// Check if video needs transcoding
-		if codecs.VideoCodec == codecH264 || codecs.VideoCodec == codecH265 {
+		// Check if video needs transcoding (ffprobe reports H.265 as hevc)
+		if codecs.VideoCodec == codecH264 || codecs.VideoCodec == codecH265 || codecs.VideoCodec == codecHEVC {
 			videoCodec = codecCopy
 		} else {
 			// Default to h264 for compatibility
@@ -115,3 +95,64 @@ func GetOptimalCodecs(codecs StreamCodecs, preferredVideoCodec, preferredAudioCo
 
 	return videoCodec, audioCodec
 }
+
+// Decision records how each track of a source stream is handled.
+type Decision struct {
+	SourceVideoCodec string
+	SourceAudioCodec string
+	VideoCodec       string
+	AudioCodec       string
+	Probed           bool
+	Cached           bool
+}
+
+// DecideCodecs chooses per-track copy or transcode for auto mode. Tracks the
+// client can already play are copied; incompatible tracks are transcoded to
+// the given target codecs. Without a client profile the default compatibility
+// rules of GetOptimalCodecs apply. When the source could not be probed, both
+// tracks are transcoded since that is always safe.
+func DecideCodecs(source StreamCodecs, probed bool, targetVideoCodec, targetAudioCodec string, client *ClientProfile) Decision {
+	decision := Decision{
+		SourceVideoCodec: source.VideoCodec,
+		SourceAudioCodec: source.AudioCodec,
+		VideoCodec:       targetVideoCodec,
+		AudioCodec:       targetAudioCodec,
+		Probed:           probed,
+	}
+
+	if !probed {
+		return decision
+	}
+
+	if client != nil {
+		if source.VideoCodec != "" && client.SupportsVideo(source.VideoCodec) {
+			decision.VideoCodec = codecCopy
+		}
+		if source.AudioCodec != "" && client.SupportsAudio(source.AudioCodec) {
+			decision.AudioCodec = codecCopy
+		}
+		return decision
+	}
+
+	videoCodec, audioCodec := GetOptimalCodecs(source, auto, auto)
+	if videoCodec == codecCopy {
+		decision.VideoCodec = codecCopy
+	}
+	if audioCodec == codecCopy {
+		decision.AudioCodec = codecCopy
+	}
+
+	return decision
+}
+
+// String returns a compact description of the decision for headers and logs.
+func (d Decision) String() string {
+	source := func(codec string) string {
+		if codec == "" {
+			return "unknown"
+		}
+		return codec
+	}
+	return fmt.Sprintf("video=%s(%s) audio=%s(%s)",
+		d.VideoCodec, source(d.SourceVideoCodec), d.AudioCodec, source(d.SourceAudioCodec))
+}
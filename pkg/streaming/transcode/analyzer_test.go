@@ -0,0 +1,217 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/process/processtest"
+)
+
+func TestDecideCodecs(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    StreamCodecs
+		probed    bool
+		wantVideo string
+		wantAudio string
+	}{
+		{
+			name:      "compatible source is copied",
+			source:    StreamCodecs{VideoCodec: "h264", AudioCodec: "aac"},
+			probed:    true,
+			wantVideo: "copy",
+			wantAudio: "copy",
+		},
+		{
+			name:      "ac3 audio is transcoded while video is copied",
+			source:    StreamCodecs{VideoCodec: "h264", AudioCodec: "ac3"},
+			probed:    true,
+			wantVideo: "copy",
+			wantAudio: "aac",
+		},
+		{
+			name:      "mpeg2 video is transcoded while audio is copied",
+			source:    StreamCodecs{VideoCodec: "mpeg2video", AudioCodec: "mp3"},
+			probed:    true,
+			wantVideo: "h264",
+			wantAudio: "copy",
+		},
+		{
+			name:      "unprobed source is fully transcoded",
+			probed:    false,
+			wantVideo: "h264",
+			wantAudio: "aac",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := DecideCodecs(tt.source, tt.probed, "h264", "aac", nil)
+			if decision.VideoCodec != tt.wantVideo {
+				t.Errorf("VideoCodec = %q, want %q", decision.VideoCodec, tt.wantVideo)
+			}
+			if decision.AudioCodec != tt.wantAudio {
+				t.Errorf("AudioCodec = %q, want %q", decision.AudioCodec, tt.wantAudio)
+			}
+		})
+	}
+}
+
+func TestDecisionString(t *testing.T) {
+	decision := Decision{
+		SourceVideoCodec: "h264",
+		SourceAudioCodec: "ac3",
+		VideoCodec:       "copy",
+		AudioCodec:       "aac",
+	}
+
+	want := "video=copy(h264) audio=aac(ac3)"
+	if got := decision.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestProbeCacheRefresh(t *testing.T) {
+	var probes atomic.Int32
+	cache := NewProbeCache(time.Hour, func(_ context.Context, url string) (SourceInfo, error) {
+		probes.Add(1)
+		if url == "http://example.com/2" {
+			return SourceInfo{}, errors.New("probe failed")
+		}
+		return SourceInfo{Codecs: StreamCodecs{VideoCodec: "h264", AudioCodec: "aac"}}, nil
+	})
+
+	if _, ok := cache.Get("http://example.com/1"); ok {
+		t.Fatal("unknown URL should not be cached")
+	}
+	if !cache.Refresh("http://example.com/1") {
+		t.Fatal("first refresh should start a probe")
+	}
+	cache.Wait()
+
+	info, ok := cache.Get("http://example.com/1")
+	if !ok || info.Codecs.VideoCodec != "h264" {
+		t.Fatalf("Get() = %+v, %v, want the probed codecs", info, ok)
+	}
+	if cache.Refresh("http://example.com/1") {
+		t.Error("probed URL should not be probed again")
+	}
+
+	cache.Refresh("http://example.com/2")
+	cache.Wait()
+	if _, ok := cache.Get("http://example.com/2"); ok {
+		t.Error("failed probe should not be cached")
+	}
+	if probes.Load() != 2 {
+		t.Errorf("probes = %d, want 2", probes.Load())
+	}
+}
+
+func TestProbeCacheRefreshSingleFlight(t *testing.T) {
+	release := make(chan struct{})
+	var probes atomic.Int32
+	cache := NewProbeCache(time.Hour, func(context.Context, string) (SourceInfo, error) {
+		probes.Add(1)
+		<-release
+		return SourceInfo{}, nil
+	})
+
+	if !cache.Refresh("http://example.com/1") {
+		t.Fatal("first refresh should start a probe")
+	}
+	if cache.Refresh("http://example.com/1") {
+		t.Error("refresh should not start a second probe while one is running")
+	}
+	close(release)
+	cache.Wait()
+
+	if probes.Load() != 1 {
+		t.Errorf("probes = %d, want 1", probes.Load())
+	}
+}
+
+func TestProbeCacheObserve(t *testing.T) {
+	cache := NewProbeCache(time.Hour, nil)
+	url := "http://example.com/1"
+	probed := SourceInfo{
+		Codecs: StreamCodecs{VideoCodec: "h264", AudioCodec: "aac", AudioChannels: 2},
+		Stream: StreamInfo{Width: 1920, Height: 1080, VideoBitrate: 5000, AudioBitrate: 128},
+	}
+
+	// A new channel is known from FFmpeg output but still needs a probe
+	if cache.Observe(url, SourceInfo{Codecs: probed.Codecs, Stream: StreamInfo{Width: 1920, Height: 1080}}) {
+		t.Error("first observation should not be a change")
+	}
+	if _, ok := cache.Get(url); !ok {
+		t.Error("observed info should be cached")
+	}
+	if !cache.NeedsProbe(url) {
+		t.Error("observed info without a probe should need a probe")
+	}
+
+	// The same source keeps the probed bitrates
+	cache.Set(url, probed)
+	if cache.Observe(url, SourceInfo{Codecs: probed.Codecs, Stream: StreamInfo{Width: 1920, Height: 1080}}) {
+		t.Error("unchanged source should not be a change")
+	}
+	if info, _ := cache.Get(url); info.Stream.VideoBitrate != 5000 {
+		t.Errorf("VideoBitrate = %d, want the probed 5000", info.Stream.VideoBitrate)
+	}
+	if cache.NeedsProbe(url) {
+		t.Error("unchanged source should not need a probe")
+	}
+
+	// A new resolution replaces the entry and asks for a probe
+	changed := SourceInfo{Codecs: probed.Codecs, Stream: StreamInfo{Width: 1280, Height: 720}}
+	if !cache.Observe(url, changed) {
+		t.Error("new resolution should be a change")
+	}
+	if info, _ := cache.Get(url); info.Stream.Height != 720 {
+		t.Errorf("Height = %d, want 720", info.Stream.Height)
+	}
+	if !cache.NeedsProbe(url) {
+		t.Error("changed source should need a probe")
+	}
+}
+
+func TestProbeCacheExpiry(t *testing.T) {
+	cache := NewProbeCache(time.Millisecond, nil)
+	cache.Set("http://example.com/1", SourceInfo{Codecs: StreamCodecs{VideoCodec: "h264"}})
+
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := cache.Get("http://example.com/1"); ok {
+		t.Error("expired entry should not be returned")
+	}
+	if !cache.NeedsProbe("http://example.com/1") {
+		t.Error("expired entry should need a probe")
+	}
+}
+
+func TestProbeSourceWith(t *testing.T) {
+	runner := processtest.NewRunner(func(processtest.Call) processtest.Script {
+		return processtest.Script{Stdout: processtest.ProbeJSON("hevc", "ac3")}
+	})
+
+	info, err := ProbeSourceWith(context.Background(), runner, "http://example.com/1", "-user_agent", "Provider/2.0")
+	if err != nil {
+		t.Fatalf("ProbeSourceWith() error = %v", err)
+	}
+	if info.Codecs.VideoCodec != "hevc" || info.Codecs.AudioCodec != "ac3" || info.Codecs.AudioChannels != 2 {
+		t.Errorf("Codecs = %+v, want hevc and stereo ac3", info.Codecs)
+	}
+	if info.Stream.Width != 1920 || info.Stream.Height != 1080 || info.Stream.Framerate != 25 {
+		t.Errorf("Stream = %+v, want 1920x1080 at 25 fps", info.Stream)
+	}
+	calls := runner.Calls("ffprobe")
+	if len(calls) != 1 {
+		t.Fatalf("ffprobe calls = %d, want a single probe", len(calls))
+	}
+	args := calls[0].Args
+	if calls[0].Arg("-user_agent") != "Provider/2.0" || args[len(args)-1] != "http://example.com/1" {
+		t.Errorf("ffprobe args = %v, want the input options before the URL", args)
+	}
+}
@@ -0,0 +1,138 @@
+// Package transcode handles video and audio transcoding operations.
+package transcode
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// probeTimeout bounds a background probe of a source stream.
+const probeTimeout = 30 * time.Second
+
+// ProbeFunc probes the source stream at a URL.
+type ProbeFunc func(ctx context.Context, url string) (SourceInfo, error)
+
+// probeCacheEntry is what is known about a source stream.
+type probeCacheEntry struct {
+	info      SourceInfo
+	updatedAt time.Time
+	// probed is false while the entry only holds what FFmpeg printed, which
+	// usually lacks bitrates.
+	probed bool
+}
+
+// ProbeCache caches the source info of each channel URL so that tune-ins
+// never wait for ffprobe. Entries come from FFmpeg's own input description
+// during sessions and from ffprobe runs in the background, which are only
+// needed for new channels, expired entries or sources that changed.
+type ProbeCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	probe    ProbeFunc
+	entries  map[string]probeCacheEntry
+	inflight map[string]bool
+	wg       sync.WaitGroup
+}
+
+// NewProbeCache creates a probe cache whose entries expire after ttl, using
+// probe for background probes.
+func NewProbeCache(ttl time.Duration, probe ProbeFunc) *ProbeCache {
+	return &ProbeCache{
+		ttl:      ttl,
+		probe:    probe,
+		entries:  make(map[string]probeCacheEntry),
+		inflight: make(map[string]bool),
+	}
+}
+
+// Get returns the source info for a URL if present and not expired.
+func (c *ProbeCache) Get(url string) (SourceInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[url]
+	if !ok || time.Since(entry.updatedAt) > c.ttl {
+		return SourceInfo{}, false
+	}
+	return entry.info, true
+}
+
+// Set stores probed source info for a URL.
+func (c *ProbeCache) Set(url string, info SourceInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[url] = probeCacheEntry{
+		info:      info,
+		updatedAt: time.Now(),
+		probed:    true,
+	}
+}
+
+// Observe records the source info FFmpeg printed for a URL during a session
+// and reports whether it differs from the cached info. Unchanged info renews
+// the entry; changed or new info replaces it and marks it for a probe.
+func (c *ProbeCache) Observe(url string, info SourceInfo) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[url]
+	if ok && !entry.info.Changed(info) {
+		entry.updatedAt = time.Now()
+		c.entries[url] = entry
+		return false
+	}
+
+	c.entries[url] = probeCacheEntry{info: info, updatedAt: time.Now()}
+	return ok
+}
+
+// NeedsProbe reports whether the URL has no probed, unexpired source info.
+func (c *ProbeCache) NeedsProbe(url string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[url]
+	return !ok || !entry.probed || time.Since(entry.updatedAt) > c.ttl
+}
+
+// Refresh probes a URL in the background if it needs a probe and no probe of
+// it is running, and reports whether a probe was started. Failed probes
+// leave the entry unchanged.
+func (c *ProbeCache) Refresh(url string) bool {
+	if !c.NeedsProbe(url) {
+		return false
+	}
+
+	c.mu.Lock()
+	if c.inflight[url] {
+		c.mu.Unlock()
+		return false
+	}
+	c.inflight[url] = true
+	c.wg.Add(1)
+	c.mu.Unlock()
+
+	go func() {
+		defer c.wg.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		defer cancel()
+
+		info, err := c.probe(ctx, url)
+		if err == nil {
+			c.Set(url, info)
+		}
+
+		c.mu.Lock()
+		delete(c.inflight, url)
+		c.mu.Unlock()
+	}()
+	return true
+}
+
+// Wait blocks until the running background probes have finished.
+func (c *ProbeCache) Wait() {
+	c.wg.Wait()
+}
@@ -0,0 +1,292 @@
+// Package transcode handles video and audio transcoding operations.
+package transcode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/savid/iptv-proxy/pkg/types"
+)
+
+// Output containers supported by client profiles.
+const (
+	ContainerMPEGTS   = "mpegts"
+	ContainerMP4      = "mp4"
+	ContainerMatroska = "matroska"
+)
+
+var (
+	// ErrUnknownClientProfile is returned when a request names an undefined client profile.
+	ErrUnknownClientProfile = errors.New("unknown client profile")
+	// ErrInvalidClientProfile is returned when a client profile contains invalid settings.
+	ErrInvalidClientProfile = errors.New("invalid client profile")
+)
+
+// ClientProfile describes what a playback client can decode. Codec names use
+// ffprobe naming (h264, hevc, mpeg2video, aac, ac3, ...); the encoder names
+// h265 and mpeg2 are accepted as aliases.
+type ClientProfile struct {
+	Name        string   `json:"name"`
+	UserAgents  []string `json:"user_agents,omitempty"`
+	VideoCodecs []string `json:"video_codecs"`
+	AudioCodecs []string `json:"audio_codecs"`
+	Containers  []string `json:"containers,omitempty"`
+	MaxWidth    int      `json:"max_width,omitempty"`
+	MaxHeight   int      `json:"max_height,omitempty"`
+}
+
+// ClientProfileSet holds client profiles in User-Agent matching order.
+type ClientProfileSet struct {
+	Profiles []ClientProfile `json:"profiles"`
+}
+
+// DefaultClientProfiles returns the built-in profiles for common clients.
+// They match no User-Agent, so they only apply when requested by name or
+// enabled for a User-Agent in a client profiles file.
+func DefaultClientProfiles() *ClientProfileSet {
+	return &ClientProfileSet{
+		Profiles: []ClientProfile{
+			{
+				Name:        "plex",
+				VideoCodecs: []string{codecH264, codecHEVC, codecMPEG2Video},
+				AudioCodecs: []string{codecAAC, codecAC3, codecEAC3, codecMP3, codecMP2},
+				Containers:  []string{ContainerMPEGTS},
+			},
+			{
+				Name:        "jellyfin",
+				VideoCodecs: []string{codecH264, codecHEVC, codecMPEG2Video},
+				AudioCodecs: []string{codecAAC, codecAC3, codecEAC3, codecMP3, codecMP2, codecOpus},
+				Containers:  []string{ContainerMPEGTS},
+			},
+			{
+				Name:        "kodi",
+				VideoCodecs: []string{codecH264, codecHEVC, codecMPEG2Video, codecVP9},
+				AudioCodecs: []string{codecAAC, codecAC3, codecEAC3, codecDTS, codecMP3, codecMP2, codecOpus},
+				Containers:  []string{ContainerMPEGTS},
+			},
+			{
+				Name:        "vlc",
+				VideoCodecs: []string{codecH264, codecHEVC, codecMPEG2Video, codecVP9},
+				AudioCodecs: []string{codecAAC, codecAC3, codecEAC3, codecDTS, codecTrueHD, codecMP3, codecMP2, codecOpus},
+				Containers:  []string{ContainerMPEGTS},
+			},
+			{
+				Name:        "browser",
+				VideoCodecs: []string{codecH264},
+				AudioCodecs: []string{codecAAC, codecMP3, codecOpus},
+				Containers:  []string{ContainerMP4},
+				MaxWidth:    1920,
+				MaxHeight:   1080,
+			},
+		},
+	}
+}
+
+// LoadClientProfiles reads client profiles from a JSON file and merges them
+// with the built-in profiles. Profiles from the file are matched first and
+// replace built-in profiles with the same name; a profile that only names a
+// built-in profile and its User-Agents enables matching for that profile.
+func LoadClientProfiles(path string) (*ClientProfileSet, error) {
+	raw, err := os.ReadFile(path) // #nosec G304 - path is provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client profiles file: %w", err)
+	}
+
+	return ParseClientProfiles(raw)
+}
+
+// ParseClientProfiles parses JSON client profiles and merges them with the built-in profiles.
+func ParseClientProfiles(raw []byte) (*ClientProfileSet, error) {
+	custom := &ClientProfileSet{}
+	if err := json.Unmarshal(raw, custom); err != nil {
+		return nil, fmt.Errorf("failed to parse client profiles: %w", err)
+	}
+
+	defaults := DefaultClientProfiles()
+	names := make(map[string]bool, len(custom.Profiles))
+	for i, profile := range custom.Profiles {
+		if builtin, ok := defaults.Lookup(profile.Name); ok && len(profile.VideoCodecs) == 0 && len(profile.AudioCodecs) == 0 {
+			builtin.UserAgents = profile.UserAgents
+			profile = builtin
+			custom.Profiles[i] = profile
+		}
+		if err := profile.Validate(); err != nil {
+			return nil, fmt.Errorf("client profile %d: %w", i, err)
+		}
+		names[strings.ToLower(profile.Name)] = true
+	}
+
+	set := &ClientProfileSet{Profiles: custom.Profiles}
+	for _, profile := range defaults.Profiles {
+		if !names[profile.Name] {
+			set.Profiles = append(set.Profiles, profile)
+		}
+	}
+
+	return set, nil
+}
+
+// Validate checks that the profile only uses supported values.
+func (p ClientProfile) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidClientProfile)
+	}
+	if len(p.VideoCodecs) == 0 || len(p.AudioCodecs) == 0 {
+		return fmt.Errorf("%w: %s must list video and audio codecs", ErrInvalidClientProfile, p.Name)
+	}
+	for _, container := range p.Containers {
+		if !contains([]string{ContainerMPEGTS, ContainerMP4, ContainerMatroska}, container) {
+			return fmt.Errorf("%w: %s has unsupported container %s", ErrInvalidClientProfile, p.Name, container)
+		}
+	}
+	if p.MaxWidth < 0 || p.MaxHeight < 0 {
+		return fmt.Errorf("%w: %s resolution must not be negative", ErrInvalidClientProfile, p.Name)
+	}
+	return nil
+}
+
+// Lookup returns a profile by name.
+func (s *ClientProfileSet) Lookup(name string) (ClientProfile, bool) {
+	if s == nil {
+		return ClientProfile{}, false
+	}
+	for _, profile := range s.Profiles {
+		if strings.EqualFold(profile.Name, name) {
+			return profile, true
+		}
+	}
+	return ClientProfile{}, false
+}
+
+// Match returns the first profile with a User-Agent fragment contained in userAgent.
+func (s *ClientProfileSet) Match(userAgent string) (ClientProfile, bool) {
+	if s == nil || userAgent == "" {
+		return ClientProfile{}, false
+	}
+
+	userAgent = strings.ToLower(userAgent)
+	for _, profile := range s.Profiles {
+		for _, fragment := range profile.UserAgents {
+			if fragment != "" && strings.Contains(userAgent, strings.ToLower(fragment)) {
+				return profile, true
+			}
+		}
+	}
+	return ClientProfile{}, false
+}
+
+// SupportsVideo reports whether the client can decode the video codec.
+func (p ClientProfile) SupportsVideo(codec string) bool {
+	return supportsCodec(p.VideoCodecs, codec)
+}
+
+// SupportsAudio reports whether the client can decode the audio codec.
+func (p ClientProfile) SupportsAudio(codec string) bool {
+	return supportsCodec(p.AudioCodecs, codec)
+}
+
+// VideoEncoderCodec returns the codec to encode video to for this client. The
+// preferred codec is kept when the client supports it, otherwise the first
+// supported codec that can be encoded is used.
+func (p ClientProfile) VideoEncoderCodec(preferred string) string {
+	return encoderCodec(p.VideoCodecs, preferred, []string{codecH264, codecH265, codecVP9, codecMPEG2})
+}
+
+// AudioEncoderCodec returns the codec to encode audio to for this client.
+func (p ClientProfile) AudioEncoderCodec(preferred string) string {
+	return encoderCodec(p.AudioCodecs, preferred, []string{codecAAC, codecMP3, codecMP2, codecOpus})
+}
+
+// Container returns the output container for the client, preferring MPEG-TS.
+func (p ClientProfile) Container() string {
+	if len(p.Containers) == 0 || contains(p.Containers, ContainerMPEGTS) {
+		return ContainerMPEGTS
+	}
+	return p.Containers[0]
+}
+
+// encoderCodec picks an encodable codec supported by the client.
+func encoderCodec(supported []string, preferred string, encodable []string) string {
+	if preferred != "" && preferred != codecCopy && supportsCodec(supported, preferred) {
+		return preferred
+	}
+	for _, codec := range supported {
+		for _, encoder := range encodable {
+			if normalizeCodec(codec) == normalizeCodec(encoder) {
+				return encoder
+			}
+		}
+	}
+	return preferred
+}
+
+// supportsCodec reports whether codec is in the list, ignoring naming aliases.
+func supportsCodec(codecs []string, codec string) bool {
+	codec = normalizeCodec(codec)
+	for _, c := range codecs {
+		if normalizeCodec(c) == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeCodec maps encoder codec names to their ffprobe equivalents.
+func normalizeCodec(codec string) string {
+	codec = strings.ToLower(codec)
+	switch codec {
+	case codecH265:
+		return codecHEVC
+	case codecMPEG2:
+		return codecMPEG2Video
+	default:
+		return codec
+	}
+}
+
+// ApplyContainer switches a profile to a different output container, adjusting
+// the muxer arguments in the profile's extra arguments.
+func ApplyContainer(profile types.TranscodingProfile, container string) types.TranscodingProfile {
+	if container == "" || container == profile.Container {
+		return profile
+	}
+
+	args := make([]string, 0, len(profile.ExtraArgs)+2)
+	for i := 0; i < len(profile.ExtraArgs); i++ {
+		arg := profile.ExtraArgs[i]
+		switch {
+		case arg == "-f" && i+1 < len(profile.ExtraArgs):
+			args = append(args, arg, container)
+			i++
+		case strings.HasPrefix(arg, "-mpegts_") && i+1 < len(profile.ExtraArgs):
+			// MPEG-TS muxer options are rejected by other muxers
+			i++
+		default:
+			args = append(args, arg)
+		}
+	}
+
+	if container == ContainerMP4 {
+		// Fragmented MP4 can be written to a pipe and played while downloading
+		args = append(args, "-movflags", "frag_keyframe+empty_moov+default_base_moof")
+	}
+
+	profile.Container = container
+	profile.ExtraArgs = args
+	return profile
+}
+
+// ContainerContentType returns the HTTP content type for an output container.
+func ContainerContentType(container string) string {
+	switch container {
+	case ContainerMP4:
+		return "video/mp4"
+	case ContainerMatroska:
+		return "video/x-matroska"
+	default:
+		return "video/mp2t"
+	}
+}
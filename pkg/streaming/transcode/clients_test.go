@@ -0,0 +1,144 @@
+package transcode
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/savid/iptv-proxy/pkg/types"
+)
+
+func TestClientProfileMatch(t *testing.T) {
+	for _, userAgent := range []string{"PlexMediaServer/1.40.0", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/126.0"} {
+		if profile, ok := DefaultClientProfiles().Match(userAgent); ok {
+			t.Errorf("Match(%q) = %s, want no match without a profiles file", userAgent, profile.Name)
+		}
+	}
+
+	clients, err := ParseClientProfiles([]byte(`{
+  "profiles": [
+    {"name": "plex", "user_agents": ["Plex"]},
+    {"name": "vlc", "user_agents": ["VLC", "LibVLC"]}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("ParseClientProfiles() error = %v", err)
+	}
+
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"PlexMediaServer/1.40.0", "plex"},
+		{"VLC/3.0.20 LibVLC/3.0.20", "vlc"},
+		{"Mozilla/5.0 Plex/4.0", "plex"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/126.0", ""},
+		{"Kodi/21.0 (X11; Linux x86_64)", ""},
+		{"curl/8.0", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.userAgent, func(t *testing.T) {
+			profile, ok := clients.Match(tt.userAgent)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("Match() = %s, want no match", profile.Name)
+				}
+				return
+			}
+			if !ok || profile.Name != tt.want {
+				t.Errorf("Match() = %s (%v), want %s", profile.Name, ok, tt.want)
+			}
+		})
+	}
+
+	plex, _ := clients.Lookup("plex")
+	if !plex.SupportsVideo("hevc") || plex.Container() != ContainerMPEGTS {
+		t.Errorf("plex = %+v, want the built-in capabilities", plex)
+	}
+}
+
+func TestParseClientProfiles(t *testing.T) {
+	clients, err := ParseClientProfiles([]byte(`{
+  "profiles": [
+    {"name": "tv", "user_agents": ["SmartTV"], "video_codecs": ["h264"], "audio_codecs": ["aac"], "max_height": 720},
+    {"name": "browser", "user_agents": ["Mozilla"], "video_codecs": ["h264", "hevc"], "audio_codecs": ["aac"], "containers": ["matroska"]}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("ParseClientProfiles() error = %v", err)
+	}
+
+	tv, ok := clients.Match("Mozilla/5.0 SmartTV")
+	if !ok || tv.Name != "tv" {
+		t.Errorf("Match() = %s, want tv (custom profiles match first)", tv.Name)
+	}
+
+	browser, ok := clients.Lookup("browser")
+	if !ok || !browser.SupportsVideo("h265") {
+		t.Error("custom browser profile should replace the built-in one")
+	}
+	if browser.Container() != ContainerMatroska {
+		t.Errorf("Container() = %s, want %s", browser.Container(), ContainerMatroska)
+	}
+
+	if _, ok := clients.Lookup("plex"); !ok {
+		t.Error("built-in profiles should be kept")
+	}
+
+	_, err = ParseClientProfiles([]byte(`{"profiles": [{"name": "bad", "video_codecs": ["h264"], "audio_codecs": ["aac"], "containers": ["avi"]}]}`))
+	if !errors.Is(err, ErrInvalidClientProfile) {
+		t.Errorf("error = %v, want %v", err, ErrInvalidClientProfile)
+	}
+}
+
+func TestClientProfileEncoderCodec(t *testing.T) {
+	browser, _ := DefaultClientProfiles().Lookup("browser")
+	plex, _ := DefaultClientProfiles().Lookup("plex")
+
+	if got := browser.VideoEncoderCodec("h265"); got != "h264" {
+		t.Errorf("browser VideoEncoderCodec(h265) = %s, want h264", got)
+	}
+	if got := plex.VideoEncoderCodec("h265"); got != "h265" {
+		t.Errorf("plex VideoEncoderCodec(h265) = %s, want h265", got)
+	}
+	if got := plex.AudioEncoderCodec("opus"); got != "aac" {
+		t.Errorf("plex AudioEncoderCodec(opus) = %s, want aac", got)
+	}
+}
+
+func TestDecideCodecsForClient(t *testing.T) {
+	source := StreamCodecs{VideoCodec: "hevc", AudioCodec: "ac3"}
+
+	plex, _ := DefaultClientProfiles().Lookup("plex")
+	decision := DecideCodecs(source, true, "h264", "aac", &plex)
+	if decision.VideoCodec != "copy" || decision.AudioCodec != "copy" {
+		t.Errorf("plex decision = %s, want both tracks copied", decision)
+	}
+
+	browser, _ := DefaultClientProfiles().Lookup("browser")
+	decision = DecideCodecs(source, true, "h264", "aac", &browser)
+	if decision.VideoCodec != "h264" || decision.AudioCodec != "aac" {
+		t.Errorf("browser decision = %s, want both tracks transcoded", decision)
+	}
+}
+
+func TestApplyContainer(t *testing.T) {
+	profile := types.TranscodingProfile{
+		Container: ContainerMPEGTS,
+		ExtraArgs: []string{"-f", "mpegts", "-mpegts_copyts", "1", "-max_muxing_queue_size", "1024"},
+	}
+
+	got := ApplyContainer(profile, ContainerMP4)
+	want := []string{"-f", "mp4", "-max_muxing_queue_size", "1024", "-movflags", "frag_keyframe+empty_moov+default_base_moof"}
+	if !slices.Equal(got.ExtraArgs, want) {
+		t.Errorf("ExtraArgs = %v, want %v", got.ExtraArgs, want)
+	}
+	if got.Container != ContainerMP4 {
+		t.Errorf("Container = %s, want %s", got.Container, ContainerMP4)
+	}
+
+	if same := ApplyContainer(profile, ContainerMPEGTS); !slices.Equal(same.ExtraArgs, profile.ExtraArgs) {
+		t.Errorf("ApplyContainer with same container changed args: %v", same.ExtraArgs)
+	}
+}
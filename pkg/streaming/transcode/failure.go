@@ -0,0 +1,140 @@
+// Package transcode handles video and audio transcoding operations.
+package transcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FailureKind classifies why FFmpeg failed to start producing output.
+type FailureKind string
+
+const (
+	// FailureNone means no failure was detected.
+	FailureNone FailureKind = ""
+	// FailureDeviceBusy means the hardware encoder is out of sessions or memory.
+	FailureDeviceBusy FailureKind = "device-busy"
+	// FailureDriver means the hardware driver or device could not be initialized.
+	FailureDriver FailureKind = "driver-error"
+	// FailureUnsupported means the device cannot encode the requested codec or profile.
+	FailureUnsupported FailureKind = "unsupported"
+	// FailureInput means the upstream stream could not be read.
+	FailureInput FailureKind = "input-error"
+	// FailureUnknown means FFmpeg exited without output for an unrecognized reason.
+	FailureUnknown FailureKind = "unknown"
+)
+
+// ErrStartupFailed is returned when FFmpeg exits before producing any output.
+var ErrStartupFailed = errors.New("transcoder exited before producing output")
+
+// StartupError describes an FFmpeg process that failed before sending output.
+type StartupError struct {
+	Kind   FailureKind
+	Stderr string
+	Err    error
+}
+
+func (e *StartupError) Error() string {
+	msg := fmt.Sprintf("%s (%s)", ErrStartupFailed, e.Kind)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if line := logLevelTag.ReplaceAllString(lastLine(e.Stderr), ""); line != "" {
+		msg += ": " + line
+	}
+	return msg
+}
+
+func (e *StartupError) Unwrap() error {
+	return ErrStartupFailed
+}
+
+// IsHardwareFailure reports whether the failure is caused by the encoding
+// device, so that retrying on another device may succeed.
+func (k FailureKind) IsHardwareFailure() bool {
+	return k == FailureDeviceBusy || k == FailureDriver || k == FailureUnsupported
+}
+
+// failurePatterns maps FFmpeg stderr fragments to failure kinds. Device and
+// encoder patterns are checked first, as they only appear when the hardware
+// failed, while a missing device also reports "no such file or directory" like
+// a missing input file.
+func failurePatterns() []struct {
+	kind      FailureKind
+	fragments []string
+} {
+	return []struct {
+		kind      FailureKind
+		fragments []string
+	}{
+		{FailureDriver, []string{
+			"cannot load libcuda",
+			"cannot load libnvidia-encode",
+			"driver does not support the required nvenc api version",
+			"cuinit(0) failed",
+			"cuda_error_no_device",
+			"cuda_error_invalid_device",
+			"cuda_error_not_initialized",
+			"cuda_error_system_driver_mismatch",
+			"cuda_error_unknown",
+			"failed to initialise vaapi connection",
+			"vainitialize failed",
+			"no va display found",
+			"device creation failed",
+			"failed to create a vaapi device",
+			"for option 'init_hw_device'",
+			"for option 'vaapi_device'",
+			"for option 'filter_hw_device'",
+		}},
+		{FailureDeviceBusy, []string{
+			"openencodesessionex failed: out of memory",
+			"incompatible client key",
+			"maximum number of sessions",
+			"device or resource busy",
+			"cuda_error_out_of_memory",
+		}},
+		{FailureUnsupported, []string{
+			"doesn't support required nvenc features",
+			"no capable devices found",
+			"no usable encoding profile found",
+			"no support for codec",
+			"not supported by the hardware",
+			"unsupported profile",
+			"initializeencoder failed: invalid param",
+			"no usable encoding entrypoint found",
+		}},
+		{FailureInput, []string{
+			"server returned 4",
+			"server returned 5",
+			"connection refused",
+			"connection timed out",
+			"invalid data found when processing input",
+			"no such file or directory",
+		}},
+	}
+}
+
+// ClassifyFailure determines why FFmpeg failed from its stderr output.
+func ClassifyFailure(stderr string) FailureKind {
+	lower := strings.ToLower(stderr)
+	for _, pattern := range failurePatterns() {
+		for _, fragment := range pattern.fragments {
+			if strings.Contains(lower, fragment) {
+				return pattern.kind
+			}
+		}
+	}
+	return FailureUnknown
+}
+
+// lastLine returns the last non-empty line of s.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,243 @@
+package transcode
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   FailureKind
+	}{
+		{
+			name:   "libcuda missing",
+			stderr: "[AVHWDeviceContext @ 0x55] Cannot load libcuda.so.1",
+			want:   FailureDriver,
+		},
+		{
+			name:   "nvenc driver missing",
+			stderr: "[h264_nvenc @ 0x55] Cannot load libnvidia-encode.so.1",
+			want:   FailureDriver,
+		},
+		{
+			name:   "nvenc api version",
+			stderr: "[h264_nvenc @ 0x55] Driver does not support the required nvenc API version. Required: 12.1 Found: 11.0",
+			want:   FailureDriver,
+		},
+		{
+			name:   "cuinit failed",
+			stderr: "[AVHWDeviceContext @ 0x55] cu->cuInit(0) failed -> CUDA_ERROR_NO_DEVICE: no CUDA-capable device is detected",
+			want:   FailureDriver,
+		},
+		{
+			name:   "cuda no device",
+			stderr: "[h264_nvenc @ 0x55] cuda check failed: CUDA_ERROR_NO_DEVICE",
+			want:   FailureDriver,
+		},
+		{
+			name:   "cuda invalid device",
+			stderr: "[AVHWDeviceContext @ 0x55] cuDeviceGet(&hwctx->internal->cuda_device, device_idx) failed -> CUDA_ERROR_INVALID_DEVICE: invalid device ordinal",
+			want:   FailureDriver,
+		},
+		{
+			name:   "cuda not initialized",
+			stderr: "[h264_nvenc @ 0x55] cuCtxCreate failed -> CUDA_ERROR_NOT_INITIALIZED: initialization error",
+			want:   FailureDriver,
+		},
+		{
+			name:   "cuda driver mismatch",
+			stderr: "[AVHWDeviceContext @ 0x55] cuInit failed -> CUDA_ERROR_SYSTEM_DRIVER_MISMATCH: system has unsupported display driver / cuda driver combination",
+			want:   FailureDriver,
+		},
+		{
+			name:   "cuda unknown",
+			stderr: "[AVHWDeviceContext @ 0x55] cuCtxCreate failed -> CUDA_ERROR_UNKNOWN: unknown error",
+			want:   FailureDriver,
+		},
+		{
+			name:   "vaapi connection",
+			stderr: "[AVHWDeviceContext @ 0x55] Failed to initialise VAAPI connection: -1 (unknown libva error).",
+			want:   FailureDriver,
+		},
+		{
+			name:   "vainitialize",
+			stderr: "libva error: vaInitialize failed with error code -1",
+			want:   FailureDriver,
+		},
+		{
+			name:   "no va display",
+			stderr: "[AVHWDeviceContext @ 0x55] No VA display found for device /dev/dri/renderD128.",
+			want:   FailureDriver,
+		},
+		{
+			name:   "device creation",
+			stderr: "Device creation failed: -5.",
+			want:   FailureDriver,
+		},
+		{
+			name:   "vaapi device",
+			stderr: "[h264_vaapi @ 0x55] Failed to create a VAAPI device",
+			want:   FailureDriver,
+		},
+		{
+			name:   "missing render node",
+			stderr: "Failed to set value 'vaapi=va:/dev/dri/renderD128' for option 'init_hw_device': No such file or directory",
+			want:   FailureDriver,
+		},
+		{
+			name:   "missing vaapi device",
+			stderr: "Failed to set value '/dev/dri/renderD129' for option 'vaapi_device': No such file or directory",
+			want:   FailureDriver,
+		},
+		{
+			name:   "missing filter device",
+			stderr: "Failed to set value 'va' for option 'filter_hw_device': Invalid argument",
+			want:   FailureDriver,
+		},
+		{
+			name:   "nvenc session limit",
+			stderr: "[h264_nvenc @ 0x55] OpenEncodeSessionEx failed: out of memory (10): (no details)",
+			want:   FailureDeviceBusy,
+		},
+		{
+			name:   "nvenc client key",
+			stderr: "[h264_nvenc @ 0x55] OpenEncodeSessionEx failed: incompatible client key (21): (no details)",
+			want:   FailureDeviceBusy,
+		},
+		{
+			name:   "maximum sessions",
+			stderr: "[hevc_nvenc @ 0x55] The maximum number of sessions has been reached",
+			want:   FailureDeviceBusy,
+		},
+		{
+			name:   "device busy",
+			stderr: "[AVHWDeviceContext @ 0x55] Failed to open /dev/dri/renderD128: Device or resource busy",
+			want:   FailureDeviceBusy,
+		},
+		{
+			name:   "cuda out of memory",
+			stderr: "[h264_nvenc @ 0x55] cuMemAlloc failed -> CUDA_ERROR_OUT_OF_MEMORY: out of memory",
+			want:   FailureDeviceBusy,
+		},
+		{
+			name:   "nvenc features",
+			stderr: "[hevc_nvenc @ 0x55] Provided device doesn't support required NVENC features",
+			want:   FailureUnsupported,
+		},
+		{
+			name:   "no capable devices",
+			stderr: "[h264_nvenc @ 0x55] No capable devices found",
+			want:   FailureUnsupported,
+		},
+		{
+			name:   "unsupported profile",
+			stderr: "[h264_vaapi @ 0x55] No usable encoding profile found.",
+			want:   FailureUnsupported,
+		},
+		{
+			name:   "no codec support",
+			stderr: "[hevc_nvenc @ 0x55] Codec not supported: No support for codec hevc",
+			want:   FailureUnsupported,
+		},
+		{
+			name:   "not supported by hardware",
+			stderr: "[hevc_vaapi @ 0x55] 10-bit encoding is not supported by the hardware",
+			want:   FailureUnsupported,
+		},
+		{
+			name:   "unsupported encoder profile",
+			stderr: "[h264_nvenc @ 0x55] Unsupported profile: high444p",
+			want:   FailureUnsupported,
+		},
+		{
+			name:   "invalid param",
+			stderr: "[h264_nvenc @ 0x55] InitializeEncoder failed: invalid param (8): Invalid Level.",
+			want:   FailureUnsupported,
+		},
+		{
+			name:   "no entrypoint",
+			stderr: "[h264_vaapi @ 0x55] No usable encoding entrypoint found for profile VAProfileH264High (7).",
+			want:   FailureUnsupported,
+		},
+		{
+			name:   "upstream 404",
+			stderr: "[http @ 0x55] HTTP error 404 Not Found\nhttp://example.com/stream: Server returned 404 Not Found",
+			want:   FailureInput,
+		},
+		{
+			name:   "upstream 503",
+			stderr: "http://example.com/stream: Server returned 5XX Server Error reply",
+			want:   FailureInput,
+		},
+		{
+			name:   "connection refused",
+			stderr: "[tcp @ 0x55] Connection to tcp://example.com:80 failed: Connection refused",
+			want:   FailureInput,
+		},
+		{
+			name:   "connection timed out",
+			stderr: "[tcp @ 0x55] Connection to tcp://example.com:80 failed: Connection timed out",
+			want:   FailureInput,
+		},
+		{
+			name:   "invalid input data",
+			stderr: "http://example.com/stream: Invalid data found when processing input",
+			want:   FailureInput,
+		},
+		{
+			name:   "missing input file",
+			stderr: "/media/missing.ts: No such file or directory",
+			want:   FailureInput,
+		},
+		{
+			name:   "unknown",
+			stderr: "something unexpected happened",
+			want:   FailureUnknown,
+		},
+		{
+			name:   "unrelated set value",
+			stderr: "Failed to set value 'fast' for option 'preset': Option not found",
+			want:   FailureUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyFailure(tt.stderr); got != tt.want {
+				t.Errorf("ClassifyFailure() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailureKindIsHardwareFailure(t *testing.T) {
+	for _, kind := range []FailureKind{FailureDeviceBusy, FailureDriver, FailureUnsupported} {
+		if !kind.IsHardwareFailure() {
+			t.Errorf("%q should be a hardware failure", kind)
+		}
+	}
+	for _, kind := range []FailureKind{FailureNone, FailureInput, FailureUnknown} {
+		if kind.IsHardwareFailure() {
+			t.Errorf("%q should not be a hardware failure", kind)
+		}
+	}
+}
+
+func TestStartupError(t *testing.T) {
+	err := error(&StartupError{Kind: FailureDriver, Stderr: "first line\nCannot load libcuda.so.1\n"})
+
+	if !errors.Is(err, ErrStartupFailed) {
+		t.Error("StartupError should wrap ErrStartupFailed")
+	}
+	if !strings.Contains(err.Error(), "Cannot load libcuda.so.1") {
+		t.Errorf("Error() = %q, want last stderr line", err.Error())
+	}
+
+	tagged := &StartupError{Kind: FailureInput, Stderr: "[info] Input #0\n[error] Conversion failed!\n"}
+	if !strings.HasSuffix(tagged.Error(), ": Conversion failed!") {
+		t.Errorf("Error() = %q, want the last line without its level", tagged.Error())
+	}
+}
@@ -2,16 +2,19 @@
 package transcode
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"os/exec"
+	"regexp"
 	"sync"
+	"time"
 
 	"github.com/savid/iptv-proxy/pkg/hardware"
+	"github.com/savid/iptv-proxy/pkg/process"
 	"github.com/savid/iptv-proxy/pkg/types"
+	"github.com/sirupsen/logrus"
 )
 
 var (
@@ -23,6 +26,10 @@ var (
 	ErrStdoutNotAvailable = errors.New("stdout not available")
 )
 
+// logLevelTag matches the level FFmpeg prints before each message with the
+// "level" log flag.
+var logLevelTag = regexp.MustCompile(`\[(trace|debug|verbose|info|warning|error|fatal|panic)\] `)
+
 // CloseError wraps multiple errors that occurred during close.
 type CloseError struct {
 	Errors []error
@@ -39,15 +46,32 @@ type FFmpegTranscoder struct {
 	bufferConfig types.BufferConfig
 	selector     *hardware.Selector
 	inputURL     string
-	cmd          *exec.Cmd
+	inputOptions []string
+	runner       process.Runner
+	proc         process.Process
 	stdin        io.WriteCloser
 	stdout       io.ReadCloser
 	stderr       io.ReadCloser
-	logger       *log.Logger
+	logger       *logrus.Entry
 	mu           sync.Mutex
 	closed       bool
+	stderrMu     sync.Mutex
+	stderrTail   []byte
+	stderrDone   chan struct{}
+	input        InputParser
+	onInput      func(SourceInfo)
 }
 
+const (
+	// maxStderrTail bounds how much FFmpeg stderr is kept for failure classification.
+	maxStderrTail = 16 * 1024
+	// maxStderrLine bounds how much of an unterminated stderr line is held
+	// back before it is logged.
+	maxStderrLine = 4096
+	// stderrDrainTimeout bounds the wait for stderr after FFmpeg exits.
+	stderrDrainTimeout = 2 * time.Second
+)
+
 // NewFFmpegTranscoder creates a new FFmpeg-based transcoder.
 func NewFFmpegTranscoder(
 	profile types.TranscodingProfile,
@@ -55,7 +79,7 @@ func NewFFmpegTranscoder(
 	bufferConfig types.BufferConfig,
 	selector *hardware.Selector,
 	inputURL string,
-	logger *log.Logger,
+	logger *logrus.Entry,
 ) *FFmpegTranscoder {
 	return &FFmpegTranscoder{
 		profile:      profile,
@@ -63,10 +87,30 @@ func NewFFmpegTranscoder(
 		bufferConfig: bufferConfig,
 		selector:     selector,
 		inputURL:     inputURL,
+		runner:       process.NewExecRunner(),
 		logger:       logger,
 	}
 }
 
+// SetRunner replaces the runner used to start FFmpeg. It must be called before Start.
+func (t *FFmpegTranscoder) SetRunner(runner process.Runner) {
+	if runner != nil {
+		t.runner = runner
+	}
+}
+
+// SetInputHandler sets a function called with the source info FFmpeg prints
+// for its input. It must be called before Start.
+func (t *FFmpegTranscoder) SetInputHandler(handler func(SourceInfo)) {
+	t.onInput = handler
+}
+
+// SetInputOptions sets options passed before the input URL, such as the
+// upstream headers. It must be called before Start.
+func (t *FFmpegTranscoder) SetInputOptions(options []string) {
+	t.inputOptions = options
+}
+
 // Start begins the transcoding process.
 func (t *FFmpegTranscoder) Start(ctx context.Context) error {
 	t.mu.Lock()
@@ -77,35 +121,24 @@ func (t *FFmpegTranscoder) Start(ctx context.Context) error {
 	}
 
 	args := t.buildCommand()
-	t.logger.Printf("Starting FFmpeg with args: %v", args)
-
-	t.cmd = exec.CommandContext(ctx, "ffmpeg", args...) // #nosec G204 - args are internally constructed
-
-	// Set up pipes
-	var err error
-	if t.inputURL == "-" {
-		t.stdin, err = t.cmd.StdinPipe()
-		if err != nil {
-			return fmt.Errorf("failed to create stdin pipe: %w", err)
-		}
-	}
+	t.logger.Infof("Starting FFmpeg with args: %v", args)
 
-	t.stdout, err = t.cmd.StdoutPipe()
+	proc, err := t.runner.Start(ctx, process.Spec{
+		Name:  "ffmpeg",
+		Args:  args,
+		Stdin: t.inputURL == "-",
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %w", err)
-	}
-
-	t.stderr, err = t.cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %w", err)
-	}
-
-	// Start FFmpeg
-	if err := t.cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start FFmpeg: %w", err)
 	}
 
+	t.proc = proc
+	t.stdin = proc.Stdin()
+	t.stdout = proc.Stdout()
+	t.stderr = proc.Stderr()
+
 	// Log stderr in background
+	t.stderrDone = make(chan struct{})
 	go t.logStderr()
 
 	return nil
@@ -126,8 +159,10 @@ func (t *FFmpegTranscoder) buildCommand() []string {
 	sections := &commandSection{
 		global: []string{
 			"-hide_banner",
-			"-loglevel", "warning",
-			"-stats",
+			// Info level includes the input description read by InputParser;
+			// the level tags let only warnings and errors be logged
+			"-loglevel", "level+info",
+			"-nostats",
 		},
 		input:   []string{},
 		filters: []string{},
@@ -146,8 +181,11 @@ func (t *FFmpegTranscoder) buildCommand() []string {
 	sections.input = append(sections.input,
 		"-fflags", "+genpts+discardcorrupt+nobuffer",
 		"-err_detect", "ignore_err",
-		"-i", t.inputURL,
 	)
+	if t.inputURL != "-" {
+		sections.input = append(sections.input, t.inputOptions...)
+	}
+	sections.input = append(sections.input, "-i", t.inputURL)
 
 	// Add profile's extra arguments, categorizing them appropriately
 	t.categorizeProfileArgs(t.profile.ExtraArgs, sections)
@@ -182,6 +220,12 @@ func (t *FFmpegTranscoder) categorizeHardwareArgs(args []string, sections *comma
 				sections.video = append(sections.video, args[i], args[i+1])
 				i++
 			}
+		case "-vf":
+			// Video filter chain - goes in filters section after the input
+			if i+1 < len(args) {
+				sections.filters = append(sections.filters, args[i], args[i+1])
+				i++
+			}
 		case "-vaapi_device", "-init_hw_device", "-filter_hw_device":
 			// VA-API device setup - goes in global section
 			if i+1 < len(args) {
@@ -313,12 +357,13 @@ func (t *FFmpegTranscoder) Close() error {
 		}
 	}
 
-	// Wait for FFmpeg to finish
-	if t.cmd != nil && t.cmd.Process != nil {
-		if err := t.cmd.Wait(); err != nil {
+	// Stop FFmpeg and reap it. Nobody reads its output after Close, so a
+	// process that is still running would otherwise block on a full pipe.
+	if t.proc != nil {
+		killed := t.proc.Kill() == nil
+		if err := t.proc.Wait(); err != nil && !killed {
 			// Exit status 255 is often normal termination for streaming
-			var exitErr *exec.ExitError
-			if !errors.As(err, &exitErr) || exitErr.ExitCode() != 255 {
+			if process.ExitCode(err) != 255 {
 				errs = append(errs, fmt.Errorf("FFmpeg process error: %w", err))
 			}
 		}
@@ -342,16 +387,121 @@ func (t *FFmpegTranscoder) Close() error {
 	return nil
 }
 
-// logStderr logs FFmpeg stderr output.
+// WaitForOutput blocks until FFmpeg writes its first output and returns it.
+// The returned bytes must be sent before reading further from the transcoder.
+// If FFmpeg exits before producing output, a *StartupError classifying the
+// failure from stderr is returned.
+func (t *FFmpegTranscoder) WaitForOutput() ([]byte, error) {
+	if t.stdout == nil {
+		return nil, ErrStdoutNotAvailable
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := t.stdout.Read(buf)
+		if n > 0 {
+			return buf[:n], nil
+		}
+		if err != nil {
+			stderr := t.stderrOutput()
+			startupErr := &StartupError{Kind: ClassifyFailure(stderr), Stderr: stderr}
+			if !errors.Is(err, io.EOF) {
+				startupErr.Err = err
+			}
+			return nil, startupErr
+		}
+	}
+}
+
+// stderrOutput returns the captured stderr once FFmpeg has closed it.
+func (t *FFmpegTranscoder) stderrOutput() string {
+	if t.stderrDone != nil {
+		select {
+		case <-t.stderrDone:
+		case <-time.After(stderrDrainTimeout):
+		}
+	}
+
+	t.stderrMu.Lock()
+	defer t.stderrMu.Unlock()
+	return string(t.stderrTail)
+}
+
+// logStderr logs FFmpeg stderr output line by line and keeps its tail for
+// failure classification.
 func (t *FFmpegTranscoder) logStderr() {
+	defer close(t.stderrDone)
+
 	buf := make([]byte, 1024)
+	var pending []byte
 	for {
 		n, err := t.stderr.Read(buf)
 		if n > 0 {
-			t.logger.Printf("FFmpeg: %s", string(buf[:n]))
+			pending = t.logLines(append(pending, buf[:n]...))
+
+			t.stderrMu.Lock()
+			t.stderrTail = append(t.stderrTail, buf[:n]...)
+			if len(t.stderrTail) > maxStderrTail {
+				t.stderrTail = t.stderrTail[len(t.stderrTail)-maxStderrTail:]
+			}
+			t.stderrMu.Unlock()
 		}
 		if err != nil {
+			t.handleLine(pending)
+			if info, ok := t.input.Flush(); ok && t.onInput != nil {
+				t.onInput(info)
+			}
 			break
 		}
 	}
 }
+
+// logLines logs the complete lines of output, split at newlines and at the
+// carriage returns of progress updates, and returns the incomplete rest.
+// Overlong lines are logged without waiting for their end.
+func (t *FFmpegTranscoder) logLines(output []byte) []byte {
+	end := bytes.LastIndexAny(output, "\r\n")
+	if end < 0 {
+		if len(output) < maxStderrLine {
+			return output
+		}
+		end = len(output) - 1
+	}
+
+	for _, line := range bytes.FieldsFunc(output[:end+1], func(r rune) bool { return r == '\r' || r == '\n' }) {
+		t.handleLine(line)
+	}
+	return append([]byte(nil), output[end+1:]...)
+}
+
+// handleLine passes a stderr line to the input parser and logs it at its
+// FFmpeg level, unless FFmpeg tagged it below the warning level.
+func (t *FFmpegTranscoder) handleLine(line []byte) {
+	text := string(bytes.TrimSpace(line))
+	level := ""
+	if match := logLevelTag.FindStringSubmatchIndex(text); match != nil {
+		level = text[match[2]:match[3]]
+		text = text[:match[0]] + text[match[1]:]
+	}
+	if text == "" {
+		return
+	}
+
+	t.parseInput(text)
+	switch level {
+	case "":
+		t.logger.Infof("FFmpeg: %s", text)
+	case "warning":
+		t.logger.Warnf("FFmpeg: %s", text)
+	case "error", "fatal", "panic":
+		t.logger.Errorf("FFmpeg: %s", text)
+	}
+}
+
+// parseInput passes a stderr line to the input parser and reports the source
+// info once the input description is complete.
+func (t *FFmpegTranscoder) parseInput(line string) {
+	if info, ok := t.input.Line(line); ok && t.onInput != nil {
+		t.onInput(info)
+	}
+}
@@ -0,0 +1,216 @@
+package transcode
+
+import (
+	"context"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/hardware"
+	"github.com/savid/iptv-proxy/pkg/process/processtest"
+	"github.com/savid/iptv-proxy/pkg/types"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+// discardEntry returns a log entry that writes nowhere.
+func discardEntry() *logrus.Entry {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logrus.NewEntry(logger)
+}
+
+func TestBuildCommandFilterPlacement(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	selector := hardware.NewSelector(nil, types.HardwareAuto, logger)
+
+	profile := types.TranscodingProfile{
+		VideoCodec:  "h264",
+		AudioCodec:  "aac",
+		Container:   "mpegts",
+		MaxHeight:   720,
+		Deinterlace: types.DeinterlaceYadif,
+	}
+	hw := types.HardwareInfo{Type: types.HardwareNVIDIA}
+
+	transcoder := NewFFmpegTranscoder(profile, hw, types.BufferConfig{}, selector, "http://example.com/stream", discardEntry())
+	args := transcoder.buildCommand()
+
+	input := slices.Index(args, "-i")
+	filters := slices.Index(args, "-vf")
+	device := slices.Index(args, "-init_hw_device")
+	encoder := slices.Index(args, "h264_nvenc")
+
+	if input < 0 || filters < 0 || device < 0 || encoder < 0 {
+		t.Fatalf("Missing expected arguments in %v", args)
+	}
+	if device > input {
+		t.Errorf("Expected hardware device setup before input, got %v", args)
+	}
+	if filters < input || filters > encoder {
+		t.Errorf("Expected filters between input and encoder, got %v", args)
+	}
+}
+
+func TestBuildCommandInputOptions(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	selector := hardware.NewSelector(nil, types.HardwareAuto, logger)
+	profile := types.TranscodingProfile{VideoCodec: "copy", AudioCodec: "copy", Container: "mpegts"}
+
+	transcoder := NewFFmpegTranscoder(profile, types.HardwareInfo{Type: types.HardwareCPU}, types.BufferConfig{}, selector, "http://example.com/stream", discardEntry())
+	transcoder.SetInputOptions([]string{"-user_agent", "Provider/2.0", "-headers", "Referer: http://example.com/\r\n"})
+	args := transcoder.buildCommand()
+
+	input := slices.Index(args, "-i")
+	agent := slices.Index(args, "-user_agent")
+	headers := slices.Index(args, "-headers")
+	if input < 0 || agent < 0 || headers < 0 {
+		t.Fatalf("Missing expected arguments in %v", args)
+	}
+	if agent > input || headers > input || args[agent+1] != "Provider/2.0" {
+		t.Errorf("Expected input options before the input, got %v", args)
+	}
+}
+
+func TestLogLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		logged []string
+		rest   string
+	}{
+		{
+			name:   "complete lines",
+			output: "Input #0, mpegts\n  Stream #0:0: Video: h264\n",
+			logged: []string{"info FFmpeg: Input #0, mpegts", "info FFmpeg: Stream #0:0: Video: h264"},
+		},
+		{
+			name:   "progress updates",
+			output: "frame=  10 fps=25\rframe=  20 fps=25\rframe=  3",
+			logged: []string{"info FFmpeg: frame=  10 fps=25", "info FFmpeg: frame=  20 fps=25"},
+			rest:   "frame=  3",
+		},
+		{
+			name:   "info lines are not logged",
+			output: "[info] Input #0, mpegts\n[info]   Stream #0:0: Video: h264\n[info] frame=  10 fps=25\r",
+		},
+		{
+			name:   "warnings and errors at their level",
+			output: "[h264 @ 0x55] [warning] non-existing PPS 0 referenced\n[error] Conversion failed!\n",
+			logged: []string{"warning FFmpeg: [h264 @ 0x55] non-existing PPS 0 referenced", "error FFmpeg: Conversion failed!"},
+		},
+		{
+			name:   "incomplete line",
+			output: "Press [q] to stop",
+			rest:   "Press [q] to stop",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, hook := logtest.NewNullLogger()
+			transcoder := &FFmpegTranscoder{logger: logrus.NewEntry(logger)}
+
+			rest := transcoder.logLines([]byte(tt.output))
+			if string(rest) != tt.rest {
+				t.Errorf("Expected rest %q, got %q", tt.rest, rest)
+			}
+			var logged []string
+			for _, entry := range hook.AllEntries() {
+				logged = append(logged, entry.Level.String()+" "+entry.Message)
+			}
+			if !slices.Equal(logged, tt.logged) {
+				t.Errorf("Expected lines %q, got %q", tt.logged, logged)
+			}
+		})
+	}
+}
+
+func TestInputParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   SourceInfo
+	}{
+		{
+			name:   "video and audio",
+			stderr: processtest.InputDump("h264", "aac"),
+			want: SourceInfo{
+				Codecs: StreamCodecs{VideoCodec: "h264", VideoProfile: "High", AudioCodec: "aac", AudioChannels: 2},
+				Stream: StreamInfo{Width: 1920, Height: 1080, Framerate: 25, AudioBitrate: 128},
+			},
+		},
+		{
+			name: "multi word profile and surround audio",
+			stderr: "Input #0, mpegts, from 'http://example.com/1':\n" +
+				"  Stream #0:0[0x100]: Video: hevc (Main 10) ([36][0][0][0] / 0x0024), yuv420p10le(tv), 3840x2160, 50 fps, 50 tbr, 90k tbn\n" +
+				"  Stream #0:1[0x101]: Audio: eac3 ([135][0][0][0] / 0x0087), 48000 Hz, 5.1(side), fltp, 384 kb/s\n" +
+				"  Stream #0:2[0x102]: Audio: aac (LC), 48000 Hz, stereo, fltp\n" +
+				"Output #0, mpegts, to 'pipe:1':\n" +
+				"  Stream #0:0: Video: h264 (libx264), yuv420p, 1280x720, q=2-31\n",
+			want: SourceInfo{
+				Codecs: StreamCodecs{VideoCodec: "hevc", VideoProfile: "Main 10", AudioCodec: "eac3", AudioChannels: 6},
+				Stream: StreamInfo{Width: 3840, Height: 2160, Framerate: 50, AudioBitrate: 384},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var parser InputParser
+			var got []SourceInfo
+			for _, line := range strings.Split(tt.stderr, "\n") {
+				if info, ok := parser.Line(line); ok {
+					got = append(got, info)
+				}
+			}
+			if _, ok := parser.Flush(); ok {
+				t.Error("Flush() reported the input again")
+			}
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscoderReportsInput(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	runner := processtest.NewRunner(func(processtest.Call) processtest.Script {
+		// FFmpeg tags every line with its level
+		stderr := "[info] " + strings.ReplaceAll(strings.TrimSuffix(processtest.InputDump("mpeg2video", "mp2"), "\n"), "\n", "\n[info] ") + "\n"
+		return processtest.Script{Stdout: processtest.MPEGTS(10), Stderr: stderr}
+	})
+
+	transcoder := NewFFmpegTranscoder(types.TranscodingProfile{VideoCodec: "h264", AudioCodec: "aac", Container: "mpegts"},
+		types.HardwareInfo{Type: types.HardwareCPU}, types.BufferConfig{},
+		hardware.NewSelector(nil, types.HardwareCPU, logger), "http://example.com/1", discardEntry())
+	transcoder.SetRunner(runner)
+
+	reported := make(chan SourceInfo, 2)
+	transcoder.SetInputHandler(func(info SourceInfo) { reported <- info })
+
+	if err := transcoder.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	_, _ = io.Copy(io.Discard, transcoder)
+	_ = transcoder.Close()
+
+	select {
+	case info := <-reported:
+		if info.Codecs.VideoCodec != "mpeg2video" || info.Codecs.AudioCodec != "mp2" {
+			t.Errorf("reported %+v, want mpeg2video and mp2", info.Codecs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("input was not reported")
+	}
+	if len(reported) != 0 {
+		t.Error("input was reported more than once")
+	}
+	if call := runner.Calls("ffmpeg")[0]; call.Arg("-loglevel") != "level+info" || !call.HasArg("-nostats") {
+		t.Errorf("-loglevel = %q, want level+info for the input description without stats", call.Arg("-loglevel"))
+	}
+}
@@ -0,0 +1,129 @@
+// Package transcode handles video and audio transcoding operations.
+package transcode
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// inputResolution matches the frame size of a video stream, e.g. 1920x1080.
+	inputResolution = regexp.MustCompile(`\b(\d{2,5})x(\d{2,5})\b`)
+	// inputFramerate matches the frame rate of a video stream, e.g. 29.97 fps.
+	inputFramerate = regexp.MustCompile(`\b(\d+(?:\.\d+)?) fps\b`)
+	// inputBitrate matches the bitrate of a stream, e.g. 128 kb/s.
+	inputBitrate = regexp.MustCompile(`\b(\d+) kb/s\b`)
+	// inputChannels matches an explicit audio channel count, e.g. 6 channels.
+	inputChannels = regexp.MustCompile(`\b(\d+) channels\b`)
+)
+
+// inputLayouts maps FFmpeg channel layout names to channel counts.
+var inputLayouts = map[string]int{
+	"mono":   1,
+	"stereo": 2,
+	"2.1":    3,
+	"quad":   4,
+	"4.0":    4,
+	"5.0":    5,
+	"5.1":    6,
+	"6.1":    7,
+	"7.1":    8,
+}
+
+// InputParser reads the source stream description that FFmpeg prints for
+// its input before transcoding, so that every session confirms or updates
+// what is known about a channel without running ffprobe.
+type InputParser struct {
+	inInput bool
+	found   bool
+	done    bool
+	info    SourceInfo
+}
+
+// Line parses one line of FFmpeg stderr. It returns the source info and true
+// once, when the input description is complete.
+func (p *InputParser) Line(line string) (SourceInfo, bool) {
+	if p.done {
+		return SourceInfo{}, false
+	}
+	line = strings.TrimSpace(line)
+
+	switch {
+	case strings.HasPrefix(line, "Input #0"):
+		p.inInput = true
+	case !p.inInput:
+	case strings.HasPrefix(line, "Output #"), strings.HasPrefix(line, "Stream mapping:"),
+		strings.HasPrefix(line, "Input #"):
+		p.done = true
+		return p.info, p.found
+	case strings.HasPrefix(line, "Stream #0:"):
+		p.stream(line)
+	}
+	return SourceInfo{}, false
+}
+
+// Flush returns the source info and true if streams were found but the end
+// of the input description was not seen, e.g. because FFmpeg exited.
+func (p *InputParser) Flush() (SourceInfo, bool) {
+	if p.done || !p.found {
+		return SourceInfo{}, false
+	}
+	p.done = true
+	return p.info, true
+}
+
+// stream records the first video and audio stream of the input.
+func (p *InputParser) stream(line string) {
+	if _, desc, ok := strings.Cut(line, ": Video: "); ok && p.info.Codecs.VideoCodec == "" {
+		p.found = true
+		p.info.Codecs.VideoCodec, p.info.Codecs.VideoProfile = inputCodec(desc)
+		if m := inputResolution.FindStringSubmatch(desc); m != nil {
+			p.info.Stream.Width, _ = strconv.Atoi(m[1])
+			p.info.Stream.Height, _ = strconv.Atoi(m[2])
+		}
+		if m := inputFramerate.FindStringSubmatch(desc); m != nil {
+			p.info.Stream.Framerate, _ = strconv.ParseFloat(m[1], 64)
+		}
+		if m := inputBitrate.FindStringSubmatch(desc); m != nil {
+			p.info.Stream.VideoBitrate, _ = strconv.Atoi(m[1])
+		}
+		return
+	}
+
+	if _, desc, ok := strings.Cut(line, ": Audio: "); ok && p.info.Codecs.AudioCodec == "" {
+		p.found = true
+		p.info.Codecs.AudioCodec, _ = inputCodec(desc)
+		p.info.Codecs.AudioChannels = inputChannelCount(desc)
+		if m := inputBitrate.FindStringSubmatch(desc); m != nil {
+			p.info.Stream.AudioBitrate, _ = strconv.Atoi(m[1])
+		}
+	}
+}
+
+// inputCodec returns the codec name and profile from a stream description
+// like "h264 (High) ([27][0][0][0] / 0x001B), yuv420p, ...".
+func inputCodec(desc string) (codec, profile string) {
+	codec, rest, _ := strings.Cut(desc, " ")
+	codec = strings.TrimSuffix(codec, ",")
+	if strings.HasPrefix(rest, "(") && !strings.HasPrefix(rest, "([") {
+		profile, _, _ = strings.Cut(rest[1:], ")")
+	}
+	return codec, profile
+}
+
+// inputChannelCount returns the channel count of an audio stream description
+// like "aac (LC), 48000 Hz, stereo, fltp, 128 kb/s".
+func inputChannelCount(desc string) int {
+	if m := inputChannels.FindStringSubmatch(desc); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+	for _, field := range strings.Split(desc, ",") {
+		layout, _, _ := strings.Cut(strings.TrimSpace(field), "(")
+		if n, ok := inputLayouts[layout]; ok {
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,202 @@
+// Package transcode handles video and audio transcoding operations.
+package transcode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+	"github.com/savid/iptv-proxy/pkg/types"
+)
+
+var (
+	// ErrUnknownProfile is returned when a rule or request references an undefined profile.
+	ErrUnknownProfile = errors.New("unknown transcoding profile")
+	// ErrInvalidOverride is returned when a profile override contains invalid settings.
+	ErrInvalidOverride = errors.New("invalid transcoding profile override")
+	// ErrEmptyRule is returned when a rule has no match criteria.
+	ErrEmptyRule = errors.New("rule must match on channel, group or pattern")
+)
+
+// ProfileOverride holds transcoding settings that replace the global defaults
+// for matching channels. Empty fields keep the global value.
+type ProfileOverride struct {
+	Mode           string  `json:"mode,omitempty"`
+	VideoCodec     string  `json:"video_codec,omitempty"`
+	AudioCodec     string  `json:"audio_codec,omitempty"`
+	VideoQuality   string  `json:"video_quality,omitempty"`
+	AudioQuality   string  `json:"audio_quality,omitempty"`
+	VideoBitrate   string  `json:"video_bitrate,omitempty"`
+	AudioBitrate   string  `json:"audio_bitrate,omitempty"`
+	MaxWidth       int     `json:"max_width,omitempty"`
+	MaxHeight      int     `json:"max_height,omitempty"`
+	Framerate      float64 `json:"framerate,omitempty"`
+	Deinterlace    string  `json:"deinterlace,omitempty"`
+	HardwareDevice string  `json:"hardware_device,omitempty"`
+}
+
+// ProfileRule selects a named profile for channels matching its criteria.
+// All non-empty criteria must match.
+type ProfileRule struct {
+	Channel string `json:"channel,omitempty"`
+	Group   string `json:"group,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	Profile string `json:"profile"`
+
+	re *regexp.Regexp
+}
+
+// RuleSet contains named transcoding profiles and the rules that select them.
+type RuleSet struct {
+	Profiles map[string]ProfileOverride `json:"profiles"`
+	Rules    []ProfileRule              `json:"rules"`
+}
+
+// LoadRuleSet reads and validates a JSON rules file.
+func LoadRuleSet(path string) (*RuleSet, error) {
+	raw, err := os.ReadFile(path) // #nosec G304 - path is provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	return ParseRuleSet(raw)
+}
+
+// ParseRuleSet parses and validates a JSON rule set.
+func ParseRuleSet(raw []byte) (*RuleSet, error) {
+	rs := &RuleSet{}
+	if err := json.Unmarshal(raw, rs); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+
+	if rs.Profiles == nil {
+		rs.Profiles = map[string]ProfileOverride{}
+	}
+
+	for name, override := range rs.Profiles {
+		if err := override.Validate(); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", name, err)
+		}
+	}
+
+	for i := range rs.Rules {
+		rule := &rs.Rules[i]
+		if rule.Channel == "" && rule.Group == "" && rule.Pattern == "" {
+			return nil, fmt.Errorf("rule %d: %w", i, ErrEmptyRule)
+		}
+		if _, ok := rs.Profiles[rule.Profile]; !ok {
+			return nil, fmt.Errorf("rule %d: %w: %s", i, ErrUnknownProfile, rule.Profile)
+		}
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid pattern: %w", i, err)
+			}
+			rule.re = re
+		}
+	}
+
+	return rs, nil
+}
+
+// Profile returns a named profile.
+func (rs *RuleSet) Profile(name string) (ProfileOverride, bool) {
+	if rs == nil {
+		return ProfileOverride{}, false
+	}
+	override, ok := rs.Profiles[name]
+	return override, ok
+}
+
+// Match returns the profile selected by the first rule matching the channel.
+func (rs *RuleSet) Match(channel m3u.Channel) (string, ProfileOverride, bool) {
+	if rs == nil {
+		return "", ProfileOverride{}, false
+	}
+
+	for _, rule := range rs.Rules {
+		if rule.matches(channel) {
+			return rule.Profile, rs.Profiles[rule.Profile], true
+		}
+	}
+
+	return "", ProfileOverride{}, false
+}
+
+// matches reports whether the channel satisfies every criterion of the rule.
+func (r *ProfileRule) matches(channel m3u.Channel) bool {
+	if r.Channel != "" && !strings.EqualFold(r.Channel, channel.Name) {
+		return false
+	}
+	if r.Group != "" && !strings.EqualFold(r.Group, channel.Group) {
+		return false
+	}
+	if r.re != nil && !r.re.MatchString(channel.Name) {
+		return false
+	}
+	return true
+}
+
+// Validate checks that the override only uses supported values.
+func (o ProfileOverride) Validate() error {
+	checks := []struct {
+		field string
+		value string
+		valid []string
+	}{
+		{"mode", o.Mode, []string{codecCopy, "transcode", auto}},
+		{"video_codec", o.VideoCodec, []string{codecH264, codecH265, codecVP9, codecMPEG2}},
+		{"audio_codec", o.AudioCodec, []string{codecAAC, codecMP3, codecMP2, codecOpus}},
+		{"video_quality", o.VideoQuality, []string{"low", "medium", "high", "custom"}},
+		{"audio_quality", o.AudioQuality, []string{"low", "medium", "high", "custom"}},
+		{"deinterlace", o.Deinterlace, []string{types.DeinterlaceNone, types.DeinterlaceYadif, types.DeinterlaceBwdif}},
+	}
+
+	for _, check := range checks {
+		if check.value == "" {
+			continue
+		}
+		if !contains(check.valid, check.value) {
+			return fmt.Errorf("%w: %s=%s (must be one of %s)",
+				ErrInvalidOverride, check.field, check.value, strings.Join(check.valid, ", "))
+		}
+	}
+
+	if o.VideoQuality == "custom" && o.VideoBitrate == "" {
+		return fmt.Errorf("%w: video_bitrate required when video_quality is custom", ErrInvalidOverride)
+	}
+	if o.AudioQuality == "custom" && o.AudioBitrate == "" {
+		return fmt.Errorf("%w: audio_bitrate required when audio_quality is custom", ErrInvalidOverride)
+	}
+
+	if o.MaxWidth < 0 || o.MaxHeight < 0 {
+		return fmt.Errorf("%w: resolution must not be negative", ErrInvalidOverride)
+	}
+
+	if o.Framerate < 0 {
+		return fmt.Errorf("%w: framerate must not be negative", ErrInvalidOverride)
+	}
+
+	if o.HardwareDevice != "" {
+		if _, _, err := config.ParseDevice(o.HardwareDevice); err != nil {
+			return fmt.Errorf("%w: hardware_device: %w", ErrInvalidOverride, err)
+		}
+	}
+
+	return nil
+}
+
+// contains reports whether the slice contains the value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package transcode
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/m3u"
+)
+
+const testRules = `{
+  "profiles": {
+    "sports4k": {"mode": "transcode", "video_codec": "h265", "max_height": 1080, "hardware_device": "nvidia:1"},
+    "news": {"mode": "copy"},
+    "mobile": {"video_quality": "low", "audio_quality": "low", "max_height": 480}
+  },
+  "rules": [
+    {"group": "US Sports", "pattern": "4K", "profile": "sports4k"},
+    {"group": "News", "profile": "news"},
+    {"channel": "CNN International", "profile": "news"}
+  ]
+}`
+
+func TestParseRuleSet(t *testing.T) {
+	rs, err := ParseRuleSet([]byte(testRules))
+	if err != nil {
+		t.Fatalf("ParseRuleSet failed: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		channel     m3u.Channel
+		wantProfile string
+		wantMatch   bool
+	}{
+		{
+			name:        "group and pattern",
+			channel:     m3u.Channel{Name: "ESPN 4K", Group: "US Sports"},
+			wantProfile: "sports4k",
+			wantMatch:   true,
+		},
+		{
+			name:      "group without pattern match",
+			channel:   m3u.Channel{Name: "ESPN", Group: "US Sports"},
+			wantMatch: false,
+		},
+		{
+			name:        "group only, case insensitive",
+			channel:     m3u.Channel{Name: "BBC News", Group: "news"},
+			wantProfile: "news",
+			wantMatch:   true,
+		},
+		{
+			name:        "exact channel name",
+			channel:     m3u.Channel{Name: "CNN International", Group: "World"},
+			wantProfile: "news",
+			wantMatch:   true,
+		},
+		{
+			name:      "no rule",
+			channel:   m3u.Channel{Name: "Cartoon Network", Group: "Kids"},
+			wantMatch: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, _, ok := rs.Match(tt.channel)
+			if ok != tt.wantMatch {
+				t.Fatalf("Expected match=%v, got %v", tt.wantMatch, ok)
+			}
+			if name != tt.wantProfile {
+				t.Errorf("Expected profile %q, got %q", tt.wantProfile, name)
+			}
+		})
+	}
+
+	if profile, ok := rs.Profile("mobile"); !ok || profile.MaxHeight != 480 {
+		t.Errorf("Expected mobile profile with max height 480, got %+v", profile)
+	}
+
+	// A nil rule set never matches
+	var empty *RuleSet
+	if _, _, ok := empty.Match(m3u.Channel{Name: "ESPN"}); ok {
+		t.Error("Nil rule set should not match")
+	}
+}
+
+func TestParseRuleSetErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		wantErr error
+	}{
+		{
+			name:    "unknown profile",
+			rules:   `{"profiles": {}, "rules": [{"group": "News", "profile": "missing"}]}`,
+			wantErr: ErrUnknownProfile,
+		},
+		{
+			name:    "rule without criteria",
+			rules:   `{"profiles": {"news": {}}, "rules": [{"profile": "news"}]}`,
+			wantErr: ErrEmptyRule,
+		},
+		{
+			name:    "invalid codec",
+			rules:   `{"profiles": {"bad": {"video_codec": "av1"}}}`,
+			wantErr: ErrInvalidOverride,
+		},
+		{
+			name:    "custom quality without bitrate",
+			rules:   `{"profiles": {"bad": {"video_quality": "custom"}}}`,
+			wantErr: ErrInvalidOverride,
+		},
+		{
+			name:    "hardware device without numeric id",
+			rules:   `{"profiles": {"bad": {"hardware_device": "nvidia:first"}}}`,
+			wantErr: config.ErrInvalidDeviceID,
+		},
+		{
+			name:    "hardware device with extra parts",
+			rules:   `{"profiles": {"bad": {"hardware_device": "vaapi:0:1"}}}`,
+			wantErr: config.ErrInvalidHardwareDeviceFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRuleSet([]byte(tt.rules))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
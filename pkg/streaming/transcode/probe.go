@@ -0,0 +1,186 @@
+// Package transcode handles video and audio transcoding operations.
+package transcode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/savid/iptv-proxy/pkg/process"
+	"github.com/savid/iptv-proxy/pkg/tracing"
+)
+
+// DefaultStreamInfo is assumed for adaptive bitrates while a channel's source
+// has not been probed yet.
+var DefaultStreamInfo = StreamInfo{
+	AudioBitrate: 128,
+	Width:        1920,
+	Height:       1080,
+	Framerate:    30,
+}
+
+// SourceInfo is what is known about the source stream of a channel: its
+// codecs for auto mode decisions and its properties for adaptive bitrates.
+type SourceInfo struct {
+	Codecs StreamCodecs
+	Stream StreamInfo
+}
+
+// Changed reports whether other describes a different source, e.g. after the
+// provider switched a channel to another codec or resolution. Bitrates are
+// not compared since they vary and FFmpeg often does not report them.
+func (s SourceInfo) Changed(other SourceInfo) bool {
+	return s.Codecs.VideoCodec != other.Codecs.VideoCodec ||
+		s.Codecs.AudioCodec != other.Codecs.AudioCodec ||
+		s.Codecs.AudioChannels != other.Codecs.AudioChannels ||
+		s.Stream.Width != other.Stream.Width ||
+		s.Stream.Height != other.Stream.Height
+}
+
+// String returns a compact description of the source for logs.
+func (s SourceInfo) String() string {
+	return fmt.Sprintf("video=%s %dx%d@%.3g audio=%s/%dch",
+		s.Codecs.VideoCodec, s.Stream.Width, s.Stream.Height, s.Stream.Framerate,
+		s.Codecs.AudioCodec, s.Codecs.AudioChannels)
+}
+
+// ProbeSourceWith probes a stream's codecs and properties with a single
+// ffprobe run using the given process runner. Input options, such as the
+// upstream headers, are passed before the URL.
+func ProbeSourceWith(ctx context.Context, runner process.Runner, url string, inputOptions ...string) (_ SourceInfo, err error) {
+	ctx, span := tracing.Start(ctx, "transcode.ProbeSource")
+	defer func() { tracing.End(span, err) }()
+
+	args := []string{
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_streams",
+		"-show_format",
+		"-analyzeduration", "1000000", // 1 second
+		"-probesize", "1000000", // 1MB
+	}
+	args = append(args, inputOptions...)
+	stdout, stderr, err := runner.Output(ctx, "ffprobe", append(args, url)...)
+	if err != nil {
+		return SourceInfo{}, fmt.Errorf("ffprobe failed: %w, stderr: %s", err, stderr)
+	}
+
+	output, err := parseProbeOutput(stdout)
+	if err != nil {
+		return SourceInfo{}, err
+	}
+	return SourceInfo{Codecs: output.streamCodecs(), Stream: output.streamInfo()}, nil
+}
+
+// probeOutput is the part of ffprobe's JSON output used by the probes.
+type probeOutput struct {
+	Streams []struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Profile      string `json:"profile"`
+		Level        int    `json:"level"`
+		Channels     int    `json:"channels"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		BitRate      string `json:"bit_rate"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+	} `json:"streams"`
+	Format struct {
+		BitRate string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// parseProbeOutput parses ffprobe -print_format json output.
+func parseProbeOutput(stdout []byte) (probeOutput, error) {
+	var output probeOutput
+	if err := json.Unmarshal(stdout, &output); err != nil {
+		return probeOutput{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	return output, nil
+}
+
+// streamCodecs returns the codecs of the first video and audio streams.
+func (p probeOutput) streamCodecs() StreamCodecs {
+	codecs := StreamCodecs{}
+
+	// Find video and audio streams
+	for _, stream := range p.Streams {
+		switch stream.CodecType {
+		case "video":
+			codecs.VideoCodec = stream.CodecName
+			codecs.VideoProfile = stream.Profile
+			if stream.Level > 0 {
+				codecs.VideoLevel = fmt.Sprintf("%.1f", float64(stream.Level)/10.0)
+			}
+		case "audio":
+			codecs.AudioCodec = stream.CodecName
+			codecs.AudioChannels = stream.Channels
+		}
+	}
+
+	return codecs
+}
+
+// streamInfo returns the resolution, framerate and bitrates of the stream.
+func (p probeOutput) streamInfo() StreamInfo {
+	info := StreamInfo{}
+
+	// Find video and audio streams
+	for _, stream := range p.Streams {
+		switch stream.CodecType {
+		case "video":
+			info.Width = stream.Width
+			info.Height = stream.Height
+
+			// Parse bitrate
+			if stream.BitRate != "" {
+				if br, err := strconv.Atoi(stream.BitRate); err == nil {
+					info.VideoBitrate = br / 1000 // Convert to kbps
+				}
+			}
+
+			// Parse framerate
+			if stream.AvgFrameRate != "" {
+				parts := strings.Split(stream.AvgFrameRate, "/")
+				if len(parts) == 2 {
+					num, _ := strconv.ParseFloat(parts[0], 64)
+					den, _ := strconv.ParseFloat(parts[1], 64)
+					if den > 0 {
+						info.Framerate = num / den
+					}
+				}
+			}
+
+		case "audio":
+			// Parse audio bitrate
+			if stream.BitRate != "" {
+				if br, err := strconv.Atoi(stream.BitRate); err == nil {
+					info.AudioBitrate = br / 1000 // Convert to kbps
+				}
+			}
+		}
+	}
+
+	// If individual stream bitrates not found, estimate from total
+	if info.VideoBitrate == 0 && p.Format.BitRate != "" {
+		if totalBitrate, err := strconv.Atoi(p.Format.BitRate); err == nil {
+			// Estimate 90% for video, 10% for audio
+			info.VideoBitrate = (totalBitrate * 9 / 10) / 1000
+			if info.AudioBitrate == 0 {
+				info.AudioBitrate = (totalBitrate * 1 / 10) / 1000
+			}
+		}
+	}
+
+	// Set defaults if still missing
+	if info.Framerate == 0 {
+		info.Framerate = 30
+	}
+	if info.AudioBitrate == 0 {
+		info.AudioBitrate = 128
+	}
+
+	return info
+}
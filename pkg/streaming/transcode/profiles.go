@@ -2,14 +2,11 @@
 package transcode
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"os/exec"
-	"strconv"
-	"strings"
 
 	"github.com/savid/iptv-proxy/config"
+	"github.com/savid/iptv-proxy/pkg/process"
 	"github.com/savid/iptv-proxy/pkg/types"
 )
 
@@ -235,96 +232,25 @@ func CalculateAdaptiveBitrate(source StreamInfo) (videoBitrate, audioBitrate str
 
 // ProbeStream analyzes a stream to get its properties.
 func ProbeStream(url string) (StreamInfo, error) {
-	cmd := exec.Command("ffprobe",
+	return ProbeStreamWith(process.NewExecRunner(), url)
+}
+
+// ProbeStreamWith analyzes a stream using the given process runner.
+func ProbeStreamWith(runner process.Runner, url string) (StreamInfo, error) {
+	stdout, stderr, err := runner.Output(context.Background(), "ffprobe",
 		"-v", "quiet",
 		"-print_format", "json",
 		"-show_streams",
 		"-show_format",
 		url,
 	)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return StreamInfo{}, fmt.Errorf("ffprobe failed: %w, stderr: %s", err, stderr.String())
-	}
-
-	// Parse JSON output
-	var probeData struct {
-		Streams []struct {
-			CodecType    string `json:"codec_type"`
-			Width        int    `json:"width"`
-			Height       int    `json:"height"`
-			BitRate      string `json:"bit_rate"`
-			AvgFrameRate string `json:"avg_frame_rate"`
-		} `json:"streams"`
-		Format struct {
-			BitRate string `json:"bit_rate"`
-		} `json:"format"`
-	}
-
-	if err := json.Unmarshal(stdout.Bytes(), &probeData); err != nil {
-		return StreamInfo{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
-	}
-
-	info := StreamInfo{}
-
-	// Find video and audio streams
-	for _, stream := range probeData.Streams {
-		switch stream.CodecType {
-		case "video":
-			info.Width = stream.Width
-			info.Height = stream.Height
-
-			// Parse bitrate
-			if stream.BitRate != "" {
-				if br, err := strconv.Atoi(stream.BitRate); err == nil {
-					info.VideoBitrate = br / 1000 // Convert to kbps
-				}
-			}
-
-			// Parse framerate
-			if stream.AvgFrameRate != "" {
-				parts := strings.Split(stream.AvgFrameRate, "/")
-				if len(parts) == 2 {
-					num, _ := strconv.ParseFloat(parts[0], 64)
-					den, _ := strconv.ParseFloat(parts[1], 64)
-					if den > 0 {
-						info.Framerate = num / den
-					}
-				}
-			}
-
-		case "audio":
-			// Parse audio bitrate
-			if stream.BitRate != "" {
-				if br, err := strconv.Atoi(stream.BitRate); err == nil {
-					info.AudioBitrate = br / 1000 // Convert to kbps
-				}
-			}
-		}
+	if err != nil {
+		return StreamInfo{}, fmt.Errorf("ffprobe failed: %w, stderr: %s", err, stderr)
 	}
 
-	// If individual stream bitrates not found, estimate from total
-	if info.VideoBitrate == 0 && probeData.Format.BitRate != "" {
-		if totalBitrate, err := strconv.Atoi(probeData.Format.BitRate); err == nil {
-			// Estimate 90% for video, 10% for audio
-			info.VideoBitrate = (totalBitrate * 9 / 10) / 1000
-			if info.AudioBitrate == 0 {
-				info.AudioBitrate = (totalBitrate * 1 / 10) / 1000
-			}
-		}
-	}
-
-	// Set defaults if still missing
-	if info.Framerate == 0 {
-		info.Framerate = 30
+	output, err := parseProbeOutput(stdout)
+	if err != nil {
+		return StreamInfo{}, err
 	}
-	if info.AudioBitrate == 0 {
-		info.AudioBitrate = 128
-	}
-
-	return info, nil
+	return output.streamInfo(), nil
 }
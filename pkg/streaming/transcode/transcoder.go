@@ -4,10 +4,10 @@ package transcode
 import (
 	"context"
 	"io"
-	"log"
 
 	"github.com/savid/iptv-proxy/pkg/hardware"
 	"github.com/savid/iptv-proxy/pkg/types"
+	"github.com/sirupsen/logrus"
 )
 
 // Transcoder defines the interface for media transcoding.
@@ -24,7 +24,7 @@ func NewTranscoder(
 	buffer types.BufferConfig,
 	selector *hardware.Selector,
 	inputURL string,
-	logger *log.Logger,
+	logger *logrus.Entry,
 ) (Transcoder, error) {
 	// Create profile from codec settings
 	prof := CreateProfile(videoCodec, audioCodec, videoBitrate, audioBitrate)
@@ -2,20 +2,41 @@
 package testchannels
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"os/exec"
+	"log"
+
+	"github.com/savid/iptv-proxy/pkg/process"
 )
 
 // TestPatternGenerator creates test video streams using FFmpeg.
 type TestPatternGenerator struct {
 	ffmpegPath string
+	runner     process.Runner
+	logger     *log.Logger
 }
 
 // NewTestPatternGenerator creates a new test pattern generator.
 func NewTestPatternGenerator() *TestPatternGenerator {
 	return &TestPatternGenerator{
 		ffmpegPath: "ffmpeg",
+		runner:     process.NewExecRunner(),
+		logger:     log.Default(),
+	}
+}
+
+// SetRunner replaces the runner used to start FFmpeg.
+func (g *TestPatternGenerator) SetRunner(runner process.Runner) {
+	if runner != nil {
+		g.runner = runner
+	}
+}
+
+// SetLogger replaces the logger FFmpeg output is written to.
+func (g *TestPatternGenerator) SetLogger(logger *log.Logger) {
+	if logger != nil {
+		g.logger = logger
 	}
 }
 
@@ -23,24 +44,12 @@ func NewTestPatternGenerator() *TestPatternGenerator {
 func (g *TestPatternGenerator) GenerateStream(profile TestChannelProfile) (io.ReadCloser, error) {
 	args := g.buildFFmpegArgs(profile)
 
-	// #nosec G204 - ffmpeg path is hardcoded and args are built from validated profile data
-	cmd := exec.Command(g.ffmpegPath, args...)
-
-	// Capture stderr for debugging
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
-	}
-
-	stdout, err := cmd.StdoutPipe()
+	proc, err := g.runner.Start(context.Background(), process.Spec{Name: g.ffmpegPath, Args: args})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
-	}
-
-	if err := cmd.Start(); err != nil {
-		_ = stdout.Close()
 		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
 	}
+	stdout := proc.Stdout()
+	stderr := proc.Stderr()
 
 	// Log stderr in background for debugging
 	go func() {
@@ -51,11 +60,11 @@ func (g *TestPatternGenerator) GenerateStream(profile TestChannelProfile) (io.Re
 			if n > 0 {
 				// Log FFmpeg output more clearly
 				lines := string(buf[:n])
-				fmt.Printf("[FFmpeg %s] %s", profile.Name, lines)
+				g.logger.Printf("[FFmpeg %s] %s", profile.Name, lines)
 			}
 			if err != nil {
 				if err.Error() != eofError {
-					fmt.Printf("[FFmpeg %s] stderr read error: %v\n", profile.Name, err)
+					g.logger.Printf("[FFmpeg %s] stderr read error: %v", profile.Name, err)
 				}
 				break
 			}
@@ -65,7 +74,7 @@ func (g *TestPatternGenerator) GenerateStream(profile TestChannelProfile) (io.Re
 	// Return a wrapper that will clean up the process when closed
 	return &streamCloser{
 		ReadCloser: stdout,
-		cmd:        cmd,
+		proc:       proc,
 	}, nil
 }
 
@@ -119,7 +128,7 @@ func (g *TestPatternGenerator) buildFFmpegArgs(profile TestChannelProfile) []str
 // streamCloser wraps a ReadCloser and ensures the FFmpeg process is terminated.
 type streamCloser struct {
 	io.ReadCloser
-	cmd *exec.Cmd
+	proc process.Process
 }
 
 // Close terminates the FFmpeg process and closes the pipe.
@@ -128,10 +137,8 @@ func (s *streamCloser) Close() error {
 	err := s.ReadCloser.Close()
 
 	// Terminate the process
-	if s.cmd.Process != nil {
-		_ = s.cmd.Process.Kill()
-		_ = s.cmd.Wait()
-	}
+	_ = s.proc.Kill()
+	_ = s.proc.Wait()
 
 	return err
 }
@@ -2,20 +2,41 @@
 package testchannels
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"os/exec"
+	"log"
+
+	"github.com/savid/iptv-proxy/pkg/process"
 )
 
 // TVCompatibleGenerator creates test video streams optimized for Web/TV Plex clients.
 type TVCompatibleGenerator struct {
 	ffmpegPath string
+	runner     process.Runner
+	logger     *log.Logger
 }
 
 // NewTVCompatibleGenerator creates a new TV-compatible test pattern generator.
 func NewTVCompatibleGenerator() *TVCompatibleGenerator {
 	return &TVCompatibleGenerator{
 		ffmpegPath: "ffmpeg",
+		runner:     process.NewExecRunner(),
+		logger:     log.Default(),
+	}
+}
+
+// SetRunner replaces the runner used to start FFmpeg.
+func (g *TVCompatibleGenerator) SetRunner(runner process.Runner) {
+	if runner != nil {
+		g.runner = runner
+	}
+}
+
+// SetLogger replaces the logger FFmpeg output is written to.
+func (g *TVCompatibleGenerator) SetLogger(logger *log.Logger) {
+	if logger != nil {
+		g.logger = logger
 	}
 }
 
@@ -23,24 +44,12 @@ func NewTVCompatibleGenerator() *TVCompatibleGenerator {
 func (g *TVCompatibleGenerator) GenerateStream(profile TestChannelProfile) (io.ReadCloser, error) {
 	args := g.buildFFmpegArgs(profile)
 
-	// #nosec G204 - ffmpeg path is hardcoded and args are built from validated profile data
-	cmd := exec.Command(g.ffmpegPath, args...)
-
-	// Capture stderr for debugging
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
-	}
-
-	stdout, err := cmd.StdoutPipe()
+	proc, err := g.runner.Start(context.Background(), process.Spec{Name: g.ffmpegPath, Args: args})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
-	}
-
-	if err := cmd.Start(); err != nil {
-		_ = stdout.Close()
 		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
 	}
+	stdout := proc.Stdout()
+	stderr := proc.Stderr()
 
 	// Log stderr in background for debugging
 	go func() {
@@ -49,11 +58,11 @@ func (g *TVCompatibleGenerator) GenerateStream(profile TestChannelProfile) (io.R
 			n, err := stderr.Read(buf)
 			if n > 0 {
 				lines := string(buf[:n])
-				fmt.Printf("[FFmpeg TV %s] %s", profile.Name, lines)
+				g.logger.Printf("[FFmpeg TV %s] %s", profile.Name, lines)
 			}
 			if err != nil {
 				if err.Error() != "EOF" {
-					fmt.Printf("[FFmpeg TV %s] stderr read error: %v\n", profile.Name, err)
+					g.logger.Printf("[FFmpeg TV %s] stderr read error: %v", profile.Name, err)
 				}
 				break
 			}
@@ -63,7 +72,7 @@ func (g *TVCompatibleGenerator) GenerateStream(profile TestChannelProfile) (io.R
 	// Return a wrapper that will clean up the process when closed
 	return &streamCloser{
 		ReadCloser: stdout,
-		cmd:        cmd,
+		proc:       proc,
 	}, nil
 }
 
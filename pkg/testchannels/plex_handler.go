@@ -3,28 +3,35 @@ package testchannels
 import (
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"os/exec"
 	"strconv"
 	"strings"
+
+	"github.com/savid/iptv-proxy/pkg/logging"
+	"github.com/savid/iptv-proxy/pkg/process"
 )
 
 // PlexTestHandler is optimized specifically for Plex compatibility.
 type PlexTestHandler struct {
 	generator *TestPatternGenerator
+	logger    *log.Logger
 }
 
 // NewPlexTestHandler creates a new Plex-optimized test handler.
-func NewPlexTestHandler() *PlexTestHandler {
+func NewPlexTestHandler(logger *log.Logger) *PlexTestHandler {
 	return &PlexTestHandler{
 		generator: NewTestPatternGenerator(),
+		logger:    logger,
 	}
 }
 
 func (h *PlexTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := logging.StdLogger(r.Context(), h.logger)
+
 	// Log request for debugging
 	userAgent := r.Header.Get("User-Agent")
-	fmt.Printf("[PlexTest] Request from %s: %s (UA: %s)\n", r.RemoteAddr, r.URL.Path, userAgent)
+	logger.Printf("[PlexTest] Request from %s: %s (UA: %s)", r.RemoteAddr, r.URL.Path, userAgent)
 
 	// Extract channel index
 	parts := strings.Split(r.URL.Path, "/")
@@ -46,34 +53,19 @@ func (h *PlexTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Printf("[PlexTest] Serving channel %d: %s\n", index, profile.Name)
+	logger.Printf("[PlexTest] Serving channel %d: %s", index, profile.Name)
 
 	// Use a simpler FFmpeg command specifically for Plex
 	args := h.buildPlexOptimizedArgs(profile)
 
-	// #nosec G204 - ffmpeg path is hardcoded and args are built from validated profile data
-	cmd := exec.Command("ffmpeg", args...)
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		fmt.Printf("[PlexTest] Failed to create stdout pipe: %v\n", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
-		return
-	}
-
-	stderr, err := cmd.StderrPipe()
+	proc, err := h.generator.runner.Start(r.Context(), process.Spec{Name: h.generator.ffmpegPath, Args: args})
 	if err != nil {
-		fmt.Printf("[PlexTest] Failed to create stderr pipe: %v\n", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
-		return
-	}
-
-	// Start FFmpeg
-	if err := cmd.Start(); err != nil {
-		fmt.Printf("[PlexTest] Failed to start FFmpeg: %v\n", err)
+		logger.Printf("[PlexTest] Failed to start FFmpeg: %v", err)
 		http.Error(w, "Failed to start stream", http.StatusInternalServerError)
 		return
 	}
+	stdout := proc.Stdout()
+	stderr := proc.Stderr()
 
 	// Log FFmpeg output
 	go func() {
@@ -81,7 +73,7 @@ func (h *PlexTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		for {
 			n, err := stderr.Read(buf)
 			if n > 0 {
-				fmt.Printf("[PlexTest FFmpeg] %s", string(buf[:n]))
+				logger.Printf("[PlexTest FFmpeg] %s", string(buf[:n]))
 			}
 			if err != nil {
 				break
@@ -106,20 +98,16 @@ func (h *PlexTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Clean up on exit
 	defer func() {
 		_ = stdout.Close()
-		if cmd.Process != nil {
-			_ = cmd.Process.Kill()
-			_ = cmd.Wait()
-		}
+		_ = proc.Kill()
+		_ = proc.Wait()
 	}()
 
 	// Monitor client disconnect
 	go func() {
 		select {
 		case <-r.Context().Done():
-			fmt.Printf("[PlexTest] Client disconnected\n")
-			if cmd.Process != nil {
-				_ = cmd.Process.Kill()
-			}
+			logger.Printf("[PlexTest] Client disconnected")
+			_ = proc.Kill()
 		case <-done:
 			return
 		}
@@ -131,7 +119,7 @@ func (h *PlexTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		n, err := stdout.Read(buf)
 		if n > 0 {
 			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
-				fmt.Printf("[PlexTest] Write error: %v\n", writeErr)
+				logger.Printf("[PlexTest] Write error: %v", writeErr)
 				return
 			}
 
@@ -142,7 +130,7 @@ func (h *PlexTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		if err != nil {
 			if err != io.EOF {
-				fmt.Printf("[PlexTest] Read error: %v\n", err)
+				logger.Printf("[PlexTest] Read error: %v", err)
 			}
 			return
 		}
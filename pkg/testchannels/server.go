@@ -17,8 +17,10 @@ type Server struct {
 
 // NewServer creates a new test channel server.
 func NewServer(port int, logger *log.Logger) *Server {
+	generator := NewTestPatternGenerator()
+	generator.SetLogger(logger)
 	return &Server{
-		generator: NewTestPatternGenerator(),
+		generator: generator,
 		port:      port,
 		logger:    logger,
 	}
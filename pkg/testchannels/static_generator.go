@@ -2,23 +2,44 @@ package testchannels
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"os/exec"
+	"log"
 	"sync"
 	"time"
+
+	"github.com/savid/iptv-proxy/pkg/process"
 )
 
 // StaticTestGenerator generates a static test file and loops it.
 type StaticTestGenerator struct {
 	cache      map[string][]byte
 	cacheMutex sync.RWMutex
+	runner     process.Runner
+	logger     *log.Logger
 }
 
 // NewStaticTestGenerator creates a new static test generator.
 func NewStaticTestGenerator() *StaticTestGenerator {
 	return &StaticTestGenerator{
-		cache: make(map[string][]byte),
+		cache:  make(map[string][]byte),
+		runner: process.NewExecRunner(),
+		logger: log.Default(),
+	}
+}
+
+// SetRunner replaces the runner used to start FFmpeg.
+func (g *StaticTestGenerator) SetRunner(runner process.Runner) {
+	if runner != nil {
+		g.runner = runner
+	}
+}
+
+// SetLogger replaces the logger FFmpeg errors are written to.
+func (g *StaticTestGenerator) SetLogger(logger *log.Logger) {
+	if logger != nil {
+		g.logger = logger
 	}
 }
 
@@ -76,18 +97,13 @@ func (g *StaticTestGenerator) generateClip(profile TestChannelProfile) ([]byte,
 		"pipe:1",
 	}
 
-	// #nosec G204 - ffmpeg path is hardcoded and args are built from validated profile data
-	cmd := exec.Command("ffmpeg", args...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("FFmpeg error: %s\n", stderr.String())
+	stdout, stderr, err := g.runner.Output(context.Background(), "ffmpeg", args...)
+	if err != nil {
+		g.logger.Printf("FFmpeg error: %s", stderr)
 		return nil, fmt.Errorf("ffmpeg failed: %w", err)
 	}
 
-	return stdout.Bytes(), nil
+	return stdout, nil
 }
 
 // loopingReader reads data in a loop.
@@ -0,0 +1,291 @@
+// Package timeshift records live channels into a rolling window so that
+// clients can start behind live, pause and seek.
+package timeshift
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/mpegts"
+)
+
+// ErrReaderClosed is returned by Read after the reader was closed.
+var ErrReaderClosed = errors.New("time-shift reader closed")
+
+// markInterval is how often the index records a position between keyframes.
+const markInterval = time.Second
+
+// entry is an indexed stream position. Keyframe entries carry the tables
+// to send before them; the others only map offsets to media time.
+type entry struct {
+	offset   int64
+	time     time.Duration
+	keyframe bool
+	tables   mpegts.Tables
+}
+
+// Buffer holds the last window of a transport stream, indexed by media
+// time from the PCR or PTS timestamps. It has one writer and any number of
+// readers, each with its own position.
+type Buffer struct {
+	window time.Duration
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	store    *storage
+	detector *mpegts.KeyframeDetector
+	clock    clock
+	carry    []byte
+	index    []entry
+	live     time.Duration
+	closed   bool
+	err      error
+}
+
+// NewBuffer creates a buffer keeping window of media. Data is kept in
+// memory, or in files under dir when it is set.
+func NewBuffer(window time.Duration, dir string) *Buffer {
+	b := &Buffer{
+		window:   window,
+		store:    newStorage(dir),
+		detector: mpegts.NewKeyframeDetector(),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Write appends transport stream data at the live edge.
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	offset := b.store.end - int64(len(b.carry))
+	if err := b.store.write(p); err != nil {
+		return 0, err
+	}
+
+	b.carry = append(b.carry, p...)
+	i := 0
+	for len(b.carry)-i >= mpegts.PacketSize {
+		if b.carry[i] != mpegts.SyncByte {
+			i++
+			continue
+		}
+		b.indexPacket(offset+int64(i), mpegts.Packet(b.carry[i:i+mpegts.PacketSize]))
+		i += mpegts.PacketSize
+	}
+	b.carry = append(b.carry[:0], b.carry[i:]...)
+
+	b.evict()
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+// indexPacket updates the clock and records keyframes and time marks.
+func (b *Buffer) indexPacket(offset int64, packet mpegts.Packet) {
+	b.live = b.clock.update(packet)
+
+	if b.detector.Inspect(packet) {
+		if tables, ok := b.detector.Tables(); ok {
+			b.index = append(b.index, entry{offset: offset, time: b.live, keyframe: true, tables: tables})
+			return
+		}
+	}
+	if len(b.index) == 0 || b.live-b.index[len(b.index)-1].time >= markInterval {
+		b.index = append(b.index, entry{offset: offset, time: b.live})
+	}
+}
+
+// evict drops data older than the window. The window always starts at a
+// keyframe when the stream has any, so that the oldest data can be decoded.
+func (b *Buffer) evict() {
+	cutoff := b.live - b.window
+	keep, mark := -1, -1
+	for i, e := range b.index {
+		if e.time > cutoff {
+			break
+		}
+		mark = i
+		if e.keyframe {
+			keep = i
+		}
+	}
+	if keep < 0 && !b.hasKeyframes() {
+		keep = mark
+	}
+	if keep <= 0 {
+		return
+	}
+
+	b.index = append(b.index[:0], b.index[keep:]...)
+	b.store.trim(b.index[0].offset)
+}
+
+// hasKeyframes reports whether any keyframe is indexed.
+func (b *Buffer) hasKeyframes() bool {
+	for _, e := range b.index {
+		if e.keyframe {
+			return true
+		}
+	}
+	return false
+}
+
+// CloseWrite marks the end of the recording. Readers receive err, or
+// io.EOF when it is nil, once they reach the live edge.
+func (b *Buffer) CloseWrite(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.err = err
+	b.cond.Broadcast()
+}
+
+// Release frees the stored data. The buffer must not be used afterwards.
+func (b *Buffer) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.store.close()
+	b.index = nil
+}
+
+// Live returns the media time at the live edge.
+func (b *Buffer) Live() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.live
+}
+
+// Start returns the media time of the oldest data in the window.
+func (b *Buffer) Start() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.index) == 0 {
+		return b.live
+	}
+	return b.index[0].time
+}
+
+// Size returns how many bytes the window holds.
+func (b *Buffer) Size() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.store.end - b.store.start()
+}
+
+// NewReader creates a reader starting at the last keyframe at or before
+// the media time position, or at the oldest keyframe if position is before
+// the window.
+func (b *Buffer) NewReader(position time.Duration) *Reader {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := &Reader{buffer: b}
+	r.seekLocked(position)
+	return r
+}
+
+// seekLocked positions the reader. The buffer lock must be held.
+func (r *Reader) seekLocked(position time.Duration) {
+	b := r.buffer
+
+	// Without keyframes any indexed position will do
+	keyframesOnly := b.hasKeyframes()
+	found := -1
+	for i, e := range b.index {
+		if e.time > position && found >= 0 {
+			break
+		}
+		if e.keyframe || !keyframesOnly {
+			found = i
+		}
+	}
+
+	r.pending = nil
+	if found < 0 {
+		// Nothing indexed yet, start with the oldest stored data
+		r.pos = b.store.start()
+		r.time = b.live
+		return
+	}
+
+	e := b.index[found]
+	r.pos = e.offset
+	r.time = e.time
+	if e.keyframe && e.offset > b.store.start() {
+		r.pending = append(r.pending, e.tables.PAT...)
+		for _, pmt := range e.tables.PMTs {
+			r.pending = append(r.pending, pmt...)
+		}
+	}
+}
+
+// Reader reads a buffer from its own position, waiting at the live edge
+// for more data. A reader that falls out of the window continues at its
+// oldest keyframe.
+type Reader struct {
+	buffer  *Buffer
+	pos     int64
+	time    time.Duration
+	pending []byte
+	closed  bool
+}
+
+// Position returns the media time the reader started at.
+func (r *Reader) Position() time.Duration {
+	r.buffer.mu.Lock()
+	defer r.buffer.mu.Unlock()
+	return r.time
+}
+
+// Read reads stream data, blocking at the live edge.
+func (r *Reader) Read(p []byte) (int, error) {
+	b := r.buffer
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for {
+		if r.closed {
+			return 0, ErrReaderClosed
+		}
+		if len(r.pending) > 0 {
+			n := copy(p, r.pending)
+			r.pending = r.pending[n:]
+			return n, nil
+		}
+		if r.pos < b.store.start() {
+			r.seekLocked(0)
+			continue
+		}
+		if r.pos < b.store.end {
+			n, err := b.store.readAt(p, r.pos)
+			r.pos += int64(n)
+			if n == 0 && err != nil {
+				return 0, err
+			}
+			return n, nil
+		}
+		if b.closed {
+			if b.err != nil {
+				return 0, b.err
+			}
+			return 0, io.EOF
+		}
+		b.cond.Wait()
+	}
+}
+
+// Close stops the reader and wakes up a blocked Read.
+func (r *Reader) Close() error {
+	r.buffer.mu.Lock()
+	defer r.buffer.mu.Unlock()
+
+	r.closed = true
+	r.buffer.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,83 @@
+package timeshift
+
+import (
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/mpegts"
+)
+
+// maxTimestampJump is the largest step between timestamps treated as
+// continuous. Larger jumps in either direction are stream discontinuities
+// and do not advance the clock.
+const maxTimestampJump = 10 * time.Second
+
+// clock turns the PCR of a stream, or the PTS of its first elementary
+// stream when it carries no PCR, into a media time that starts at zero and
+// keeps increasing across timestamp wraps and discontinuities.
+type clock struct {
+	pid     uint16
+	usePCR  bool
+	started bool
+	last    uint64
+	elapsed time.Duration
+}
+
+// update advances the clock with the timestamp in packet, if any.
+func (c *clock) update(packet mpegts.Packet) time.Duration {
+	pid := packet.PID()
+
+	if pcr, ok := packet.PCR(); ok {
+		if !c.usePCR || pid != c.pid {
+			if c.usePCR {
+				// Only one PCR PID drives the clock
+				return c.elapsed
+			}
+			// Switch from PTS to the more regular PCR
+			c.usePCR = true
+			c.pid = pid
+			c.started = false
+		}
+		c.advance(pcr)
+		return c.elapsed
+	}
+
+	if c.usePCR {
+		return c.elapsed
+	}
+	if pts, ok := packet.PTS(); ok {
+		if !c.started {
+			c.pid = pid
+		}
+		if pid == c.pid {
+			c.advance(pts)
+		}
+	}
+	return c.elapsed
+}
+
+// advance adds the step from the previous timestamp to the media time.
+func (c *clock) advance(ts uint64) {
+	if !c.started {
+		c.started = true
+		c.last = ts
+		return
+	}
+
+	forward := ticks((ts + mpegts.TimestampWrap - c.last) % mpegts.TimestampWrap)
+	backward := ticks((c.last + mpegts.TimestampWrap - ts) % mpegts.TimestampWrap)
+	switch {
+	case forward <= maxTimestampJump:
+		c.elapsed += forward
+		c.last = ts
+	case backward <= maxTimestampJump:
+		// Reordered frames, wait for the timestamps to pass the last one
+	default:
+		// Discontinuity, continue from the new timestamps
+		c.last = ts
+	}
+}
+
+// ticks converts 90kHz clock ticks to a duration.
+func ticks(n uint64) time.Duration {
+	return time.Duration(n) * time.Second / mpegts.ClockRate
+}
@@ -0,0 +1,252 @@
+package timeshift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/mpegts"
+	"github.com/savid/iptv-proxy/pkg/tuner"
+	"github.com/savid/iptv-proxy/pkg/types"
+	"github.com/savid/iptv-proxy/pkg/upstream"
+)
+
+// ErrUpstreamStatus is returned when the upstream answers with an error status.
+var ErrUpstreamStatus = errors.New("upstream returned an error status")
+
+const (
+	// maxUpstreamFailures is how many connection attempts in a row may fail
+	// before a recording stops.
+	maxUpstreamFailures = 5
+	// upstreamRetryDelay is the wait between connection attempts.
+	upstreamRetryDelay = time.Second
+)
+
+// channel is a recording and the clients reading it.
+type channel struct {
+	url       string
+	buffer    *Buffer
+	viewers   int
+	recording bool
+	started   time.Time
+	cancel    context.CancelFunc
+	idle      *time.Timer
+	lease     *tuner.Lease
+}
+
+// Manager records one time-shift buffer per channel. A recording starts
+// with the first client and continues for the length of the window after
+// the last client leaves, so that paused clients can resume.
+type Manager struct {
+	window     time.Duration
+	dir        string
+	client     *upstream.Client
+	tuners     *tuner.Pool
+	retryDelay time.Duration
+	logger     *log.Logger
+
+	mu       sync.Mutex
+	channels map[string]*channel
+}
+
+// NewManager creates a manager keeping window of every channel, in memory
+// or in files under dir when it is set.
+func NewManager(window time.Duration, dir string, logger *log.Logger) *Manager {
+	return &Manager{
+		window:     window,
+		dir:        dir,
+		client:     upstream.Default(),
+		retryDelay: upstreamRetryDelay,
+		logger:     logger,
+		channels:   make(map[string]*channel),
+	}
+}
+
+// SetUpstream replaces the client used to record upstreams. It must be
+// called before the first channel is opened.
+func (m *Manager) SetUpstream(client *upstream.Client) {
+	if client != nil {
+		m.client = client
+	}
+}
+
+// SetTuners makes every recording hold a tuner of pool until it stops,
+// including the window after the last client leaves. It must be called
+// before the first channel is opened.
+func (m *Manager) SetTuners(pool *tuner.Pool) {
+	m.tuners = pool
+}
+
+// Open returns the buffer for a channel, starting to record it if needed.
+// The release function must be called when the client is done.
+func (m *Manager) Open(targetURL string) (*Buffer, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch, ok := m.channels[targetURL]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch = &channel{
+			url:       targetURL,
+			buffer:    NewBuffer(m.window, m.dir),
+			recording: true,
+			started:   time.Now(),
+			cancel:    cancel,
+		}
+		if m.tuners != nil {
+			ch.lease = m.tuners.Acquire(tuner.PurposeTimeshift)
+		}
+		m.channels[targetURL] = ch
+		m.logger.Printf("Time-shift recording started - url: %s, window: %s", targetURL, m.window)
+		go m.record(ctx, ch)
+	}
+
+	ch.viewers++
+	if ch.idle != nil {
+		ch.idle.Stop()
+		ch.idle = nil
+	}
+
+	var once sync.Once
+	return ch.buffer, func() {
+		once.Do(func() { m.release(ch) })
+	}
+}
+
+// release removes a client from the channel. When none are left the
+// recording is kept for the window before it stops.
+func (m *Manager) release(ch *channel) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch.viewers--
+	if ch.viewers > 0 {
+		return
+	}
+	if !ch.recording {
+		ch.buffer.Release()
+		return
+	}
+	ch.idle = time.AfterFunc(m.window, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if ch.viewers == 0 {
+			ch.cancel()
+		}
+	})
+}
+
+// record copies the upstream into the buffer, reconnecting when the
+// connection drops, until it is cancelled or the upstream keeps failing.
+func (m *Manager) record(ctx context.Context, ch *channel) {
+	var (
+		repairer *mpegts.Repairer
+		failures int
+		err      error
+	)
+
+	for ctx.Err() == nil && failures < maxUpstreamFailures {
+		var body io.ReadCloser
+		body, err = m.fetch(ctx, ch.url)
+		if err == nil {
+			if repairer == nil {
+				repairer = mpegts.NewRepairer(body, nil, "")
+			} else {
+				repairer.Splice(body)
+			}
+
+			var n int64
+			n, err = io.Copy(ch.buffer, repairer)
+			_ = body.Close()
+			if n > 0 {
+				failures = 0
+				continue
+			}
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+
+		failures++
+		m.logger.Printf("Time-shift upstream failed (attempt %d/%d) - url: %s, error: %v",
+			failures, maxUpstreamFailures, ch.url, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(m.retryDelay):
+		}
+	}
+
+	if ctx.Err() != nil {
+		err = nil
+	}
+	ch.buffer.CloseWrite(err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch.recording = false
+	ch.cancel()
+	ch.lease.Release()
+	if m.channels[ch.url] == ch {
+		delete(m.channels, ch.url)
+	}
+	if ch.viewers == 0 {
+		ch.buffer.Release()
+	}
+	m.logger.Printf("Time-shift recording stopped - url: %s", ch.url)
+}
+
+// fetch opens the upstream stream.
+func (m *Manager) fetch(ctx context.Context, targetURL string) (io.ReadCloser, error) {
+	resp, err := m.client.Get(ctx, targetURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch upstream: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d", ErrUpstreamStatus, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// Channels returns the recorded channels sorted by URL.
+func (m *Manager) Channels() []types.TimeshiftChannel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	storage := "memory"
+	if m.dir != "" {
+		storage = "disk"
+	}
+
+	channels := make([]types.TimeshiftChannel, 0, len(m.channels))
+	for _, ch := range m.channels {
+		channels = append(channels, types.TimeshiftChannel{
+			URL:       ch.url,
+			Storage:   storage,
+			Start:     ch.buffer.Start().Seconds(),
+			Live:      ch.buffer.Live().Seconds(),
+			Bytes:     ch.buffer.Size(),
+			Viewers:   ch.viewers,
+			Recording: ch.recording,
+			StartTime: ch.started,
+		})
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].URL < channels[j].URL })
+	return channels
+}
+
+// Close stops all recordings.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ch := range m.channels {
+		ch.cancel()
+	}
+}
@@ -0,0 +1,162 @@
+package timeshift
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// chunkSize is how much stream data each storage chunk holds. Data is
+// discarded from the start of the window one chunk at a time.
+const chunkSize = 4 * 1024 * 1024
+
+// chunk holds a contiguous part of the recorded stream.
+type chunk interface {
+	io.Writer
+	io.ReaderAt
+	// Close releases the chunk and its data.
+	Close() error
+}
+
+// memoryChunk keeps stream data in memory.
+type memoryChunk struct {
+	data []byte
+}
+
+func (c *memoryChunk) Write(p []byte) (int, error) {
+	c.data = append(c.data, p...)
+	return len(p), nil
+}
+
+func (c *memoryChunk) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(c.data)) {
+		return 0, io.EOF
+	}
+	return copy(p, c.data[off:]), nil
+}
+
+func (c *memoryChunk) Close() error {
+	c.data = nil
+	return nil
+}
+
+// fileChunk keeps stream data in a file that is removed on Close.
+type fileChunk struct {
+	file *os.File
+}
+
+func (c *fileChunk) Write(p []byte) (int, error) {
+	return c.file.Write(p)
+}
+
+func (c *fileChunk) ReadAt(p []byte, off int64) (int, error) {
+	return c.file.ReadAt(p, off)
+}
+
+func (c *fileChunk) Close() error {
+	name := c.file.Name()
+	if err := c.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// storedChunk is a chunk and the stream offset of its first byte.
+type storedChunk struct {
+	start int64
+	size  int64
+	data  chunk
+}
+
+// storage is an append-only byte stream split into chunks, in memory or in
+// a directory on disk.
+type storage struct {
+	dir    string
+	chunks []storedChunk
+	end    int64
+}
+
+// newStorage creates empty storage. Chunks are kept in memory unless dir is set.
+func newStorage(dir string) *storage {
+	return &storage{dir: dir}
+}
+
+// newChunk creates the next chunk.
+func (s *storage) newChunk() (chunk, error) {
+	if s.dir == "" {
+		return &memoryChunk{data: make([]byte, 0, chunkSize)}, nil
+	}
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create time-shift directory: %w", err)
+	}
+	file, err := os.CreateTemp(s.dir, "timeshift-*.ts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create time-shift chunk: %w", err)
+	}
+	return &fileChunk{file: file}, nil
+}
+
+// write appends p to the stream.
+func (s *storage) write(p []byte) error {
+	for len(p) > 0 {
+		last := len(s.chunks) - 1
+		if last < 0 || s.chunks[last].size >= chunkSize {
+			data, err := s.newChunk()
+			if err != nil {
+				return err
+			}
+			s.chunks = append(s.chunks, storedChunk{start: s.end, data: data})
+			last++
+		}
+
+		current := &s.chunks[last]
+		n := min(int64(len(p)), chunkSize-current.size)
+		if _, err := current.data.Write(p[:n]); err != nil {
+			return fmt.Errorf("failed to write time-shift chunk: %w", err)
+		}
+		current.size += n
+		s.end += n
+		p = p[n:]
+	}
+	return nil
+}
+
+// readAt reads stream data starting at off, stopping at a chunk boundary.
+func (s *storage) readAt(p []byte, off int64) (int, error) {
+	for _, c := range s.chunks {
+		if off < c.start || off >= c.start+c.size {
+			continue
+		}
+		limit := min(int64(len(p)), c.start+c.size-off)
+		n, err := c.data.ReadAt(p[:limit], off-c.start)
+		if n > 0 {
+			return n, nil
+		}
+		return 0, err
+	}
+	return 0, io.EOF
+}
+
+// start returns the offset of the oldest byte still stored.
+func (s *storage) start() int64 {
+	if len(s.chunks) == 0 {
+		return s.end
+	}
+	return s.chunks[0].start
+}
+
+// trim releases whole chunks that end before off.
+func (s *storage) trim(off int64) {
+	for len(s.chunks) > 1 && s.chunks[0].start+s.chunks[0].size <= off {
+		_ = s.chunks[0].data.Close()
+		s.chunks = s.chunks[1:]
+	}
+}
+
+// close releases all chunks.
+func (s *storage) close() {
+	for _, c := range s.chunks {
+		_ = c.data.Close()
+	}
+	s.chunks = nil
+}
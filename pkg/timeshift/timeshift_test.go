@@ -0,0 +1,286 @@
+package timeshift
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/savid/iptv-proxy/pkg/mpegts"
+	"github.com/savid/iptv-proxy/pkg/tuner"
+)
+
+const (
+	testPMTPID   = 0x1000
+	testVideoPID = 0x100
+)
+
+// tsPacket builds a payload-only packet.
+func tsPacket(pid uint16, payload ...byte) []byte {
+	p := bytes.Repeat([]byte{0xFF}, mpegts.PacketSize)
+	p[0] = mpegts.SyncByte
+	p[1] = 0x40 | byte(pid>>8)&0x1F
+	p[2] = byte(pid)
+	p[3] = 0x10
+	copy(p[4:], payload)
+	return p
+}
+
+// tables builds a PAT and a PMT with one H.264 stream carrying the PCR.
+func tables() []byte {
+	pat := tsPacket(mpegts.PIDPAT,
+		0x00, 0x00, 0xB0, 0x0D, 0x00, 0x01, 0xC1, 0x00, 0x00,
+		0x00, 0x01, 0xE0|testPMTPID>>8, testPMTPID&0xFF,
+		0x00, 0x00, 0x00, 0x00,
+	)
+	pmt := tsPacket(testPMTPID,
+		0x00, 0x02, 0xB0, 0x12, 0x00, 0x01, 0xC1, 0x00, 0x00,
+		0xE0|testVideoPID>>8, testVideoPID&0xFF, 0xF0, 0x00,
+		0x1B, 0xE0|testVideoPID>>8, testVideoPID&0xFF, 0xF0, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	)
+	return append(pat, pmt...)
+}
+
+// keyframePacket builds a video packet with the random access indicator and
+// a PCR of pcr 90kHz ticks.
+func keyframePacket(pcr uint64) []byte {
+	p := tsPacket(testVideoPID)
+	p[3] |= 0x20
+	p[4] = 7
+	p[5] = 0x40 | 0x10 // random access, PCR
+	p[6] = byte(pcr >> 25)
+	p[7] = byte(pcr >> 17)
+	p[8] = byte(pcr >> 9)
+	p[9] = byte(pcr >> 1)
+	p[10] = byte(pcr<<7) | 0x7E
+	return p
+}
+
+// stream builds a stream with one keyframe per second starting at PCR
+// start, each followed by filler packets.
+func stream(start uint64, seconds int) []byte {
+	out := tables()
+	for s := range seconds {
+		out = append(out, keyframePacket(start+uint64(s)*mpegts.ClockRate)...)
+		for range 4 {
+			out = append(out, tsPacket(testVideoPID, byte(s))...)
+		}
+	}
+	return out
+}
+
+// readPackets reads n packets from r.
+func readPackets(t *testing.T, r io.Reader, n int) []mpegts.Packet {
+	t.Helper()
+	data := make([]byte, n*mpegts.PacketSize)
+	if _, err := io.ReadFull(r, data); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	packets := make([]mpegts.Packet, n)
+	for i := range packets {
+		packets[i] = mpegts.Packet(data[i*mpegts.PacketSize : (i+1)*mpegts.PacketSize])
+	}
+	return packets
+}
+
+func TestBufferOffsetFromLive(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  bool
+	}{
+		{"memory", false},
+		{"disk", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := ""
+			if tt.dir {
+				dir = t.TempDir()
+			}
+			buffer := NewBuffer(time.Minute, dir)
+			defer buffer.Release()
+
+			if _, err := buffer.Write(stream(0, 30)); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if buffer.Live() != 29*time.Second {
+				t.Fatalf("Live() = %v, want 29s", buffer.Live())
+			}
+
+			reader := buffer.NewReader(buffer.Live() - 10*time.Second)
+			if reader.Position() != 19*time.Second {
+				t.Errorf("Position() = %v, want 19s", reader.Position())
+			}
+
+			packets := readPackets(t, reader, 3)
+			if packets[0].PID() != mpegts.PIDPAT || packets[1].PID() != testPMTPID {
+				t.Errorf("tables were not sent before the keyframe")
+			}
+			if pcr, ok := packets[2].PCR(); !ok || pcr != 19*mpegts.ClockRate {
+				t.Errorf("first video packet PCR = %d, want %d", pcr, 19*mpegts.ClockRate)
+			}
+		})
+	}
+}
+
+func TestBufferWindowEviction(t *testing.T) {
+	buffer := NewBuffer(5*time.Second, "")
+	defer buffer.Release()
+
+	if _, err := buffer.Write(stream(0, 30)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if buffer.Start() != 24*time.Second {
+		t.Errorf("Start() = %v, want 24s", buffer.Start())
+	}
+
+	// Seeking before the window starts at its oldest keyframe
+	reader := buffer.NewReader(0)
+	if reader.Position() != 24*time.Second {
+		t.Errorf("Position() = %v, want 24s", reader.Position())
+	}
+}
+
+func TestBufferTimestampWrap(t *testing.T) {
+	buffer := NewBuffer(time.Minute, "")
+	defer buffer.Release()
+
+	// The PCR wraps around two seconds into the stream
+	if _, err := buffer.Write(stream(mpegts.TimestampWrap-2*mpegts.ClockRate, 5)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if buffer.Live() != 4*time.Second {
+		t.Errorf("Live() = %v, want 4s across the wrap", buffer.Live())
+	}
+}
+
+func TestReaderWaitsAtLiveEdge(t *testing.T) {
+	buffer := NewBuffer(time.Minute, "")
+	defer buffer.Release()
+
+	if _, err := buffer.Write(stream(0, 2)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	reader := buffer.NewReader(buffer.Live())
+	readPackets(t, reader, 2+5) // tables and the last second
+
+	// A paused reader keeps its position while recording continues
+	more := make(chan []mpegts.Packet)
+	go func() {
+		more <- readPackets(t, reader, 1)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if _, err := buffer.Write(keyframePacket(2 * mpegts.ClockRate)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	select {
+	case packets := <-more:
+		if pcr, _ := packets[0].PCR(); pcr != 2*mpegts.ClockRate {
+			t.Errorf("PCR = %d, want the newly written packet", pcr)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("reader did not wake up for new data")
+	}
+
+	// Closing the reader unblocks a pending read
+	done := make(chan error)
+	go func() {
+		_, err := reader.Read(make([]byte, mpegts.PacketSize))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_ = reader.Close()
+	if err := <-done; !errors.Is(err, ErrReaderClosed) {
+		t.Errorf("Read() error = %v, want ErrReaderClosed", err)
+	}
+}
+
+func TestManagerRecordsChannel(t *testing.T) {
+	data := stream(0, 3)
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(data)
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	manager := NewManager(time.Minute, "", log.New(io.Discard, "", 0))
+	defer manager.Close()
+
+	buffer, done := manager.Open(upstream.URL)
+	// The last packet is held back until the next sync byte confirms it
+	reader := buffer.NewReader(0)
+	got := readPackets(t, reader, len(data)/mpegts.PacketSize-1)
+	if !bytes.Equal(bytes.Join(toBytes(got), nil), data[:len(data)-mpegts.PacketSize]) {
+		t.Errorf("recorded data differs from the upstream")
+	}
+
+	// A second client shares the recording
+	second, doneSecond := manager.Open(upstream.URL)
+	if second != buffer {
+		t.Errorf("second client got a new recording")
+	}
+	channels := manager.Channels()
+	if len(channels) != 1 || channels[0].Viewers != 2 || !channels[0].Recording {
+		t.Errorf("Channels() = %+v, want one recording with two viewers", channels)
+	}
+	doneSecond()
+	done()
+}
+
+func TestManagerRecordingHoldsTuner(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(stream(0, 3))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	tuners := tuner.NewPool(2)
+	manager := NewManager(50*time.Millisecond, "", log.New(io.Discard, "", 0))
+	manager.SetTuners(tuners)
+	defer manager.Close()
+
+	_, done := manager.Open(upstream.URL)
+	_, doneSecond := manager.Open(upstream.URL)
+	if got := tuners.InUseFor(tuner.PurposeTimeshift); got != 1 {
+		t.Errorf("tuners in use = %d, want 1 for a shared recording", got)
+	}
+	doneSecond()
+	done()
+
+	// The recording, and its tuner, outlive the last client by the window
+	if got := tuners.InUseFor(tuner.PurposeTimeshift); got != 1 {
+		t.Errorf("tuners in use = %d, want 1 during the window", got)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for tuners.InUse() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("tuners in use = %d after the recording stopped, want 0", tuners.InUse())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if channels := manager.Channels(); len(channels) != 0 {
+		t.Errorf("Channels() = %+v, want the recording stopped", channels)
+	}
+}
+
+// toBytes converts packets to byte slices.
+func toBytes(packets []mpegts.Packet) [][]byte {
+	out := make([][]byte, len(packets))
+	for i, p := range packets {
+		out[i] = p
+	}
+	return out
+}
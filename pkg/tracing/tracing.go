@@ -0,0 +1,150 @@
+// Package tracing exports OpenTelemetry spans of the fetch and stream
+// pipelines, so that slow refreshes and tune-ins can be broken down by
+// stage.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ExporterNone disables tracing.
+	ExporterNone = "none"
+	// ExporterOTLP exports spans with OTLP over HTTP.
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes spans to standard output as JSON.
+	ExporterStdout = "stdout"
+
+	// serviceName identifies the proxy in exported spans.
+	serviceName = "iptv-proxy"
+	// instrumentationName is the name of the tracer creating all spans.
+	instrumentationName = "github.com/savid/iptv-proxy"
+	// otlpTracesPath is the OTLP/HTTP path used when the endpoint has none.
+	otlpTracesPath = "/v1/traces"
+)
+
+// ErrInvalidExporter is returned for an unknown trace exporter.
+var ErrInvalidExporter = errors.New("invalid trace exporter")
+
+// Setup installs the global tracer provider for the given exporter and
+// returns a function flushing and stopping it. Trace context is propagated
+// with the W3C traceparent header. The OTLP endpoint is a URL
+// such as http://collector:4318; when empty, the OTEL_EXPORTER_OTLP_*
+// environment variables apply. With ExporterNone spans are discarded.
+func Setup(ctx context.Context, exporter, endpoint string, sampleRatio float64) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	switch exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		if endpoint != "" {
+			endpointURL, err := otlpEndpoint(endpoint)
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, otlptracehttp.WithEndpointURL(endpointURL))
+		}
+		var err error
+		spanExporter, err = otlptracehttp.New(ctx, options...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+	case ExporterStdout:
+		var err error
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidExporter, exporter)
+	}
+
+	provider := NewProvider(sdktrace.NewBatchSpanProcessor(spanExporter), sampleRatio)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return provider.Shutdown, nil
+}
+
+// NewProvider creates a tracer provider for the proxy that sends spans to
+// processor, sampling the given ratio of new traces.
+func NewProvider(processor sdktrace.SpanProcessor, sampleRatio float64) *sdktrace.TracerProvider {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", serviceName),
+	))
+	if err != nil {
+		res = resource.Default()
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+}
+
+// otlpEndpoint adds the OTLP traces path to an endpoint URL without one.
+func otlpEndpoint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("%w: invalid OTLP endpoint %q", ErrInvalidExporter, endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpTracesPath
+	}
+	return u.String(), nil
+}
+
+// Start starts a span of the proxy as a child of the span in ctx, if any.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartServer starts the server span of an incoming request.
+func StartServer(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// urlPattern matches URLs in error messages.
+var urlPattern = regexp.MustCompile(`[A-Za-z][A-Za-z0-9+.-]*://[^\s"']+`)
+
+// End ends a span, marking it failed when err is not nil. URLs in the error
+// are reduced to their scheme and host, as upstream paths and queries often
+// carry account credentials.
+func End(span trace.Span, err error) {
+	if err != nil {
+		msg := RedactURLs(err.Error())
+		span.RecordError(errors.New(msg))
+		span.SetStatus(codes.Error, msg)
+	}
+	span.End()
+}
+
+// RedactURLs replaces the URLs in s with their scheme and host.
+func RedactURLs(s string) string {
+	return urlPattern.ReplaceAllStringFunc(s, func(raw string) string {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return "[redacted]"
+		}
+		return u.Scheme + "://" + u.Host + "/[redacted]"
+	})
+}
@@ -0,0 +1,51 @@
+// Package tracingtest records the spans of the proxy in memory, so that
+// tests can check which pipeline stages were traced.
+package tracingtest
+
+import (
+	"testing"
+
+	"github.com/savid/iptv-proxy/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Record installs a global tracer provider exporting every span to an
+// in-memory exporter for the duration of the test. Tests using it must not
+// run in parallel.
+func Record(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(sdktrace.NewSimpleSpanProcessor(exporter), 1)
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(t.Context())
+	})
+
+	return exporter
+}
+
+// Find returns the ended span with the given name.
+func Find(exporter *tracetest.InMemoryExporter, name string) (tracetest.SpanStub, bool) {
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+// Names returns the names of the ended spans in the order they ended.
+func Names(exporter *tracetest.InMemoryExporter) []string {
+	spans := exporter.GetSpans()
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
@@ -0,0 +1,169 @@
+// Package tuner tracks allocation of the virtual tuners advertised to clients.
+package tuner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/savid/iptv-proxy/pkg/events"
+)
+
+// Purpose describes what a tuner is being used for.
+type Purpose string
+
+const (
+	// PurposeStream is a tuner used by a client watching a channel.
+	PurposeStream Purpose = "stream"
+	// PurposeHealthCheck is a tuner used by the background channel prober.
+	PurposeHealthCheck Purpose = "health-check"
+	// PurposeRecording is a tuner used by a scheduled DVR recording.
+	PurposeRecording Purpose = "recording"
+	// PurposeTimeshift is a tuner used by a time-shift recording, for as
+	// long as the recording runs.
+	PurposeTimeshift Purpose = "timeshift"
+	// PurposeProbe is a spare tuner used to probe the source of a channel
+	// after a stream of it has ended.
+	PurposeProbe Purpose = "probe"
+)
+
+// leaseKey is the context key for a lease held by the caller of a stream.
+type leaseKey struct{}
+
+// NewContext returns a context carrying a lease, so that a stream started
+// on behalf of its holder does not acquire a second tuner.
+func NewContext(ctx context.Context, lease *Lease) context.Context {
+	return context.WithValue(ctx, leaseKey{}, lease)
+}
+
+// FromContext returns the lease carried by ctx, or nil.
+func FromContext(ctx context.Context) *Lease {
+	lease, _ := ctx.Value(leaseKey{}).(*Lease)
+	return lease
+}
+
+// Pool tracks how many of the advertised tuners are in use.
+//
+// Client streams are always admitted so that the proxy never refuses a viewer
+// that the client application believed it had a tuner for. Background work
+// uses TryAcquire and only runs while spare tuners are available.
+type Pool struct {
+	mu       sync.Mutex
+	capacity int
+	inUse    map[Purpose]int
+	events   *events.Bus
+}
+
+// Lease represents an acquired tuner. Release must be called when done.
+type Lease struct {
+	pool    *Pool
+	purpose Purpose
+	once    sync.Once
+}
+
+// NewPool creates a tuner pool with the given capacity.
+func NewPool(capacity int) *Pool {
+	return &Pool{
+		capacity: capacity,
+		inUse:    make(map[Purpose]int),
+	}
+}
+
+// SetEvents publishes an event to bus when a client or recording takes the
+// last free tuner.
+func (p *Pool) SetEvents(bus *events.Bus) {
+	p.events = bus
+}
+
+// Acquire registers a tuner in use for the given purpose, even if the pool is full.
+func (p *Pool) Acquire(purpose Purpose) *Lease {
+	p.mu.Lock()
+	p.inUse[purpose]++
+	total := p.totalLocked()
+	p.mu.Unlock()
+
+	p.publishFull(total, purpose)
+	return &Lease{pool: p, purpose: purpose}
+}
+
+// AcquireContext acquires a tuner like Acquire unless ctx already carries a
+// lease from this pool, in which case it returns nil. Releasing a nil lease
+// is a no-op.
+func (p *Pool) AcquireContext(ctx context.Context, purpose Purpose) *Lease {
+	if lease := FromContext(ctx); lease != nil && lease.pool == p {
+		return nil
+	}
+	return p.Acquire(purpose)
+}
+
+// TryAcquire acquires a tuner only if one is free. Returns false if the pool is full.
+func (p *Pool) TryAcquire(purpose Purpose) (*Lease, bool) {
+	p.mu.Lock()
+	if p.totalLocked() >= p.capacity {
+		p.mu.Unlock()
+		return nil, false
+	}
+	p.inUse[purpose]++
+	total := p.totalLocked()
+	p.mu.Unlock()
+
+	p.publishFull(total, purpose)
+	return &Lease{pool: p, purpose: purpose}, true
+}
+
+// publishFull reports acquisitions that leave no free tuner. Health checks
+// and source probes are not reported as they only take spare tuners.
+func (p *Pool) publishFull(total int, purpose Purpose) {
+	if total < p.capacity || purpose == PurposeHealthCheck || purpose == PurposeProbe {
+		return
+	}
+	p.events.Publish(events.Event{
+		Type:    events.TypeTunersBusy,
+		Message: fmt.Sprintf("All %d tuners are in use", p.capacity),
+		Fields:  map[string]any{"purpose": purpose},
+	})
+}
+
+// Release returns the tuner to the pool. It is safe to call more than once.
+func (l *Lease) Release() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		l.pool.mu.Lock()
+		defer l.pool.mu.Unlock()
+
+		if l.pool.inUse[l.purpose] > 0 {
+			l.pool.inUse[l.purpose]--
+		}
+	})
+}
+
+// Capacity returns the number of advertised tuners.
+func (p *Pool) Capacity() int {
+	return p.capacity
+}
+
+// InUse returns the total number of tuners currently in use.
+func (p *Pool) InUse() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.totalLocked()
+}
+
+// InUseFor returns the number of tuners in use for a specific purpose.
+func (p *Pool) InUseFor(purpose Purpose) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.inUse[purpose]
+}
+
+func (p *Pool) totalLocked() int {
+	total := 0
+	for _, n := range p.inUse {
+		total += n
+	}
+	return total
+}
@@ -10,6 +10,7 @@ type BufferConfig struct {
 	MinThreshold  int           // Minimum bytes before allowing reads.
 	MaxRetries    int           // Maximum number of retry attempts.
 	RetryDelay    time.Duration // Initial delay between retries.
+	KeyframeJoin  bool          // Start readers at the latest MPEG-TS keyframe.
 }
 
 // BufferStats tracks the current state and performance of a buffer.
@@ -20,3 +21,26 @@ type BufferStats struct {
 	Underruns     int     // Number of buffer underrun events.
 	Retries       int     // Number of retry attempts made.
 }
+
+// TSStats reports the MPEG-TS inspection results of a copied stream.
+type TSStats struct {
+	Packets          int64 `json:"packets"`
+	Resyncs          int64 `json:"resyncs"`
+	DroppedBytes     int64 `json:"dropped_bytes"`
+	ContinuityErrors int64 `json:"continuity_errors"`
+	Splices          int64 `json:"splices"`
+	InjectedTables   int64 `json:"injected_tables"`
+}
+
+// TimeshiftChannel reports the time-shift window recorded for a channel.
+// Times are media seconds from the start of the recording.
+type TimeshiftChannel struct {
+	URL       string    `json:"url"`
+	Storage   string    `json:"storage"`
+	Start     float64   `json:"start"`
+	Live      float64   `json:"live"`
+	Bytes     int64     `json:"bytes"`
+	Viewers   int       `json:"viewers"`
+	Recording bool      `json:"recording"`
+	StartTime time.Time `json:"start_time"`
+}
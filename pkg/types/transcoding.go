@@ -41,6 +41,18 @@ const (
 	TranscodeModeCopy TranscodeMode = "copy"
 	// TranscodeModeTranscode re-encodes streams with specified settings.
 	TranscodeModeTranscode TranscodeMode = "transcode"
+	// TranscodeModeAuto copies tracks the client can play and transcodes the rest.
+	TranscodeModeAuto TranscodeMode = "auto"
+)
+
+// Deinterlacing filters. Hardware encoders use their native equivalent.
+const (
+	// DeinterlaceNone leaves frames untouched.
+	DeinterlaceNone = "none"
+	// DeinterlaceYadif uses yadif (yadif_cuda or deinterlace_vaapi on GPUs).
+	DeinterlaceYadif = "yadif"
+	// DeinterlaceBwdif uses bwdif (bwdif_cuda or motion adaptive deinterlace_vaapi on GPUs).
+	DeinterlaceBwdif = "bwdif"
 )
 
 // TranscodingProfile defines the parameters for a transcoding operation.
@@ -52,6 +64,10 @@ type TranscodingProfile struct {
 	VideoBitrate  string
 	AudioBitrate  string
 	Container     string
+	MaxWidth      int     // Maximum output width in pixels (0 keeps source).
+	MaxHeight     int     // Maximum output height in pixels (0 keeps source).
+	Framerate     float64 // Output frame rate (0 keeps source).
+	Deinterlace   string  // Deinterlacing filter (none, yadif or bwdif).
 	ExtraArgs     []string
 }
 
@@ -67,10 +83,21 @@ type HardwareInfo struct {
 
 // TranscodeSession tracks an active transcoding session.
 type TranscodeSession struct {
-	ID           string
-	Profile      string
-	Hardware     HardwareType
-	StartTime    time.Time
-	BytesRead    int64
-	BytesWritten int64
+	ID               string        `json:"id"`
+	Channel          string        `json:"channel"`
+	Profile          string        `json:"profile"`
+	Client           string        `json:"client,omitempty"`
+	Mode             TranscodeMode `json:"mode"`
+	Hardware         HardwareType  `json:"hardware"`
+	DeviceID         int           `json:"device_id"`
+	SourceVideoCodec string        `json:"source_video_codec,omitempty"`
+	SourceAudioCodec string        `json:"source_audio_codec,omitempty"`
+	VideoCodec       string        `json:"video_codec"`
+	AudioCodec       string        `json:"audio_codec"`
+	Decision         string        `json:"decision,omitempty"`
+	StartTime        time.Time     `json:"start_time"`
+	BytesRead        int64         `json:"bytes_read"`
+	BytesWritten     int64         `json:"bytes_written"`
+	// TS holds packet statistics for streams copied through the MPEG-TS repair layer.
+	TS *TSStats `json:"ts,omitempty"`
 }
@@ -0,0 +1,149 @@
+// Package upstream provides the HTTP clients used to reach the IPTV
+// provider, one per source, with the provider's required headers, an
+// optional proxy and timeouts suited to long-running streams.
+package upstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Upstream sources, each with its own client.
+const (
+	// SourceM3U is the playlist download.
+	SourceM3U = "m3u"
+	// SourceEPG is the guide download.
+	SourceEPG = "epg"
+	// SourceStream is every channel stream, including health checks,
+	// time-shift recordings and FFmpeg inputs.
+	SourceStream = "stream"
+)
+
+// Override holds settings that replace the global upstream settings for one
+// source. Empty fields keep the global value; headers are added to the
+// global headers.
+type Override struct {
+	UserAgent          string            `json:"user_agent,omitempty"`
+	Referer            string            `json:"referer,omitempty"`
+	Cookies            string            `json:"cookies,omitempty"`
+	Headers            map[string]string `json:"headers,omitempty"`
+	Proxy              string            `json:"proxy,omitempty"`
+	InsecureSkipVerify bool              `json:"insecure_skip_verify,omitempty"`
+	CAFile             string            `json:"ca_file,omitempty"`
+	ConnectTimeout     string            `json:"connect_timeout,omitempty"`
+	ReadTimeout        string            `json:"read_timeout,omitempty"`
+	ForwardHeaders     []string          `json:"forward_headers,omitempty"`
+}
+
+// Apply returns the options with the override's settings.
+func (o Override) Apply(opts Options) (Options, error) {
+	if o.UserAgent != "" {
+		opts.UserAgent = o.UserAgent
+	}
+	if o.Referer != "" {
+		opts.Referer = o.Referer
+	}
+	if o.Cookies != "" {
+		opts.Cookies = o.Cookies
+	}
+	if len(o.Headers) > 0 {
+		headers := make(map[string]string, len(opts.Headers)+len(o.Headers))
+		for name, value := range opts.Headers {
+			headers[name] = value
+		}
+		for name, value := range o.Headers {
+			headers[name] = value
+		}
+		opts.Headers = headers
+	}
+	if o.Proxy != "" {
+		opts.Proxy = o.Proxy
+	}
+	if o.InsecureSkipVerify {
+		opts.InsecureSkipVerify = true
+	}
+	if o.CAFile != "" {
+		opts.CAFile = o.CAFile
+	}
+	if o.ForwardHeaders != nil {
+		opts.ForwardHeaders = o.ForwardHeaders
+	}
+
+	for _, timeout := range []struct {
+		value string
+		dst   *time.Duration
+	}{
+		{o.ConnectTimeout, &opts.ConnectTimeout},
+		{o.ReadTimeout, &opts.ReadTimeout},
+	} {
+		if timeout.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(timeout.value)
+		if err != nil || d <= 0 {
+			return Options{}, fmt.Errorf("%w: timeout %q", ErrInvalidOptions, timeout.value)
+		}
+		*timeout.dst = d
+	}
+
+	return opts, opts.Validate()
+}
+
+// Set holds the client of each upstream source.
+type Set struct {
+	M3U    *Client
+	EPG    *Client
+	Stream *Client
+}
+
+// LoadSet creates the clients of every source from the global options and
+// the per-source overrides of a JSON file, which may be empty.
+func LoadSet(opts Options, path string) (*Set, error) {
+	if path == "" {
+		return ParseSet(opts, nil)
+	}
+
+	raw, err := os.ReadFile(path) // #nosec G304 - path is provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upstreams file: %w", err)
+	}
+	return ParseSet(opts, raw)
+}
+
+// ParseSet creates the clients of every source from the global options and
+// JSON per-source overrides keyed by source.
+func ParseSet(opts Options, raw []byte) (*Set, error) {
+	overrides := map[string]Override{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &overrides); err != nil {
+			return nil, fmt.Errorf("failed to parse upstreams: %w", err)
+		}
+	}
+	for source := range overrides {
+		switch source {
+		case SourceM3U, SourceEPG, SourceStream:
+		default:
+			return nil, fmt.Errorf("%w: unknown source %q (must be m3u, epg, or stream)", ErrInvalidOptions, source)
+		}
+	}
+
+	set := &Set{}
+	for source, dst := range map[string]**Client{
+		SourceM3U:    &set.M3U,
+		SourceEPG:    &set.EPG,
+		SourceStream: &set.Stream,
+	} {
+		sourceOpts, err := overrides[source].Apply(opts)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", source, err)
+		}
+		client, err := NewClient(sourceOpts)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", source, err)
+		}
+		*dst = client
+	}
+	return set, nil
+}
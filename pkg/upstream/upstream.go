@@ -0,0 +1,353 @@
+// Package upstream provides the HTTP clients used to reach the IPTV
+// provider, one per source, with the provider's required headers, an
+// optional proxy and timeouts suited to long-running streams.
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidOptions is returned when upstream client settings are invalid.
+	ErrInvalidOptions = errors.New("invalid upstream settings")
+	// ErrReadTimeout is returned when an upstream sends no data for the read timeout.
+	ErrReadTimeout = errors.New("upstream read timeout")
+	// ErrProxyUnsupported is returned when FFmpeg cannot use the configured proxy.
+	ErrProxyUnsupported = errors.New("proxy not supported by FFmpeg")
+)
+
+const (
+	// DefaultUserAgent is sent when no user agent is configured and the
+	// client sent none.
+	DefaultUserAgent = "IPTV-Proxy/1.0"
+	// DefaultConnectTimeout bounds connecting to an upstream and its TLS handshake.
+	DefaultConnectTimeout = 10 * time.Second
+	// DefaultReadTimeout bounds the wait for the response headers and for each
+	// read of the body.
+	DefaultReadTimeout = 30 * time.Second
+)
+
+// DefaultForwardHeaders are the client request headers forwarded to stream
+// upstreams when no allowlist is configured.
+var DefaultForwardHeaders = []string{"Range", "If-Range", "Accept"}
+
+// Options configures an upstream client. Zero timeouts use the defaults.
+type Options struct {
+	// UserAgent replaces the User-Agent of clients; empty forwards it.
+	UserAgent string
+	Referer   string
+	// Cookies is sent as the Cookie header, e.g. "session=abc; region=us".
+	Cookies string
+	Headers map[string]string
+	// Proxy is an http, https or socks5 proxy URL; empty uses the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	Proxy              string
+	InsecureSkipVerify bool
+	// CAFile is a PEM file of certificate authorities trusted in addition to
+	// the system ones.
+	CAFile         string
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	// ForwardHeaders are the client request headers passed on upstream;
+	// nil uses DefaultForwardHeaders.
+	ForwardHeaders []string
+}
+
+// Validate checks the proxy URL and timeouts.
+func (o Options) Validate() error {
+	if o.Proxy != "" {
+		u, err := url.Parse(o.Proxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("%w: proxy %s (must be a URL like socks5://host:1080)", ErrInvalidOptions, o.Proxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("%w: proxy scheme %s (must be http, https, or socks5)", ErrInvalidOptions, u.Scheme)
+		}
+	}
+	if o.ConnectTimeout < 0 || o.ReadTimeout < 0 {
+		return fmt.Errorf("%w: timeouts must not be negative", ErrInvalidOptions)
+	}
+	for name := range o.Headers {
+		if name == "" || strings.ContainsAny(name, ": \r\n") {
+			return fmt.Errorf("%w: header name %q", ErrInvalidOptions, name)
+		}
+	}
+	return nil
+}
+
+// Client sends requests to one upstream source over a shared, pooled
+// transport. Configured headers are added to every request, and only
+// allowlisted headers are forwarded from clients so that tokens of media
+// servers like Plex never reach the provider.
+type Client struct {
+	http    *http.Client
+	header  http.Header
+	forward []string
+	// forwardAgent passes on the User-Agent of clients, as none is configured.
+	forwardAgent bool
+	proxy        *url.URL
+	readTimeout  time.Duration
+}
+
+// NewClient creates a client with its own connection pool.
+func NewClient(opts Options) (*Client, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	connectTimeout := opts.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+	readTimeout := opts.ReadTimeout
+	if readTimeout == 0 {
+		readTimeout = DefaultReadTimeout
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: opts.InsecureSkipVerify, // #nosec G402 - opt-in for providers with broken certificates
+		MinVersion:         tls.VersionTLS12,
+	}
+	if opts.CAFile != "" {
+		pool, err := loadCAFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	forward := opts.ForwardHeaders
+	if forward == nil {
+		forward = DefaultForwardHeaders
+	}
+
+	client := &Client{
+		header:      opts.header(),
+		forward:     canonicalHeaders(forward),
+		readTimeout: readTimeout,
+	}
+	if client.header.Get("User-Agent") == "" {
+		client.forwardAgent = true
+		client.header.Set("User-Agent", DefaultUserAgent)
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		client.proxy, _ = url.Parse(opts.Proxy)
+		proxy = http.ProxyURL(client.proxy)
+	}
+
+	client.http = &http.Client{
+		Transport: &http.Transport{
+			Proxy: proxy,
+			DialContext: (&net.Dialer{
+				Timeout:   connectTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: connectTimeout,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+	return client, nil
+}
+
+// Default returns a client with the default headers and timeouts.
+func Default() *Client {
+	client, err := NewClient(Options{})
+	if err != nil {
+		panic(err) // the zero options are always valid
+	}
+	return client
+}
+
+// header returns the headers configured for every request.
+func (o Options) header() http.Header {
+	header := http.Header{}
+	header.Set("Accept", "*/*")
+	if o.UserAgent != "" {
+		header.Set("User-Agent", o.UserAgent)
+	}
+	if o.Referer != "" {
+		header.Set("Referer", o.Referer)
+	}
+	if o.Cookies != "" {
+		header.Set("Cookie", o.Cookies)
+	}
+	for name, value := range o.Headers {
+		header.Set(name, value)
+	}
+	return header
+}
+
+// loadCAFile reads the PEM certificates of a CA file into a copy of the
+// system pool.
+func loadCAFile(path string) (*x509.CertPool, error) {
+	raw, err := os.ReadFile(path) // #nosec G304 - path is provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, fmt.Errorf("%w: no certificates in CA file %s", ErrInvalidOptions, path)
+	}
+	return pool, nil
+}
+
+// canonicalHeaders returns the canonical forms of header names.
+func canonicalHeaders(names []string) []string {
+	canonical := make([]string, 0, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			canonical = append(canonical, http.CanonicalHeaderKey(name))
+		}
+	}
+	return canonical
+}
+
+// NewRequest creates a GET request with the configured headers. The
+// allowlisted headers of the client request, when given, replace them, as
+// does its User-Agent when none is configured.
+func (c *Client) NewRequest(ctx context.Context, rawURL string, client http.Header) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = c.header.Clone()
+	if agent := client.Get("User-Agent"); c.forwardAgent && agent != "" {
+		req.Header.Set("User-Agent", agent)
+	}
+	for _, name := range c.forward {
+		if values := client.Values(name); len(values) > 0 {
+			req.Header[name] = append([]string(nil), values...)
+		}
+	}
+	return req, nil
+}
+
+// Get requests a URL with the configured headers and the allowlisted
+// headers of the client request, which may be nil.
+func (c *Client) Get(ctx context.Context, rawURL string, client http.Header) (*http.Response, error) {
+	req, err := c.NewRequest(ctx, rawURL, client)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends a request. The response headers and every read of the body must
+// arrive within the read timeout, so that stalled upstreams are detected
+// without limiting how long a live stream may run.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timer := time.AfterFunc(c.readTimeout, func() { cancel(ErrReadTimeout) })
+
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		timer.Stop()
+		cancel(nil)
+		if errors.Is(context.Cause(ctx), ErrReadTimeout) {
+			return nil, fmt.Errorf("%w: %w", ErrReadTimeout, err)
+		}
+		return nil, err
+	}
+
+	resp.Body = &idleTimeoutBody{
+		body:    resp.Body,
+		ctx:     ctx,
+		cancel:  cancel,
+		timer:   timer,
+		timeout: c.readTimeout,
+	}
+	return resp, nil
+}
+
+// idleTimeoutBody cancels its request when no read completes within the
+// timeout.
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	timeout time.Duration
+	once    sync.Once
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	if err != nil && !errors.Is(err, io.EOF) && errors.Is(context.Cause(b.ctx), ErrReadTimeout) {
+		err = fmt.Errorf("%w: %w", ErrReadTimeout, err)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.once.Do(func() {
+		b.timer.Stop()
+		b.cancel(nil)
+	})
+	return b.body.Close()
+}
+
+// CheckInput reports whether FFmpeg and ffprobe can use the client's proxy.
+// FFmpeg only supports HTTP proxies and would connect to the upstream
+// directly through any other.
+func (c *Client) CheckInput() error {
+	if c.proxy != nil && c.proxy.Scheme != "http" {
+		return fmt.Errorf("%w: %s (only http proxies are supported)", ErrProxyUnsupported, c.proxy.Scheme)
+	}
+	return nil
+}
+
+// InputOptions returns the FFmpeg and ffprobe input options that send the
+// configured headers, for use before -i. Callers must check the proxy with
+// CheckInput first, as only HTTP proxies are passed on.
+func (c *Client) InputOptions() []string {
+	args := []string{"-user_agent", c.header.Get("User-Agent")}
+
+	names := make([]string, 0, len(c.header))
+	for name := range c.header {
+		if name != "User-Agent" {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		var headers strings.Builder
+		for _, name := range names {
+			for _, value := range c.header.Values(name) {
+				headers.WriteString(name + ": " + value + "\r\n")
+			}
+		}
+		args = append(args, "-headers", headers.String())
+	}
+
+	if c.proxy != nil && c.proxy.Scheme == "http" {
+		args = append(args, "-http_proxy", c.proxy.String())
+	}
+	return args
+}
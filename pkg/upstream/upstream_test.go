@@ -0,0 +1,306 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientHeaders(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Options{
+		UserAgent:      "Provider/2.0",
+		Referer:        "http://portal.example/",
+		Cookies:        "session=abc",
+		Headers:        map[string]string{"x-mac": "00:1A:79:00:00:01"},
+		ForwardHeaders: []string{"range"},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	clientHeader := http.Header{}
+	clientHeader.Set("Range", "bytes=100-")
+	clientHeader.Set("User-Agent", "PlexMediaServer/1.40")
+	clientHeader.Set("X-Plex-Token", "secret")
+
+	resp, err := client.Get(context.Background(), server.URL, clientHeader)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	_ = resp.Body.Close()
+
+	want := map[string]string{
+		"User-Agent": "Provider/2.0",
+		"Referer":    "http://portal.example/",
+		"Cookie":     "session=abc",
+		"X-Mac":      "00:1A:79:00:00:01",
+		"Range":      "bytes=100-",
+		"Accept":     "*/*",
+	}
+	for name, value := range want {
+		if got.Get(name) != value {
+			t.Errorf("%s = %q, want %q", name, got.Get(name), value)
+		}
+	}
+	if got.Get("X-Plex-Token") != "" {
+		t.Errorf("X-Plex-Token was forwarded upstream")
+	}
+}
+
+func TestClientForwardsUserAgentWhenUnset(t *testing.T) {
+	var agents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.UserAgent())
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	clientHeader := http.Header{}
+	clientHeader.Set("User-Agent", "VLC/3.0.20")
+
+	tests := []struct {
+		name   string
+		opts   Options
+		client http.Header
+		want   string
+	}{
+		{name: "client agent", client: clientHeader, want: "VLC/3.0.20"},
+		{name: "no client agent", client: nil, want: DefaultUserAgent},
+		{name: "configured agent", opts: Options{UserAgent: "Provider/2.0"}, client: clientHeader, want: "Provider/2.0"},
+		{name: "configured header", opts: Options{Headers: map[string]string{"User-Agent": "Box/1.0"}}, client: clientHeader, want: "Box/1.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agents = nil
+			client, err := NewClient(tt.opts)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			resp, err := client.Get(context.Background(), server.URL, tt.client)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			_ = resp.Body.Close()
+
+			if len(agents) != 1 || agents[0] != tt.want {
+				t.Errorf("User-Agent = %v, want %q", agents, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientReadTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := NewClient(Options{ReadTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	resp, err := client.Get(context.Background(), server.URL, nil)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if !errors.Is(err, ErrReadTimeout) {
+		t.Errorf("ReadAll() error = %v, want ErrReadTimeout", err)
+	}
+	if string(body) != "first" {
+		t.Errorf("body = %q, want the data sent before the stall", body)
+	}
+}
+
+func TestClientReadTimeoutIsIdle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// Runs longer than the read timeout but never stalls for that long
+		for range 6 {
+			_, _ = w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Options{ReadTimeout: 80 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	resp, err := client.Get(context.Background(), server.URL, nil)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if len(body) != 30 {
+		t.Errorf("body = %d bytes, want 30", len(body))
+	}
+}
+
+func TestClientProxy(t *testing.T) {
+	var target string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.URL.String()
+		_, _ = w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	client, err := NewClient(Options{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	resp, err := client.Get(context.Background(), "http://provider.invalid/live/1.ts", nil)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if target != "http://provider.invalid/live/1.ts" {
+		t.Errorf("proxy request = %q, want the upstream URL", target)
+	}
+}
+
+func TestInputOptions(t *testing.T) {
+	client, err := NewClient(Options{
+		UserAgent: "Provider/2.0",
+		Referer:   "http://portal.example/",
+		Proxy:     "http://127.0.0.1:3128",
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	args := client.InputOptions()
+	value := func(flag string) string {
+		if i := slices.Index(args, flag); i >= 0 && i+1 < len(args) {
+			return args[i+1]
+		}
+		return ""
+	}
+
+	if value("-user_agent") != "Provider/2.0" {
+		t.Errorf("-user_agent = %q, want the configured user agent", value("-user_agent"))
+	}
+	if headers := value("-headers"); headers != "Accept: */*\r\nReferer: http://portal.example/\r\n" {
+		t.Errorf("-headers = %q, want the configured headers", headers)
+	}
+	if value("-http_proxy") != "http://127.0.0.1:3128" {
+		t.Errorf("-http_proxy = %q, want the proxy", value("-http_proxy"))
+	}
+
+	if err := client.CheckInput(); err != nil {
+		t.Errorf("CheckInput() error = %v, want an HTTP proxy accepted", err)
+	}
+}
+
+func TestCheckInputRejectsUnsupportedProxies(t *testing.T) {
+	for _, proxy := range []string{"socks5://127.0.0.1:1080", "https://proxy.example:3129"} {
+		client, err := NewClient(Options{Proxy: proxy})
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+		if err := client.CheckInput(); !errors.Is(err, ErrProxyUnsupported) {
+			t.Errorf("CheckInput() with %s error = %v, want ErrProxyUnsupported", proxy, err)
+		}
+		if slices.Contains(client.InputOptions(), "-http_proxy") {
+			t.Errorf("InputOptions() = %v, want no -http_proxy for %s", client.InputOptions(), proxy)
+		}
+	}
+
+	if err := Default().CheckInput(); err != nil {
+		t.Errorf("CheckInput() without a proxy error = %v", err)
+	}
+}
+
+func TestParseSet(t *testing.T) {
+	var agents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.UserAgent()+" "+r.Header.Get("X-Source"))
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	raw := []byte(`{
+		"epg": {"user_agent": "Guide/1.0", "read_timeout": "2m"},
+		"stream": {"headers": {"X-Source": "live"}}
+	}`)
+	set, err := ParseSet(Options{UserAgent: "Global/1.0"}, raw)
+	if err != nil {
+		t.Fatalf("ParseSet() error = %v", err)
+	}
+
+	for _, client := range []*Client{set.M3U, set.EPG, set.Stream} {
+		resp, err := client.Get(context.Background(), server.URL, nil)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	want := []string{"Global/1.0 ", "Guide/1.0 ", "Global/1.0 live"}
+	if !slices.Equal(agents, want) {
+		t.Errorf("requests = %q, want %q", agents, want)
+	}
+	if set.EPG.readTimeout != 2*time.Minute {
+		t.Errorf("EPG read timeout = %s, want 2m", set.EPG.readTimeout)
+	}
+}
+
+func TestParseSetErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		raw  string
+	}{
+		{name: "unknown source", raw: `{"vod": {}}`},
+		{name: "invalid timeout", raw: `{"stream": {"read_timeout": "soon"}}`},
+		{name: "proxy scheme", raw: `{"m3u": {"proxy": "ftp://proxy:21"}}`},
+		{name: "proxy without host", opts: Options{Proxy: "localhost:1080"}},
+		{name: "header name", raw: `{"stream": {"headers": {"Bad Header": "x"}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSet(tt.opts, []byte(tt.raw))
+			if !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("ParseSet() error = %v, want ErrInvalidOptions", err)
+			}
+		})
+	}
+
+	if _, err := ParseSet(Options{}, []byte("{")); err == nil || !strings.Contains(err.Error(), "failed to parse upstreams") {
+		t.Errorf("ParseSet() error = %v, want a parse error", err)
+	}
+}